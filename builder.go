@@ -0,0 +1,630 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// operators that are only allowed in text objects, the text state
+// operators (§9.3) are also allowed at the page description level
+// - §8.2 Figure 9
+// - §9.4
+var textOperators = map[string]bool{
+	"Td": true, "TD": true, "Tm": true, "T*": true, "Tj": true, "TJ": true,
+	"'": true, "\"": true,
+}
+
+// operators that are not allowed in text objects
+// - §8.2 Figure 9
+var graphicsOperators = map[string]bool{
+	"q": true, "Q": true, "cm": true, "m": true, "l": true, "c": true,
+	"v": true, "y": true, "h": true, "re": true, "S": true, "s": true,
+	"f": true, "f*": true, "B": true, "B*": true, "b": true, "b*": true,
+	"n": true, "W": true, "W*": true, "sh": true, "Do": true, "BT": true,
+}
+
+// operators that end a path object
+// - §8.5.3
+var paintingOperators = map[string]bool{
+	"S": true, "s": true, "f": true, "f*": true, "B": true, "B*": true,
+	"b": true, "b*": true, "n": true,
+}
+
+// operators that are allowed in a path object
+// - §8.5.2
+var pathOperators = map[string]bool{
+	"m": true, "l": true, "c": true, "v": true, "y": true, "h": true,
+	"re": true, "W": true, "W*": true,
+}
+
+// color spaces that are used by name
+// instead of from the ColorSpace resources
+// - §8.6.3
+var deviceColorSpaces = map[Name]bool{
+	Name("DeviceGray"): true,
+	Name("DeviceRGB"):  true,
+	Name("DeviceCMYK"): true,
+	Name("Pattern"):    true,
+}
+
+// ContentBuilder writes a content stream (§7.8.2) with a method for
+// each operator. The objects the content uses, such as fonts and
+// XObjects, are added to its resource dictionary with new names.
+//
+// Misuse, such as Restore without Save, text operators outside of
+// BeginText and EndText, or painting without a path, is reported by
+// Stream, which also requires that Save and Restore, BeginText and
+// EndText, and the marked-content operators are balanced.
+type ContentBuilder struct {
+	// RealDecimals limits the number of digits written after the
+	// decimal point of numbers. When zero or less, numbers are
+	// written with as many digits as needed to read them back exactly.
+	RealDecimals int
+
+	resources Dictionary
+	buf       *buffer
+
+	// the first misuse
+	err error
+
+	saves  int  // q without Q
+	marks  int  // BMC and BDC without EMC
+	inText bool // between BT and ET
+	inPath bool // between starting and painting a path
+}
+
+// NewContentBuilder returns a ContentBuilder that adds the resources
+// the content uses to resources, which is the Resources dictionary of
+// the page or form XObject the content is for. The resource
+// dictionaries in it, such as its Font, must be direct objects. When
+// resources is nil, a new dictionary is used.
+func NewContentBuilder(resources Dictionary) *ContentBuilder {
+	if resources == nil {
+		resources = Dictionary{}
+	}
+
+	return &ContentBuilder{
+		resources: resources,
+		buf:       newBuffer(),
+	}
+}
+
+// Resources returns the resource dictionary of the content.
+func (c *ContentBuilder) Resources() Dictionary {
+	return c.resources
+}
+
+// Stream returns the content as a stream encoded with filters.
+func (c *ContentBuilder) Stream(filters ...Filter) (Stream, error) {
+	if c.err != nil {
+		return Stream{}, c.err
+	}
+	if c.buf.err != nil {
+		return Stream{}, c.buf.err
+	}
+
+	switch {
+	case c.inPath:
+		return Stream{}, errors.New("path is not painted")
+	case c.inText:
+		return Stream{}, errors.New("BT without ET")
+	case c.saves > 0:
+		return Stream{}, fmt.Errorf("%d q without Q", c.saves)
+	case c.marks > 0:
+		return Stream{}, fmt.Errorf("%d BMC or BDC without EMC", c.marks)
+	}
+
+	return NewStream(c.buf.b.Bytes(), filters...)
+}
+
+// fail records the first misuse
+func (c *ContentBuilder) fail(format string, a ...interface{}) {
+	if c.err == nil {
+		c.err = fmt.Errorf(format, a...)
+	}
+}
+
+// op checks that operator is allowed where the content is, then
+// writes it after its operands
+func (c *ContentBuilder) op(operator string, operands ...Object) {
+	switch {
+	case c.inText && graphicsOperators[operator]:
+		c.fail("%s in a text object", operator)
+	case !c.inText && textOperators[operator]:
+		c.fail("%s outside of a text object", operator)
+	case c.inPath && !pathOperators[operator] && !paintingOperators[operator]:
+		c.fail("%s before the path is painted", operator)
+	}
+
+	switch operator {
+	case "l", "c", "v", "y", "h":
+		if !c.inPath {
+			c.fail("%s without a current point", operator)
+		}
+	case "m", "re":
+		c.inPath = true
+	}
+	if paintingOperators[operator] {
+		c.inPath = false
+	}
+
+	for _, operand := range operands {
+		if c.RealDecimals > 0 {
+			operand = roundReals(operand, c.RealDecimals)
+		}
+		_, err := operand.writeTo(c.buf)
+		if err != nil && c.buf.err == nil {
+			c.buf.err = err
+		}
+		c.buf.WriteByte(' ')
+	}
+	c.buf.WriteString(operator)
+	c.buf.WriteByte('\n')
+}
+
+// numbers returns values, rounded to RealDecimals, as Integers
+// when they are whole numbers and as Reals when they are not
+func (c *ContentBuilder) numbers(values ...float64) []Object {
+	objects := make([]Object, len(values))
+	for i, value := range values {
+		if c.RealDecimals > 0 && !math.IsNaN(value) && !math.IsInf(value, 0) {
+			value, _ = strconv.ParseFloat(formatReal(value, c.RealDecimals), 64)
+		}
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			objects[i] = Integer(value)
+		} else {
+			objects[i] = Real(value)
+		}
+	}
+	return objects
+}
+
+// resource returns the name of obj in the category of the resources,
+// adding it with a name starting with prefix when it is not there
+// - §7.8.3
+func (c *ContentBuilder) resource(category Name, prefix string, obj Object) Name {
+	dict, ok := c.resources[category].(Dictionary)
+	if !ok {
+		if _, exists := c.resources[category]; exists {
+			c.fail("%s resources are not a direct dictionary", category)
+			return Name(prefix)
+		}
+		dict = Dictionary{}
+		c.resources[category] = dict
+	}
+
+	for name, value := range dict {
+		if reflect.DeepEqual(value, obj) {
+			return name
+		}
+	}
+
+	for i := len(dict) + 1; ; i++ {
+		name := Name(fmt.Sprintf("%s%d", prefix, i))
+		if _, exists := dict[name]; !exists {
+			dict[name] = obj
+			return name
+		}
+	}
+}
+
+// Graphics state (§8.4.4)
+
+// Save saves the graphics state (q).
+func (c *ContentBuilder) Save() {
+	c.op("q")
+	c.saves++
+}
+
+// Restore restores the last saved graphics state (Q).
+func (c *ContentBuilder) Restore() {
+	if c.saves == 0 {
+		c.fail("Q without q")
+	} else {
+		c.saves--
+	}
+	c.op("Q")
+}
+
+// Transform concatenates the matrix [a b c d e f]
+// to the current transformation matrix (cm).
+func (c *ContentBuilder) Transform(a, b, cc, d, e, f float64) {
+	c.op("cm", c.numbers(a, b, cc, d, e, f)...)
+}
+
+// SetLineWidth sets the line width (w).
+func (c *ContentBuilder) SetLineWidth(width float64) {
+	c.op("w", c.numbers(width)...)
+}
+
+// SetLineCap sets the line cap style (J), which is
+// 0 for butt, 1 for round and 2 for projecting square caps.
+func (c *ContentBuilder) SetLineCap(style int) {
+	c.op("J", Integer(style))
+}
+
+// SetLineJoin sets the line join style (j), which is
+// 0 for miter, 1 for round and 2 for bevel joins.
+func (c *ContentBuilder) SetLineJoin(style int) {
+	c.op("j", Integer(style))
+}
+
+// SetMiterLimit sets the miter limit (M).
+func (c *ContentBuilder) SetMiterLimit(limit float64) {
+	c.op("M", c.numbers(limit)...)
+}
+
+// SetDash sets the line dash pattern (d). An empty
+// array draws solid lines.
+func (c *ContentBuilder) SetDash(array []float64, phase float64) {
+	c.op("d", Array(c.numbers(array...)), c.numbers(phase)[0])
+}
+
+// SetRenderingIntent sets the color rendering intent (ri).
+func (c *ContentBuilder) SetRenderingIntent(intent Name) {
+	c.op("ri", intent)
+}
+
+// SetFlatness sets the flatness tolerance (i).
+func (c *ContentBuilder) SetFlatness(flatness float64) {
+	c.op("i", c.numbers(flatness)...)
+}
+
+// SetExtGState sets the parameters in the graphics state parameter
+// dictionary extGState, which is added to the ExtGState resources (gs).
+func (c *ContentBuilder) SetExtGState(extGState Object) {
+	c.op("gs", c.resource(Name("ExtGState"), "GS", extGState))
+}
+
+// Path construction (§8.5.2)
+
+// MoveTo begins a subpath at x, y (m).
+func (c *ContentBuilder) MoveTo(x, y float64) {
+	c.op("m", c.numbers(x, y)...)
+}
+
+// LineTo adds a line to x, y (l).
+func (c *ContentBuilder) LineTo(x, y float64) {
+	c.op("l", c.numbers(x, y)...)
+}
+
+// CurveTo adds a Bézier curve to x3, y3
+// with the control points x1, y1 and x2, y2 (c).
+func (c *ContentBuilder) CurveTo(x1, y1, x2, y2, x3, y3 float64) {
+	c.op("c", c.numbers(x1, y1, x2, y2, x3, y3)...)
+}
+
+// CurveToV adds a Bézier curve to x3, y3 whose first control
+// point is the current point and whose second is x2, y2 (v).
+func (c *ContentBuilder) CurveToV(x2, y2, x3, y3 float64) {
+	c.op("v", c.numbers(x2, y2, x3, y3)...)
+}
+
+// CurveToY adds a Bézier curve to x3, y3 whose first control
+// point is x1, y1 and whose second is x3, y3 (y).
+func (c *ContentBuilder) CurveToY(x1, y1, x3, y3 float64) {
+	c.op("y", c.numbers(x1, y1, x3, y3)...)
+}
+
+// ClosePath closes the current subpath (h).
+func (c *ContentBuilder) ClosePath() {
+	c.op("h")
+}
+
+// Rectangle adds a rectangle with its lower-left
+// corner at x, y as a complete subpath (re).
+func (c *ContentBuilder) Rectangle(x, y, width, height float64) {
+	c.op("re", c.numbers(x, y, width, height)...)
+}
+
+// Path painting (§8.5.3)
+
+// Stroke strokes the path (S).
+func (c *ContentBuilder) Stroke() {
+	c.op("S")
+}
+
+// CloseAndStroke closes and strokes the path (s).
+func (c *ContentBuilder) CloseAndStroke() {
+	c.op("s")
+}
+
+// Fill fills the path using the nonzero winding number rule (f).
+func (c *ContentBuilder) Fill() {
+	c.op("f")
+}
+
+// FillEvenOdd fills the path using the even-odd rule (f*).
+func (c *ContentBuilder) FillEvenOdd() {
+	c.op("f*")
+}
+
+// FillAndStroke fills the path using the nonzero
+// winding number rule and then strokes it (B).
+func (c *ContentBuilder) FillAndStroke() {
+	c.op("B")
+}
+
+// FillAndStrokeEvenOdd fills the path using the
+// even-odd rule and then strokes it (B*).
+func (c *ContentBuilder) FillAndStrokeEvenOdd() {
+	c.op("B*")
+}
+
+// CloseFillAndStroke closes the path, fills it using the
+// nonzero winding number rule and then strokes it (b).
+func (c *ContentBuilder) CloseFillAndStroke() {
+	c.op("b")
+}
+
+// CloseFillAndStrokeEvenOdd closes the path, fills it
+// using the even-odd rule and then strokes it (b*).
+func (c *ContentBuilder) CloseFillAndStrokeEvenOdd() {
+	c.op("b*")
+}
+
+// EndPath ends the path without painting it,
+// which is used after clipping (n).
+func (c *ContentBuilder) EndPath() {
+	c.op("n")
+}
+
+// Clipping paths (§8.5.4)
+
+// Clip intersects the clipping path with the path using the nonzero
+// winding number rule when the path is painted or ended (W).
+func (c *ContentBuilder) Clip() {
+	c.op("W")
+}
+
+// ClipEvenOdd intersects the clipping path with the path using
+// the even-odd rule when the path is painted or ended (W*).
+func (c *ContentBuilder) ClipEvenOdd() {
+	c.op("W*")
+}
+
+// Color (§8.6.8)
+
+// colorSpace returns the operand for the color space cs
+func (c *ContentBuilder) colorSpace(cs Object) Object {
+	if name, ok := cs.(Name); ok && deviceColorSpaces[name] {
+		return name
+	}
+	return c.resource(Name("ColorSpace"), "CS", cs)
+}
+
+// SetStrokeColorSpace sets the color space for stroking (CS). The
+// device color spaces and Pattern are used by name; other color
+// spaces are added to the ColorSpace resources.
+func (c *ContentBuilder) SetStrokeColorSpace(cs Object) {
+	c.op("CS", c.colorSpace(cs))
+}
+
+// SetFillColorSpace sets the color space for
+// filling (cs), like SetStrokeColorSpace.
+func (c *ContentBuilder) SetFillColorSpace(cs Object) {
+	c.op("cs", c.colorSpace(cs))
+}
+
+// SetStrokeColor sets the color for stroking in
+// the stroking color space (SCN).
+func (c *ContentBuilder) SetStrokeColor(components ...float64) {
+	c.op("SCN", c.numbers(components...)...)
+}
+
+// SetFillColor sets the color for filling in
+// the filling color space (scn).
+func (c *ContentBuilder) SetFillColor(components ...float64) {
+	c.op("scn", c.numbers(components...)...)
+}
+
+// SetStrokePattern sets the pattern for stroking, which is added to
+// the Pattern resources, when the stroking color space is Pattern
+// (SCN). The components are for uncolored tiling patterns.
+func (c *ContentBuilder) SetStrokePattern(pattern Object, components ...float64) {
+	c.op("SCN", append(c.numbers(components...), c.resource(Name("Pattern"), "P", pattern))...)
+}
+
+// SetFillPattern sets the pattern for filling,
+// like SetStrokePattern (scn).
+func (c *ContentBuilder) SetFillPattern(pattern Object, components ...float64) {
+	c.op("scn", append(c.numbers(components...), c.resource(Name("Pattern"), "P", pattern))...)
+}
+
+// SetStrokeGray sets DeviceGray for stroking and its gray level (G).
+func (c *ContentBuilder) SetStrokeGray(gray float64) {
+	c.op("G", c.numbers(gray)...)
+}
+
+// SetFillGray sets DeviceGray for filling and its gray level (g).
+func (c *ContentBuilder) SetFillGray(gray float64) {
+	c.op("g", c.numbers(gray)...)
+}
+
+// SetStrokeRGB sets DeviceRGB for stroking and its color (RG).
+func (c *ContentBuilder) SetStrokeRGB(r, g, b float64) {
+	c.op("RG", c.numbers(r, g, b)...)
+}
+
+// SetFillRGB sets DeviceRGB for filling and its color (rg).
+func (c *ContentBuilder) SetFillRGB(r, g, b float64) {
+	c.op("rg", c.numbers(r, g, b)...)
+}
+
+// SetStrokeCMYK sets DeviceCMYK for stroking and its color (K).
+func (c *ContentBuilder) SetStrokeCMYK(cyan, magenta, yellow, black float64) {
+	c.op("K", c.numbers(cyan, magenta, yellow, black)...)
+}
+
+// SetFillCMYK sets DeviceCMYK for filling and its color (k).
+func (c *ContentBuilder) SetFillCMYK(cyan, magenta, yellow, black float64) {
+	c.op("k", c.numbers(cyan, magenta, yellow, black)...)
+}
+
+// Shade paints the shading, which is added to the Shading
+// resources, over the clipping path (sh).
+// - §8.7.4.2
+func (c *ContentBuilder) Shade(shading Object) {
+	c.op("sh", c.resource(Name("Shading"), "Sh", shading))
+}
+
+// External objects (§8.8)
+
+// DrawXObject paints the XObject, which is added
+// to the XObject resources (Do).
+func (c *ContentBuilder) DrawXObject(xobject Object) {
+	c.op("Do", c.resource(Name("XObject"), "X", xobject))
+}
+
+// Text objects (§9.4)
+
+// BeginText begins a text object (BT).
+func (c *ContentBuilder) BeginText() {
+	c.op("BT")
+	c.inText = true
+}
+
+// EndText ends the text object (ET).
+func (c *ContentBuilder) EndText() {
+	if !c.inText {
+		c.fail("ET without BT")
+	}
+	c.inText = false
+	c.op("ET")
+}
+
+// Text state (§9.3)
+
+// SetCharSpacing sets the character spacing (Tc).
+func (c *ContentBuilder) SetCharSpacing(spacing float64) {
+	c.op("Tc", c.numbers(spacing)...)
+}
+
+// SetWordSpacing sets the word spacing (Tw).
+func (c *ContentBuilder) SetWordSpacing(spacing float64) {
+	c.op("Tw", c.numbers(spacing)...)
+}
+
+// SetHorizontalScaling sets the horizontal scaling,
+// which is a percentage (Tz).
+func (c *ContentBuilder) SetHorizontalScaling(scale float64) {
+	c.op("Tz", c.numbers(scale)...)
+}
+
+// SetLeading sets the text leading (TL).
+func (c *ContentBuilder) SetLeading(leading float64) {
+	c.op("TL", c.numbers(leading)...)
+}
+
+// SetFont sets the font, which is added to the
+// Font resources, and the font size (Tf).
+func (c *ContentBuilder) SetFont(font Object, size float64) {
+	c.op("Tf", c.resource(Name("Font"), "F", font), c.numbers(size)[0])
+}
+
+// SetTextRenderingMode sets the text rendering mode (Tr).
+func (c *ContentBuilder) SetTextRenderingMode(mode int) {
+	c.op("Tr", Integer(mode))
+}
+
+// SetTextRise sets the text rise (Ts).
+func (c *ContentBuilder) SetTextRise(rise float64) {
+	c.op("Ts", c.numbers(rise)...)
+}
+
+// Text positioning (§9.4.2)
+
+// MoveText moves to the start of the next
+// line, offset by tx, ty (Td).
+func (c *ContentBuilder) MoveText(tx, ty float64) {
+	c.op("Td", c.numbers(tx, ty)...)
+}
+
+// MoveTextSetLeading moves to the start of the next line,
+// offset by tx, ty, and sets the leading to -ty (TD).
+func (c *ContentBuilder) MoveTextSetLeading(tx, ty float64) {
+	c.op("TD", c.numbers(tx, ty)...)
+}
+
+// SetTextMatrix sets the text matrix and
+// the text line matrix to [a b c d e f] (Tm).
+func (c *ContentBuilder) SetTextMatrix(a, b, cc, d, e, f float64) {
+	c.op("Tm", c.numbers(a, b, cc, d, e, f)...)
+}
+
+// NextLine moves to the start of the next line (T*).
+func (c *ContentBuilder) NextLine() {
+	c.op("T*")
+}
+
+// Text showing (§9.4.3)
+
+// ShowText shows text, which is encoded for the font (Tj).
+func (c *ContentBuilder) ShowText(text String) {
+	c.op("Tj", text)
+}
+
+// ShowTextAdjusted shows the Strings in array, moving by the
+// numbers in it, which are in thousandths of text space (TJ).
+func (c *ContentBuilder) ShowTextAdjusted(array Array) {
+	c.op("TJ", array)
+}
+
+// NextLineShowText moves to the next line and shows text (').
+func (c *ContentBuilder) NextLineShowText(text String) {
+	c.op("'", text)
+}
+
+// NextLineShowTextSpacing sets the word and character spacing,
+// moves to the next line and shows text (").
+func (c *ContentBuilder) NextLineShowTextSpacing(wordSpacing, charSpacing float64, text String) {
+	c.op("\"", append(c.numbers(wordSpacing, charSpacing), text)...)
+}
+
+// Marked content (§14.6)
+
+// properties returns the operand for a property list
+func (c *ContentBuilder) properties(properties Object) Object {
+	if dict, ok := properties.(Dictionary); ok {
+		return dict
+	}
+	return c.resource(Name("Properties"), "MC", properties)
+}
+
+// MarkPoint marks a point with tag (MP).
+func (c *ContentBuilder) MarkPoint(tag Name) {
+	c.op("MP", tag)
+}
+
+// MarkPointProperties marks a point with tag and the property list
+// properties (DP). A Dictionary is written in the content; other
+// objects, such as references, are added to the Properties resources.
+func (c *ContentBuilder) MarkPointProperties(tag Name, properties Object) {
+	c.op("DP", tag, c.properties(properties))
+}
+
+// BeginMarkedContent begins a marked-content sequence with tag (BMC).
+func (c *ContentBuilder) BeginMarkedContent(tag Name) {
+	c.op("BMC", tag)
+	c.marks++
+}
+
+// BeginMarkedContentProperties begins a marked-content sequence
+// with tag and properties, like MarkPointProperties (BDC).
+func (c *ContentBuilder) BeginMarkedContentProperties(tag Name, properties Object) {
+	c.op("BDC", tag, c.properties(properties))
+	c.marks++
+}
+
+// EndMarkedContent ends a marked-content sequence (EMC).
+func (c *ContentBuilder) EndMarkedContent() {
+	if c.marks == 0 {
+		c.fail("EMC without BMC or BDC")
+	} else {
+		c.marks--
+	}
+	c.op("EMC")
+}
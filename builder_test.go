@@ -0,0 +1,207 @@
+package pdf
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentBuilder(t *testing.T) {
+	font := ObjectReference{ObjectNumber: 5}
+	image := ObjectReference{ObjectNumber: 6}
+	separation := Array{Name("Separation"), Name("Spot"), Name("DeviceCMYK"), ObjectReference{ObjectNumber: 7}}
+
+	// existing resources are kept
+	resources := Dictionary{
+		Name("Font"): Dictionary{Name("F1"): ObjectReference{ObjectNumber: 4}},
+	}
+	c := NewContentBuilder(resources)
+
+	c.Save()
+	c.Transform(2, 0, 0, 2, 10.5, 20)
+	c.SetLineWidth(0.25)
+	c.SetDash([]float64{3, 1}, 0)
+	c.SetStrokeRGB(1, 0, 0)
+	c.MoveTo(0, 0)
+	c.LineTo(100, 0)
+	c.CurveTo(1, 2, 3, 4, 5, 6)
+	c.ClosePath()
+	c.Stroke()
+	c.Rectangle(0, 0, 10, 10)
+	c.Clip()
+	c.EndPath()
+	c.SetFillColorSpace(separation)
+	c.SetFillColor(0.5)
+	c.DrawXObject(image)
+	c.Restore()
+
+	c.BeginMarkedContentProperties(Name("Span"), Dictionary{Name("MCID"): Integer(0)})
+	c.BeginText()
+	c.SetFont(font, 12)
+	c.MoveText(72, 720)
+	c.ShowText(String("Hello (World)"))
+	c.ShowTextAdjusted(Array{String("A"), Integer(-120), String("B")})
+	c.SetFont(font, 10)
+	c.EndText()
+	c.EndMarkedContent()
+	c.DrawXObject(image)
+
+	stream, err := c.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "q\n" +
+		"2 0 0 2 10.5 20 cm\n" +
+		"0.25 w\n" +
+		"[3 1] 0 d\n" +
+		"1 0 0 RG\n" +
+		"0 0 m\n" +
+		"100 0 l\n" +
+		"1 2 3 4 5 6 c\n" +
+		"h\n" +
+		"S\n" +
+		"0 0 10 10 re\n" +
+		"W\n" +
+		"n\n" +
+		"/CS1 cs\n" +
+		"0.5 scn\n" +
+		"/X1 Do\n" +
+		"Q\n" +
+		"/Span <</MCID 0>> BDC\n" +
+		"BT\n" +
+		"/F2 12 Tf\n" +
+		"72 720 Td\n" +
+		"(Hello \\(World\\)) Tj\n" +
+		"[(A) -120 (B)] TJ\n" +
+		"/F2 10 Tf\n" +
+		"ET\n" +
+		"EMC\n" +
+		"/X1 Do\n"
+	if string(stream.Stream) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, stream.Stream)
+	}
+
+	// the resources are added once each, with names that are not used
+	expectedResources := Dictionary{
+		Name("Font"): Dictionary{
+			Name("F1"): ObjectReference{ObjectNumber: 4},
+			Name("F2"): font,
+		},
+		Name("XObject"):    Dictionary{Name("X1"): image},
+		Name("ColorSpace"): Dictionary{Name("CS1"): separation},
+	}
+	if !reflect.DeepEqual(c.Resources(), expectedResources) || !reflect.DeepEqual(resources, expectedResources) {
+		t.Errorf("expected resources %v, got %v", expectedResources, c.Resources())
+	}
+
+	// the content can be read back
+	instructions, err := ParseContent(stream.Stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 27 {
+		t.Errorf("expected 27 instructions, got %d", len(instructions))
+	}
+}
+
+func TestContentBuilderRealDecimals(t *testing.T) {
+	c := NewContentBuilder(nil)
+	c.RealDecimals = 2
+	c.Transform(1, 0, 0, 1, 1.0/3, 0.1+0.2)
+
+	stream, err := c.Stream(Filter{Name: Name("FlateDecode")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := stream.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "1 0 0 1 0.33 0.3 cm\n" {
+		t.Errorf("incorrect content %q", decoded)
+	}
+}
+
+func TestContentBuilderErrors(t *testing.T) {
+	for _, test := range []struct {
+		build func(c *ContentBuilder)
+		err   string
+	}{
+		{func(c *ContentBuilder) { c.Restore() }, "Q without q"},
+		{func(c *ContentBuilder) { c.Save(); c.Save(); c.Restore() }, "1 q without Q"},
+		{func(c *ContentBuilder) { c.ShowText(String("a")) }, "Tj outside of a text object"},
+		{func(c *ContentBuilder) { c.BeginText(); c.Save() }, "q in a text object"},
+		{func(c *ContentBuilder) { c.BeginText() }, "BT without ET"},
+		{func(c *ContentBuilder) { c.EndText() }, "ET without BT"},
+		{func(c *ContentBuilder) { c.LineTo(1, 1) }, "l without a current point"},
+		{func(c *ContentBuilder) { c.MoveTo(1, 1); c.SetFillGray(0) }, "g before the path is painted"},
+		{func(c *ContentBuilder) { c.MoveTo(1, 1) }, "path is not painted"},
+		{func(c *ContentBuilder) { c.EndMarkedContent() }, "EMC without BMC or BDC"},
+		{func(c *ContentBuilder) { c.BeginMarkedContent(Name("Tag")) }, "1 BMC or BDC without EMC"},
+		{func(c *ContentBuilder) { c.SetLineWidth(math.NaN()) }, "cannot write NaN"},
+	} {
+		c := NewContentBuilder(nil)
+		test.build(c)
+		_, err := c.Stream()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected an error containing %q, got %v", test.err, err)
+		}
+	}
+
+	// resource dictionaries that are references cannot be added to
+	c := NewContentBuilder(Dictionary{Name("Font"): ObjectReference{ObjectNumber: 1}})
+	c.BeginText()
+	c.SetFont(ObjectReference{ObjectNumber: 2}, 12)
+	c.EndText()
+	_, err := c.Stream()
+	if err == nil || !strings.Contains(err.Error(), "Font resources are not a direct dictionary") {
+		t.Errorf("expected an error about the Font resources, got %v", err)
+	}
+
+	// the text state operators are allowed outside of text objects
+	c = NewContentBuilder(nil)
+	c.SetCharSpacing(1)
+	c.SetWordSpacing(2)
+	c.SetHorizontalScaling(90)
+	c.SetLeading(12)
+	c.SetFont(Dictionary{Name("Type"): Name("Font")}, 10)
+	c.SetTextRenderingMode(1)
+	c.SetTextRise(3)
+	_, err = c.Stream()
+	if err != nil {
+		t.Errorf("expected text state operators outside of a text object, got %v", err)
+	}
+}
+
+// string operands are written so they are read back unchanged
+func TestContentBuilderStrings(t *testing.T) {
+	text := String("\x00\\ (\n")
+
+	c := NewContentBuilder(nil)
+	c.BeginText()
+	c.ShowText(text)
+	c.ShowTextAdjusted(Array{text, Integer(-100), text})
+	c.EndText()
+	stream, err := c.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instructions, err := ParseContent(stream.Stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 4 {
+		t.Fatalf("expected 4 instructions, got %v", instructions)
+	}
+	err = compare(instructions[1].Operands, []Object{text})
+	if err != nil {
+		t.Error(err)
+	}
+	err = compare(instructions[2].Operands, []Object{Array{text, Integer(-100), text}})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,590 @@
+package pdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Decoding of the facsimile codings of ITU-T T.4 and T.6, used by
+// CCITTFaxDecode (§7.4.6) and the MMR coded regions of JBIG2.
+//
+// Rows are decoded as lists of changing elements, the positions
+// where the color changes from that of the pixel before them. The
+// first element of a row is where the first black run starts, as
+// each row starts with white.
+
+// the most columns that can be decoded, as a row is allocated
+// before its data is read
+const maxCCITTColumns = 1 << 20
+
+// ccittParameters are those of CCITTFaxDecode, §7.4.6 Table 11
+type ccittParameters struct {
+	// K < 0 is two-dimensional coding (Group 4), K = 0 is
+	// one-dimensional coding (Group 3) and K > 0 is mixed coding,
+	// each row being either, as given by its tag bit
+	k int
+
+	columns int
+	rows    int // not known when zero or less
+
+	encodedByteAlign bool
+	endOfLine        bool
+	blackIs1         bool
+
+	// the number of rows that are repeated from the previous row
+	// when they cannot be decoded, before it is an error
+	damagedRowsBeforeError int
+}
+
+// ccittReader decodes rows of 1 bit pixels, 0 being black
+// unless blackIs1
+type ccittReader struct {
+	bits       ccittBits
+	parameters ccittParameters
+
+	// changing elements of the reference line, which is the
+	// previous row, followed by three at the end of the row
+	// so that b1 and b2 can always be found
+	reference []int
+	coding    []int
+
+	row     []byte
+	pending []byte
+	decoded int
+	damaged int
+	err     error
+}
+
+func newCCITTReader(r io.Reader, parameters ccittParameters) (*ccittReader, error) {
+	if parameters.columns < 1 || parameters.columns > maxCCITTColumns {
+		return nil, fmt.Errorf("cannot decode rows of %d columns", parameters.columns)
+	}
+
+	columns := parameters.columns
+	z := &ccittReader{
+		bits:       ccittBits{r: bufio.NewReader(r)},
+		parameters: parameters,
+		reference:  []int{columns, columns, columns},
+		row:        make([]byte, (columns+7)/8),
+	}
+	return z, nil
+}
+
+func (z *ccittReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(z.pending) == 0 {
+			if z.err != nil {
+				return n, z.err
+			}
+			z.err = z.nextRow()
+			if z.err != nil {
+				continue
+			}
+			z.pack()
+			z.pending = z.row
+		}
+
+		copied := copy(p[n:], z.pending)
+		z.pending = z.pending[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// nextRow decodes the next row into the reference line
+func (z *ccittReader) nextRow() error {
+	parameters := z.parameters
+	if parameters.rows > 0 && z.decoded >= parameters.rows {
+		return io.EOF
+	}
+
+	if parameters.k >= 0 {
+		// fill bits and the end of line before the row, where two
+		// ends of line are the end of the data (RTC of T.4)
+		eol, err := z.endOfLine()
+		if err != nil {
+			return err
+		}
+		if eol && parameters.k == 0 && z.bits.peek(12) == 1 {
+			return io.EOF
+		}
+		// fill bits that align the row can also be after
+		// the end of line, unless a tag bit follows it
+		if parameters.encodedByteAlign && (parameters.k == 0 || !eol) {
+			z.bits.align()
+			if eol && parameters.k == 0 && z.bits.endOfLineNext() {
+				return io.EOF
+			}
+		}
+	} else {
+		if parameters.encodedByteAlign {
+			z.bits.align()
+		}
+		if z.bits.peek(12) == 1 {
+			// the end of the data (EOFB of T.6)
+			return io.EOF
+		}
+	}
+	if z.bits.exhausted() {
+		return z.end()
+	}
+
+	twoDimensional := parameters.k < 0
+	if parameters.k > 0 {
+		twoDimensional = z.bits.read(1) == 0
+		if z.bits.endOfLineNext() {
+			return io.EOF
+		}
+	}
+
+	var err error
+	if twoDimensional {
+		err = z.decode2D()
+	} else {
+		err = z.decode1D()
+	}
+	if z.bits.err != nil {
+		return z.bits.err
+	}
+	if z.bits.past > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		if parameters.k < 0 || !parameters.endOfLine || z.damaged >= parameters.damagedRowsBeforeError {
+			return err
+		}
+
+		// the previous row is repeated, decoding
+		// resumes at the next end of line
+		z.damaged++
+		for z.bits.peek(12) != 1 && !z.bits.exhausted() {
+			z.bits.skip(1)
+		}
+		z.decoded++
+		return nil
+	}
+
+	z.reference, z.coding = z.coding, z.reference
+	z.decoded++
+	return nil
+}
+
+// endOfLine skips the fill bits before a row and its end of line,
+// reporting whether there was an end of line
+func (z *ccittReader) endOfLine() (bool, error) {
+	if !z.parameters.endOfLine && z.parameters.encodedByteAlign {
+		if z.bits.exhausted() {
+			return false, z.end()
+		}
+		return z.alignedEndOfLine(), nil
+	}
+
+	for z.bits.peek(12) != 1 {
+		if z.bits.exhausted() {
+			return false, z.end()
+		}
+		// without EndOfLine, only fill bits are skipped
+		if !z.parameters.endOfLine && z.bits.peek(12) != 0 {
+			return false, nil
+		}
+		z.bits.skip(1)
+	}
+	z.bits.skip(12)
+	return true, nil
+}
+
+// alignedEndOfLine skips an end of line when rows are aligned without
+// EndOfLine, where there are only those of the end of the data. As a
+// row cannot start with 8 0 bits, fill bits and an end of line end
+// after the first byte of the row, or with it when followed by another
+// end of line, since the codes of runs of 1792 and more can end there.
+func (z *ccittReader) alignedEndOfLine() bool {
+	// without fill bits, directly followed by another
+	if z.bits.peek(24) == 1<<12|1 {
+		z.bits.skip(12)
+		return true
+	}
+
+	fill := z.bits.n % 8
+	n := fill
+	for n < 12 {
+		n += 8
+	}
+	first := n == fill+8 && z.parameters.k == 0
+	for {
+		switch z.bits.peek(n) {
+		case 1:
+			if first && z.bits.peek(n+16)&0xffff >= 1<<5 {
+				return false
+			}
+			z.bits.skip(n)
+			return true
+		case 0:
+			if z.bits.exhausted() {
+				return false
+			}
+			z.bits.skip(8)
+			first = false
+		default:
+			return false
+		}
+	}
+}
+
+// end returns the error for the end of the data before the next row
+func (z *ccittReader) end() error {
+	if z.bits.err != nil {
+		return z.bits.err
+	}
+	if z.parameters.rows > 0 && z.decoded < z.parameters.rows {
+		return io.ErrUnexpectedEOF
+	}
+	return io.EOF
+}
+
+var (
+	errCCITTCode = errors.New("invalid code")
+	errCCITTRow  = errors.New("row does not match the number of columns")
+)
+
+// decode1D decodes a row of runs, alternately white and black
+func (z *ccittReader) decode1D() error {
+	columns := z.parameters.columns
+	coding := z.coding[:0]
+	position, white := 0, true
+	for position < columns {
+		run, err := z.run(white)
+		if err != nil {
+			return err
+		}
+		position += run
+		if position > columns {
+			return errCCITTRow
+		}
+		coding = append(coding, position)
+		white = !white
+	}
+
+	z.coding = append(coding, columns, columns, columns)
+	return nil
+}
+
+// decode2D decodes a row coded relative to the reference line,
+// with the modes of T.4 4.2.1.3
+func (z *ccittReader) decode2D() error {
+	columns := z.parameters.columns
+	reference := z.reference
+	coding := z.coding[:0]
+
+	// a0 starts before the row, the next element
+	// of the reference line after a0 is at i
+	a0, white, i := -1, true, 0
+	for a0 < columns {
+		for reference[i] <= a0 {
+			i++
+		}
+		// b1 is the first changing element after a0 that starts
+		// a run of the other color, b2 is the one after it
+		b1 := i
+		if (b1%2 == 0) != white {
+			b1++
+		}
+		b2 := b1 + 1
+
+		mode, ok := z.bits.decode(ccittModes)
+		if !ok {
+			return errCCITTCode
+		}
+
+		start := a0
+		if start < 0 {
+			start = 0
+		}
+		switch {
+		case mode == ccittPass:
+			a0 = reference[b2]
+		case mode == ccittHorizontal:
+			run1, err := z.run(white)
+			if err != nil {
+				return err
+			}
+			run2, err := z.run(!white)
+			if err != nil {
+				return err
+			}
+			a1 := start + run1
+			a2 := a1 + run2
+			if a2 > columns {
+				return errCCITTRow
+			}
+			coding = append(coding, a1, a2)
+			a0 = a2
+		case mode >= ccittVertical:
+			a1 := reference[b1] + mode - ccittVertical - 3
+			if a1 < start || a1 > columns {
+				return errCCITTRow
+			}
+			coding = append(coding, a1)
+			a0 = a1
+			white = !white
+		default:
+			// uncompressed mode is not supported
+			return errors.New("unsupported extension")
+		}
+	}
+
+	z.coding = append(coding, columns, columns, columns)
+	return nil
+}
+
+// run returns the length of a run, which is
+// make-up codes followed by a terminating code
+func (z *ccittReader) run(white bool) (int, error) {
+	table := ccittBlack
+	if white {
+		table = ccittWhite
+	}
+
+	length := 0
+	for {
+		value, ok := z.bits.decode(table)
+		if !ok {
+			return 0, errCCITTCode
+		}
+		length += value
+		if value < 64 {
+			return length, nil
+		}
+		if length > z.parameters.columns {
+			return 0, errCCITTRow
+		}
+	}
+}
+
+// pack sets row to the pixels of the reference line,
+// the bits after the last column being 0
+func (z *ccittReader) pack() {
+	var white, black byte = 0xff, 0
+	if z.parameters.blackIs1 {
+		white, black = 0, 0xff
+	}
+	for i := range z.row {
+		z.row[i] = white
+	}
+
+	columns := z.parameters.columns
+	reference := z.reference
+	for i := 0; i+1 < len(reference) && reference[i] < columns; i += 2 {
+		for x := reference[i]; x < reference[i+1] && x < columns; x++ {
+			z.row[x/8] ^= (white ^ black) & (0x80 >> uint(x%8))
+		}
+	}
+	if columns%8 != 0 {
+		z.row[len(z.row)-1] &= 0xff << uint(8-columns%8)
+	}
+}
+
+// ccittBits reads the bits of the coded data, most significant first
+type ccittBits struct {
+	r   io.ByteReader
+	err error // other than io.EOF
+
+	// the next n bits, in the high bits
+	bits uint64
+	n    uint
+	eof  bool
+
+	// the number of bits skipped after the end of the data
+	past int
+}
+
+// fill reads bytes until at least 57 bits are buffered
+func (b *ccittBits) fill() {
+	for b.n <= 56 && !b.eof {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				b.err = err
+			}
+			b.eof = true
+			return
+		}
+		b.bits |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+// peek returns the next n bits, which are 0 after the end of the data
+func (b *ccittBits) peek(n uint) uint32 {
+	if b.n < n {
+		b.fill()
+	}
+	return uint32(b.bits >> (64 - n))
+}
+
+func (b *ccittBits) skip(n uint) {
+	if b.n < n {
+		b.fill()
+	}
+	if n > b.n {
+		b.past += int(n - b.n)
+		b.bits, b.n = 0, 0
+		return
+	}
+	b.bits <<= n
+	b.n -= n
+}
+
+func (b *ccittBits) read(n uint) uint32 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// align skips to the next byte boundary
+func (b *ccittBits) align() {
+	b.skip(b.n % 8)
+}
+
+// endOfLineNext reports whether an end of line, which can be
+// after fill bits, is next rather than the code of a row, as
+// those start with fewer than 11 0 bits
+func (b *ccittBits) endOfLineNext() bool {
+	return bits.LeadingZeros32(b.peek(32)) >= 11 && !b.exhausted()
+}
+
+// exhausted reports whether the rest of the data is only
+// 0 bits, such as those that pad the last byte
+func (b *ccittBits) exhausted() bool {
+	b.fill()
+	return b.eof && b.bits == 0
+}
+
+// decode returns the value of the next code in the table
+func (b *ccittBits) decode(table ccittTable) (int, bool) {
+	node := 0
+	for {
+		next := table[node][b.read(1)]
+		switch {
+		case next == 0:
+			return 0, false
+		case next < 0:
+			return int(-next - 1), true
+		}
+		node = int(next)
+	}
+}
+
+// ccittTable is a binary tree of codes, each node having the indexes
+// of the nodes after a 0 and a 1 bit. Codes end at negative indexes,
+// which are their values plus one, negated. Invalid codes end at 0.
+type ccittTable [][2]int32
+
+func newCCITTTable(codes map[string]int) ccittTable {
+	table := ccittTable{{}}
+	for code, value := range codes {
+		node := 0
+		for i := 0; i < len(code); i++ {
+			bit := code[i] - '0'
+			if i == len(code)-1 {
+				table[node][bit] = int32(-value - 1)
+				break
+			}
+			if table[node][bit] == 0 {
+				table = append(table, [2]int32{})
+				table[node][bit] = int32(len(table) - 1)
+			}
+			node = int(table[node][bit])
+		}
+	}
+	return table
+}
+
+// modes of two-dimensional coding, vertical modes
+// being ccittVertical plus 3 plus a1 - b1
+const (
+	ccittPass = iota
+	ccittHorizontal
+	ccittExtension
+	ccittVertical
+)
+
+var (
+	ccittModes = newCCITTTable(map[string]int{
+		"0001":    ccittPass,
+		"001":     ccittHorizontal,
+		"0000001": ccittExtension,
+		"0000010": ccittVertical + 0,
+		"000010":  ccittVertical + 1,
+		"010":     ccittVertical + 2,
+		"1":       ccittVertical + 3,
+		"011":     ccittVertical + 4,
+		"000011":  ccittVertical + 5,
+		"0000011": ccittVertical + 6,
+	})
+	ccittWhite = newCCITTTable(ccittRunCodes(ccittWhiteTerminating, ccittWhiteMakeUp))
+	ccittBlack = newCCITTTable(ccittRunCodes(ccittBlackTerminating, ccittBlackMakeUp))
+)
+
+// ccittRunCodes returns the codes of run lengths from the terminating
+// codes of 0 to 63 and the make-up codes of 64 to 1728, T.4 Tables 2
+// and 3, with the make-up codes of 1792 to 2560 shared by both colors
+func ccittRunCodes(terminating [64]string, makeUp [27]string) map[string]int {
+	codes := map[string]int{}
+	for length, code := range terminating {
+		codes[code] = length
+	}
+	for i, code := range makeUp {
+		codes[code] = 64 * (i + 1)
+	}
+	for i, code := range ccittExtendedMakeUp {
+		codes[code] = 1792 + 64*i
+	}
+	return codes
+}
+
+var ccittWhiteTerminating = [64]string{
+	"00110101", "000111", "0111", "1000", "1011", "1100", "1110", "1111",
+	"10011", "10100", "00111", "01000", "001000", "000011", "110100", "110101",
+	"101010", "101011", "0100111", "0001100", "0001000", "0010111", "0000011", "0000100",
+	"0101000", "0101011", "0010011", "0100100", "0011000", "00000010", "00000011", "00011010",
+	"00011011", "00010010", "00010011", "00010100", "00010101", "00010110", "00010111", "00101000",
+	"00101001", "00101010", "00101011", "00101100", "00101101", "00000100", "00000101", "00001010",
+	"00001011", "01010010", "01010011", "01010100", "01010101", "00100100", "00100101", "01011000",
+	"01011001", "01011010", "01011011", "01001010", "01001011", "00110010", "00110011", "00110100",
+}
+
+var ccittWhiteMakeUp = [27]string{
+	"11011", "10010", "010111", "0110111", "00110110", "00110111", "01100100", "01100101",
+	"01101000", "01100111", "011001100", "011001101", "011010010", "011010011", "011010100", "011010101",
+	"011010110", "011010111", "011011000", "011011001", "011011010", "011011011", "010011000", "010011001",
+	"010011010", "011000", "010011011",
+}
+
+var ccittBlackTerminating = [64]string{
+	"0000110111", "010", "11", "10", "011", "0011", "0010", "00011",
+	"000101", "000100", "0000100", "0000101", "0000111", "00000100", "00000111", "000011000",
+	"0000010111", "0000011000", "0000001000", "00001100111", "00001101000", "00001101100", "00000110111", "00000101000",
+	"00000010111", "00000011000", "000011001010", "000011001011", "000011001100", "000011001101", "000001101000", "000001101001",
+	"000001101010", "000001101011", "000011010010", "000011010011", "000011010100", "000011010101", "000011010110", "000011010111",
+	"000001101100", "000001101101", "000011011010", "000011011011", "000001010100", "000001010101", "000001010110", "000001010111",
+	"000001100100", "000001100101", "000001010010", "000001010011", "000000100100", "000000110111", "000000111000", "000000100111",
+	"000000101000", "000001011000", "000001011001", "000000101011", "000000101100", "000001011010", "000001100110", "000001100111",
+}
+
+var ccittBlackMakeUp = [27]string{
+	"0000001111", "000011001000", "000011001001", "000001011011", "000000110011", "000000110100", "000000110101", "0000001101100",
+	"0000001101101", "0000001001010", "0000001001011", "0000001001100", "0000001001101", "0000001110010", "0000001110011", "0000001110100",
+	"0000001110101", "0000001110110", "0000001110111", "0000001010010", "0000001010011", "0000001010100", "0000001010101", "0000001011010",
+	"0000001011011", "0000001100100", "0000001100101",
+}
+
+var ccittExtendedMakeUp = [13]string{
+	"00000001000", "00000001100", "00000001101", "000000010010", "000000010011", "000000010100", "000000010101",
+	"000000010110", "000000010111", "000000011100", "000000011101", "000000011110", "000000011111",
+}
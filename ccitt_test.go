@@ -0,0 +1,355 @@
+package pdf
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// ccittEncoder codes rows with T.4 and T.6,
+// used to make test data for the decoder
+type ccittEncoder struct {
+	parameters ccittParameters
+	out        []byte
+	n          uint // bits used in the last byte
+
+	// put the fill bits that align rows after an end of line
+	// rather than before it, when they are not followed by a tag bit
+	alignAfterEndOfLine bool
+
+	reference []int
+	rows      int
+}
+
+func newCCITTEncoder(parameters ccittParameters) *ccittEncoder {
+	columns := parameters.columns
+	return &ccittEncoder{
+		parameters: parameters,
+		reference:  []int{columns, columns, columns},
+	}
+}
+
+func (e *ccittEncoder) put(code string) {
+	for i := 0; i < len(code); i++ {
+		if e.n%8 == 0 {
+			e.out = append(e.out, 0)
+			e.n = 0
+		}
+		if code[i] == '1' {
+			e.out[len(e.out)-1] |= 0x80 >> e.n
+		}
+		e.n++
+	}
+}
+
+// align puts fill bits so that the next code starts at a byte boundary
+func (e *ccittEncoder) align() {
+	e.n = 8
+}
+
+// endOfLine puts an end of line, and fill bits when
+// aligned so that it ends at a byte boundary
+func (e *ccittEncoder) endOfLine() {
+	if e.parameters.encodedByteAlign && !e.alignAfterEndOfLine {
+		for (e.n+12)%8 != 0 {
+			e.put("0")
+		}
+	}
+	e.put("000000000001")
+}
+
+// row codes a row of pixels, true being black, two-dimensionally
+// when K < 0 or for all but every K-th row when K > 0
+func (e *ccittEncoder) row(pixels []bool) {
+	parameters := e.parameters
+	twoDimensional := parameters.k < 0 || parameters.k > 0 && e.rows%parameters.k != 0
+
+	if parameters.endOfLine && parameters.k >= 0 {
+		e.endOfLine()
+		if parameters.encodedByteAlign && e.alignAfterEndOfLine && parameters.k == 0 {
+			e.align()
+		}
+	} else if parameters.encodedByteAlign {
+		e.align()
+	}
+	if parameters.k > 0 {
+		if twoDimensional {
+			e.put("0")
+		} else {
+			e.put("1")
+		}
+	}
+
+	coding := []int{}
+	for x := range pixels {
+		previous := x > 0 && pixels[x-1]
+		if pixels[x] != previous {
+			coding = append(coding, x)
+		}
+	}
+	coding = append(coding, len(pixels), len(pixels), len(pixels))
+
+	if twoDimensional {
+		e.code2D(coding)
+	} else {
+		e.code1D(coding)
+	}
+	e.reference = coding
+	e.rows++
+}
+
+func (e *ccittEncoder) code1D(coding []int) {
+	position, white := 0, true
+	for i := 0; position < e.parameters.columns; i++ {
+		e.run(coding[i]-position, white)
+		position, white = coding[i], !white
+	}
+}
+
+// the encoding procedure of T.4 Figure 7
+func (e *ccittEncoder) code2D(coding []int) {
+	columns := e.parameters.columns
+	reference := e.reference
+	verticalCodes := []string{"0000010", "000010", "010", "1", "011", "000011", "0000011"}
+
+	a0, white := -1, true
+	for a0 < columns {
+		a1 := 0
+		for coding[a1] <= a0 {
+			a1++
+		}
+		b1 := 0
+		for reference[b1] <= a0 || (b1%2 == 0) != white {
+			b1++
+		}
+		b2 := reference[b1+1]
+
+		start := a0
+		if start < 0 {
+			start = 0
+		}
+		switch d := coding[a1] - reference[b1]; {
+		case b2 < coding[a1]:
+			e.put("0001")
+			a0 = b2
+		case d >= -3 && d <= 3:
+			e.put(verticalCodes[d+3])
+			a0 = coding[a1]
+			white = !white
+		default:
+			e.put("001")
+			e.run(coding[a1]-start, white)
+			e.run(coding[a1+1]-coding[a1], !white)
+			a0 = coding[a1+1]
+		}
+	}
+}
+
+func (e *ccittEncoder) run(length int, white bool) {
+	terminating, makeUp := ccittBlackTerminating, ccittBlackMakeUp
+	if white {
+		terminating, makeUp = ccittWhiteTerminating, ccittWhiteMakeUp
+	}
+	for length >= 2560 {
+		e.put(ccittExtendedMakeUp[12])
+		length -= 2560
+	}
+	switch {
+	case length >= 1792:
+		e.put(ccittExtendedMakeUp[length/64-28])
+	case length >= 64:
+		e.put(makeUp[length/64-1])
+	}
+	e.put(terminating[length%64])
+}
+
+// end puts the end of the data, RTC or EOFB
+func (e *ccittEncoder) end() []byte {
+	if e.parameters.k < 0 {
+		if e.parameters.encodedByteAlign {
+			e.align()
+		}
+		e.put("000000000001000000000001")
+		return e.out
+	}
+	for i := 0; i < 6; i++ {
+		e.endOfLine()
+		if e.parameters.k > 0 {
+			e.put("1")
+		}
+	}
+	return e.out
+}
+
+// ccittTestImage has runs of all lengths up to its width, long runs
+// needing make-up codes and rows coded with each two-dimensional mode
+func ccittTestImage(columns, rows int) [][]bool {
+	image := make([][]bool, rows)
+	for y := range image {
+		image[y] = make([]bool, columns)
+		switch y % 5 {
+		case 0:
+			// runs of increasing lengths
+			black, length := false, 1
+			for x := 0; x < columns; {
+				for i := 0; i < length && x < columns; i++ {
+					image[y][x] = black
+					x++
+				}
+				black, length = !black, length+y+1
+			}
+		case 1:
+			// the previous row shifted
+			for x := 0; x < columns; x++ {
+				image[y][x] = image[y-1][(x+columns-y%4)%columns]
+			}
+		case 2:
+			// a long black run
+			for x := columns / 5; x < columns-y; x++ {
+				image[y][x] = true
+			}
+		case 3:
+			// a long white run, after a black one as rows without
+			// ends of line cannot always start with one when aligned
+			image[y][0] = true
+		case 4:
+			for x := 0; x < columns; x++ {
+				image[y][x] = (x/3+y)%7 == 0 || x == columns-1
+			}
+		}
+	}
+	return image
+}
+
+// ccittPack returns the rows packed as decoded
+func ccittPack(image [][]bool, blackIs1 bool) []byte {
+	packed := []byte{}
+	for _, pixels := range image {
+		row := make([]byte, (len(pixels)+7)/8)
+		for x, black := range pixels {
+			if black == blackIs1 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		packed = append(packed, row...)
+	}
+	return packed
+}
+
+func TestCCITT(t *testing.T) {
+	type test struct {
+		parameters          ccittParameters
+		alignAfterEndOfLine bool
+	}
+	tests := []test{}
+	for _, k := range []int{-1, 0, 1, 4} {
+		for _, align := range []bool{false, true} {
+			for _, eol := range []bool{false, true} {
+				for _, rows := range []int{0, 23} {
+					tests = append(tests, test{
+						parameters: ccittParameters{k: k, columns: 3000, rows: rows, encodedByteAlign: align, endOfLine: eol, blackIs1: rows == 0},
+					})
+				}
+			}
+		}
+	}
+	tests = append(tests,
+		test{parameters: ccittParameters{k: 0, columns: 153, encodedByteAlign: true}, alignAfterEndOfLine: true},
+		test{parameters: ccittParameters{k: 0, columns: 153, encodedByteAlign: true, endOfLine: true}, alignAfterEndOfLine: true},
+		test{parameters: ccittParameters{k: 2, columns: 1}},
+		test{parameters: ccittParameters{k: -1, columns: 7}},
+	)
+
+	for _, test := range tests {
+		parameters := test.parameters
+		image := ccittTestImage(parameters.columns, 23)
+
+		e := newCCITTEncoder(parameters)
+		e.alignAfterEndOfLine = test.alignAfterEndOfLine
+		for _, row := range image {
+			e.row(row)
+		}
+		data := e.out
+		if parameters.rows == 0 {
+			data = e.end()
+		}
+
+		r, err := newCCITTReader(bytes.NewReader(data), parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%+v: %v", parameters, err)
+			continue
+		}
+		if !bytes.Equal(decoded, ccittPack(image, parameters.blackIs1)) {
+			t.Errorf("%+v: decoded rows do not match", parameters)
+		}
+	}
+}
+
+func TestCCITTDamagedRows(t *testing.T) {
+	parameters := ccittParameters{k: 2, columns: 40, rows: 5, endOfLine: true}
+	image := ccittTestImage(parameters.columns, parameters.rows)
+
+	e := newCCITTEncoder(parameters)
+	for y, row := range image {
+		e.row(row)
+		if y == 1 {
+			// a one-dimensional row with an invalid code
+			e.endOfLine()
+			e.put("1000000001")
+		}
+	}
+	expected := append([][]bool{}, image[:2]...)
+	expected = append(expected, image[1], image[2], image[3])
+
+	for _, test := range []struct {
+		damaged int
+		err     bool
+	}{
+		{0, true},
+		{1, false},
+	} {
+		parameters.damagedRowsBeforeError = test.damaged
+		r, err := newCCITTReader(bytes.NewReader(e.out), parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if test.err {
+			if err == nil {
+				t.Errorf("%d damaged rows: expected an error", test.damaged)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d damaged rows: %v", test.damaged, err)
+			continue
+		}
+		if !bytes.Equal(decoded, ccittPack(expected, false)) {
+			t.Errorf("%d damaged rows: decoded rows do not match", test.damaged)
+		}
+	}
+}
+
+func TestCCITTTruncated(t *testing.T) {
+	parameters := ccittParameters{k: -1, columns: 100, rows: 10}
+	e := newCCITTEncoder(parameters)
+	for _, row := range ccittTestImage(parameters.columns, parameters.rows) {
+		e.row(row)
+	}
+
+	for _, length := range []int{0, len(e.out) / 2, len(e.out) - 1} {
+		r, err := newCCITTReader(bytes.NewReader(e.out[:length]), parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(r)
+		if err != io.ErrUnexpectedEOF && err != errCCITTCode && err != errCCITTRow {
+			t.Errorf("%d bytes: expected the data to be truncated, got %v", length, err)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// An Instruction is an operator in a content stream
+// and the operands that precede it.
+// - §7.8.2
+//
+// An inline image (§8.9.7) is one instruction whose Operator is BI
+// and whose only operand is a Stream holding the image's dictionary
+// and its data. Abbreviated keys, filter names and color space names
+// in the dictionary are replaced by their full names, so that the
+// Stream can be decoded. The ID and EI operators of the image are not
+// returned.
+type Instruction struct {
+	Operator string
+	Operands []Object
+
+	// Offset is where the instruction starts in the content
+	// stream, which is at its first operand if it has any.
+	Offset int
+}
+
+// operators defined for content streams
+// - §A.2
+var operators = map[string]bool{
+	"b": true, "B": true, "b*": true, "B*": true, "BDC": true, "BI": true,
+	"BMC": true, "BT": true, "BX": true, "c": true, "cm": true, "CS": true,
+	"cs": true, "d": true, "d0": true, "d1": true, "Do": true, "DP": true,
+	"EI": true, "EMC": true, "ET": true, "EX": true, "f": true, "F": true,
+	"f*": true, "G": true, "g": true, "gs": true, "h": true, "i": true,
+	"ID": true, "j": true, "J": true, "K": true, "k": true, "l": true,
+	"m": true, "M": true, "MP": true, "n": true, "q": true, "Q": true,
+	"re": true, "RG": true, "rg": true, "ri": true, "s": true, "S": true,
+	"SC": true, "sc": true, "SCN": true, "scn": true, "sh": true, "T*": true,
+	"Tc": true, "Td": true, "TD": true, "Tf": true, "Tj": true, "TJ": true,
+	"TL": true, "Tm": true, "Tr": true, "Ts": true, "Tw": true, "Tz": true,
+	"v": true, "w": true, "W": true, "W*": true, "y": true, "'": true,
+	"\"": true,
+}
+
+// number of instructions after EI that are checked to be content
+// when finding the end of an inline image's data
+const inlineImageLookahead = 2
+
+// ContentParser reads the instructions of a content stream, which is
+// the decoded data of a page's Contents or of a form XObject, pattern
+// or Type 3 glyph.
+type ContentParser struct {
+	data   []byte
+	offset int
+}
+
+// NewContentParser returns a ContentParser that reads the instructions in data.
+func NewContentParser(data []byte) *ContentParser {
+	return &ContentParser{data: data}
+}
+
+// ParseContent returns the instructions in the content stream data.
+func ParseContent(data []byte) ([]Instruction, error) {
+	instructions := []Instruction{}
+	parser := NewContentParser(data)
+	for {
+		instruction, err := parser.Next()
+		if err == io.EOF {
+			return instructions, nil
+		}
+		if err != nil {
+			return instructions, err
+		}
+		instructions = append(instructions, instruction)
+	}
+}
+
+// Next returns the next instruction, or io.EOF when there are no more.
+// Operators that are not defined (such as those in BX/EX compatibility
+// sections) are returned like the others.
+func (p *ContentParser) Next() (instruction Instruction, err error) {
+	instruction.Offset = -1
+	for {
+		start, object, operator, err := p.token()
+		if err != nil {
+			if err == io.EOF && len(instruction.Operands) > 0 {
+				return instruction, fmt.Errorf("content stream offset %d: operands without an operator", instruction.Offset)
+			}
+			return instruction, err
+		}
+		if instruction.Offset < 0 {
+			instruction.Offset = start
+		}
+
+		if operator == "" {
+			instruction.Operands = append(instruction.Operands, object)
+			continue
+		}
+
+		instruction.Operator = operator
+		if operator == "BI" {
+			if len(instruction.Operands) > 0 {
+				return instruction, fmt.Errorf("content stream offset %d: BI has operands", start)
+			}
+
+			image, err := p.inlineImage()
+			if err != nil {
+				return instruction, err
+			}
+			instruction.Operands = []Object{image}
+		}
+
+		return instruction, nil
+	}
+}
+
+// token reads the next operand or operator, returning where it starts.
+// Only one of object and operator is set.
+func (p *ContentParser) token() (int, Object, string, error) {
+	// skip white space and comments
+	for p.offset < len(p.data) {
+		if isWhitespace(p.data[p.offset]) {
+			p.offset++
+			continue
+		}
+		if p.data[p.offset] == '%' {
+			for p.offset < len(p.data) && p.data[p.offset] != '\n' && p.data[p.offset] != '\r' {
+				p.offset++
+			}
+			continue
+		}
+		break
+	}
+	if p.offset >= len(p.data) {
+		return p.offset, nil, "", io.EOF
+	}
+
+	start := p.offset
+	slice := p.data[start:]
+
+	var parser parseFn
+	switch slice[0] {
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '+', '-', '.':
+		// content streams do not have object references
+		parser = parseNumeric
+	case '(':
+		parser = parseLiteralString
+	case '/':
+		parser = parseName
+	case '[':
+		parser = parseArray
+	case '<':
+		if len(slice) > 1 && slice[1] == '<' {
+			parser = parseDictionary
+		} else {
+			parser = parseHexadecimalString
+		}
+	case ')', '>', ']', '{', '}':
+		return start, nil, "", fmt.Errorf("content stream offset %d: unexpected %q", start, slice[0])
+	}
+
+	if parser != nil {
+		object, n, err := parser(slice)
+		if err != nil {
+			return start, nil, "", fmt.Errorf("content stream offset %d: %v", start+n, err)
+		}
+		p.offset += n
+		return start, object, "", nil
+	}
+
+	// a sequence of regular characters
+	token, n := nextToken(slice)
+	p.offset += n
+	switch string(token) {
+	case "true":
+		return start, Boolean(true), "", nil
+	case "false":
+		return start, Boolean(false), "", nil
+	case "null":
+		return start, Null{}, "", nil
+	}
+	return start, nil, string(token), nil
+}
+
+// inlineImage reads the dictionary and data of an inline image,
+// after its BI operator, through its EI operator
+// - §8.9.7
+func (p *ContentParser) inlineImage() (Stream, error) {
+	dict := Dictionary{}
+	for {
+		start, object, operator, err := p.token()
+		if err == io.EOF {
+			return Stream{}, fmt.Errorf("content stream offset %d: inline image without ID", start)
+		}
+		if err != nil {
+			return Stream{}, err
+		}
+		if operator == "ID" {
+			break
+		}
+
+		key, ok := object.(Name)
+		if !ok {
+			return Stream{}, fmt.Errorf("content stream offset %d: expected a key of the inline image", start)
+		}
+		start, value, operator, err := p.token()
+		if err == io.EOF || operator != "" {
+			return Stream{}, fmt.Errorf("content stream offset %d: expected a value for %v", start, key)
+		}
+		if err != nil {
+			return Stream{}, err
+		}
+		dict[key] = value
+	}
+	dict = expandInlineImage(dict)
+
+	// ID is followed by a single white-space character
+	if p.offset < len(p.data) && isWhitespace(p.data[p.offset]) {
+		p.offset++
+	}
+	start := p.offset
+
+	if length, ok := inlineImageLength(dict, len(p.data)-start); ok {
+		end := start + length
+		n, ok := match(p.data[end:], "EI")
+		if ok {
+			p.offset = end + n
+			return Stream{Dictionary: dict, Stream: p.data[start:end]}, nil
+		}
+	}
+
+	// ASCII encoded data ends at its end of data marker,
+	// which may be directly followed by EI
+	if length, ok := asciiDataLength(dict, p.data[start:]); ok {
+		end := start + length
+		n, ok := match(p.data[end:], "EI")
+		if ok {
+			p.offset = end + n
+			return Stream{Dictionary: dict, Stream: p.data[start:end]}, nil
+		}
+	}
+
+	// the data might contain EI, so its end is the
+	// first EI that is followed by more content
+	for i := start; i+2 <= len(p.data); i++ {
+		if p.data[i] != 'E' || p.data[i+1] != 'I' {
+			continue
+		}
+		if i > start && !isWhitespace(p.data[i-1]) {
+			continue
+		}
+		if i+2 < len(p.data) && !isWhitespace(p.data[i+2]) && !isDelimiter(p.data[i+2]) {
+			continue
+		}
+		if !isContent(p.data[i+2:]) {
+			continue
+		}
+
+		// the white space before EI is not part of the data
+		end := i
+		if end > start {
+			end--
+		}
+		p.offset = i + 2
+		return Stream{Dictionary: dict, Stream: p.data[start:end]}, nil
+	}
+
+	return Stream{}, fmt.Errorf("content stream offset %d: inline image without EI", start)
+}
+
+// full names of the abbreviated keys of inline images
+// - §8.9.7 Table 91
+var inlineImageKeys = map[Name]Name{
+	"BPC": "BitsPerComponent",
+	"CS":  "ColorSpace",
+	"D":   "Decode",
+	"DP":  "DecodeParms",
+	"F":   "Filter",
+	"H":   "Height",
+	"IM":  "ImageMask",
+	"I":   "Interpolate",
+	"L":   "Length",
+	"W":   "Width",
+}
+
+// full names of the abbreviated color spaces of inline images
+// - §8.9.7 Table 92
+var inlineImageColorSpaces = map[Name]Name{
+	"G":    "DeviceGray",
+	"RGB":  "DeviceRGB",
+	"CMYK": "DeviceCMYK",
+	"I":    "Indexed",
+}
+
+// full names of the abbreviated filters of inline images
+// - §8.9.7 Table 92
+var inlineImageFilters = map[Name]Name{
+	"AHx": "ASCIIHexDecode",
+	"A85": "ASCII85Decode",
+	"LZW": "LZWDecode",
+	"Fl":  "FlateDecode",
+	"RL":  "RunLengthDecode",
+	"CCF": "CCITTFaxDecode",
+	"DCT": "DCTDecode",
+}
+
+// expandInlineImage returns dict with the abbreviated
+// keys, color spaces and filters replaced by their full names
+func expandInlineImage(dict Dictionary) Dictionary {
+	expand := func(obj Object, names map[Name]Name) Object {
+		if name, ok := obj.(Name); ok {
+			if full, ok := names[name]; ok {
+				return full
+			}
+		}
+		return obj
+	}
+
+	expanded := Dictionary{}
+	for key, value := range dict {
+		expanded[expand(key, inlineImageKeys).(Name)] = value
+	}
+
+	switch cs := expanded[Name("ColorSpace")].(type) {
+	case Name:
+		expanded[Name("ColorSpace")] = expand(cs, inlineImageColorSpaces)
+	case Array:
+		// Indexed, whose base can also be abbreviated
+		cs = append(Array{}, cs...)
+		for i := 0; i < len(cs) && i < 2; i++ {
+			cs[i] = expand(cs[i], inlineImageColorSpaces)
+		}
+		expanded[Name("ColorSpace")] = cs
+	}
+
+	switch filter := expanded[Name("Filter")].(type) {
+	case Name:
+		expanded[Name("Filter")] = expand(filter, inlineImageFilters)
+	case Array:
+		filter = append(Array{}, filter...)
+		for i := range filter {
+			filter[i] = expand(filter[i], inlineImageFilters)
+		}
+		expanded[Name("Filter")] = filter
+	}
+
+	return expanded
+}
+
+// inlineImageLength returns the length of the data of an inline image
+// with the expanded dict, which is known when it has a Length or is not
+// filtered. Lengths over max are not returned.
+func inlineImageLength(dict Dictionary, max int) (int, bool) {
+	if length, ok := dict[Name("Length")].(Integer); ok && length >= 0 {
+		return int(length), length <= Integer(max)
+	}
+
+	if filter, ok := dict[Name("Filter")]; ok {
+		if array, ok := filter.(Array); !ok || len(array) > 0 {
+			return 0, false
+		}
+	}
+
+	width, ok := dict[Name("Width")].(Integer)
+	if !ok || width <= 0 {
+		return 0, false
+	}
+	height, ok := dict[Name("Height")].(Integer)
+	if !ok || height <= 0 {
+		return 0, false
+	}
+
+	components, bits := 0, 0
+	if mask, _ := dict[Name("ImageMask")].(Boolean); mask {
+		components, bits = 1, 1
+	} else {
+		switch cs := dict[Name("ColorSpace")].(type) {
+		case Name:
+			switch cs {
+			case Name("DeviceGray"):
+				components = 1
+			case Name("DeviceRGB"):
+				components = 3
+			case Name("DeviceCMYK"):
+				components = 4
+			default:
+				// the number of components of named
+				// color spaces is not known here
+				return 0, false
+			}
+		case Array:
+			if len(cs) == 0 || cs[0] != Name("Indexed") {
+				return 0, false
+			}
+			components = 1
+		default:
+			return 0, false
+		}
+
+		bpc, ok := dict[Name("BitsPerComponent")].(Integer)
+		if !ok || bpc <= 0 || bpc > 16 {
+			return 0, false
+		}
+		bits = int(bpc)
+	}
+
+	// rows start on byte boundaries, checking
+	// each step for lengths over max
+	bitsPerPixel := Integer(components * bits)
+	if width > (Integer(max)*8+7)/bitsPerPixel {
+		return 0, false
+	}
+	rowLength := (width*bitsPerPixel + 7) / 8
+	if height > Integer(max)/rowLength {
+		return 0, false
+	}
+	return int(rowLength * height), true
+}
+
+// asciiDataLength returns the length of the data of an inline image
+// with the expanded dict through the end of data marker of its first
+// filter, when that is ASCIIHexDecode or ASCII85Decode
+// - §7.4.2, §7.4.3
+func asciiDataLength(dict Dictionary, data []byte) (int, bool) {
+	filter := dict[Name("Filter")]
+	if array, ok := filter.(Array); ok && len(array) > 0 {
+		filter = array[0]
+	}
+
+	var marker []byte
+	switch filter {
+	case Name("ASCIIHexDecode"):
+		marker = []byte(">")
+	case Name("ASCII85Decode"):
+		marker = []byte("~>")
+	default:
+		return 0, false
+	}
+
+	i := bytes.Index(data, marker)
+	if i < 0 {
+		return 0, false
+	}
+	return i + len(marker), true
+}
+
+// isContent reports whether data starts with content stream
+// instructions, or is only white space and comments
+func isContent(data []byte) bool {
+	p := NewContentParser(data)
+	for i := 0; i < inlineImageLookahead; i++ {
+		var operator string
+		for operator == "" {
+			_, _, token, err := p.token()
+			if err == io.EOF {
+				return true
+			}
+			if err != nil {
+				return false
+			}
+			operator = token
+		}
+
+		if !operators[operator] {
+			return false
+		}
+		if operator == "BI" {
+			// the next image is not read
+			return true
+		}
+	}
+	return true
+}
@@ -0,0 +1,293 @@
+package pdf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContent(t *testing.T) {
+	content := "q 1 0 0 1 72 .5 cm % a comment\n" +
+		"BT /F1 12 Tf [(Hello) -250 (\\(World\\))] TJ ET\n" +
+		"/OC /MC0 BDC <48 65 6C6C 6F> Tj EMC\n" +
+		"/Span <</ActualText (x) /MCID 3>> BDC EMC\n" +
+		"[3 -1.5] 0 d true null f* Q"
+
+	instructions, err := ParseContent([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Instruction{
+		{"q", nil, 0},
+		{"cm", []Object{Integer(1), Integer(0), Integer(0), Integer(1), Integer(72), Real(.5)}, 2},
+		{"BT", nil, 31},
+		{"Tf", []Object{Name("F1"), Integer(12)}, 34},
+		{"TJ", []Object{Array{String("Hello"), Integer(-250), String("(World)")}}, 44},
+		{"ET", nil, 74},
+		{"BDC", []Object{Name("OC"), Name("MC0")}, 77},
+		{"Tj", []Object{String("Hello")}, 90},
+		{"EMC", nil, 109},
+		{"BDC", []Object{Name("Span"), Dictionary{Name("ActualText"): String("x"), Name("MCID"): Integer(3)}}, 113},
+		{"EMC", nil, 151},
+		{"d", []Object{Array{Integer(3), Real(-1.5)}, Integer(0)}, 155},
+		{"f*", []Object{Boolean(true), Null{}}, 168},
+		{"Q", nil, 181},
+	}
+	if len(instructions) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d: %v", len(expected), len(instructions), instructions)
+	}
+	for i, instruction := range instructions {
+		if !reflect.DeepEqual(instruction, expected[i]) {
+			t.Errorf("%d: expected %#v, got %#v", i, expected[i], instruction)
+		}
+		if !strings.HasPrefix(content[instruction.Offset:], contentPrefix(expected[i])) {
+			t.Errorf("%d: offset %d is not at the instruction: %q", i, instruction.Offset, content[instruction.Offset:])
+		}
+	}
+}
+
+// returns the first character an instruction is written with
+func contentPrefix(instruction Instruction) string {
+	if len(instruction.Operands) == 0 {
+		return instruction.Operator[:1]
+	}
+	switch instruction.Operands[0].(type) {
+	case Name:
+		return "/"
+	case Array:
+		return "["
+	case Boolean:
+		return "t"
+	case Real:
+		return "-"
+	}
+	return ""
+}
+
+func TestParseContentInlineImage(t *testing.T) {
+	// binary data containing EI, which is followed by more content
+	data := []byte{0, 'E', 'I', ' ', 0xff, '\n', 'E', 'I', ' ', 'Q', 1}
+
+	for _, test := range []struct {
+		name       string
+		dictionary string
+		data       []byte
+		expected   Dictionary
+	}{
+		{
+			"size from the dictionary",
+			"/W 11 /H 1 /BPC 8 /CS /G",
+			data,
+			Dictionary{Name("Width"): Integer(11), Name("Height"): Integer(1), Name("BitsPerComponent"): Integer(8), Name("ColorSpace"): Name("DeviceGray")},
+		},
+		{
+			"Indexed",
+			"/W 11 /H 1 /BPC 8 /CS [/I /RGB 1 <000000FFFFFF>]",
+			data,
+			Dictionary{
+				Name("Width"):            Integer(11),
+				Name("Height"):           Integer(1),
+				Name("BitsPerComponent"): Integer(8),
+				Name("ColorSpace"):       Array{Name("Indexed"), Name("DeviceRGB"), Integer(1), String{0, 0, 0, 0xff, 0xff, 0xff}},
+			},
+		},
+		{
+			"size that is too large",
+			"/W 4611686018427387904 /H 1 /CS /G /BPC 2",
+			data,
+			Dictionary{Name("Width"): Integer(4611686018427387904), Name("Height"): Integer(1), Name("BitsPerComponent"): Integer(2), Name("ColorSpace"): Name("DeviceGray")},
+		},
+		{
+			"Length that is too large",
+			"/W 1 /H 1 /F /AHx /L 9223372036854775807",
+			data,
+			Dictionary{Name("Width"): Integer(1), Name("Height"): Integer(1), Name("Filter"): Name("ASCIIHexDecode"), Name("Length"): Integer(9223372036854775807)},
+		},
+		{
+			"size that is too large for the data",
+			"/W 20 /H 1 /BPC 8 /CS /G",
+			data,
+			Dictionary{Name("Width"): Integer(20), Name("Height"): Integer(1), Name("BitsPerComponent"): Integer(8), Name("ColorSpace"): Name("DeviceGray")},
+		},
+		{
+			"Length",
+			"/W 1 /H 1 /F /AHx /L 11",
+			data,
+			Dictionary{Name("Width"): Integer(1), Name("Height"): Integer(1), Name("Filter"): Name("ASCIIHexDecode"), Name("Length"): Integer(11)},
+		},
+		{
+			"EI in filtered data",
+			"/W 1 /H 1 /F /Fl",
+			[]byte{0, '\n', 'E', 'I', ' ', 0xff, 0xfe},
+			Dictionary{Name("Width"): Integer(1), Name("Height"): Integer(1), Name("Filter"): Name("FlateDecode")},
+		},
+		{
+			"found by EI",
+			"/W 1 /H 1 /F [/A85] /D [1 0]",
+			[]byte("9jqo^BlbD-BleB1DJ+*+F(f,q/0JhKF<GL>Cj@.4Gp$d7F!,L7@<6@)/0JDEF<G%<+EV:2F!,O<DJ+*.@<*K0@<6L(Df-\\0Ec5e;DffZ(EZee.Bl.9pF\"AGXBPCsi+DGm>@3BB/F*&OCAfu2/AKYi(DIb:@FD,*)+C]U=@3BB/F*&OCAfu2/AKYi(DIb:@FD,*)+C]U=@3BB/F*&OCAfu2/~>"),
+			Dictionary{Name("Width"): Integer(1), Name("Height"): Integer(1), Name("Filter"): Array{Name("ASCII85Decode")}, Name("Decode"): Array{Integer(1), Integer(0)}},
+		},
+	} {
+		content := append([]byte("q BI "+test.dictionary+" ID "), test.data...)
+		content = append(content, []byte("\nEI Q")...)
+
+		instructions, err := ParseContent(content)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(instructions) != 3 {
+			t.Errorf("%s: expected 3 instructions, got %v", test.name, instructions)
+			continue
+		}
+
+		image := instructions[1]
+		if image.Operator != "BI" || image.Offset != 2 || len(image.Operands) != 1 {
+			t.Errorf("%s: expected an inline image at 2, got %v", test.name, image)
+			continue
+		}
+		stream := image.Operands[0].(Stream)
+		if !reflect.DeepEqual(stream.Dictionary, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, stream.Dictionary)
+		}
+		if !reflect.DeepEqual(stream.Stream, test.data) {
+			t.Errorf("%s: expected data %q, got %q", test.name, test.data, stream.Stream)
+		}
+		if instructions[2].Operator != "Q" || instructions[2].Offset != len(content)-1 {
+			t.Errorf("%s: expected Q after the image, got %v", test.name, instructions[2])
+		}
+	}
+}
+
+// parsing takes time in proportion to the size of the content
+func TestParseContentLarge(t *testing.T) {
+	const n = 1 << 17
+	content := bytes.Repeat([]byte("/F1 12 Tf (hello world) Tj <48656C6C6F> Tj\n"), n)
+	if len(content) < 4<<20 {
+		t.Fatalf("expected at least 4 MiB of content, got %d bytes", len(content))
+	}
+
+	start := time.Now()
+	instructions, err := ParseContent(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 3*n {
+		t.Errorf("expected %d instructions, got %d", 3*n, len(instructions))
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected parsing to take less than 10s, took %v", elapsed)
+	}
+}
+
+// ASCII encoded data can be directly followed by EI
+func TestInlineImageEndOfData(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		data    string
+	}{
+		{"q BI /W 1 /H 1 /CS /G /BPC 8 /F /AHx ID 00>EI Q", "00>"},
+		{"q BI /W 1 /H 1 /CS /G /BPC 8 /F [/A85 /Fl] ID z~>EI Q", "z~>"},
+	} {
+		instructions, err := ParseContent([]byte(test.content))
+		if err != nil {
+			t.Errorf("%q: %v", test.content, err)
+			continue
+		}
+		if len(instructions) != 3 || instructions[2].Operator != "Q" {
+			t.Errorf("%q: expected 3 instructions, got %v", test.content, instructions)
+			continue
+		}
+		stream := instructions[1].Operands[0].(Stream)
+		if string(stream.Stream) != test.data {
+			t.Errorf("%q: expected data %q, got %q", test.content, test.data, stream.Stream)
+		}
+	}
+}
+
+// inline images with abbreviated filters can be decoded
+func TestInlineImageDecode(t *testing.T) {
+	instructions, err := ParseContent([]byte("BI /W 5 /H 1 /BPC 8 /CS /G /F [/AHx] ID 48656C6C6F> EI"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := instructions[0].Operands[0].(Stream)
+	if stream.IsExternal() {
+		t.Error("expected the inline image not to be an external file stream")
+	}
+	decoded, err := (&File{}).Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Hello" {
+		t.Errorf("expected %q, got %q", "Hello", decoded)
+	}
+
+	img, err := (&File{}).Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 5 {
+		t.Errorf("expected an image 5 pixels wide, got %v", img.Bounds())
+	}
+
+	// sizes that overflow
+	for _, content := range []string{
+		"BI /W 4611686018427387904 /H 1 /CS /G /BPC 2 ID \nEI",
+		"BI /W 1 /H 4611686018427387904 /CS /G /BPC 8 ID \nEI",
+		"BI /W 1 /H 1 /L 9223372036854775807 ID \nEI",
+	} {
+		_, err := ParseContent([]byte(content))
+		if err != nil {
+			t.Errorf("%q: %v", content, err)
+		}
+	}
+}
+
+func TestParseContentErrors(t *testing.T) {
+	for _, test := range []struct {
+		content, err string
+	}{
+		{"1 0 0", "offset 0: operands without an operator"},
+		{"q ] Q", "offset 2: unexpected ']'"},
+		{"BI /W 1 /H 1 ID \x00\x01", "inline image without EI"},
+		{"BI /W 1 /H 1", "inline image without ID"},
+		{"BI 1 1 ID EI", "expected a key of the inline image"},
+		{"1 BI /W 1 ID x EI", "BI has operands"},
+		{"<4G> Tj", "expected a hexadecimal digit"},
+		{"[1 (a", "couldn't find end of string"},
+		{"<</A 1 >", "offset 7: not a name"},
+	} {
+		_, err := ParseContent([]byte(test.content))
+		if err == nil {
+			t.Errorf("%q: expected an error", test.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected an error containing %q, got %q", test.content, test.err, err)
+		}
+	}
+}
+
+func TestContentParserNext(t *testing.T) {
+	parser := NewContentParser([]byte("0 g\n10 10 m"))
+
+	for _, expected := range []string{"g", "m"} {
+		instruction, err := parser.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instruction.Operator != expected {
+			t.Errorf("expected %s, got %s", expected, instruction.Operator)
+		}
+	}
+
+	_, err := parser.Next()
+	if err == nil || err.Error() != "EOF" {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package document
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// End is the last page of a document in a PageRange.
+const End = 0
+
+// MaxAssembledPages is the most pages an Assembly can select, which
+// keeps repeated ranges from exhausting memory.
+const MaxAssembledPages = 1 << 16
+
+// Assembly selects pages from documents, named A, B, C and so on, to
+// place in a new document. It is written as ranges separated by spaces
+// or commas, each of which is a document's name, the pages, and
+// modifiers that start with colons:
+//
+//	A1-5 B3 A10-end:rotate90
+//
+// The pages are a page number, two page numbers separated by a hyphen
+// or nothing for all pages. Page numbers start at 1 and end is the last
+// page. When the first page number is greater than the second, the pages
+// are in reverse order. The modifiers are:
+//
+//	even      only the even page numbers
+//	odd       only the odd page numbers
+//	rotateN   rotate the pages N degrees clockwise, a multiple of 90
+//	xN        repeat the range N times
+//
+// At most MaxAssembledPages pages can be selected.
+//
+// When the name is left out the pages are from A, so that "1-end:odd"
+// selects the odd pages of the only document.
+type Assembly []PageRange
+
+// PageRange is a range of pages in an Assembly.
+type PageRange struct {
+	// Source is the document the pages are from, 0 being A.
+	Source int
+
+	// From and To are the first and last page numbers, the first page
+	// being 1. The pages are in reverse order when From is after To.
+	From, To int
+
+	// Even and Odd keep only the pages with even or odd page numbers.
+	Even, Odd bool
+
+	// Rotate is added to the rotation of the pages.
+	Rotate int
+
+	// Repeat is how many times the range is placed, at least 1.
+	Repeat int
+
+	// the text of the range, for error messages
+	text string
+}
+
+// AssembledPage is a page selected by an Assembly.
+type AssembledPage struct {
+	// Source is the document the page is from, 0 being A.
+	Source int
+
+	// Index is the page in its document, the first page being 0.
+	Index int
+
+	// Rotate is added to the rotation of the page.
+	Rotate int
+}
+
+// ParseAssembly parses spec as described for Assembly. Errors
+// give the column of the range that could not be parsed.
+func ParseAssembly(spec string) (Assembly, error) {
+	assembly := Assembly{}
+
+	column := 0
+	for column < len(spec) {
+		if isSeparator(spec[column]) {
+			column++
+			continue
+		}
+
+		end := column
+		for end < len(spec) && !isSeparator(spec[end]) {
+			end++
+		}
+
+		r, err := parsePageRange(spec[column:end])
+		if err != nil {
+			return nil, fmt.Errorf("page assembly column %d: %q: %v", column+1, spec[column:end], err)
+		}
+		assembly = append(assembly, r)
+
+		column = end
+	}
+
+	if len(assembly) == 0 {
+		return nil, fmt.Errorf("page assembly %q does not select any pages", spec)
+	}
+
+	return assembly, nil
+}
+
+func isSeparator(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ','
+}
+
+// parsePageRange parses a range in an Assembly
+func parsePageRange(text string) (PageRange, error) {
+	r := PageRange{From: 1, To: End, Repeat: 1, text: text}
+
+	parts := strings.Split(text, ":")
+	pages := parts[0]
+
+	// the document's name, in the letters A to Z, AA after Z and so on
+	name := 0
+	for name < len(pages) && 'A' <= pages[name] && pages[name] <= 'Z' {
+		r.Source = r.Source*26 + int(pages[name]-'A') + 1
+		name++
+	}
+	if name > 0 {
+		r.Source--
+	}
+	pages = pages[name:]
+
+	if pages != "" && 'a' <= pages[0] && pages[0] <= 'z' && !strings.HasPrefix(pages, "end") {
+		return r, fmt.Errorf("document names are capital letters")
+	}
+
+	if pages != "" {
+		from, to := pages, pages
+		if i := strings.IndexByte(pages, '-'); i >= 0 {
+			from, to = pages[:i], pages[i+1:]
+		}
+
+		var err error
+		r.From, err = parsePageNumber(from)
+		if err != nil {
+			return r, err
+		}
+		r.To, err = parsePageNumber(to)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	for _, modifier := range parts[1:] {
+		switch {
+		case modifier == "even":
+			r.Even = true
+		case modifier == "odd":
+			r.Odd = true
+		case strings.HasPrefix(modifier, "rotate"):
+			degrees, err := strconv.Atoi(modifier[len("rotate"):])
+			if err != nil || degrees%90 != 0 {
+				return r, fmt.Errorf("rotation must be a multiple of 90 degrees, such as rotate90, not %q", modifier)
+			}
+			r.Rotate = (r.Rotate + degrees) % 360
+		case strings.HasPrefix(modifier, "x"):
+			repeat, err := strconv.Atoi(modifier[len("x"):])
+			if err != nil || repeat < 1 {
+				return r, fmt.Errorf("repetition must be a positive number of times, such as x2, not %q", modifier)
+			}
+			if repeat > MaxAssembledPages {
+				return r, fmt.Errorf("repetition can be at most %d times, not %q", MaxAssembledPages, modifier)
+			}
+			r.Repeat = repeat
+		case modifier == "":
+			return r, fmt.Errorf("empty modifier")
+		default:
+			return r, fmt.Errorf("unknown modifier %q; expected even, odd, rotateN or xN", modifier)
+		}
+	}
+
+	if r.Even && r.Odd {
+		return r, fmt.Errorf("pages cannot be both even and odd")
+	}
+
+	return r, nil
+}
+
+// parsePageNumber parses a page number in an Assembly
+func parsePageNumber(text string) (int, error) {
+	if text == "end" {
+		return End, nil
+	}
+
+	n, err := strconv.Atoi(text)
+	if err != nil || text[0] == '+' || text[0] == '-' {
+		if text == "" {
+			return 0, fmt.Errorf("missing page number")
+		}
+		return 0, fmt.Errorf("expected a page number or end, not %q", text)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("page numbers start at 1, not %d", n)
+	}
+
+	return n, nil
+}
+
+// Pages returns the pages selected from documents with counts
+// pages, in the order they are selected.
+func (a Assembly) Pages(counts []int) ([]AssembledPage, error) {
+	pages := []AssembledPage{}
+	for _, r := range a {
+		if r.Source < 0 || r.Source >= len(counts) {
+			return nil, fmt.Errorf("%q: there is no document %s; there are %d", r.text, sourceName(r.Source), len(counts))
+		}
+		count := counts[r.Source]
+		if count == 0 {
+			return nil, fmt.Errorf("%q: %s has no pages", r.text, sourceName(r.Source))
+		}
+
+		from, to := r.From, r.To
+		for _, n := range []*int{&from, &to} {
+			if *n == End {
+				*n = count
+			}
+			if *n < 1 || *n > count {
+				return nil, fmt.Errorf("%q: page %d does not exist; %s has %d pages", r.text, *n, sourceName(r.Source), count)
+			}
+		}
+
+		step := 1
+		if from > to {
+			step = -1
+		}
+
+		repeat := r.Repeat
+		if repeat < 1 {
+			repeat = 1
+		}
+		for i := 0; i < repeat; i++ {
+			for n := from; ; n += step {
+				if !(r.Even && n%2 == 1) && !(r.Odd && n%2 == 0) {
+					if len(pages) == MaxAssembledPages {
+						return nil, fmt.Errorf("%q: more than %d pages are selected", r.text, MaxAssembledPages)
+					}
+					pages = append(pages, AssembledPage{r.Source, n - 1, r.Rotate})
+				}
+				if n == to {
+					break
+				}
+			}
+		}
+	}
+
+	return pages, nil
+}
+
+// sourceName returns the name of the ith document in an Assembly
+func sourceName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+// Assemble appends the pages of srcs selected by spec, which is
+// described with Assembly, to dst. The pages are copied with what
+// refers to them as with Extract, and each source is copied once,
+// so pages can be selected more than once. When dst does not have
+// a catalog, one is created.
+//
+// The streams of the copied objects share their data with srcs,
+// so srcs must remain open until dst is saved.
+func Assemble(dst *Document, spec string, srcs ...*Document) error {
+	return MergeOptions{}.Assemble(dst, spec, srcs...)
+}
+
+// Assemble assembles the pages of srcs in dst, as with the Assemble
+// function. Bookmarks are to the first page from each source.
+func (options MergeOptions) Assemble(dst *Document, spec string, srcs ...*Document) error {
+	assembly, err := ParseAssembly(spec)
+	if err != nil {
+		return err
+	}
+
+	counts := make([]int, len(srcs))
+	for i, src := range srcs {
+		counts[i], err = src.PageCount()
+		if err != nil {
+			return fmt.Errorf("document %s: %v", sourceName(i), err)
+		}
+	}
+
+	pages, err := assembly.Pages(counts)
+	if err != nil {
+		return err
+	}
+
+	m, err := newMerger(dst, options)
+	if err != nil {
+		return err
+	}
+
+	placements := make([]placement, len(pages))
+	for i, page := range pages {
+		placements[i] = placement{
+			src:    srcs[page.Source],
+			n:      page.Source,
+			index:  page.Index,
+			rotate: page.Rotate,
+		}
+	}
+
+	err = m.place(placements)
+	if err != nil {
+		return err
+	}
+
+	return m.finish()
+}
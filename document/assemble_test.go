@@ -0,0 +1,188 @@
+package document
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+func TestAssemblyPages(t *testing.T) {
+	// A has 6 pages and B has 3
+	counts := []int{6, 3}
+
+	for _, test := range []struct {
+		spec  string
+		pages []AssembledPage
+	}{
+		{"A1-3 B2", []AssembledPage{{0, 0, 0}, {0, 1, 0}, {0, 2, 0}, {1, 1, 0}}},
+		{"B", []AssembledPage{{1, 0, 0}, {1, 1, 0}, {1, 2, 0}}},
+		{"Bend-1", []AssembledPage{{1, 2, 0}, {1, 1, 0}, {1, 0, 0}}},
+		{"A5-end:rotate90", []AssembledPage{{0, 4, 90}, {0, 5, 90}}},
+		{"A:even", []AssembledPage{{0, 1, 0}, {0, 3, 0}, {0, 5, 0}}},
+		{"1-end:odd", []AssembledPage{{0, 0, 0}, {0, 2, 0}, {0, 4, 0}}},
+		{"A6-1:odd", []AssembledPage{{0, 4, 0}, {0, 2, 0}, {0, 0, 0}}},
+		{"B1:x3", []AssembledPage{{1, 0, 0}, {1, 0, 0}, {1, 0, 0}}},
+		{"A1-2:x2:rotate-90,B3", []AssembledPage{{0, 0, -90}, {0, 1, -90}, {0, 0, -90}, {0, 1, -90}, {1, 2, 0}}},
+	} {
+		assembly, err := ParseAssembly(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+
+		pages, err := assembly.Pages(counts)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("%q: expected %v, got %v", test.spec, test.pages, pages)
+		}
+	}
+}
+
+func TestAssemblyErrors(t *testing.T) {
+	counts := []int{6, 3}
+
+	for _, test := range []struct {
+		spec, err string
+	}{
+		{"", "does not select any pages"},
+		{"A1 B1-x", `column 4: "B1-x": expected a page number or end, not "x"`},
+		{"A1-", "missing page number"},
+		{"A0", "page numbers start at 1"},
+		{"a1", "document names are capital letters"},
+		{"A1:rotate45", "multiple of 90 degrees"},
+		{"A1:x0", "positive number of times"},
+		{"A:x100000000", `repetition can be at most 65536 times, not "x100000000"`},
+		{"A:x65536", `"A:x65536": more than 65536 pages are selected`},
+		{"A1:flip", `unknown modifier "flip"`},
+		{"A1:", "empty modifier"},
+		{"A:even:odd", "both even and odd"},
+		{"C1", `"C1": there is no document C; there are 2`},
+		{"B4", `"B4": page 4 does not exist; B has 3 pages`},
+		{"A2-7", "page 7 does not exist; A has 6 pages"},
+	} {
+		assembly, err := ParseAssembly(test.spec)
+		if err == nil {
+			_, err = assembly.Pages(counts)
+		}
+		if err == nil {
+			t.Errorf("%q: expected an error", test.spec)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected an error containing %q, got %q", test.spec, test.err, err)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	a, cleanupA := createMergeSource(t)
+	defer cleanupA()
+	b, cleanupB := createPagesDocument(t, 3)
+	defer cleanupB()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	err := MergeOptions{Bookmarks: true}.Assemble(dst, "B3 A2 B1-2:rotate90 A2", a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []Page{}
+	err = dst.Pages(func(page Page) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pages of B have their index as contents
+	expected := []struct {
+		contents pdf.Object
+		rotate   int
+	}{
+		{pdf.Integer(2), 0},
+		{nil, 0},
+		{pdf.Integer(0), 90},
+		{pdf.Integer(1), 90},
+		{nil, 0},
+	}
+	if len(pages) != len(expected) {
+		t.Fatalf("expected %d pages, got %d", len(expected), len(pages))
+	}
+	for i, page := range pages {
+		if page.Dictionary[pdf.Name("Contents")] != expected[i].contents {
+			t.Errorf("%d: expected Contents %v, got %v", i, expected[i].contents, page.Dictionary[pdf.Name("Contents")])
+		}
+		if page.Rotate != expected[i].rotate {
+			t.Errorf("%d: expected Rotate %d, got %d", i, expected[i].rotate, page.Rotate)
+		}
+	}
+
+	// the bookmarks are in the order the documents are first used
+	catalog, err := dst.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlines := dst.File.Get(catalog.Outlines.(pdf.ObjectReference)).(pdf.Dictionary)
+	item := outlines[pdf.Name("First")]
+	for i, expected := range []struct {
+		title string
+		page  int
+	}{
+		{"Document 2", 0},
+		{"Document 1", 1},
+	} {
+		bookmark := dst.File.Get(item.(pdf.ObjectReference)).(pdf.Dictionary)
+		if string(bookmark[pdf.Name("Title")].(pdf.String)) != expected.title {
+			t.Errorf("%d: expected %q, got %v", i, expected.title, bookmark[pdf.Name("Title")])
+		}
+		if dest := bookmark[pdf.Name("Dest")].(pdf.Array); dest[0] != pages[expected.page].ObjectReference {
+			t.Errorf("%d: expected a destination of page %d, got %v", i, expected.page, dest[0])
+		}
+		item = bookmark[pdf.Name("Next")]
+	}
+
+	err = Assemble(dst, "A3", a)
+	if err == nil || !strings.Contains(err.Error(), "page 3 does not exist") {
+		t.Errorf("expected a missing page error, got %v", err)
+	}
+}
+
+func TestAssembleNewFile(t *testing.T) {
+	src, cleanupSrc := createPagesDocument(t, 3)
+	defer cleanupSrc()
+
+	dir, err := ioutil.TempDir("", "document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := pdf.Create(filepath.Join(dir, "assembled.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// the catalog is created
+	dst := New(file)
+	err = Assemble(dst, "A3-1", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPages(t, dst, []int{2, 1, 0})
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
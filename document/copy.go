@@ -0,0 +1,126 @@
+package document
+
+import (
+	"fmt"
+
+	"github.com/nathankerr/pdf"
+)
+
+// Copier copies objects from one File to another. Each indirect
+// object is copied once, so objects shared in the source are
+// shared in the destination and reference cycles are kept.
+type Copier struct {
+	dst, src *pdf.File
+
+	// the objects references in src are replaced with in dst
+	refs map[pdf.ObjectReference]pdf.Object
+
+	// Dictionary, when not nil, is called with each copied dictionary,
+	// including those of streams, before it is stored in dst.
+	Dictionary func(dict pdf.Dictionary)
+}
+
+// NewCopier returns a Copier that copies objects from src to dst.
+// Streams share their data with src, so src must remain open
+// until dst is saved. When dst and src are the same File, indirect
+// objects are not copied as they are already in dst.
+func NewCopier(dst, src *pdf.File) *Copier {
+	return &Copier{
+		dst:  dst,
+		src:  src,
+		refs: map[pdf.ObjectReference]pdf.Object{},
+	}
+}
+
+// Map replaces references to ref in the copied objects with obj,
+// such as an ObjectReference to an object already in the
+// destination or Null to remove the reference. The object at ref
+// is not copied.
+func (c *Copier) Map(ref pdf.ObjectReference, obj pdf.Object) {
+	c.refs[ref] = obj
+}
+
+// Copied returns what references to ref are replaced with,
+// and whether ref has been copied or mapped.
+func (c *Copier) Copied(ref pdf.ObjectReference) (pdf.Object, bool) {
+	obj, ok := c.refs[ref]
+	return obj, ok
+}
+
+// Copy returns obj as it is in the destination, copying the
+// objects it references that have not already been copied.
+func (c *Copier) Copy(obj pdf.Object) (pdf.Object, error) {
+	switch typed := obj.(type) {
+	case pdf.ObjectReference:
+		if copied, ok := c.refs[typed]; ok {
+			return copied, nil
+		}
+		if c.dst == c.src {
+			return typed, nil
+		}
+
+		// reserve the reference before copying the object
+		// so that cycles refer to it
+		ref, err := c.dst.Add(pdf.Null{})
+		if err != nil {
+			return nil, err
+		}
+		c.refs[typed] = ref
+
+		copied, err := c.Copy(c.src.Get(typed))
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = c.dst.Add(pdf.IndirectObject{
+			ObjectReference: ref,
+			Object:          copied,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ref, nil
+	case pdf.Dictionary:
+		dict := make(pdf.Dictionary, len(typed))
+		for name, value := range typed {
+			copied, err := c.Copy(value)
+			if err != nil {
+				return nil, err
+			}
+			dict[name] = copied
+		}
+
+		if c.Dictionary != nil {
+			c.Dictionary(dict)
+		}
+		return dict, nil
+	case pdf.Array:
+		array := make(pdf.Array, len(typed))
+		for i, value := range typed {
+			copied, err := c.Copy(value)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = copied
+		}
+		return array, nil
+	case pdf.Stream:
+		dict, err := c.Copy(typed.Dictionary)
+		if err != nil {
+			return nil, err
+		}
+		return pdf.Stream{
+			Dictionary: dict.(pdf.Dictionary),
+			Stream:     typed.Stream,
+		}, nil
+	case pdf.String:
+		return append(pdf.String{}, typed...), nil
+	case pdf.Null:
+		// the reason for the Null is not kept
+		return pdf.Null{}, nil
+	case pdf.Boolean, pdf.Integer, pdf.Real, pdf.Name:
+		return typed, nil
+	}
+
+	return nil, fmt.Errorf("unable to copy %T", obj)
+}
@@ -0,0 +1,202 @@
+/*
+Package document provides the structure of a PDF document (§7.7),
+such as its catalog and page tree, built on the objects managed
+by a pdf.File.
+*/
+package document
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nathankerr/pdf"
+)
+
+// Document is the document structure of the objects in a File.
+type Document struct {
+	File *pdf.File
+}
+
+// New returns the Document contained in file.
+func New(file *pdf.File) *Document {
+	return &Document{File: file}
+}
+
+// Catalog is the root of a document's object hierarchy.
+// Entries that may be direct or indirect objects are kept
+// as they are stored.
+// - §7.7.2
+type Catalog struct {
+	// Version overrides the version in the file's header.
+	Version pdf.Name
+
+	// Pages is the root of the page tree.
+	Pages pdf.ObjectReference
+
+	PageLabels     pdf.Object
+	Names          pdf.Object
+	Dests          pdf.Object
+	PageLayout     pdf.Name
+	PageMode       pdf.Name
+	Outlines       pdf.Object
+	AcroForm       pdf.Object
+	Metadata       pdf.Object
+	StructTreeRoot pdf.Object
+	OCProperties   pdf.Object
+
+	// Other holds the entries that do not have a field.
+	Other pdf.Dictionary
+}
+
+// Catalog returns the document's catalog from the File's Root.
+func (d *Document) Catalog() (Catalog, error) {
+	dict, ok := d.resolve(d.File.Root).(pdf.Dictionary)
+	if !ok {
+		return Catalog{}, fmt.Errorf("catalog %v is not a dictionary", d.File.Root)
+	}
+
+	catalog := Catalog{Other: pdf.Dictionary{}}
+	for name, value := range dict {
+		switch name {
+		case "Type":
+			if value != pdf.Name("Catalog") {
+				return Catalog{}, fmt.Errorf("catalog has Type %v", value)
+			}
+		case "Version":
+			catalog.Version, _ = d.resolve(value).(pdf.Name)
+		case "Pages":
+			pages, ok := value.(pdf.ObjectReference)
+			if !ok {
+				return Catalog{}, errors.New("catalog Pages is not an indirect reference")
+			}
+			catalog.Pages = pages
+		case "PageLabels":
+			catalog.PageLabels = value
+		case "Names":
+			catalog.Names = value
+		case "Dests":
+			catalog.Dests = value
+		case "PageLayout":
+			catalog.PageLayout, _ = d.resolve(value).(pdf.Name)
+		case "PageMode":
+			catalog.PageMode, _ = d.resolve(value).(pdf.Name)
+		case "Outlines":
+			catalog.Outlines = value
+		case "AcroForm":
+			catalog.AcroForm = value
+		case "Metadata":
+			catalog.Metadata = value
+		case "StructTreeRoot":
+			catalog.StructTreeRoot = value
+		case "OCProperties":
+			catalog.OCProperties = value
+		default:
+			catalog.Other[name] = value
+		}
+	}
+
+	if catalog.Pages == (pdf.ObjectReference{}) {
+		return Catalog{}, errors.New("catalog does not have Pages")
+	}
+
+	return catalog, nil
+}
+
+// ensureCatalog returns the document's catalog, first adding
+// one with an empty page tree when the File does not have a Root
+func (d *Document) ensureCatalog() (Catalog, error) {
+	if d.File.Root != (pdf.ObjectReference{}) {
+		return d.Catalog()
+	}
+
+	pages, err := d.File.Add(pdf.Dictionary{
+		pdf.Name("Type"):  pdf.Name("Pages"),
+		pdf.Name("Kids"):  pdf.Array{},
+		pdf.Name("Count"): pdf.Integer(0),
+	})
+	if err != nil {
+		return Catalog{}, err
+	}
+
+	catalog := Catalog{Pages: pages, Other: pdf.Dictionary{}}
+	return catalog, d.SetCatalog(catalog)
+}
+
+// SetCatalog stores the catalog in the File, replacing the one at
+// the File's Root. When the File does not have a Root, one is added.
+func (d *Document) SetCatalog(catalog Catalog) error {
+	object := pdf.Object(catalog.Dictionary())
+	if d.File.Root != (pdf.ObjectReference{}) {
+		object = pdf.IndirectObject{
+			ObjectReference: d.File.Root,
+			Object:          object,
+		}
+	}
+
+	root, err := d.File.Add(object)
+	if err != nil {
+		return err
+	}
+	d.File.Root = root
+
+	return nil
+}
+
+// Dictionary returns the catalog as it is stored in a File.
+func (catalog Catalog) Dictionary() pdf.Dictionary {
+	dict := pdf.Dictionary{}
+	for name, value := range catalog.Other {
+		dict[name] = value
+	}
+
+	dict[pdf.Name("Type")] = pdf.Name("Catalog")
+	dict[pdf.Name("Pages")] = catalog.Pages
+
+	names := map[pdf.Name]pdf.Object{
+		"PageLabels":     catalog.PageLabels,
+		"Names":          catalog.Names,
+		"Dests":          catalog.Dests,
+		"Outlines":       catalog.Outlines,
+		"AcroForm":       catalog.AcroForm,
+		"Metadata":       catalog.Metadata,
+		"StructTreeRoot": catalog.StructTreeRoot,
+		"OCProperties":   catalog.OCProperties,
+	}
+	for name, value := range names {
+		if value != nil {
+			dict[name] = value
+		}
+	}
+
+	if catalog.Version != "" {
+		dict[pdf.Name("Version")] = catalog.Version
+	}
+	if catalog.PageLayout != "" {
+		dict[pdf.Name("PageLayout")] = catalog.PageLayout
+	}
+	if catalog.PageMode != "" {
+		dict[pdf.Name("PageMode")] = catalog.PageMode
+	}
+
+	return dict
+}
+
+// resolve returns the referenced object when obj is an
+// ObjectReference, otherwise obj is returned.
+func (d *Document) resolve(obj pdf.Object) pdf.Object {
+	if ref, ok := obj.(pdf.ObjectReference); ok {
+		return d.File.Get(ref)
+	}
+	return obj
+}
+
+// number returns the value of an Integer or Real
+func number(obj pdf.Object) (float64, bool) {
+	switch typed := obj.(type) {
+	case pdf.Integer:
+		return float64(typed), true
+	case pdf.Real:
+		return float64(typed), true
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package document
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document in a temporary directory with the page tree
+// built by pages, which is given the reference of the root node
+// returns the document and a cleanup function
+func createTestDocument(t *testing.T, pages func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary) (*Document, func()) {
+	dir, err := ioutil.TempDir("", "document")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := createTestDocumentAt(t, filepath.Join(dir, "test.pdf"), pages)
+	return d, func() {
+		d.File.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// creates a document in filename like createTestDocument
+func createTestDocumentAt(t *testing.T, filename string, pages func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary) *Document {
+	file, err := pdf.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := file.Add(pdf.Null{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(t, file, root, pages(file, root))
+
+	file.Root, err = file.Add(pdf.Dictionary{
+		pdf.Name("Type"):  pdf.Name("Catalog"),
+		pdf.Name("Pages"): root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(file)
+}
+
+// adds obj to file at ref, or at a new reference when ref is the zero value
+func add(t *testing.T, file *pdf.File, ref pdf.ObjectReference, obj pdf.Object) pdf.ObjectReference {
+	if ref != (pdf.ObjectReference{}) {
+		obj = pdf.IndirectObject{ObjectReference: ref, Object: obj}
+	}
+
+	ref, err := file.Add(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestCatalog(t *testing.T) {
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Pages"),
+			pdf.Name("Kids"):  pdf.Array{},
+			pdf.Name("Count"): pdf.Integer(0),
+		}
+	})
+	defer cleanup()
+
+	catalog, err := d.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog.PageMode = pdf.Name("UseOutlines")
+	catalog.Other[pdf.Name("Lang")] = pdf.String("en")
+	err = d.SetCatalog(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := pdf.Dictionary{
+		pdf.Name("Type"):     pdf.Name("Catalog"),
+		pdf.Name("Pages"):    catalog.Pages,
+		pdf.Name("PageMode"): pdf.Name("UseOutlines"),
+		pdf.Name("Lang"):     pdf.String("en"),
+	}
+	stored := d.File.Get(d.File.Root)
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("expected %v, got %v", expected, stored)
+	}
+
+	reread, err := d.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reread, catalog) {
+		t.Errorf("expected %#v, got %#v", catalog, reread)
+	}
+}
+
+func TestCatalogErrors(t *testing.T) {
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{}
+	})
+	defer cleanup()
+
+	tests := []pdf.Object{
+		pdf.Integer(1),
+		pdf.Dictionary{pdf.Name("Type"): pdf.Name("Pages")},
+		pdf.Dictionary{pdf.Name("Type"): pdf.Name("Catalog")},
+		pdf.Dictionary{pdf.Name("Pages"): pdf.Dictionary{}},
+	}
+
+	for i, test := range tests {
+		add(t, d.File, d.File.Root, test)
+		_, err := d.Catalog()
+		if err == nil {
+			t.Errorf("%d: expected an error for %v", i, test)
+		}
+	}
+}
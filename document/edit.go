@@ -0,0 +1,606 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/nathankerr/pdf"
+)
+
+// maximum number of Kids in the page tree nodes created when
+// editing pages; larger nodes are split so that the tree stays
+// balanced (§7.7.3.2)
+const maxKids = 32
+
+// a page tree node and its reference
+type node struct {
+	ref  pdf.ObjectReference
+	dict pdf.Dictionary
+}
+
+// InsertPage adds page to the document so that it is at index, the
+// first page being 0. An index equal to the page count appends the
+// page. The page inherits attributes it does not have from its new
+// ancestors in the page tree.
+func (d *Document) InsertPage(index int, page pdf.Dictionary) (pdf.ObjectReference, error) {
+	ref, err := d.File.Add(pdf.Null{})
+	if err != nil {
+		return pdf.ObjectReference{}, err
+	}
+
+	return ref, d.insertPage(index, ref, page, nil)
+}
+
+// insertPage stores page at ref and adds it to the page tree at index.
+// When inherited is not nil, the attributes the page inherited are
+// set on the page when they are different from those it will inherit.
+func (d *Document) insertPage(index int, ref pdf.ObjectReference, page, inherited pdf.Dictionary) error {
+	path, position, err := d.find(index, true)
+	if err != nil {
+		return err
+	}
+
+	if inherited != nil {
+		materialize(page, inherited, ancestors(path))
+	}
+	page[pdf.Name("Type")] = pdf.Name("Page")
+	page[pdf.Name("Parent")] = path[len(path)-1].ref
+	err = d.set(ref, page)
+	if err != nil {
+		return err
+	}
+
+	return d.insert(path, position, ref)
+}
+
+// DeletePages removes the pages at indexes from the document and
+// frees them. Page tree nodes left without Kids are also freed.
+func (d *Document) DeletePages(indexes ...int) error {
+	// delete from the end so that the indexes do not change
+	sorted := append([]int{}, indexes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	for i, index := range sorted {
+		if i > 0 && index == sorted[i-1] {
+			continue
+		}
+
+		ref, _, err := d.remove(index)
+		if err != nil {
+			return err
+		}
+		d.File.Free(ref.ObjectNumber)
+	}
+
+	return nil
+}
+
+// MovePage moves the page at from so that it is at index to. The
+// attributes the page inherited are set on the page when they are
+// different from those inherited in its new position.
+func (d *Document) MovePage(from, to int) error {
+	count, err := d.PageCount()
+	if err != nil {
+		return err
+	}
+	if to < 0 || to >= count {
+		return fmt.Errorf("page %d does not exist", to)
+	}
+
+	ref, inherited, err := d.remove(from)
+	if err != nil {
+		return err
+	}
+
+	page, ok := d.File.Get(ref).(pdf.Dictionary)
+	if !ok {
+		return fmt.Errorf("page %v is not a dictionary", ref)
+	}
+
+	return d.insertPage(to, ref, page, inherited)
+}
+
+// SetRotate sets the clockwise rotation of the page at index,
+// which must be a multiple of 90 degrees.
+func (d *Document) SetRotate(index, degrees int) error {
+	if degrees%90 != 0 {
+		return fmt.Errorf("rotation of %d is not a multiple of 90", degrees)
+	}
+
+	page, err := d.Page(index)
+	if err != nil {
+		return err
+	}
+
+	page.Dictionary[pdf.Name("Rotate")] = pdf.Integer((degrees%360 + 360) % 360)
+	return d.set(page.ObjectReference, page.Dictionary)
+}
+
+// RebalancePages rebuilds the page tree so that no node has more than
+// maxKids Kids and all pages are at the same depth. The root node is
+// kept; the other nodes are replaced.
+func (d *Document) RebalancePages() error {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return err
+	}
+
+	root, ok := d.File.Get(catalog.Pages).(pdf.Dictionary)
+	if !ok {
+		return fmt.Errorf("page tree root %v is not a dictionary", catalog.Pages)
+	}
+	rootInherited := inherit(pdf.Dictionary{}, root)
+
+	// collect the pages, making their attributes independent
+	// of the nodes that will be replaced
+	pages := []node{}
+	isPage := map[pdf.ObjectReference]bool{}
+	visited := map[pdf.ObjectReference]bool{}
+	_, err = d.walk(catalog.Pages, pdf.Dictionary{}, visited, func(ref pdf.ObjectReference, page, inherited pdf.Dictionary) (bool, error) {
+		materialize(page, inherited, rootInherited)
+		pages = append(pages, node{ref, page})
+		isPage[ref] = true
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for ref := range visited {
+		if ref != catalog.Pages && !isPage[ref] {
+			d.File.Free(ref.ObjectNumber)
+		}
+	}
+
+	// build the levels of the tree from the pages up
+	level := pages
+	counts := make([]int, len(pages))
+	for i := range counts {
+		counts[i] = 1
+	}
+	for len(level) > maxKids {
+		groups := (len(level) + maxKids - 1) / maxKids
+		parents := make([]node, 0, groups)
+		parentCounts := make([]int, 0, groups)
+
+		start := 0
+		for group := 0; group < groups; group++ {
+			// spread the kids evenly over the groups
+			end := start + (len(level)-start)/(groups-group)
+
+			ref, err := d.File.Add(pdf.Null{})
+			if err != nil {
+				return err
+			}
+
+			parent := node{ref, pdf.Dictionary{
+				pdf.Name("Type"):   pdf.Name("Pages"),
+				pdf.Name("Parent"): catalog.Pages,
+			}}
+			count, err := d.adopt(parent, level[start:end], counts[start:end])
+			if err != nil {
+				return err
+			}
+
+			parents = append(parents, parent)
+			parentCounts = append(parentCounts, count)
+			start = end
+		}
+
+		level = parents
+		counts = parentCounts
+	}
+
+	_, err = d.adopt(node{catalog.Pages, root}, level, counts)
+	return err
+}
+
+// adopt makes kids, which contain counts pages, the Kids of parent
+// and stores them and parent, returning the number of pages in parent
+func (d *Document) adopt(parent node, kids []node, counts []int) (int, error) {
+	array := make(pdf.Array, len(kids))
+	total := 0
+	for i, kid := range kids {
+		kid.dict[pdf.Name("Parent")] = parent.ref
+		err := d.set(kid.ref, kid.dict)
+		if err != nil {
+			return 0, err
+		}
+
+		array[i] = kid.ref
+		total += counts[i]
+	}
+
+	parent.dict[pdf.Name("Kids")] = array
+	parent.dict[pdf.Name("Count")] = pdf.Integer(total)
+	return total, d.set(parent.ref, parent.dict)
+}
+
+// find returns the page tree nodes from the root to the node whose
+// Kids contain the page at index, and the position of the page in
+// those Kids. When inserting, index may be the page count and the
+// position is where a page would be inserted.
+func (d *Document) find(index int, inserting bool) ([]node, int, error) {
+	if index < 0 {
+		return nil, 0, fmt.Errorf("page %d does not exist", index)
+	}
+
+	catalog, err := d.Catalog()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	path := []node{}
+	ref := catalog.Pages
+	visited := map[pdf.ObjectReference]bool{}
+	for {
+		if visited[ref] {
+			return nil, 0, fmt.Errorf("page tree node %v is its own ancestor", ref)
+		}
+		visited[ref] = true
+
+		dict, ok := d.File.Get(ref).(pdf.Dictionary)
+		if !ok || dict[pdf.Name("Type")] != pdf.Name("Pages") {
+			return nil, 0, fmt.Errorf("page tree node %v is not a Pages dictionary", ref)
+		}
+		path = append(path, node{ref, dict})
+
+		kids, err := d.kids(ref, dict)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		descended := false
+		for i, kid := range kids {
+			count, err := d.count(kid)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if kidNode, ok := d.File.Get(kid).(pdf.Dictionary); ok && kidNode[pdf.Name("Type")] == pdf.Name("Page") {
+				if index == 0 {
+					return path, i, nil
+				}
+				index--
+				continue
+			}
+
+			// pages appended to the end go into the last subtree
+			if index < count || (inserting && index == count && i == len(kids)-1) {
+				ref = kid
+				descended = true
+				break
+			}
+			index -= count
+		}
+
+		if !descended {
+			if inserting && index == 0 {
+				return path, len(kids), nil
+			}
+			return nil, 0, errors.New("page does not exist")
+		}
+	}
+}
+
+// insert adds ref to the Kids of the last node in path at position,
+// splitting nodes that have too many Kids
+func (d *Document) insert(path []node, position int, ref pdf.ObjectReference) error {
+	leaf := path[len(path)-1]
+	kids, err := d.kids(leaf.ref, leaf.dict)
+	if err != nil {
+		return err
+	}
+
+	kids = append(kids, pdf.ObjectReference{})
+	copy(kids[position+1:], kids[position:])
+	kids[position] = ref
+	setKids(leaf.dict, kids)
+
+	err = adjustCounts(path, 1)
+	if err != nil {
+		return err
+	}
+
+	for level := len(path) - 1; level >= 0; level-- {
+		kids, err := d.kids(path[level].ref, path[level].dict)
+		if err != nil {
+			return err
+		}
+
+		// trees that were not built with maxKids are rebuilt
+		if len(kids) > 2*maxKids {
+			err = d.setNodes(path)
+			if err != nil {
+				return err
+			}
+			return d.RebalancePages()
+		}
+
+		if len(kids) <= maxKids {
+			break
+		}
+
+		if level == 0 {
+			err = d.splitRoot(path, kids)
+		} else {
+			err = d.split(path, level, kids)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.setNodes(path)
+}
+
+// split moves the second half of the kids of the node at level in
+// path into a new node after it in its parent, with the same
+// inheritable attributes
+func (d *Document) split(path []node, level int, kids []pdf.ObjectReference) error {
+	parent, n := path[level-1], path[level]
+	half := len(kids) / 2
+
+	sibling := pdf.Dictionary{
+		pdf.Name("Type"):   pdf.Name("Pages"),
+		pdf.Name("Parent"): parent.ref,
+	}
+	for _, name := range inheritable {
+		if value, ok := n.dict[name]; ok {
+			sibling[name] = value
+		}
+	}
+	siblingRef, err := d.File.Add(pdf.Null{})
+	if err != nil {
+		return err
+	}
+
+	count, err := d.moveKids(path, kids[half:], siblingRef, sibling)
+	if err != nil {
+		return err
+	}
+	setKids(n.dict, kids[:half])
+	err = adjustCounts([]node{n}, -count)
+	if err != nil {
+		return err
+	}
+
+	parentKids, err := d.kids(parent.ref, parent.dict)
+	if err != nil {
+		return err
+	}
+	for i, kid := range parentKids {
+		if kid == n.ref {
+			parentKids = append(parentKids[:i+1], append([]pdf.ObjectReference{siblingRef}, parentKids[i+1:]...)...)
+			break
+		}
+	}
+	setKids(parent.dict, parentKids)
+
+	return nil
+}
+
+// splitRoot moves the kids of the root, the first node in path, into
+// two new nodes so that the root, which the catalog refers to, is kept
+func (d *Document) splitRoot(path []node, kids []pdf.ObjectReference) error {
+	root := path[0]
+	half := len(kids) / 2
+
+	newKids := []pdf.ObjectReference{}
+	for _, part := range [][]pdf.ObjectReference{kids[:half], kids[half:]} {
+		child := pdf.Dictionary{
+			pdf.Name("Type"):   pdf.Name("Pages"),
+			pdf.Name("Parent"): root.ref,
+		}
+		ref, err := d.File.Add(pdf.Null{})
+		if err != nil {
+			return err
+		}
+
+		_, err = d.moveKids(path, part, ref, child)
+		if err != nil {
+			return err
+		}
+		newKids = append(newKids, ref)
+	}
+	setKids(root.dict, newKids)
+
+	return nil
+}
+
+// moveKids makes kids the Kids of the node at ref and stores it,
+// returning the number of pages moved. Kids that are in path are
+// changed there, as the nodes in path are stored later and have
+// changes that the file does not have yet.
+func (d *Document) moveKids(path []node, kids []pdf.ObjectReference, ref pdf.ObjectReference, dict pdf.Dictionary) (int, error) {
+	total := 0
+	for _, kid := range kids {
+		var kidDict pdf.Dictionary
+		for _, n := range path {
+			if n.ref == kid {
+				kidDict = n.dict
+			}
+		}
+		if kidDict == nil {
+			var ok bool
+			kidDict, ok = d.File.Get(kid).(pdf.Dictionary)
+			if !ok {
+				return 0, fmt.Errorf("page tree node %v is not a dictionary", kid)
+			}
+		}
+
+		count, err := d.nodeCount(kid, kidDict)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+
+		kidDict[pdf.Name("Parent")] = ref
+		err = d.set(kid, kidDict)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	setKids(dict, append([]pdf.ObjectReference{}, kids...))
+	dict[pdf.Name("Count")] = pdf.Integer(total)
+	return total, d.set(ref, dict)
+}
+
+// remove takes the page at index out of the page tree, removing
+// nodes left without Kids, and returns the page and the attributes
+// it inherited
+func (d *Document) remove(index int) (pdf.ObjectReference, pdf.Dictionary, error) {
+	path, position, err := d.find(index, false)
+	if err != nil {
+		return pdf.ObjectReference{}, nil, err
+	}
+	inherited := ancestors(path)
+
+	leaf := path[len(path)-1]
+	kids, err := d.kids(leaf.ref, leaf.dict)
+	if err != nil {
+		return pdf.ObjectReference{}, nil, err
+	}
+	ref := kids[position]
+	setKids(leaf.dict, append(kids[:position:position], kids[position+1:]...))
+
+	err = adjustCounts(path, -1)
+	if err != nil {
+		return pdf.ObjectReference{}, nil, err
+	}
+
+	// the root is kept even when there are no pages
+	for level := len(path) - 1; level > 0; level-- {
+		kids, err := d.kids(path[level].ref, path[level].dict)
+		if err != nil {
+			return pdf.ObjectReference{}, nil, err
+		}
+		if len(kids) > 0 {
+			break
+		}
+
+		parent := path[level-1]
+		parentKids, err := d.kids(parent.ref, parent.dict)
+		if err != nil {
+			return pdf.ObjectReference{}, nil, err
+		}
+		for i, kid := range parentKids {
+			if kid == path[level].ref {
+				parentKids = append(parentKids[:i:i], parentKids[i+1:]...)
+				break
+			}
+		}
+		setKids(parent.dict, parentKids)
+
+		d.File.Free(path[level].ref.ObjectNumber)
+		path = path[:level]
+	}
+
+	return ref, inherited, d.setNodes(path)
+}
+
+// kids returns the Kids of the page tree node at ref
+func (d *Document) kids(ref pdf.ObjectReference, dict pdf.Dictionary) ([]pdf.ObjectReference, error) {
+	array, ok := d.resolve(dict[pdf.Name("Kids")]).(pdf.Array)
+	if !ok {
+		return nil, fmt.Errorf("page tree node %v does not have Kids", ref)
+	}
+
+	kids := make([]pdf.ObjectReference, len(array))
+	for i, kid := range array {
+		kids[i], ok = kid.(pdf.ObjectReference)
+		if !ok {
+			return nil, fmt.Errorf("kid of page tree node %v is not an indirect reference", ref)
+		}
+	}
+	return kids, nil
+}
+
+func setKids(dict pdf.Dictionary, kids []pdf.ObjectReference) {
+	array := make(pdf.Array, len(kids))
+	for i, kid := range kids {
+		array[i] = kid
+	}
+	dict[pdf.Name("Kids")] = array
+}
+
+// adjustCounts adds delta to the Count of each node in path
+func adjustCounts(path []node, delta int) error {
+	for _, n := range path {
+		count, ok := n.dict[pdf.Name("Count")].(pdf.Integer)
+		if !ok {
+			return fmt.Errorf("invalid Count for page tree node %v: %v", n.ref, n.dict[pdf.Name("Count")])
+		}
+		n.dict[pdf.Name("Count")] = count + pdf.Integer(delta)
+	}
+	return nil
+}
+
+// setNodes stores the nodes in path
+func (d *Document) setNodes(path []node) error {
+	for _, n := range path {
+		err := d.set(n.ref, n.dict)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set replaces the object at ref
+func (d *Document) set(ref pdf.ObjectReference, obj pdf.Object) error {
+	_, err := d.File.Add(pdf.IndirectObject{
+		ObjectReference: ref,
+		Object:          obj,
+	})
+	return err
+}
+
+// ancestors returns the attributes inherited from the nodes in path
+func ancestors(path []node) pdf.Dictionary {
+	inherited := pdf.Dictionary{}
+	for _, n := range path {
+		inherited = inherit(inherited, n.dict)
+	}
+	return inherited
+}
+
+// materialize sets the inheritable attributes page does not have
+// when those it inherited from are different from those it
+// will inherit from to, so that the page's attributes do not change
+func materialize(page, from, to pdf.Dictionary) {
+	for _, name := range inheritable {
+		if _, ok := page[name]; ok {
+			continue
+		}
+		if reflect.DeepEqual(from[name], to[name]) {
+			continue
+		}
+
+		if value, ok := from[name]; ok {
+			page[name] = value
+			continue
+		}
+
+		// the defaults when an attribute is not inherited
+		switch name {
+		case "Resources":
+			page[name] = pdf.Dictionary{}
+		case "MediaBox":
+			page[name] = defaultMediaBox.Array()
+		case "CropBox":
+			if mediaBox, ok := page[pdf.Name("MediaBox")]; ok {
+				page[name] = mediaBox
+			} else if mediaBox, ok := from[pdf.Name("MediaBox")]; ok {
+				page[name] = mediaBox
+			} else {
+				page[name] = defaultMediaBox.Array()
+			}
+		case "Rotate":
+			page[name] = pdf.Integer(0)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package document
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document with n pages in a single node,
+// each page's Contents is its original index
+func createPagesDocument(t *testing.T, n int) (*Document, func()) {
+	return createTestDocument(t, pageTree(t, n))
+}
+
+// returns a function that builds a page tree for createTestDocument
+// with n pages in a single node, each page's Contents is its index
+func pageTree(t *testing.T, n int) func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+	return func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		kids := pdf.Array{}
+		for i := 0; i < n; i++ {
+			kids = append(kids, add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+				pdf.Name("Type"):     pdf.Name("Page"),
+				pdf.Name("Parent"):   root,
+				pdf.Name("Contents"): pdf.Integer(i),
+			}))
+		}
+
+		return pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Pages"),
+			pdf.Name("Kids"):     kids,
+			pdf.Name("Count"):    pdf.Integer(n),
+			pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(100)},
+		}
+	}
+}
+
+// checks that the pages, identified by their Contents, are in order
+// and that the tree's Parents, Counts and number of Kids are correct
+func checkPages(t *testing.T, d *Document, expected []int) {
+	contents := []int{}
+	err := d.Pages(func(page Page) bool {
+		contents = append(contents, int(page.Dictionary[pdf.Name("Contents")].(pdf.Integer)))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected pages %v, got %v", expected, contents)
+	}
+
+	catalog, err := d.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var check func(ref, parent pdf.ObjectReference, depth int) (int, int)
+	check = func(ref, parent pdf.ObjectReference, depth int) (int, int) {
+		dict := d.File.Get(ref).(pdf.Dictionary)
+		if parent != (pdf.ObjectReference{}) && dict[pdf.Name("Parent")] != parent {
+			t.Errorf("expected %v to have Parent %v, got %v", ref, parent, dict[pdf.Name("Parent")])
+		}
+
+		if dict[pdf.Name("Type")] == pdf.Name("Page") {
+			return 1, depth
+		}
+
+		kids := dict[pdf.Name("Kids")].(pdf.Array)
+		if len(kids) > maxKids {
+			t.Errorf("%v has %d Kids", ref, len(kids))
+		}
+
+		count, leafDepth := 0, -1
+		for _, kid := range kids {
+			kidCount, kidDepth := check(kid.(pdf.ObjectReference), ref, depth+1)
+			count += kidCount
+			if leafDepth != -1 && kidDepth != leafDepth {
+				t.Errorf("pages under %v are at different depths", ref)
+			}
+			leafDepth = kidDepth
+		}
+
+		if dict[pdf.Name("Count")] != pdf.Integer(count) {
+			t.Errorf("expected %v to have Count %d, got %v", ref, count, dict[pdf.Name("Count")])
+		}
+		return count, leafDepth
+	}
+	check(catalog.Pages, pdf.ObjectReference{}, 0)
+}
+
+func sequence(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+func TestInsertPage(t *testing.T) {
+	d, cleanup := createPagesDocument(t, 0)
+	defer cleanup()
+
+	// enough pages to split the root and then its kids
+	n := maxKids * 4
+	expected := []int{}
+	for i := 0; i < n; i++ {
+		// alternate between appending and inserting in the middle
+		index := len(expected)
+		if i%2 == 1 {
+			index = len(expected) / 2
+		}
+
+		_, err := d.InsertPage(index, pdf.Dictionary{pdf.Name("Contents"): pdf.Integer(i)})
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		expected = append(expected, 0)
+		copy(expected[index+1:], expected[index:])
+		expected[index] = i
+	}
+	checkPages(t, d, expected)
+
+	for i := range expected {
+		page, err := d.Page(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Dictionary[pdf.Name("Contents")] != pdf.Integer(expected[i]) {
+			t.Errorf("%d: expected page %d, got %v", i, expected[i], page.Dictionary[pdf.Name("Contents")])
+		}
+		// inherited from the root
+		if page.MediaBox != (Rectangle{0, 0, 100, 100}) {
+			t.Errorf("%d: incorrect MediaBox %v", i, page.MediaBox)
+		}
+	}
+
+	_, err := d.InsertPage(n+1, pdf.Dictionary{})
+	if err == nil {
+		t.Error("expected an error when inserting past the end")
+	}
+}
+
+// nodes read from a file are copies, the splits must not
+// use them instead of the nodes that are being changed
+func TestInsertPageReopened(t *testing.T) {
+	dir, err := ioutil.TempDir("", "document")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.pdf")
+
+	// a full tree, so that inserting splits a node and the root
+	n := maxKids * maxKids
+	d := createTestDocumentAt(t, filename, pageTree(t, n))
+	err = d.RebalancePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.File.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.File.Close()
+
+	file, err := pdf.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	d = New(file)
+
+	expected := sequence(n)
+	for i, index := range []int{n / 2, 0, n + 1, n / 2} {
+		_, err := d.InsertPage(index, pdf.Dictionary{pdf.Name("Contents"): pdf.Integer(n + i)})
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		expected = append(expected, 0)
+		copy(expected[index+1:], expected[index:])
+		expected[index] = n + i
+		checkPages(t, d, expected)
+	}
+}
+
+func TestDeletePages(t *testing.T) {
+	d, cleanup := createPagesDocument(t, 100)
+	defer cleanup()
+
+	err := d.RebalancePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPages(t, d, sequence(100))
+
+	// delete all of the first node's pages and some others
+	deleted := map[int]bool{}
+	indexes := []int{}
+	for i := 0; i < 100; i++ {
+		if i < 40 || i%3 == 0 {
+			deleted[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	err = d.DeletePages(indexes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{}
+	for i := 0; i < 100; i++ {
+		if !deleted[i] {
+			expected = append(expected, i)
+		}
+	}
+	checkPages(t, d, expected)
+
+	err = d.DeletePages(len(expected))
+	if err == nil {
+		t.Error("expected an error when deleting a page that does not exist")
+	}
+}
+
+func TestMovePage(t *testing.T) {
+	d, refs, cleanup := createInheritanceDocument(t)
+	defer cleanup()
+
+	before := []Page{}
+	err := d.Pages(func(page Page) bool {
+		before = append(before, page)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// moves between nodes with different attributes
+	err = d.MovePage(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.MovePage(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []int{2, 1, 0}
+	for i, j := range order {
+		page, err := d.Page(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.ObjectReference != refs[j] {
+			t.Errorf("%d: expected %v, got %v", i, refs[j], page.ObjectReference)
+		}
+
+		page.Dictionary = before[j].Dictionary
+		if !reflect.DeepEqual(page, before[j]) {
+			t.Errorf("%d: attributes changed from %v to %v", i, before[j], page)
+		}
+	}
+
+	err = d.MovePage(0, 3)
+	if err == nil {
+		t.Error("expected an error when moving past the end")
+	}
+}
+
+func TestSetRotate(t *testing.T) {
+	d, _, cleanup := createInheritanceDocument(t)
+	defer cleanup()
+
+	tests := []struct {
+		degrees  int
+		expected int
+	}{
+		{0, 0},
+		{90, 90},
+		{-90, 270},
+		{450, 90},
+	}
+
+	for _, test := range tests {
+		err := d.SetRotate(1, test.degrees)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := d.Page(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Rotate != test.expected {
+			t.Errorf("%d: expected %d, got %d", test.degrees, test.expected, page.Rotate)
+		}
+	}
+
+	err := d.SetRotate(1, 45)
+	if err == nil {
+		t.Error("expected an error for 45 degrees")
+	}
+}
+
+func TestRebalancePages(t *testing.T) {
+	for _, n := range []int{0, 1, maxKids, maxKids + 1, maxKids * maxKids * 2} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			d, cleanup := createPagesDocument(t, n)
+			defer cleanup()
+
+			err := d.RebalancePages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPages(t, d, sequence(n))
+		})
+	}
+}
+
+func TestInsertPageRebalances(t *testing.T) {
+	// a flat tree, as written by many producers
+	n := maxKids * 3
+	d, cleanup := createPagesDocument(t, n)
+	defer cleanup()
+
+	_, err := d.InsertPage(n, pdf.Dictionary{pdf.Name("Contents"): pdf.Integer(n)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPages(t, d, sequence(n+1))
+}
@@ -0,0 +1,208 @@
+package document
+
+// StandardEncoding, the built-in encoding of Type 1 Latin text fonts
+// - §D.2
+var standardEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x2019, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x2018, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x0000, // 0x78
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x80
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x88
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x90
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x98
+	0x0000, 0x00a1, 0x00a2, 0x00a3, 0x2044, 0x00a5, 0x0192, 0x00a7, // 0xa0
+	0x00a4, 0x0027, 0x201c, 0x00ab, 0x2039, 0x203a, 0xfb01, 0xfb02, // 0xa8
+	0x0000, 0x2013, 0x2020, 0x2021, 0x00b7, 0x0000, 0x00b6, 0x2022, // 0xb0
+	0x201a, 0x201e, 0x201d, 0x00bb, 0x2026, 0x2030, 0x0000, 0x00bf, // 0xb8
+	0x0000, 0x0060, 0x00b4, 0x02c6, 0x02dc, 0x00af, 0x02d8, 0x02d9, // 0xc0
+	0x00a8, 0x0000, 0x02da, 0x00b8, 0x0000, 0x02dd, 0x02db, 0x02c7, // 0xc8
+	0x2014, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0xd0
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0xd8
+	0x0000, 0x00c6, 0x0000, 0x00aa, 0x0000, 0x0000, 0x0000, 0x0000, // 0xe0
+	0x0141, 0x00d8, 0x0152, 0x00ba, 0x0000, 0x0000, 0x0000, 0x0000, // 0xe8
+	0x0000, 0x00e6, 0x0000, 0x0000, 0x0000, 0x0131, 0x0000, 0x0000, // 0xf0
+	0x0142, 0x00f8, 0x0153, 0x00df, 0x0000, 0x0000, 0x0000, 0x0000, // 0xf8
+}
+
+// WinAnsiEncoding, the Windows code page 1252, where unused codes
+// above 040 are bullets
+// - §D.2
+var winAnsiEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x0027, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x0060, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x2022, // 0x78
+	0x20ac, 0x2022, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, // 0x80
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x2022, 0x017d, 0x2022, // 0x88
+	0x2022, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, // 0x90
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x2022, 0x017e, 0x0178, // 0x98
+	0x0020, 0x00a1, 0x00a2, 0x00a3, 0x00a4, 0x00a5, 0x00a6, 0x00a7, // 0xa0
+	0x00a8, 0x00a9, 0x00aa, 0x00ab, 0x00ac, 0x002d, 0x00ae, 0x00af, // 0xa8
+	0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x00b6, 0x00b7, // 0xb0
+	0x00b8, 0x00b9, 0x00ba, 0x00bb, 0x00bc, 0x00bd, 0x00be, 0x00bf, // 0xb8
+	0x00c0, 0x00c1, 0x00c2, 0x00c3, 0x00c4, 0x00c5, 0x00c6, 0x00c7, // 0xc0
+	0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf, // 0xc8
+	0x00d0, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7, // 0xd0
+	0x00d8, 0x00d9, 0x00da, 0x00db, 0x00dc, 0x00dd, 0x00de, 0x00df, // 0xd8
+	0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7, // 0xe0
+	0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef, // 0xe8
+	0x00f0, 0x00f1, 0x00f2, 0x00f3, 0x00f4, 0x00f5, 0x00f6, 0x00f7, // 0xf0
+	0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff, // 0xf8
+}
+
+// MacRomanEncoding, the Mac OS standard encoding for Latin text
+// - §D.2
+var macRomanEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x0027, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x0060, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x0000, // 0x78
+	0x00c4, 0x00c5, 0x00c7, 0x00c9, 0x00d1, 0x00d6, 0x00dc, 0x00e1, // 0x80
+	0x00e0, 0x00e2, 0x00e4, 0x00e3, 0x00e5, 0x00e7, 0x00e9, 0x00e8, // 0x88
+	0x00ea, 0x00eb, 0x00ed, 0x00ec, 0x00ee, 0x00ef, 0x00f1, 0x00f3, // 0x90
+	0x00f2, 0x00f4, 0x00f6, 0x00f5, 0x00fa, 0x00f9, 0x00fb, 0x00fc, // 0x98
+	0x2020, 0x00b0, 0x00a2, 0x00a3, 0x00a7, 0x2022, 0x00b6, 0x00df, // 0xa0
+	0x00ae, 0x00a9, 0x2122, 0x00b4, 0x00a8, 0x2260, 0x00c6, 0x00d8, // 0xa8
+	0x221e, 0x00b1, 0x2264, 0x2265, 0x00a5, 0x00b5, 0x2202, 0x2211, // 0xb0
+	0x220f, 0x03c0, 0x222b, 0x00aa, 0x00ba, 0x03a9, 0x00e6, 0x00f8, // 0xb8
+	0x00bf, 0x00a1, 0x00ac, 0x221a, 0x0192, 0x2248, 0x2206, 0x00ab, // 0xc0
+	0x00bb, 0x2026, 0x0020, 0x00c0, 0x00c3, 0x00d5, 0x0152, 0x0153, // 0xc8
+	0x2013, 0x2014, 0x201c, 0x201d, 0x2018, 0x2019, 0x00f7, 0x25ca, // 0xd0
+	0x00ff, 0x0178, 0x2044, 0x00a4, 0x2039, 0x203a, 0xfb01, 0xfb02, // 0xd8
+	0x2021, 0x00b7, 0x201a, 0x201e, 0x2030, 0x00c2, 0x00ca, 0x00c1, // 0xe0
+	0x00cb, 0x00c8, 0x00cd, 0x00ce, 0x00cf, 0x00cc, 0x00d3, 0x00d4, // 0xe8
+	0x0000, 0x00d2, 0x00da, 0x00db, 0x00d9, 0x0131, 0x02c6, 0x02dc, // 0xf0
+	0x00af, 0x02d8, 0x02d9, 0x02da, 0x00b8, 0x02dd, 0x02db, 0x02c7, // 0xf8
+}
+
+// glyphNames maps the names of glyphs in Encoding Differences to Unicode.
+// Other names are read as uniXXXX or uXXXX[XX] (§9.10.2).
+var glyphNames = map[string]rune{
+	"space": 0x0020, "exclam": 0x0021, "quotedbl": 0x0022, "numbersign": 0x0023,
+	"dollar": 0x0024, "percent": 0x0025, "ampersand": 0x0026,
+	"quotesingle": 0x0027, "parenleft": 0x0028, "parenright": 0x0029,
+	"asterisk": 0x002a, "plus": 0x002b, "comma": 0x002c, "hyphen": 0x002d,
+	"period": 0x002e, "slash": 0x002f, "zero": 0x0030, "one": 0x0031,
+	"two": 0x0032, "three": 0x0033, "four": 0x0034, "five": 0x0035, "six": 0x0036,
+	"seven": 0x0037, "eight": 0x0038, "nine": 0x0039, "colon": 0x003a,
+	"semicolon": 0x003b, "less": 0x003c, "equal": 0x003d, "greater": 0x003e,
+	"question": 0x003f, "at": 0x0040, "A": 0x0041, "B": 0x0042, "C": 0x0043,
+	"D": 0x0044, "E": 0x0045, "F": 0x0046, "G": 0x0047, "H": 0x0048, "I": 0x0049,
+	"J": 0x004a, "K": 0x004b, "L": 0x004c, "M": 0x004d, "N": 0x004e, "O": 0x004f,
+	"P": 0x0050, "Q": 0x0051, "R": 0x0052, "S": 0x0053, "T": 0x0054, "U": 0x0055,
+	"V": 0x0056, "W": 0x0057, "X": 0x0058, "Y": 0x0059, "Z": 0x005a,
+	"bracketleft": 0x005b, "backslash": 0x005c, "bracketright": 0x005d,
+	"asciicircum": 0x005e, "underscore": 0x005f, "grave": 0x0060, "a": 0x0061,
+	"b": 0x0062, "c": 0x0063, "d": 0x0064, "e": 0x0065, "f": 0x0066, "g": 0x0067,
+	"h": 0x0068, "i": 0x0069, "j": 0x006a, "k": 0x006b, "l": 0x006c, "m": 0x006d,
+	"n": 0x006e, "o": 0x006f, "p": 0x0070, "q": 0x0071, "r": 0x0072, "s": 0x0073,
+	"t": 0x0074, "u": 0x0075, "v": 0x0076, "w": 0x0077, "x": 0x0078, "y": 0x0079,
+	"z": 0x007a, "braceleft": 0x007b, "bar": 0x007c, "braceright": 0x007d,
+	"asciitilde": 0x007e, "nbspace": 0x00a0, "exclamdown": 0x00a1, "cent": 0x00a2,
+	"sterling": 0x00a3, "currency": 0x00a4, "yen": 0x00a5, "brokenbar": 0x00a6,
+	"section": 0x00a7, "dieresis": 0x00a8, "copyright": 0x00a9,
+	"ordfeminine": 0x00aa, "guillemotleft": 0x00ab, "logicalnot": 0x00ac,
+	"sfthyphen": 0x00ad, "registered": 0x00ae, "macron": 0x00af, "degree": 0x00b0,
+	"plusminus": 0x00b1, "twosuperior": 0x00b2, "threesuperior": 0x00b3,
+	"acute": 0x00b4, "mu": 0x00b5, "paragraph": 0x00b6, "periodcentered": 0x00b7,
+	"cedilla": 0x00b8, "onesuperior": 0x00b9, "ordmasculine": 0x00ba,
+	"guillemotright": 0x00bb, "onequarter": 0x00bc, "onehalf": 0x00bd,
+	"threequarters": 0x00be, "questiondown": 0x00bf, "Agrave": 0x00c0,
+	"Aacute": 0x00c1, "Acircumflex": 0x00c2, "Atilde": 0x00c3,
+	"Adieresis": 0x00c4, "Aring": 0x00c5, "AE": 0x00c6, "Ccedilla": 0x00c7,
+	"Egrave": 0x00c8, "Eacute": 0x00c9, "Ecircumflex": 0x00ca,
+	"Edieresis": 0x00cb, "Igrave": 0x00cc, "Iacute": 0x00cd,
+	"Icircumflex": 0x00ce, "Idieresis": 0x00cf, "Eth": 0x00d0, "Ntilde": 0x00d1,
+	"Ograve": 0x00d2, "Oacute": 0x00d3, "Ocircumflex": 0x00d4, "Otilde": 0x00d5,
+	"Odieresis": 0x00d6, "multiply": 0x00d7, "Oslash": 0x00d8, "Ugrave": 0x00d9,
+	"Uacute": 0x00da, "Ucircumflex": 0x00db, "Udieresis": 0x00dc,
+	"Yacute": 0x00dd, "Thorn": 0x00de, "germandbls": 0x00df, "agrave": 0x00e0,
+	"aacute": 0x00e1, "acircumflex": 0x00e2, "atilde": 0x00e3,
+	"adieresis": 0x00e4, "aring": 0x00e5, "ae": 0x00e6, "ccedilla": 0x00e7,
+	"egrave": 0x00e8, "eacute": 0x00e9, "ecircumflex": 0x00ea,
+	"edieresis": 0x00eb, "igrave": 0x00ec, "iacute": 0x00ed,
+	"icircumflex": 0x00ee, "idieresis": 0x00ef, "eth": 0x00f0, "ntilde": 0x00f1,
+	"ograve": 0x00f2, "oacute": 0x00f3, "ocircumflex": 0x00f4, "otilde": 0x00f5,
+	"odieresis": 0x00f6, "divide": 0x00f7, "oslash": 0x00f8, "ugrave": 0x00f9,
+	"uacute": 0x00fa, "ucircumflex": 0x00fb, "udieresis": 0x00fc,
+	"yacute": 0x00fd, "thorn": 0x00fe, "ydieresis": 0x00ff, "Amacron": 0x0100,
+	"amacron": 0x0101, "Abreve": 0x0102, "abreve": 0x0103, "Aogonek": 0x0104,
+	"aogonek": 0x0105, "Cacute": 0x0106, "cacute": 0x0107, "Ccaron": 0x010c,
+	"ccaron": 0x010d, "Dcaron": 0x010e, "dcaron": 0x010f, "Dcroat": 0x0110,
+	"dcroat": 0x0111, "Emacron": 0x0112, "emacron": 0x0113, "Eogonek": 0x0118,
+	"eogonek": 0x0119, "Ecaron": 0x011a, "ecaron": 0x011b, "Gbreve": 0x011e,
+	"gbreve": 0x011f, "Imacron": 0x012a, "imacron": 0x012b, "Idotaccent": 0x0130,
+	"dotlessi": 0x0131, "Lslash": 0x0141, "lslash": 0x0142, "Nacute": 0x0143,
+	"nacute": 0x0144, "Ncaron": 0x0147, "ncaron": 0x0148, "Omacron": 0x014c,
+	"omacron": 0x014d, "Ohungarumlaut": 0x0150, "ohungarumlaut": 0x0151,
+	"OE": 0x0152, "oe": 0x0153, "Racute": 0x0154, "racute": 0x0155,
+	"Rcaron": 0x0158, "rcaron": 0x0159, "Sacute": 0x015a, "sacute": 0x015b,
+	"Scedilla": 0x015e, "scedilla": 0x015f, "Scaron": 0x0160, "scaron": 0x0161,
+	"Tcaron": 0x0164, "tcaron": 0x0165, "Umacron": 0x016a, "umacron": 0x016b,
+	"Uring": 0x016e, "uring": 0x016f, "Uhungarumlaut": 0x0170,
+	"uhungarumlaut": 0x0171, "Ydieresis": 0x0178, "Zacute": 0x0179,
+	"zacute": 0x017a, "Zdotaccent": 0x017b, "zdotaccent": 0x017c,
+	"Zcaron": 0x017d, "zcaron": 0x017e, "florin": 0x0192, "dotlessj": 0x0237,
+	"circumflex": 0x02c6, "caron": 0x02c7, "breve": 0x02d8, "dotaccent": 0x02d9,
+	"ring": 0x02da, "ogonek": 0x02db, "tilde": 0x02dc, "hungarumlaut": 0x02dd,
+	"Alpha": 0x0391, "Beta": 0x0392, "Gamma": 0x0393, "Delta": 0x0394,
+	"Epsilon": 0x0395, "Zeta": 0x0396, "Eta": 0x0397, "Theta": 0x0398,
+	"Iota": 0x0399, "Kappa": 0x039a, "Lambda": 0x039b, "Mu": 0x039c, "Nu": 0x039d,
+	"Xi": 0x039e, "Omicron": 0x039f, "Pi": 0x03a0, "Rho": 0x03a1, "Sigma": 0x03a3,
+	"Tau": 0x03a4, "Upsilon": 0x03a5, "Phi": 0x03a6, "Chi": 0x03a7, "Psi": 0x03a8,
+	"Omega": 0x03a9, "alpha": 0x03b1, "beta": 0x03b2, "gamma": 0x03b3,
+	"delta": 0x03b4, "epsilon": 0x03b5, "zeta": 0x03b6, "eta": 0x03b7,
+	"theta": 0x03b8, "iota": 0x03b9, "kappa": 0x03ba, "lambda": 0x03bb,
+	"nu": 0x03bd, "xi": 0x03be, "omicron": 0x03bf, "pi": 0x03c0, "rho": 0x03c1,
+	"sigma1": 0x03c2, "sigma": 0x03c3, "tau": 0x03c4, "upsilon": 0x03c5,
+	"phi": 0x03c6, "chi": 0x03c7, "psi": 0x03c8, "omega": 0x03c9,
+	"endash": 0x2013, "emdash": 0x2014, "quoteleft": 0x2018, "quoteright": 0x2019,
+	"quotesinglbase": 0x201a, "quotedblleft": 0x201c, "quotedblright": 0x201d,
+	"quotedblbase": 0x201e, "dagger": 0x2020, "daggerdbl": 0x2021,
+	"bullet": 0x2022, "ellipsis": 0x2026, "perthousand": 0x2030,
+	"guilsinglleft": 0x2039, "guilsinglright": 0x203a, "fraction": 0x2044,
+	"Euro": 0x20ac, "trademark": 0x2122, "arrowleft": 0x2190, "arrowup": 0x2191,
+	"arrowright": 0x2192, "arrowdown": 0x2193, "partialdiff": 0x2202,
+	"increment": 0x2206, "product": 0x220f, "summation": 0x2211, "minus": 0x2212,
+	"radical": 0x221a, "infinity": 0x221e, "integral": 0x222b,
+	"approxequal": 0x2248, "notequal": 0x2260, "lessequal": 0x2264,
+	"greaterequal": 0x2265, "lozenge": 0x25ca, "ff": 0xfb00, "fi": 0xfb01,
+	"fl": 0xfb02, "ffi": 0xfb03, "ffl": 0xfb04,
+}
@@ -0,0 +1,136 @@
+package document
+
+import "github.com/nathankerr/pdf"
+
+// Extract appends the pages of src at indexes to dst in the order
+// given, the first page being 0. Only the objects the pages refer to,
+// such as their contents, fonts, images and annotations, are copied.
+// Links, outline items and named destinations to pages that are not
+// extracted are left out, as are form fields without widgets on the
+// extracted pages. When dst does not have a catalog, one is created.
+//
+// The streams of the extracted objects share their data with src,
+// so src must remain open until dst is saved.
+func Extract(dst, src *Document, indexes ...int) error {
+	m, err := newMerger(dst, MergeOptions{})
+	if err != nil {
+		return err
+	}
+
+	placements := make([]placement, len(indexes))
+	for i, index := range indexes {
+		placements[i] = placement{src: src, index: index}
+	}
+
+	err = m.place(placements)
+	if err != nil {
+		return err
+	}
+
+	return m.finish()
+}
+
+// destinations finds the pages that destinations are on
+// - §12.3.2
+type destinations struct {
+	d *Document
+
+	// the Dests name tree and the catalog's Dests dictionary
+	strings map[string]pdf.Object
+	names   pdf.Dictionary
+}
+
+// destinations reads the named destinations of the document
+func (d *Document) destinations(catalog Catalog) (*destinations, error) {
+	dests := &destinations{
+		d:       d,
+		strings: map[string]pdf.Object{},
+	}
+
+	if names, ok := d.resolve(catalog.Names).(pdf.Dictionary); ok {
+		if tree, ok := names[pdf.Name("Dests")]; ok {
+			var err error
+			dests.strings, err = d.nameTree(tree)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dests.names, _ = d.resolve(catalog.Dests).(pdf.Dictionary)
+
+	return dests, nil
+}
+
+// page returns the page the destination dest is on, and
+// whether dest is a destination on a page in the document
+func (dests *destinations) page(dest pdf.Object) (pdf.ObjectReference, bool) {
+	d := dests.d
+
+	dest = d.resolve(dest)
+	switch name := dest.(type) {
+	case pdf.String:
+		dest = d.resolve(dests.strings[string(name)])
+	case pdf.Name:
+		dest = d.resolve(dests.names[name])
+	}
+
+	// named destinations may be dictionaries with a D entry
+	if dict, ok := dest.(pdf.Dictionary); ok {
+		dest = d.resolve(dict[pdf.Name("D")])
+	}
+
+	array, ok := dest.(pdf.Array)
+	if !ok || len(array) == 0 {
+		return pdf.ObjectReference{}, false
+	}
+
+	page, ok := array[0].(pdf.ObjectReference)
+	return page, ok
+}
+
+// target returns the destination of the outline item or
+// annotation dict, which may be that of a go-to action
+// - §12.6.4.2
+func (d *Document) target(dict pdf.Dictionary) pdf.Object {
+	if dest, ok := dict[pdf.Name("Dest")]; ok {
+		return dest
+	}
+
+	action, ok := d.resolve(dict[pdf.Name("A")]).(pdf.Dictionary)
+	if ok && action[pdf.Name("S")] == pdf.Name("GoTo") {
+		return action[pdf.Name("D")]
+	}
+
+	return nil
+}
+
+// excluded returns whether dest is on a page of s that is not placed
+func (s *source) excluded(dest pdf.Object) bool {
+	if dest == nil {
+		return false
+	}
+
+	page, ok := s.dests.page(dest)
+	return ok && !s.included[page]
+}
+
+// topField returns the top-level field of the widget annotation at ref
+// - §12.7.4
+func topField(d *Document, ref pdf.ObjectReference) pdf.ObjectReference {
+	visited := map[pdf.ObjectReference]bool{}
+	for !visited[ref] {
+		visited[ref] = true
+
+		dict, ok := d.File.Get(ref).(pdf.Dictionary)
+		if !ok {
+			break
+		}
+		parent, ok := dict[pdf.Name("Parent")].(pdf.ObjectReference)
+		if !ok {
+			break
+		}
+		ref = parent
+	}
+	return ref
+}
@@ -0,0 +1,174 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document without pages
+func createEmptyDocument(t *testing.T) (*Document, func()) {
+	return createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Pages"),
+			pdf.Name("Kids"):  pdf.Array{},
+			pdf.Name("Count"): pdf.Integer(0),
+		}
+	})
+}
+
+func TestExtractPrunes(t *testing.T) {
+	src, cleanupSrc := createMergeSource(t)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	// the link, named destination and outline item are
+	// to the second page, which has the form field
+	err := Extract(dst, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := dst.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annots := page.Dictionary[pdf.Name("Annots")]; !reflect.DeepEqual(annots, pdf.Array{}) {
+		t.Errorf("expected the link to be removed, got %v", annots)
+	}
+	if structParents, ok := page.Dictionary[pdf.Name("StructParents")]; ok {
+		t.Errorf("expected StructParents to be removed, got %v", structParents)
+	}
+	if page.MediaBox != (Rectangle{0, 0, 100, 100}) {
+		t.Errorf("incorrect MediaBox %v", page.MediaBox)
+	}
+
+	catalog, err := dst.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]pdf.Object{
+		"Names":          catalog.Names,
+		"Outlines":       catalog.Outlines,
+		"AcroForm":       catalog.AcroForm,
+		"StructTreeRoot": catalog.StructTreeRoot,
+	} {
+		if value != nil {
+			t.Errorf("expected no %s, got %v", name, value)
+		}
+	}
+
+	labels, err := dst.numberTree(catalog.PageLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]pdf.Object{0: pdf.Dictionary{pdf.Name("S"): pdf.Name("r")}}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected page labels %v, got %v", expected, labels)
+	}
+
+	// the second page and what refers to it are not copied
+	for _, info := range dst.File.Objects() {
+		if info.State != pdf.ObjectNew {
+			continue
+		}
+		dict, ok := dst.File.Get(info.ObjectReference).(pdf.Dictionary)
+		if !ok {
+			continue
+		}
+		if dict[pdf.Name("Subtype")] == pdf.Name("Widget") || dict[pdf.Name("Title")] != nil {
+			t.Errorf("expected %v not to be copied: %v", info.ObjectReference, dict)
+		}
+	}
+
+	err = dst.File.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractOrder(t *testing.T) {
+	src, cleanupSrc := createMergeSource(t)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	err := Extract(dst, src, 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []Page{}
+	err = dst.Pages(func(page Page) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if pages[0].ObjectReference == pages[2].ObjectReference {
+		t.Errorf("expected a repeated page to be a new page object")
+	}
+
+	// the link is kept, and refers to where its destination was first placed
+	link := dst.File.Get(pages[1].Dictionary[pdf.Name("Annots")].(pdf.Array)[0].(pdf.ObjectReference)).(pdf.Dictionary)
+	if link[pdf.Name("P")] != pages[1].ObjectReference {
+		t.Errorf("expected the link's page to be %v, got %v", pages[1].ObjectReference, link[pdf.Name("P")])
+	}
+
+	catalog, err := dst.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dests, err := dst.nameTree(catalog.Names.(pdf.Dictionary)[pdf.Name("Dests")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDests := map[string]pdf.Object{
+		"chapter": pdf.Array{pages[0].ObjectReference, pdf.Name("Fit")},
+	}
+	if !reflect.DeepEqual(dests, expectedDests) {
+		t.Errorf("expected Dests %v, got %v", expectedDests, dests)
+	}
+
+	outlines := dst.File.Get(catalog.Outlines.(pdf.ObjectReference)).(pdf.Dictionary)
+	if outlines[pdf.Name("Count")] != pdf.Integer(1) {
+		t.Errorf("expected outline Count 1, got %v", outlines[pdf.Name("Count")])
+	}
+
+	fields := catalog.AcroForm.(pdf.Dictionary)[pdf.Name("Fields")].(pdf.Array)
+	if len(fields) != 1 {
+		t.Errorf("expected 1 field, got %v", fields)
+	}
+
+	// the pages keep their roman numerals, with the third
+	// continuing the range of the second
+	labels, err := dst.numberTree(catalog.PageLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[int]pdf.Object{
+		0: pdf.Dictionary{pdf.Name("S"): pdf.Name("r"), pdf.Name("St"): pdf.Integer(2)},
+		1: pdf.Dictionary{pdf.Name("S"): pdf.Name("r")},
+	}
+	if !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("expected page labels %v, got %v", expectedLabels, labels)
+	}
+}
+
+func TestExtractPageDoesNotExist(t *testing.T) {
+	src, cleanupSrc := createMergeSource(t)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	err := Extract(dst, src, 2)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
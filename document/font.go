@@ -0,0 +1,609 @@
+package document
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/nathankerr/pdf"
+)
+
+// widths of glyphs when a font does not have them, as the standard
+// 14 fonts might not, in thousandths of text space units
+const (
+	defaultWidth    = 500
+	monospacedWidth = 600
+)
+
+// a font's ascent and descent when its descriptor does not have them
+const (
+	defaultAscent  = 0.8
+	defaultDescent = -0.2
+)
+
+// a font that text is shown with, as far as is needed
+// to find the text and positions of its glyphs
+// - §9.5
+type font struct {
+	name pdf.Name
+
+	// composite fonts have codes of one or more bytes,
+	// which are mapped to CIDs by encoding, and the widths
+	// are by CID; simple fonts have one byte codes, which
+	// are mapped to text by runes, and the widths are by code
+	composite bool
+	encoding  *cmap
+	runes     [256]string
+
+	toUnicode *cmap
+
+	// in text space units
+	widths       map[int]float64
+	widthRanges  []widthRange
+	defaultWidth float64
+	ascent       float64
+	descent      float64
+}
+
+// the width of the CIDs from first through last,
+// from the cfirst clast w form of a W array
+type widthRange struct {
+	first, last int
+	width       float64
+}
+
+// a glyph shown with a font
+type glyph struct {
+	text string
+
+	// in text space units
+	width float64
+
+	// word spacing is applied to single-byte codes of 32
+	space bool
+}
+
+// font returns the font dictionary at ref, which
+// is loaded once for each ref
+func (e *textExtractor) font(obj pdf.Object) *font {
+	ref, isRef := obj.(pdf.ObjectReference)
+	if isRef {
+		if f, ok := e.fonts[ref]; ok {
+			return f
+		}
+	}
+
+	f := e.d.loadFont(e.d.dictionary(obj))
+	if isRef {
+		e.fonts[ref] = f
+	}
+	return f
+}
+
+// dictionary returns obj, resolved, when it is a dictionary
+func (d *Document) dictionary(obj pdf.Object) pdf.Dictionary {
+	switch typed := d.resolve(obj).(type) {
+	case pdf.Dictionary:
+		return typed
+	case pdf.Stream:
+		return typed.Dictionary
+	}
+	return pdf.Dictionary{}
+}
+
+// loadFont reads what is needed from the font dictionary dict.
+// Fonts are read leniently, missing entries having defaults,
+// so that as much text as possible is found.
+func (d *Document) loadFont(dict pdf.Dictionary) *font {
+	f := &font{
+		widths:       map[int]float64{},
+		defaultWidth: defaultWidth / 1000.0,
+		ascent:       defaultAscent,
+		descent:      defaultDescent,
+	}
+	f.name, _ = d.resolve(dict[pdf.Name("BaseFont")]).(pdf.Name)
+
+	if stream, ok := d.resolve(dict[pdf.Name("ToUnicode")]).(pdf.Stream); ok {
+		data, err := d.File.Decode(stream)
+		if err == nil {
+			f.toUnicode = parseCMap(data)
+		}
+	}
+
+	// glyph space is thousandths of text space,
+	// except for Type 3 fonts (§9.6.5)
+	scale, vertical := 0.001, 0.001
+	subtype, _ := d.resolve(dict[pdf.Name("Subtype")]).(pdf.Name)
+	if subtype == "Type3" {
+		matrix, _ := d.resolve(dict[pdf.Name("FontMatrix")]).(pdf.Array)
+		if len(matrix) == 6 {
+			scale, _ = number(d.resolve(matrix[0]))
+			vertical, _ = number(d.resolve(matrix[3]))
+		}
+
+		bbox, _ := d.resolve(dict[pdf.Name("FontBBox")]).(pdf.Array)
+		if len(bbox) == 4 {
+			lly, _ := number(d.resolve(bbox[1]))
+			ury, _ := number(d.resolve(bbox[3]))
+			if lly < ury {
+				f.descent, f.ascent = lly*vertical, ury*vertical
+			}
+		}
+	}
+
+	descriptor := d.dictionary(dict[pdf.Name("FontDescriptor")])
+	if subtype == "Type0" {
+		f.composite = true
+		descendants, _ := d.resolve(dict[pdf.Name("DescendantFonts")]).(pdf.Array)
+		if len(descendants) > 0 {
+			descendant := d.dictionary(descendants[0])
+			descriptor = d.dictionary(descendant[pdf.Name("FontDescriptor")])
+			d.loadCIDWidths(f, descendant)
+		}
+		d.loadCMap(f, dict[pdf.Name("Encoding")])
+	} else {
+		d.loadSimpleWidths(f, dict, descriptor, scale)
+		d.loadEncoding(f, dict[pdf.Name("Encoding")])
+	}
+
+	if ascent, ok := number(d.resolve(descriptor[pdf.Name("Ascent")])); ok && ascent != 0 {
+		f.ascent = ascent * vertical
+	}
+	if descent, ok := number(d.resolve(descriptor[pdf.Name("Descent")])); ok && descent != 0 {
+		f.descent = descent * vertical
+	}
+
+	return f
+}
+
+// loadSimpleWidths reads the Widths of a simple font, which are
+// in glyph space and are by code from FirstChar
+// - §9.6.2.1
+func (d *Document) loadSimpleWidths(f *font, dict, descriptor pdf.Dictionary, scale float64) {
+	if strings.Contains(string(f.name), "Courier") {
+		f.defaultWidth = monospacedWidth / 1000.0
+	}
+	if missing, ok := number(d.resolve(descriptor[pdf.Name("MissingWidth")])); ok && missing > 0 {
+		f.defaultWidth = missing * scale
+	}
+
+	first, _ := number(d.resolve(dict[pdf.Name("FirstChar")]))
+	widths, _ := d.resolve(dict[pdf.Name("Widths")]).(pdf.Array)
+	for i, width := range widths {
+		if w, ok := number(d.resolve(width)); ok {
+			f.widths[int(first)+i] = w * scale
+		}
+	}
+}
+
+// loadCIDWidths reads the W and DW of a CIDFont,
+// which are in thousandths of text space and are by CID
+// - §9.7.4.3
+func (d *Document) loadCIDWidths(f *font, dict pdf.Dictionary) {
+	f.defaultWidth = 1
+	if dw, ok := number(d.resolve(dict[pdf.Name("DW")])); ok {
+		f.defaultWidth = dw / 1000
+	}
+
+	w, _ := d.resolve(dict[pdf.Name("W")]).(pdf.Array)
+	for i := 0; i+1 < len(w); {
+		first, ok := number(d.resolve(w[i]))
+		if !ok {
+			return
+		}
+
+		// c [w1 w2 ... wn]
+		if widths, ok := d.resolve(w[i+1]).(pdf.Array); ok {
+			for j, width := range widths {
+				if value, ok := number(d.resolve(width)); ok {
+					f.widths[int(first)+j] = value / 1000
+				}
+			}
+			i += 2
+			continue
+		}
+
+		// cfirst clast w
+		if i+2 >= len(w) {
+			return
+		}
+		last, _ := number(d.resolve(w[i+1]))
+		width, _ := number(d.resolve(w[i+2]))
+		f.widthRanges = append(f.widthRanges, widthRange{int(first), int(last), width / 1000})
+		i += 3
+	}
+}
+
+// width returns the width of the glyph with the code or CID id,
+// where the ranges are kept rather than each of their CIDs, as
+// a small W array can have ranges of millions of CIDs
+func (f *font) width(id int) float64 {
+	if width, ok := f.widths[id]; ok {
+		return width
+	}
+	for i := len(f.widthRanges) - 1; i >= 0; i-- {
+		if r := f.widthRanges[i]; r.first <= id && id <= r.last {
+			return r.width
+		}
+	}
+	return f.defaultWidth
+}
+
+// loadEncoding reads the Encoding of a simple font. When it does not
+// have one, StandardEncoding is used instead of its built-in encoding.
+// - §9.6.6
+func (d *Document) loadEncoding(f *font, obj pdf.Object) {
+	base := pdf.Name("StandardEncoding")
+	var differences pdf.Array
+	switch typed := d.resolve(obj).(type) {
+	case pdf.Name:
+		base = typed
+	case pdf.Dictionary:
+		if name, ok := d.resolve(typed[pdf.Name("BaseEncoding")]).(pdf.Name); ok {
+			base = name
+		}
+		differences, _ = d.resolve(typed[pdf.Name("Differences")]).(pdf.Array)
+	}
+
+	table := &standardEncoding
+	switch base {
+	case "WinAnsiEncoding":
+		table = &winAnsiEncoding
+	case "MacRomanEncoding":
+		table = &macRomanEncoding
+	}
+	for code, r := range table {
+		if r != 0 {
+			f.runes[code] = string(r)
+		}
+	}
+
+	// [code name name ... code name ...]
+	code := 0
+	for _, difference := range differences {
+		switch typed := d.resolve(difference).(type) {
+		case pdf.Integer:
+			code = int(typed)
+		case pdf.Name:
+			if code >= 0 && code < len(f.runes) {
+				f.runes[code] = glyphText(string(typed))
+			}
+			code++
+		}
+	}
+}
+
+// loadCMap reads the Encoding of a composite font, which is
+// the name of a predefined CMap or an embedded CMap. Of the
+// predefined CMaps, only Identity-H and Identity-V are known;
+// the others are read as two byte codes.
+// - §9.7.5
+func (d *Document) loadCMap(f *font, obj pdf.Object) {
+	switch typed := d.resolve(obj).(type) {
+	case pdf.Stream:
+		data, err := d.File.Decode(typed)
+		if err == nil {
+			f.encoding = parseCMap(data)
+		}
+	case pdf.Name:
+		// Unicode CMaps have Unicode codes, which
+		// are the text when there is no ToUnicode
+		name := string(typed)
+		if f.toUnicode == nil && (strings.Contains(name, "UCS2") || strings.Contains(name, "UTF16")) {
+			f.toUnicode = &cmap{unicode: true}
+		}
+	}
+
+	if f.encoding == nil {
+		f.encoding = &cmap{}
+	}
+	if len(f.encoding.codespace) == 0 {
+		f.encoding.codespace = []codespaceRange{{low: []byte{0, 0}, high: []byte{0xff, 0xff}}}
+	}
+}
+
+// glyphs returns the glyphs that s shows
+// - §9.4.3
+func (f *font) glyphs(s pdf.String) []glyph {
+	glyphs := []glyph{}
+	for len(s) > 0 {
+		n := 1
+		if f.composite {
+			n = f.encoding.codeLength(s)
+		}
+		code := s[:n]
+		s = s[n:]
+
+		g := glyph{space: n == 1 && code[0] == 32}
+
+		// codes that are not mapped to CIDs are CID 0
+		// - §9.7.6.3
+		id := int(code[0])
+		if f.composite {
+			if cid, ok := f.encoding.cid(code); ok {
+				id = cid
+			} else if len(f.encoding.cids) > 0 || len(f.encoding.cidRanges) > 0 {
+				id = 0
+			} else {
+				id = codeValue(code)
+			}
+		}
+
+		g.width = f.width(id)
+
+		text, ok := "", false
+		if f.toUnicode != nil {
+			text, ok = f.toUnicode.text(code)
+		}
+		if !ok && !f.composite {
+			text, ok = f.runes[code[0]], f.runes[code[0]] != ""
+		}
+		if !ok {
+			text = "�"
+		}
+		g.text = text
+
+		glyphs = append(glyphs, g)
+	}
+	return glyphs
+}
+
+// glyphText returns the text of the glyph with name, which is one of
+// glyphNames, uniXXXX (which can have several code units), uXXXX[XX],
+// or those joined by underscores for ligatures, optionally followed
+// by a suffix after a period
+// - §9.10.2
+func glyphText(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	if r, ok := glyphNames[name]; ok {
+		return string(r)
+	}
+
+	if strings.Contains(name, "_") {
+		text := ""
+		for _, component := range strings.Split(name, "_") {
+			text += glyphText(component)
+		}
+		return text
+	}
+
+	if strings.HasPrefix(name, "uni") && len(name) > 3 && (len(name)-3)%4 == 0 {
+		units := []uint16{}
+		for i := 3; i < len(name); i += 4 {
+			unit, err := strconv.ParseUint(name[i:i+4], 16, 16)
+			if err != nil {
+				return ""
+			}
+			units = append(units, uint16(unit))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	if strings.HasPrefix(name, "u") && len(name) >= 5 && len(name) <= 7 {
+		r, err := strconv.ParseUint(name[1:], 16, 32)
+		if err == nil {
+			return string(rune(r))
+		}
+	}
+
+	return ""
+}
+
+// a CMap, which maps codes to CIDs for the
+// Encoding of composite fonts and to Unicode
+// text for ToUnicode
+// - §9.7.5
+// - §9.10.3
+type cmap struct {
+	codespace []codespaceRange
+
+	cids      map[string]int
+	cidRanges []cidRange
+
+	chars    map[string]string
+	bfRanges []bfRange
+
+	// the codes are UTF-16BE text
+	unicode bool
+}
+
+type codespaceRange struct {
+	low, high []byte
+}
+
+type cidRange struct {
+	low, high []byte
+	cid       int
+}
+
+// the destination is the first code's text, whose last code unit
+// is incremented for the others, or the text of each code
+type bfRange struct {
+	low, high   []byte
+	destination []uint16
+	texts       []string
+}
+
+// parseCMap reads the mappings in the CMap data, which is
+// PostScript that can be read like a content stream. As much
+// of a damaged CMap is used as can be read.
+func parseCMap(data []byte) *cmap {
+	c := &cmap{
+		cids:  map[string]int{},
+		chars: map[string]string{},
+	}
+
+	// the operands of the end operators are the mappings
+	instructions, _ := pdf.ParseContent(data)
+	for _, instruction := range instructions {
+		operands := instruction.Operands
+		switch instruction.Operator {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				low, ok1 := operands[i].(pdf.String)
+				high, ok2 := operands[i+1].(pdf.String)
+				if ok1 && ok2 && len(low) == len(high) && len(low) > 0 {
+					c.codespace = append(c.codespace, codespaceRange{low, high})
+				}
+			}
+		case "endcidchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, ok1 := operands[i].(pdf.String)
+				cid, ok2 := operands[i+1].(pdf.Integer)
+				if ok1 && ok2 {
+					c.cids[string(code)] = int(cid)
+				}
+			}
+		case "endcidrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(pdf.String)
+				high, ok2 := operands[i+1].(pdf.String)
+				cid, ok3 := operands[i+2].(pdf.Integer)
+				if ok1 && ok2 && ok3 && len(low) == len(high) {
+					c.cidRanges = append(c.cidRanges, cidRange{low, high, int(cid)})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, ok := operands[i].(pdf.String)
+				if !ok {
+					continue
+				}
+				switch destination := operands[i+1].(type) {
+				case pdf.String:
+					c.chars[string(code)] = utf16Text(destination)
+				case pdf.Name:
+					c.chars[string(code)] = glyphText(string(destination))
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(pdf.String)
+				high, ok2 := operands[i+1].(pdf.String)
+				if !ok1 || !ok2 || len(low) != len(high) {
+					continue
+				}
+				r := bfRange{low: low, high: high}
+				switch destination := operands[i+2].(type) {
+				case pdf.String:
+					r.destination = utf16Units(destination)
+				case pdf.Array:
+					for _, text := range destination {
+						s, _ := text.(pdf.String)
+						r.texts = append(r.texts, utf16Text(s))
+					}
+				}
+				c.bfRanges = append(c.bfRanges, r)
+			}
+		}
+	}
+
+	return c
+}
+
+// codeLength returns the number of bytes in the code at the start
+// of s, which is the length of the codespace range it is in. When it
+// is not in one, the length of the shortest range is used.
+// - §9.7.6.2
+func (c *cmap) codeLength(s []byte) int {
+	shortest := 0
+	for _, r := range c.codespace {
+		n := len(r.low)
+		if shortest == 0 || n < shortest {
+			shortest = n
+		}
+		if n <= len(s) && inRange(s[:n], r.low, r.high) {
+			return n
+		}
+	}
+
+	if shortest == 0 {
+		shortest = 1
+	}
+	if shortest > len(s) {
+		shortest = len(s)
+	}
+	return shortest
+}
+
+// cid returns the CID of code
+func (c *cmap) cid(code []byte) (int, bool) {
+	if cid, ok := c.cids[string(code)]; ok {
+		return cid, true
+	}
+	for _, r := range c.cidRanges {
+		if len(code) == len(r.low) && inRange(code, r.low, r.high) {
+			return r.cid + codeValue(code) - codeValue(r.low), true
+		}
+	}
+	return 0, false
+}
+
+// text returns the Unicode text of code
+func (c *cmap) text(code []byte) (string, bool) {
+	if c.unicode {
+		return utf16Text(code), true
+	}
+
+	if text, ok := c.chars[string(code)]; ok {
+		return text, true
+	}
+	for _, r := range c.bfRanges {
+		if len(code) != len(r.low) || !inRange(code, r.low, r.high) {
+			continue
+		}
+
+		offset := codeValue(code) - codeValue(r.low)
+		if r.texts != nil {
+			if offset < len(r.texts) {
+				return r.texts[offset], true
+			}
+			return "", false
+		}
+		if len(r.destination) == 0 {
+			return "", false
+		}
+		units := append([]uint16{}, r.destination...)
+		units[len(units)-1] += uint16(offset)
+		return string(utf16.Decode(units)), true
+	}
+	return "", false
+}
+
+// inRange reports whether each byte of code is between
+// the bytes of low and high, which have the same length
+func inRange(code, low, high []byte) bool {
+	for i := range code {
+		if code[i] < low[i] || code[i] > high[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// codeValue returns code as a big-endian number
+func codeValue(code []byte) int {
+	value := 0
+	for _, b := range code {
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+// utf16Units returns the big-endian code units in s
+func utf16Units(s []byte) []uint16 {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return units
+}
+
+// utf16Text returns the UTF-16BE s as text, dropping a byte order mark
+func utf16Text(s []byte) string {
+	s = bytes.TrimPrefix(s, []byte{0xfe, 0xff})
+	return string(utf16.Decode(utf16Units(s)))
+}
@@ -0,0 +1,401 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/nathankerr/pdf"
+)
+
+// Order is the order pages fill the cells of an Imposition's grid.
+type Order int
+
+const (
+	// LeftToRight fills the rows from left to right,
+	// starting with the top row.
+	LeftToRight Order = iota
+
+	// RightToLeft fills the rows from right to left,
+	// starting with the top row.
+	RightToLeft
+
+	// TopToBottom fills the columns from top to bottom,
+	// starting with the left column.
+	TopToBottom
+
+	// TopToBottomRightToLeft fills the columns from top
+	// to bottom, starting with the right column.
+	TopToBottomRightToLeft
+)
+
+// Margins are the space between the edges of a sheet and its grid.
+type Margins struct {
+	Top, Right, Bottom, Left float64
+}
+
+// the distance crop marks are from the corners of the pages and their length
+const (
+	cropMarkOffset = 3
+	cropMarkLength = 12
+)
+
+// Imposition places the pages of a document on sheets, which are
+// added to another, in a grid of equally sized cells. The pages are
+// imported as form XObjects, so they are drawn as they are displayed,
+// and each page is centered in its cell and clipped to it. Distances
+// are in default user space units, which are 1/72 inch.
+type Imposition struct {
+	// Width and Height are the size of the sheets. When they are
+	// zero, the sheets fit the grid of the first page at Scale.
+	Width, Height float64
+
+	// Columns and Rows of the grid, which are 1 when zero.
+	Columns, Rows int
+
+	Margins Margins
+
+	// ColumnGutter and RowGutter are the space between
+	// the columns and between the rows.
+	ColumnGutter, RowGutter float64
+
+	// Order is how the pages fill the grid. For booklets, RightToLeft
+	// binds on the right, and the other orders bind on the left.
+	Order Order
+
+	// Scale of the pages. When zero, each page is scaled to
+	// fit its cell, keeping its aspect ratio.
+	Scale float64
+
+	// CropMarks adds marks around the corners of the pages
+	// where the sheets are cut. Marks are not drawn over pages.
+	CropMarks bool
+
+	// SignatureSheets is the number of sheets folded together in
+	// each signature of a booklet. When zero, all sheets are in one.
+	SignatureSheets int
+
+	// Creep is how far the pages of a booklet are moved toward the
+	// spine for each sheet they are inside of their signature's
+	// outermost sheet, compensating for the thickness of the paper.
+	Creep float64
+}
+
+// a page in a cell of a sheet
+type cell struct {
+	column, row int
+
+	// index of the page, or -1 when the cell is blank
+	page int
+
+	// where the page is horizontally in the cell,
+	// 0 at the left and 1 at the right
+	align float64
+
+	// moves the page to the right
+	shift float64
+}
+
+// NUp places the pages of src in order on sheets that are added
+// to the end of dst, filling each sheet's grid in Order.
+func (im Imposition) NUp(dst, src *Document) error {
+	count, err := src.PageCount()
+	if err != nil {
+		return err
+	}
+
+	columns, rows := im.grid()
+	sheets := [][]cell{}
+	for page := 0; page < count; page++ {
+		i := page % (columns * rows)
+		if i == 0 {
+			sheets = append(sheets, []cell{})
+		}
+
+		column, row := im.position(i)
+		sheets[len(sheets)-1] = append(sheets[len(sheets)-1], cell{
+			column: column,
+			row:    row,
+			page:   page,
+			align:  0.5,
+		})
+	}
+
+	return im.impose(dst, src, columns, rows, sheets)
+}
+
+// StepAndRepeat fills the grid of a sheet with each
+// page of src, adding the sheets to the end of dst.
+func (im Imposition) StepAndRepeat(dst, src *Document) error {
+	count, err := src.PageCount()
+	if err != nil {
+		return err
+	}
+
+	columns, rows := im.grid()
+	sheets := [][]cell{}
+	for page := 0; page < count; page++ {
+		sheet := []cell{}
+		for i := 0; i < columns*rows; i++ {
+			column, row := im.position(i)
+			sheet = append(sheet, cell{
+				column: column,
+				row:    row,
+				page:   page,
+				align:  0.5,
+			})
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	return im.impose(dst, src, columns, rows, sheets)
+}
+
+// Booklet places the pages of src for saddle stitching. Two pages
+// are placed side by side on each side of a sheet, with the spine
+// between them, so that when the sheets of a signature are printed
+// on both sides, folded and nested, the pages are in order. Blank
+// pages are added when the number of pages is not a multiple of
+// four. The sides of the sheets are added to the end of dst, the
+// front of each sheet being followed by its back. Both sides are
+// upright, for printing on both sides by flipping on the edge that
+// is parallel to the spine, which is the short edge of sheets that
+// are wider than they are tall. Columns, Rows and ColumnGutter are
+// not used.
+func (im Imposition) Booklet(dst, src *Document) error {
+	count, err := src.PageCount()
+	if err != nil {
+		return err
+	}
+
+	im.Columns, im.Rows, im.ColumnGutter = 2, 1, 0
+	return im.impose(dst, src, 2, 1, im.booklet(count))
+}
+
+// booklet returns the sides of the sheets of a booklet with count pages
+func (im Imposition) booklet(count int) [][]cell {
+	padded := (count + 3) / 4 * 4
+	size := padded
+	if im.SignatureSheets > 0 {
+		size = 4 * im.SignatureSheets
+	}
+
+	sides := [][]cell{}
+	for start := 0; start < padded; start += size {
+		n := size
+		if start+n > padded {
+			n = padded - start
+		}
+
+		for sheet := 0; sheet < n/4; sheet++ {
+			// the pages of the front and back, left to right
+			// when the booklet is bound on the left
+			for _, pages := range [][2]int{
+				{n - 1 - 2*sheet, 2 * sheet},
+				{2*sheet + 1, n - 2 - 2*sheet},
+			} {
+				if im.Order == RightToLeft {
+					pages[0], pages[1] = pages[1], pages[0]
+				}
+
+				side := []cell{}
+				for column, page := range pages {
+					page += start
+					if page >= count {
+						page = -1
+					}
+
+					// the pages are against the spine, moved
+					// toward it to compensate for creep
+					c := cell{column: column, page: page, align: 1, shift: float64(sheet) * im.Creep}
+					if column == 1 {
+						c.align, c.shift = 0, -c.shift
+					}
+					side = append(side, c)
+				}
+				sides = append(sides, side)
+			}
+		}
+	}
+
+	return sides
+}
+
+// grid returns the number of columns and rows
+func (im Imposition) grid() (int, int) {
+	columns, rows := im.Columns, im.Rows
+	if columns < 1 {
+		columns = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return columns, rows
+}
+
+// position returns the column and row of the ith cell in Order,
+// the top left cell being in column 0 and row 0
+func (im Imposition) position(i int) (int, int) {
+	columns, rows := im.grid()
+	switch im.Order {
+	case RightToLeft:
+		return columns - 1 - i%columns, i / columns
+	case TopToBottom:
+		return i / rows, i % rows
+	case TopToBottomRightToLeft:
+		return columns - 1 - i/rows, i % rows
+	}
+	return i % columns, i / columns
+}
+
+// impose adds the sheets, which are in a grid of columns and rows,
+// to the end of dst
+func (im Imposition) impose(dst, src *Document, columns, rows int, sheets [][]cell) error {
+	pages := []Page{}
+	err := src.Pages(func(page Page) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return errors.New("there are no pages to impose")
+	}
+
+	margins := im.Margins
+	width, height := im.Width, im.Height
+	if width == 0 || height == 0 {
+		scale := im.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		pageWidth, pageHeight := pages[0].Size()
+
+		width = margins.Left + margins.Right + float64(columns)*pageWidth*scale + float64(columns-1)*im.ColumnGutter
+		height = margins.Top + margins.Bottom + float64(rows)*pageHeight*scale + float64(rows-1)*im.RowGutter
+	}
+
+	cellWidth := (width - margins.Left - margins.Right - float64(columns-1)*im.ColumnGutter) / float64(columns)
+	cellHeight := (height - margins.Top - margins.Bottom - float64(rows-1)*im.RowGutter) / float64(rows)
+	if cellWidth <= 0 || cellHeight <= 0 {
+		return fmt.Errorf("the margins and gutters leave no room for pages on a %vx%v sheet", width, height)
+	}
+
+	_, err = dst.ensureCatalog()
+	if err != nil {
+		return err
+	}
+	count, err := dst.PageCount()
+	if err != nil {
+		return err
+	}
+
+	copier := NewCopier(dst.File, src.File)
+	xobjects := map[int]pdf.ObjectReference{}
+	for _, sheet := range sheets {
+		contents := pdf.NewContentBuilder(nil)
+		contents.RealDecimals = 4
+
+		// where the pages are drawn, for the crop marks
+		trims := []Rectangle{}
+		for _, c := range sheet {
+			if c.page < 0 {
+				continue
+			}
+			page := pages[c.page]
+
+			ref, ok := xobjects[c.page]
+			if !ok {
+				ref, err = copier.ImportPage(page)
+				if err != nil {
+					return err
+				}
+				xobjects[c.page] = ref
+			}
+
+			pageWidth, pageHeight := page.Size()
+			scale := im.Scale
+			if scale == 0 {
+				scale = math.Min(cellWidth/pageWidth, cellHeight/pageHeight)
+			}
+
+			x := margins.Left + float64(c.column)*(cellWidth+im.ColumnGutter)
+			y := height - margins.Top - float64(c.row+1)*cellHeight - float64(c.row)*im.RowGutter
+			trim := Rectangle{
+				LLX: x + (cellWidth-pageWidth*scale)*c.align,
+				LLY: y + (cellHeight-pageHeight*scale)/2,
+			}
+			trim.URX = trim.LLX + pageWidth*scale
+			trim.URY = trim.LLY + pageHeight*scale
+			trims = append(trims, trim)
+
+			contents.Save()
+			contents.Rectangle(x, y, cellWidth, cellHeight)
+			contents.Clip()
+			contents.EndPath()
+			contents.Transform(scale, 0, 0, scale, trim.LLX+c.shift, trim.LLY)
+			contents.DrawXObject(ref)
+			contents.Restore()
+		}
+
+		if im.CropMarks && len(trims) > 0 {
+			cropMarks(contents, width, height, trims)
+		}
+
+		stream, err := contents.Stream(pdf.Filter{Name: pdf.Name("FlateDecode")})
+		if err != nil {
+			return err
+		}
+		contentsRef, err := dst.File.Add(stream)
+		if err != nil {
+			return err
+		}
+
+		_, err = dst.InsertPage(count, pdf.Dictionary{
+			pdf.Name("MediaBox"):  Rectangle{0, 0, width, height}.Array(),
+			pdf.Name("Resources"): contents.Resources(),
+			pdf.Name("Contents"):  contentsRef,
+		})
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	return nil
+}
+
+// cropMarks draws lines out from the corners of the trims,
+// except over the trims, on a width by height sheet
+func cropMarks(contents *pdf.ContentBuilder, width, height float64, trims []Rectangle) {
+	// the even-odd rule excludes the trims from the sheet
+	contents.Save()
+	contents.Rectangle(0, 0, width, height)
+	for _, trim := range trims {
+		contents.Rectangle(trim.LLX, trim.LLY, trim.Width(), trim.Height())
+	}
+	contents.ClipEvenOdd()
+	contents.EndPath()
+	contents.SetStrokeCMYK(0, 0, 0, 1)
+	contents.SetLineWidth(0.25)
+
+	for _, trim := range trims {
+		for _, corner := range [][4]float64{
+			// the corner and which way is out
+			{trim.LLX, trim.LLY, -1, -1},
+			{trim.URX, trim.LLY, 1, -1},
+			{trim.LLX, trim.URY, -1, 1},
+			{trim.URX, trim.URY, 1, 1},
+		} {
+			x, y, dx, dy := corner[0], corner[1], corner[2], corner[3]
+			contents.MoveTo(x+dx*cropMarkOffset, y)
+			contents.LineTo(x+dx*(cropMarkOffset+cropMarkLength), y)
+			contents.MoveTo(x, y+dy*cropMarkOffset)
+			contents.LineTo(x, y+dy*(cropMarkOffset+cropMarkLength))
+		}
+	}
+
+	contents.Stroke()
+	contents.Restore()
+}
@@ -0,0 +1,267 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document with n 100x200 pages
+func createImposeSource(t *testing.T, n int) (*Document, func()) {
+	return createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		kids := pdf.Array{}
+		for i := 0; i < n; i++ {
+			contents := add(t, file, pdf.ObjectReference{}, pdf.Stream{
+				Dictionary: pdf.Dictionary{},
+				Stream:     []byte("0 0 100 200 re f"),
+			})
+			kids = append(kids, add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+				pdf.Name("Type"):     pdf.Name("Page"),
+				pdf.Name("Parent"):   root,
+				pdf.Name("Contents"): contents,
+			}))
+		}
+
+		return pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Pages"),
+			pdf.Name("Kids"):     kids,
+			pdf.Name("Count"):    pdf.Integer(n),
+			pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(200)},
+		}
+	})
+}
+
+// returns the sheets of d and their decoded contents
+func sheets(t *testing.T, d *Document) ([]Page, []string) {
+	pages := []Page{}
+	contents := []string{}
+	err := d.Pages(func(page Page) bool {
+		stream := d.File.Get(page.Dictionary[pdf.Name("Contents")].(pdf.ObjectReference)).(pdf.Stream)
+		decoded, err := stream.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+		contents = append(contents, string(decoded))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages, contents
+}
+
+func TestNUp(t *testing.T) {
+	src, cleanupSrc := createImposeSource(t, 5)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	im := Imposition{
+		Columns:      2,
+		Rows:         2,
+		Margins:      Margins{10, 10, 10, 10},
+		ColumnGutter: 5,
+		RowGutter:    5,
+	}
+	err := im.NUp(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, contents := sheets(t, dst)
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 sheets, got %d", len(pages))
+	}
+
+	// the sheets fit the grid of pages
+	if pages[0].MediaBox != (Rectangle{0, 0, 225, 425}) {
+		t.Errorf("incorrect MediaBox %v", pages[0].MediaBox)
+	}
+
+	expected := []string{
+		"q\n10 215 100 200 re\nW\nn\n1 0 0 1 10 215 cm\n/X1 Do\nQ\n" +
+			"q\n115 215 100 200 re\nW\nn\n1 0 0 1 115 215 cm\n/X2 Do\nQ\n" +
+			"q\n10 10 100 200 re\nW\nn\n1 0 0 1 10 10 cm\n/X3 Do\nQ\n" +
+			"q\n115 10 100 200 re\nW\nn\n1 0 0 1 115 10 cm\n/X4 Do\nQ\n",
+		"q\n10 215 100 200 re\nW\nn\n1 0 0 1 10 215 cm\n/X1 Do\nQ\n",
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected contents %q, got %q", expected, contents)
+	}
+
+	xobject := dst.File.Get(pages[1].Resources[pdf.Name("XObject")].(pdf.Dictionary)[pdf.Name("X1")].(pdf.ObjectReference)).(pdf.Stream)
+	if xobject.Dictionary[pdf.Name("Subtype")] != pdf.Name("Form") {
+		t.Errorf("expected a form XObject, got %v", xobject.Dictionary)
+	}
+
+	err = dst.File.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNUpScale(t *testing.T) {
+	src, cleanupSrc := createImposeSource(t, 1)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	// the page is scaled to fit the cell and centered
+	err := Imposition{Width: 300, Height: 100}.NUp(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, contents := sheets(t, dst)
+	expected := "q\n0 0 300 100 re\nW\nn\n0.5 0 0 0.5 125 0 cm\n/X1 Do\nQ\n"
+	if len(contents) != 1 || contents[0] != expected {
+		t.Errorf("expected contents %q, got %q", expected, contents)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	for _, test := range []struct {
+		order     Order
+		positions [][2]int
+	}{
+		{LeftToRight, [][2]int{{0, 0}, {1, 0}, {2, 0}, {0, 1}, {1, 1}, {2, 1}}},
+		{RightToLeft, [][2]int{{2, 0}, {1, 0}, {0, 0}, {2, 1}, {1, 1}, {0, 1}}},
+		{TopToBottom, [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 0}, {2, 1}}},
+		{TopToBottomRightToLeft, [][2]int{{2, 0}, {2, 1}, {1, 0}, {1, 1}, {0, 0}, {0, 1}}},
+	} {
+		im := Imposition{Columns: 3, Rows: 2, Order: test.order}
+		for i, expected := range test.positions {
+			column, row := im.position(i)
+			if [2]int{column, row} != expected {
+				t.Errorf("%d: expected cell %d at %v, got %v", test.order, i, expected, [2]int{column, row})
+			}
+		}
+	}
+}
+
+func TestBookletOrder(t *testing.T) {
+	// the pages of each side, left to right
+	pagesOf := func(sides [][]cell) [][2]int {
+		pages := [][2]int{}
+		for _, side := range sides {
+			pages = append(pages, [2]int{side[0].page, side[1].page})
+		}
+		return pages
+	}
+
+	for _, test := range []struct {
+		im    Imposition
+		count int
+		pages [][2]int
+	}{
+		// padded with blank pages
+		{Imposition{}, 6, [][2]int{{-1, 0}, {1, -1}, {5, 2}, {3, 4}}},
+		{Imposition{SignatureSheets: 1}, 8, [][2]int{{3, 0}, {1, 2}, {7, 4}, {5, 6}}},
+		{Imposition{SignatureSheets: 2}, 12, [][2]int{{7, 0}, {1, 6}, {5, 2}, {3, 4}, {11, 8}, {9, 10}}},
+		{Imposition{Order: RightToLeft}, 4, [][2]int{{0, 3}, {2, 1}}},
+	} {
+		pages := pagesOf(test.im.booklet(test.count))
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("%+v with %d pages: expected %v, got %v", test.im, test.count, test.pages, pages)
+		}
+	}
+
+	// inner sheets are moved toward the spine
+	sides := Imposition{Creep: 0.5}.booklet(12)
+	for i, side := range sides {
+		shift := float64(i/2) * 0.5
+		if side[0].shift != shift || side[1].shift != -shift {
+			t.Errorf("side %d: expected shifts of %v and %v, got %v and %v", i, shift, -shift, side[0].shift, side[1].shift)
+		}
+		if side[0].align != 1 || side[1].align != 0 {
+			t.Errorf("side %d: expected the pages to be against the spine", i)
+		}
+	}
+}
+
+func TestBooklet(t *testing.T) {
+	src, cleanupSrc := createImposeSource(t, 3)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	err := Imposition{Width: 400, Height: 200, Creep: 1}.Booklet(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, contents := sheets(t, dst)
+	if len(pages) != 2 {
+		t.Fatalf("expected both sides of a sheet, got %d", len(pages))
+	}
+
+	// the pages are against the spine of the wider sheet,
+	// and the last page is blank; the back is upright, not
+	// rotated, so the sheet is flipped on its short edge
+	expected := []string{
+		"q\n200 0 200 200 re\nW\nn\n1 0 0 1 200 0 cm\n/X1 Do\nQ\n",
+		"q\n0 0 200 200 re\nW\nn\n1 0 0 1 100 0 cm\n/X1 Do\nQ\n" +
+			"q\n200 0 200 200 re\nW\nn\n1 0 0 1 200 0 cm\n/X2 Do\nQ\n",
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected contents %q, got %q", expected, contents)
+	}
+	for i, page := range pages {
+		if page.Rotate != 0 {
+			t.Errorf("side %d: expected the side to be upright, got Rotate %d", i, page.Rotate)
+		}
+	}
+}
+
+func TestStepAndRepeat(t *testing.T) {
+	src, cleanupSrc := createImposeSource(t, 2)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	im := Imposition{Columns: 3, Rows: 2, ColumnGutter: 30, RowGutter: 30, Margins: Margins{20, 20, 20, 20}, CropMarks: true}
+	err := im.StepAndRepeat(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, contents := sheets(t, dst)
+	if len(pages) != 2 {
+		t.Fatalf("expected a sheet for each page, got %d", len(pages))
+	}
+	for i, content := range contents {
+		if n := strings.Count(content, "/X1 Do"); n != 6 {
+			t.Errorf("%d: expected the page 6 times, got %d", i, n)
+		}
+
+		// the marks are clipped to outside of the pages
+		if !strings.Contains(content, "q\n0 0 400 470 re\n20 250 100 200 re\n") || !strings.Contains(content, "W*\nn\n") {
+			t.Errorf("%d: expected the crop marks to be clipped: %q", i, content)
+		}
+		if !strings.Contains(content, "17 250 m\n5 250 l\n20 247 m\n20 235 l\n") {
+			t.Errorf("%d: expected a crop mark at the lower-left corner of the first page: %q", i, content)
+		}
+	}
+}
+
+func TestImpositionErrors(t *testing.T) {
+	src, cleanupSrc := createImposeSource(t, 1)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	err := Imposition{Width: 100, Height: 100, Margins: Margins{Left: 60, Right: 60}}.NUp(dst, src)
+	if err == nil || !strings.Contains(err.Error(), "leave no room") {
+		t.Errorf("expected an error about the margins, got %v", err)
+	}
+
+	empty, cleanupEmpty := createEmptyDocument(t)
+	defer cleanupEmpty()
+	err = Imposition{}.NUp(dst, empty)
+	if err == nil {
+		t.Errorf("expected an error when there are no pages")
+	}
+}
@@ -0,0 +1,1465 @@
+package document
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nathankerr/pdf"
+)
+
+// MergeOptions change how documents are merged.
+type MergeOptions struct {
+	// Bookmarks adds a top-level outline item for each merged
+	// document, which contains the document's outline.
+	Bookmarks bool
+
+	// Titles are the titles of the bookmarks, by source. When a title
+	// is missing, the Title in the source's document information
+	// dictionary is used, or "Document n" when it does not have one.
+	Titles []string
+}
+
+// Merge appends the pages of srcs to dst, along with their outlines,
+// named destinations and other name trees, interactive forms, page
+// labels, structure trees and optional content. Destination names
+// and form field names that are already used are renamed. When dst
+// does not have a catalog, one is created.
+//
+// The streams of the merged objects share their data with srcs,
+// so srcs must remain open until dst is saved.
+func Merge(dst *Document, srcs ...*Document) error {
+	return MergeOptions{}.Merge(dst, srcs...)
+}
+
+// Merge merges srcs into dst, as with the Merge function.
+func (options MergeOptions) Merge(dst *Document, srcs ...*Document) error {
+	m, err := newMerger(dst, options)
+	if err != nil {
+		return err
+	}
+
+	for i, src := range srcs {
+		err = m.placeAll(i, src)
+		if err != nil {
+			return fmt.Errorf("merging document %d: %v", i+1, err)
+		}
+	}
+
+	return m.finish()
+}
+
+// merger holds the catalog-level structures of the merged document
+// until they are written by finish
+type merger struct {
+	dst     *Document
+	options MergeOptions
+	catalog Catalog
+
+	pageCount int
+
+	// name trees in the Names dictionary, by key
+	names map[pdf.Name]map[string]pdf.Object
+
+	// the catalog's Dests dictionary
+	dests pdf.Dictionary
+
+	// page labels, by page index
+	labels    map[int]pdf.Object
+	hasLabels bool
+
+	// outline root, zero until needed
+	outlines pdf.ObjectReference
+
+	// interactive form, nil until needed
+	form       pdf.Dictionary
+	fieldNames map[string]bool
+
+	// structure tree root, zero until needed
+	structRoot pdf.ObjectReference
+	parentTree map[int]pdf.Object
+	nextKey    int
+	ids        map[string]pdf.Object
+
+	ocProperties pdf.Dictionary
+}
+
+func newMerger(dst *Document, options MergeOptions) (*merger, error) {
+	m := &merger{
+		dst:        dst,
+		options:    options,
+		names:      map[pdf.Name]map[string]pdf.Object{},
+		dests:      pdf.Dictionary{},
+		labels:     map[int]pdf.Object{},
+		fieldNames: map[string]bool{},
+		parentTree: map[int]pdf.Object{},
+		ids:        map[string]pdf.Object{},
+	}
+
+	var err error
+	m.catalog, err = dst.ensureCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	m.pageCount, err = dst.PageCount()
+	if err != nil {
+		return nil, err
+	}
+	catalog := m.catalog
+
+	if names, ok := dst.resolve(catalog.Names).(pdf.Dictionary); ok {
+		for key, tree := range names {
+			entries, err := dst.nameTree(tree)
+			if err != nil {
+				return nil, err
+			}
+			m.names[key] = entries
+		}
+	}
+
+	if dests, ok := dst.resolve(catalog.Dests).(pdf.Dictionary); ok {
+		for name, dest := range dests {
+			m.dests[name] = dest
+		}
+	}
+
+	if catalog.PageLabels != nil {
+		var err error
+		m.labels, err = dst.numberTree(catalog.PageLabels)
+		if err != nil {
+			return nil, err
+		}
+		m.hasLabels = true
+	} else if m.pageCount > 0 {
+		// used when a merged document has labels
+		m.labels[0] = pdf.Dictionary{pdf.Name("S"): pdf.Name("D")}
+	}
+
+	switch outlines := catalog.Outlines.(type) {
+	case pdf.ObjectReference:
+		m.outlines = outlines
+	case pdf.Dictionary:
+		var err error
+		m.outlines, err = dst.File.Add(outlines)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if form, ok := dst.resolve(catalog.AcroForm).(pdf.Dictionary); ok {
+		m.form = pdf.Dictionary{}
+		for name, value := range form {
+			m.form[name] = value
+		}
+
+		fields, _ := dst.resolve(form[pdf.Name("Fields")]).(pdf.Array)
+		for _, field := range fields {
+			if name, ok := fieldName(dst, field); ok {
+				m.fieldNames[name] = true
+			}
+		}
+	}
+
+	if catalog.StructTreeRoot != nil {
+		root, ok := dst.resolve(catalog.StructTreeRoot).(pdf.Dictionary)
+		if !ok {
+			return nil, fmt.Errorf("StructTreeRoot is not a dictionary")
+		}
+
+		if ref, ok := catalog.StructTreeRoot.(pdf.ObjectReference); ok {
+			m.structRoot = ref
+		} else {
+			var err error
+			m.structRoot, err = dst.File.Add(root)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := m.loadStructure(dst, root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ocProperties, ok := dst.resolve(catalog.OCProperties).(pdf.Dictionary); ok {
+		m.ocProperties = ocProperties
+	}
+
+	return m, nil
+}
+
+// loadStructure reads the parent tree and ID tree of
+// dst's structure tree root
+func (m *merger) loadStructure(dst *Document, root pdf.Dictionary) error {
+	if tree, ok := root[pdf.Name("ParentTree")]; ok {
+		var err error
+		m.parentTree, err = dst.numberTree(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	if next, ok := dst.resolve(root[pdf.Name("ParentTreeNextKey")]).(pdf.Integer); ok {
+		m.nextKey = int(next)
+	}
+	for key := range m.parentTree {
+		if key >= m.nextKey {
+			m.nextKey = key + 1
+		}
+	}
+
+	if tree, ok := root[pdf.Name("IDTree")]; ok {
+		var err error
+		m.ids, err = dst.nameTree(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// a page of a source
+type mergedPage struct {
+	ref             pdf.ObjectReference
+	dict, inherited pdf.Dictionary
+}
+
+// placement is a page of src, the nth source, placed in the
+// merged document with rotate degrees added to its rotation
+type placement struct {
+	src    *Document
+	n      int
+	index  int
+	rotate int
+}
+
+// source is a document whose pages are being placed
+type source struct {
+	doc     *Document
+	catalog Catalog
+
+	// the number of the source, for bookmark titles
+	n int
+
+	copier *Copier
+	pages  []mergedPage
+
+	// page tree nodes other than the pages
+	nodes map[pdf.ObjectReference]bool
+
+	// indexes of the pages that are placed, and whether
+	// they have been placed yet
+	placed map[int]bool
+
+	// whether only some of the pages are placed
+	partial bool
+
+	// the references of the placed pages
+	included map[pdf.ObjectReference]bool
+
+	// the first placed page, in dst
+	first pdf.Object
+
+	dests  *destinations
+	labels map[int]pdf.Object
+
+	// new names of the destinations and other names in name trees,
+	// and of those in the Dests dictionary
+	renamed map[pdf.Name]map[string]string
+	names   map[pdf.Name]pdf.Name
+
+	// top-level fields with widgets on the placed pages
+	fields map[pdf.ObjectReference]bool
+
+	structRoot   pdf.Dictionary
+	hasStructure bool
+	offset       int
+}
+
+// placeAll places all of src's pages in order,
+// src being the nth source
+func (m *merger) placeAll(n int, src *Document) error {
+	count, err := src.PageCount()
+	if err != nil {
+		return err
+	}
+
+	placements := make([]placement, count)
+	for i := range placements {
+		placements[i] = placement{src: src, n: n, index: i}
+	}
+	return m.place(placements)
+}
+
+// place appends the placed pages to the merged document along
+// with what refers to them in their sources. Each source is copied
+// once, so its pages can be placed more than once.
+func (m *merger) place(placements []placement) error {
+	sources := []*source{}
+	bySrc := map[*Document]*source{}
+	for _, p := range placements {
+		s, ok := bySrc[p.src]
+		if !ok {
+			var err error
+			s, err = m.load(p.src, p.n)
+			if err != nil {
+				return err
+			}
+			bySrc[p.src] = s
+			sources = append(sources, s)
+		}
+
+		if p.index < 0 || p.index >= len(s.pages) {
+			return fmt.Errorf("page %d does not exist", p.index)
+		}
+		s.placed[p.index] = false
+	}
+
+	for _, s := range sources {
+		err := m.prepare(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	first := m.pageCount
+	for _, p := range placements {
+		err := m.placePage(bySrc[p.src], p)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := m.addLabels(first, placements, bySrc)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sources {
+		err := m.addSource(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load reads the pages and catalog of src, the nth source
+func (m *merger) load(src *Document, n int) (*source, error) {
+	catalog, err := src.Catalog()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &source{
+		doc:      src,
+		catalog:  catalog,
+		n:        n,
+		copier:   NewCopier(m.dst.File, src.File),
+		nodes:    map[pdf.ObjectReference]bool{},
+		placed:   map[int]bool{},
+		included: map[pdf.ObjectReference]bool{},
+		fields:   map[pdf.ObjectReference]bool{},
+	}
+
+	_, err = src.walk(catalog.Pages, pdf.Dictionary{}, s.nodes, func(ref pdf.ObjectReference, page, inherited pdf.Dictionary) (bool, error) {
+		s.pages = append(s.pages, mergedPage{ref, page, inherited})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.dests, err = src.destinations(catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	if catalog.PageLabels != nil {
+		s.labels, err = src.numberTree(catalog.PageLabels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// prepare sets up the copying of s. The pages are mapped before
+// anything is copied so that references to them, such as from
+// links, refer to the placed pages. The rest of the page tree,
+// the pages that are not placed and the annotations on them, and
+// links to those pages are not copied.
+func (m *merger) prepare(s *source) error {
+	c := s.copier
+	src := s.doc
+	s.partial = len(s.placed) < len(s.pages)
+
+	for ref := range s.nodes {
+		c.Map(ref, pdf.Null{})
+	}
+	for i, page := range s.pages {
+		if _, ok := s.placed[i]; ok {
+			ref, err := m.dst.File.Add(pdf.Null{})
+			if err != nil {
+				return err
+			}
+			c.Map(page.ref, ref)
+			s.included[page.ref] = true
+			continue
+		}
+
+		c.Map(page.ref, pdf.Null{})
+		annots, _ := src.resolve(page.dict[pdf.Name("Annots")]).(pdf.Array)
+		for _, annot := range annots {
+			if ref, ok := annot.(pdf.ObjectReference); ok {
+				c.Map(ref, pdf.Null{})
+			}
+		}
+	}
+
+	for i := range s.placed {
+		annots, _ := src.resolve(s.pages[i].dict[pdf.Name("Annots")]).(pdf.Array)
+		for _, annot := range annots {
+			ref, ok := annot.(pdf.ObjectReference)
+			if !ok {
+				continue
+			}
+			dict, ok := src.File.Get(ref).(pdf.Dictionary)
+			if !ok {
+				continue
+			}
+
+			if s.excluded(src.target(dict)) {
+				c.Map(ref, pdf.Null{})
+			} else if dict[pdf.Name("Subtype")] == pdf.Name("Widget") {
+				s.fields[topField(src, ref)] = true
+			}
+		}
+	}
+
+	// destination names are renamed before the objects
+	// that refer to them are copied
+	err := m.renameNames(s)
+	if err != nil {
+		return err
+	}
+
+	// structure parents are numbered after the existing ones; the
+	// structure tree is not copied when only some pages are placed
+	s.structRoot, s.hasStructure = src.resolve(s.catalog.StructTreeRoot).(pdf.Dictionary)
+	s.hasStructure = s.hasStructure && !s.partial
+	s.offset = m.nextKey
+	if s.hasStructure {
+		err = m.ensureStructRoot()
+		if err != nil {
+			return err
+		}
+
+		if ref, ok := s.catalog.StructTreeRoot.(pdf.ObjectReference); ok {
+			c.Map(ref, m.structRoot)
+		}
+	}
+
+	c.Dictionary = func(dict pdf.Dictionary) {
+		for _, key := range []pdf.Name{"StructParents", "StructParent"} {
+			if number, ok := dict[key].(pdf.Integer); ok {
+				if s.hasStructure {
+					dict[key] = number + pdf.Integer(s.offset)
+				} else if s.partial {
+					delete(dict, key)
+				}
+			}
+		}
+
+		// only destinations are referred to by name
+		rename(dict, pdf.Name("Dest"), s.renamed[pdf.Name("Dests")], s.names)
+		if dict[pdf.Name("S")] == pdf.Name("GoTo") {
+			rename(dict, pdf.Name("D"), s.renamed[pdf.Name("Dests")], s.names)
+		}
+
+		// annotations and fields that were not copied
+		for _, key := range []pdf.Name{"Annots", "Kids"} {
+			if array, ok := dict[key].(pdf.Array); ok {
+				dict[key] = withoutNulls(array)
+			}
+		}
+	}
+
+	return nil
+}
+
+// placePage appends the page placed by p
+func (m *merger) placePage(s *source, p placement) error {
+	page := s.pages[p.index]
+
+	dict := pdf.Dictionary{}
+	for name, value := range page.dict {
+		// article beads refer to pages that might not be placed
+		if name == pdf.Name("Parent") || (s.partial && name == pdf.Name("B")) {
+			continue
+		}
+		dict[name] = value
+	}
+
+	copied, err := s.copier.Copy(dict)
+	if err != nil {
+		return err
+	}
+	inherited, err := s.copier.Copy(page.inherited)
+	if err != nil {
+		return err
+	}
+
+	// references to a page placed more than once
+	// refer to where it was first placed
+	var ref pdf.ObjectReference
+	if s.placed[p.index] {
+		ref, err = m.dst.File.Add(pdf.Null{})
+		if err != nil {
+			return err
+		}
+	} else {
+		mapped, _ := s.copier.Copied(page.ref)
+		ref = mapped.(pdf.ObjectReference)
+		s.placed[p.index] = true
+	}
+	if s.first == nil {
+		s.first = ref
+	}
+
+	if p.rotate != 0 {
+		srcPage, err := s.doc.newPage(page.ref, page.dict, page.inherited)
+		if err != nil {
+			return err
+		}
+		copied.(pdf.Dictionary)[pdf.Name("Rotate")] = pdf.Integer(((srcPage.Rotate+p.rotate)%360 + 360) % 360)
+	}
+
+	err = m.dst.insertPage(m.pageCount, ref, copied.(pdf.Dictionary), inherited.(pdf.Dictionary))
+	if err != nil {
+		return err
+	}
+	m.pageCount++
+
+	return nil
+}
+
+// addSource adds the catalog-level structures of s that
+// refer to its placed pages
+func (m *merger) addSource(s *source) error {
+	c := s.copier
+	src := s.doc
+
+	err := m.copyNames(s)
+	if err != nil {
+		return err
+	}
+
+	err = m.addOutlines(s)
+	if err != nil {
+		return err
+	}
+
+	if form, ok := src.resolve(s.catalog.AcroForm).(pdf.Dictionary); ok {
+		fields, _ := src.resolve(form[pdf.Name("Fields")]).(pdf.Array)
+		if s.partial {
+			kept := pdf.Array{}
+			for _, field := range fields {
+				if ref, ok := field.(pdf.ObjectReference); ok && s.fields[ref] {
+					kept = append(kept, field)
+				}
+			}
+			fields = kept
+		}
+
+		if len(fields) > 0 || !s.partial {
+			err = m.addForm(c, src, form, fields)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.hasStructure {
+		err = m.addStructure(c, src, s.structRoot, s.offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if markInfo, ok := s.catalog.Other[pdf.Name("MarkInfo")]; ok && !s.partial {
+		if _, ok := m.catalog.Other[pdf.Name("MarkInfo")]; !ok {
+			m.catalog.Other[pdf.Name("MarkInfo")], err = c.Copy(markInfo)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ocProperties, ok := src.resolve(s.catalog.OCProperties).(pdf.Dictionary); ok {
+		err = m.addOptionalContent(c, ocProperties)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withoutNulls returns array without its Null elements
+func withoutNulls(array pdf.Array) pdf.Array {
+	kept := pdf.Array{}
+	for _, value := range array {
+		if _, ok := value.(pdf.Null); !ok {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// renameNames adds the names in the name trees and Dests dictionary
+// of s, renaming those that are already used. When only some pages
+// are placed, only the destinations on them are added.
+func (m *merger) renameNames(s *source) error {
+	src := s.doc
+	s.renamed = map[pdf.Name]map[string]string{}
+	if names, ok := src.resolve(s.catalog.Names).(pdf.Dictionary); ok {
+		for key, tree := range names {
+			entries, err := src.nameTree(tree)
+			if err != nil {
+				return err
+			}
+
+			if m.names[key] == nil {
+				m.names[key] = map[string]pdf.Object{}
+			}
+			merged := m.names[key]
+			s.renamed[key] = map[string]string{}
+
+			for _, name := range sortedKeys(entries) {
+				if !s.keepName(key, entries[name]) {
+					continue
+				}
+
+				newName := unique(name, func(name string) bool {
+					_, ok := merged[name]
+					return ok
+				})
+				if newName != name {
+					s.renamed[key][name] = newName
+				}
+
+				// the value is copied by copyNames
+				merged[newName] = nil
+			}
+		}
+	}
+
+	s.names = map[pdf.Name]pdf.Name{}
+	if dests, ok := src.resolve(s.catalog.Dests).(pdf.Dictionary); ok {
+		for _, name := range sortedNames(dests) {
+			if !s.keepName(pdf.Name("Dests"), dests[name]) {
+				continue
+			}
+
+			newName := pdf.Name(unique(string(name), func(name string) bool {
+				_, ok := m.dests[pdf.Name(name)]
+				return ok
+			}))
+			if newName != name {
+				s.names[name] = newName
+			}
+			m.dests[newName] = nil
+		}
+	}
+
+	return nil
+}
+
+// keepName returns whether the entry with value in the
+// name tree key is added to the merged document
+func (s *source) keepName(key pdf.Name, value pdf.Object) bool {
+	if !s.partial {
+		return true
+	}
+	return key == pdf.Name("Dests") && !s.excluded(value)
+}
+
+// copyNames copies the values of the names added by renameNames
+func (m *merger) copyNames(s *source) error {
+	src := s.doc
+	if srcNames, ok := src.resolve(s.catalog.Names).(pdf.Dictionary); ok {
+		for key, tree := range srcNames {
+			entries, err := src.nameTree(tree)
+			if err != nil {
+				return err
+			}
+
+			for name, value := range entries {
+				if !s.keepName(key, value) {
+					continue
+				}
+
+				copied, err := s.copier.Copy(value)
+				if err != nil {
+					return err
+				}
+
+				newName, ok := s.renamed[key][name]
+				if !ok {
+					newName = name
+				}
+				m.names[key][newName] = copied
+			}
+		}
+	}
+
+	if dests, ok := src.resolve(s.catalog.Dests).(pdf.Dictionary); ok {
+		for name, value := range dests {
+			if !s.keepName(pdf.Name("Dests"), value) {
+				continue
+			}
+
+			copied, err := s.copier.Copy(value)
+			if err != nil {
+				return err
+			}
+
+			newName, ok := s.names[name]
+			if !ok {
+				newName = name
+			}
+			m.dests[newName] = copied
+		}
+	}
+
+	return nil
+}
+
+// rename replaces the destination name in dict[key]
+func rename(dict pdf.Dictionary, key pdf.Name, renamed map[string]string, names map[pdf.Name]pdf.Name) {
+	switch name := dict[key].(type) {
+	case pdf.String:
+		if newName, ok := renamed[string(name)]; ok {
+			dict[key] = pdf.String(newName)
+		}
+	case pdf.Name:
+		if newName, ok := names[name]; ok {
+			dict[key] = newName
+		}
+	}
+}
+
+// unique returns name, or when it is used, name with a number added
+func unique(name string, used func(name string) bool) string {
+	newName := name
+	for n := 2; used(newName); n++ {
+		newName = fmt.Sprintf("%s-%d", name, n)
+	}
+	return newName
+}
+
+func sortedKeys(entries map[string]pdf.Object) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedNames(dict pdf.Dictionary) []pdf.Name {
+	names := make([]pdf.Name, 0, len(dict))
+	for name := range dict {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// title returns the bookmark title for the ith source
+func (m *merger) title(i int, src *Document) string {
+	if i < len(m.options.Titles) && m.options.Titles[i] != "" {
+		return m.options.Titles[i]
+	}
+
+	if info, ok := src.File.Get(src.File.Info).(pdf.Dictionary); ok {
+		if title, ok := src.resolve(info[pdf.Name("Title")]).(pdf.String); ok && len(title) > 0 {
+			return string(title)
+		}
+	}
+
+	return fmt.Sprintf("Document %d", i+1)
+}
+
+// addOutlines adds the outline items of s to the end of the outline,
+// under a bookmark to its first placed page when bookmarks are used
+// - §12.3.3
+func (m *merger) addOutlines(s *source) error {
+	srcOutlines, hasOutlines := s.doc.resolve(s.catalog.Outlines).(pdf.Dictionary)
+	_, hasItems := srcOutlines[pdf.Name("First")]
+	if !hasItems && !m.options.Bookmarks {
+		return nil
+	}
+
+	created := false
+	if m.outlines == (pdf.ObjectReference{}) {
+		var err error
+		m.outlines, err = m.dst.File.Add(pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Outlines"),
+			pdf.Name("Count"): pdf.Integer(0),
+		})
+		if err != nil {
+			return err
+		}
+		created = true
+	}
+
+	// the parent of the top-level items of s
+	parent := m.outlines
+	if m.options.Bookmarks {
+		var err error
+		parent, err = m.dst.File.Add(pdf.Null{})
+		if err != nil {
+			return err
+		}
+	}
+	if ref, ok := s.catalog.Outlines.(pdf.ObjectReference); ok && hasOutlines {
+		s.copier.Map(ref, parent)
+	}
+
+	first, last, visible, err := m.copyOutline(s, srcOutlines[pdf.Name("First")], parent, map[pdf.ObjectReference]bool{})
+	if err != nil {
+		return err
+	}
+
+	if !m.options.Bookmarks {
+		if first == (pdf.ObjectReference{}) {
+			// none of the items were copied
+			if created {
+				m.dst.File.Free(m.outlines.ObjectNumber)
+				m.outlines = pdf.ObjectReference{}
+			}
+			return nil
+		}
+		return m.appendOutlines(first, last, visible)
+	}
+
+	bookmark := pdf.Dictionary{
+		pdf.Name("Title"):  pdf.String(m.title(s.n, s.doc)),
+		pdf.Name("Parent"): m.outlines,
+	}
+	if s.first != nil {
+		bookmark[pdf.Name("Dest")] = pdf.Array{s.first, pdf.Name("Fit")}
+	}
+	if first != (pdf.ObjectReference{}) {
+		bookmark[pdf.Name("First")] = first
+		bookmark[pdf.Name("Last")] = last
+		if visible > 0 {
+			// closed
+			bookmark[pdf.Name("Count")] = pdf.Integer(-visible)
+		}
+	}
+	err = m.dst.set(parent, bookmark)
+	if err != nil {
+		return err
+	}
+
+	return m.appendOutlines(parent, parent, 1)
+}
+
+// copyOutline copies the outline item at item and those following it
+// to be children of parent, returning the first and last items copied
+// and how many of the copied items and their descendants are visible.
+// Items with destinations on pages that are not placed are left out
+// unless they have descendants that are copied.
+func (m *merger) copyOutline(s *source, item pdf.Object, parent pdf.ObjectReference, visited map[pdf.ObjectReference]bool) (first, last pdf.ObjectReference, visible int, err error) {
+	var previous pdf.Dictionary
+	for item != nil {
+		ref, ok := item.(pdf.ObjectReference)
+		if !ok {
+			return first, last, visible, fmt.Errorf("outline item %v is not an indirect reference", item)
+		}
+		if visited[ref] {
+			return first, last, visible, fmt.Errorf("outline item %v is repeated", ref)
+		}
+		visited[ref] = true
+
+		dict, ok := s.doc.File.Get(ref).(pdf.Dictionary)
+		if !ok {
+			return first, last, visible, fmt.Errorf("outline item %v is not a dictionary", ref)
+		}
+		item = dict[pdf.Name("Next")]
+
+		newRef, err := m.dst.File.Add(pdf.Null{})
+		if err != nil {
+			return first, last, visible, err
+		}
+
+		childFirst, childLast, childVisible, err := m.copyOutline(s, dict[pdf.Name("First")], newRef, visited)
+		if err != nil {
+			return first, last, visible, err
+		}
+
+		excluded := s.excluded(s.doc.target(dict))
+		if excluded && childFirst == (pdf.ObjectReference{}) {
+			m.dst.File.Free(newRef.ObjectNumber)
+			s.copier.Map(ref, pdf.Null{})
+			continue
+		}
+		s.copier.Map(ref, newRef)
+
+		kept := pdf.Dictionary{}
+		for name, value := range dict {
+			switch name {
+			case "First", "Last", "Next", "Prev", "Parent", "Count":
+				continue
+			case "Dest", "A":
+				if excluded {
+					continue
+				}
+			case "SE":
+				// the structure tree is not copied
+				if !s.hasStructure {
+					continue
+				}
+			}
+			kept[name] = value
+		}
+		copied, err := s.copier.Copy(kept)
+		if err != nil {
+			return first, last, visible, err
+		}
+		newItem := copied.(pdf.Dictionary)
+
+		newItem[pdf.Name("Parent")] = parent
+		visible++
+		if childFirst != (pdf.ObjectReference{}) {
+			newItem[pdf.Name("First")] = childFirst
+			newItem[pdf.Name("Last")] = childLast
+
+			count, _ := s.doc.resolve(dict[pdf.Name("Count")]).(pdf.Integer)
+			if count > 0 {
+				newItem[pdf.Name("Count")] = pdf.Integer(childVisible)
+				visible += childVisible
+			} else if childVisible > 0 {
+				// closed
+				newItem[pdf.Name("Count")] = pdf.Integer(-childVisible)
+			}
+		}
+
+		if first == (pdf.ObjectReference{}) {
+			first = newRef
+		} else {
+			newItem[pdf.Name("Prev")] = last
+			previous[pdf.Name("Next")] = newRef
+			err = m.dst.set(last, previous)
+			if err != nil {
+				return first, last, visible, err
+			}
+		}
+
+		err = m.dst.set(newRef, newItem)
+		if err != nil {
+			return first, last, visible, err
+		}
+		last, previous = newRef, newItem
+	}
+
+	return first, last, visible, nil
+}
+
+// appendOutlines adds the items from first to last to the end
+// of the outline's top-level items
+func (m *merger) appendOutlines(first, last pdf.ObjectReference, visible int) error {
+	root, ok := m.dst.File.Get(m.outlines).(pdf.Dictionary)
+	if !ok {
+		return fmt.Errorf("outline %v is not a dictionary", m.outlines)
+	}
+
+	if previous, ok := root[pdf.Name("Last")].(pdf.ObjectReference); ok {
+		for _, link := range []struct {
+			ref, to pdf.ObjectReference
+			key     pdf.Name
+		}{
+			{previous, first, pdf.Name("Next")},
+			{first, previous, pdf.Name("Prev")},
+		} {
+			item, ok := m.dst.File.Get(link.ref).(pdf.Dictionary)
+			if !ok {
+				return fmt.Errorf("outline item %v is not a dictionary", link.ref)
+			}
+			item[link.key] = link.to
+			err := m.dst.set(link.ref, item)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		root[pdf.Name("First")] = first
+	}
+	root[pdf.Name("Last")] = last
+
+	count, _ := root[pdf.Name("Count")].(pdf.Integer)
+	if count < 0 {
+		count = -count
+	}
+	root[pdf.Name("Count")] = count + pdf.Integer(visible)
+
+	return m.dst.set(m.outlines, root)
+}
+
+// addLabels labels the placed pages, the first of which is at first,
+// as they are labeled in their sources. Pages of sources without
+// labels are labeled with their decimal page numbers.
+// - §12.4.2
+func (m *merger) addLabels(first int, placements []placement, bySrc map[*Document]*source) error {
+	// the range of source page labels the previous page was in
+	var previous struct {
+		s             *source
+		start, number int
+	}
+
+	for i, p := range placements {
+		s := bySrc[p.src]
+		if s.labels != nil {
+			m.hasLabels = true
+		}
+
+		start := -1
+		var label pdf.Object = pdf.Dictionary{pdf.Name("S"): pdf.Name("D")}
+		for index, value := range s.labels {
+			if index <= p.index && index > start {
+				start, label = index, value
+			}
+		}
+
+		number := p.index + 1
+		dict, ok := s.doc.resolve(label).(pdf.Dictionary)
+		if !ok {
+			dict = pdf.Dictionary{pdf.Name("S"): pdf.Name("D")}
+		}
+		if start >= 0 {
+			number = p.index - start + 1
+			if st, ok := s.doc.resolve(dict[pdf.Name("St")]).(pdf.Integer); ok {
+				number += int(st) - 1
+			}
+		}
+
+		// pages that continue a range need no label
+		if i > 0 && previous.s == s && previous.start == start && previous.number+1 == number {
+			previous.number = number
+			continue
+		}
+		previous.s, previous.start, previous.number = s, start, number
+
+		copied, err := s.copier.Copy(dict)
+		if err != nil {
+			return err
+		}
+		newLabel := copied.(pdf.Dictionary)
+		delete(newLabel, pdf.Name("St"))
+		if number != 1 {
+			newLabel[pdf.Name("St")] = pdf.Integer(number)
+		}
+		m.labels[first+i] = newLabel
+	}
+
+	return nil
+}
+
+// addForm adds the top-level srcFields of src's interactive form,
+// renaming those whose names are already used
+// - §12.7.2
+func (m *merger) addForm(c *Copier, src *Document, srcForm pdf.Dictionary, srcFields pdf.Array) error {
+	if m.form == nil {
+		m.form = pdf.Dictionary{}
+	}
+
+	fields, _ := m.dst.resolve(m.form[pdf.Name("Fields")]).(pdf.Array)
+	fields = append(pdf.Array{}, fields...)
+
+	for _, field := range srcFields {
+		copied, err := c.Copy(field)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, copied)
+
+		name, ok := fieldName(m.dst, copied)
+		if !ok {
+			continue
+		}
+
+		newName := unique(name, func(name string) bool { return m.fieldNames[name] })
+		m.fieldNames[newName] = true
+		if newName == name {
+			continue
+		}
+
+		ref, ok := copied.(pdf.ObjectReference)
+		if !ok {
+			return fmt.Errorf("field %v is not an indirect reference", field)
+		}
+		dict := m.dst.File.Get(ref).(pdf.Dictionary)
+		dict[pdf.Name("T")] = pdf.String(newName)
+		err = m.dst.set(ref, dict)
+		if err != nil {
+			return err
+		}
+	}
+	m.form[pdf.Name("Fields")] = fields
+
+	// the fields were copied above, and the calculation
+	// order only includes those
+	rest := pdf.Dictionary{}
+	for name, value := range srcForm {
+		if name != pdf.Name("Fields") && name != pdf.Name("CO") {
+			rest[name] = value
+		}
+	}
+	copied, err := c.Copy(rest)
+	if err != nil {
+		return err
+	}
+	form := copied.(pdf.Dictionary)
+
+	if form[pdf.Name("NeedAppearances")] == pdf.Boolean(true) {
+		m.form[pdf.Name("NeedAppearances")] = pdf.Boolean(true)
+	}
+
+	if flags, ok := m.dst.resolve(form[pdf.Name("SigFlags")]).(pdf.Integer); ok {
+		existing, _ := m.dst.resolve(m.form[pdf.Name("SigFlags")]).(pdf.Integer)
+		m.form[pdf.Name("SigFlags")] = existing | flags
+	}
+
+	if order, ok := src.resolve(srcForm[pdf.Name("CO")]).(pdf.Array); ok {
+		existing, _ := m.dst.resolve(m.form[pdf.Name("CO")]).(pdf.Array)
+		merged := append(pdf.Array{}, existing...)
+		for _, field := range order {
+			ref, ok := field.(pdf.ObjectReference)
+			if !ok {
+				continue
+			}
+			if copied, ok := c.Copied(ref); ok {
+				if _, removed := copied.(pdf.Null); !removed {
+					merged = append(merged, copied)
+				}
+			}
+		}
+		m.form[pdf.Name("CO")] = merged
+	}
+
+	for _, name := range []pdf.Name{"DA", "Q"} {
+		if _, ok := m.form[name]; !ok && form[name] != nil {
+			m.form[name] = form[name]
+		}
+	}
+
+	if resources, ok := m.dst.resolve(form[pdf.Name("DR")]).(pdf.Dictionary); ok {
+		existing, _ := m.dst.resolve(m.form[pdf.Name("DR")]).(pdf.Dictionary)
+		m.form[pdf.Name("DR")] = m.mergeResources(existing, resources)
+	}
+
+	// the XML form data describes only some of the fields
+	delete(m.form, pdf.Name("XFA"))
+
+	return nil
+}
+
+// fieldName returns the partial name of the field
+func fieldName(d *Document, field pdf.Object) (string, bool) {
+	dict, ok := d.resolve(field).(pdf.Dictionary)
+	if !ok {
+		return "", false
+	}
+
+	name, ok := d.resolve(dict[pdf.Name("T")]).(pdf.String)
+	return string(name), ok
+}
+
+// mergeResources returns the resources in dst with those in src
+// added when their names are not used
+func (m *merger) mergeResources(dst, src pdf.Dictionary) pdf.Dictionary {
+	merged := pdf.Dictionary{}
+	for category, resources := range dst {
+		merged[category] = resources
+	}
+
+	for category, resources := range src {
+		srcResources, ok := m.dst.resolve(resources).(pdf.Dictionary)
+		dstResources, dstOK := m.dst.resolve(merged[category]).(pdf.Dictionary)
+		if !ok || !dstOK {
+			if _, exists := merged[category]; !exists {
+				merged[category] = resources
+			}
+			continue
+		}
+
+		combined := pdf.Dictionary{}
+		for name, resource := range srcResources {
+			combined[name] = resource
+		}
+		for name, resource := range dstResources {
+			combined[name] = resource
+		}
+		merged[category] = combined
+	}
+
+	return merged
+}
+
+// ensureStructRoot adds a structure tree root
+// when the merged document does not have one
+func (m *merger) ensureStructRoot() error {
+	if m.structRoot != (pdf.ObjectReference{}) {
+		return nil
+	}
+
+	var err error
+	m.structRoot, err = m.dst.File.Add(pdf.Dictionary{
+		pdf.Name("Type"): pdf.Name("StructTreeRoot"),
+		pdf.Name("K"):    pdf.Array{},
+	})
+	return err
+}
+
+// addStructure adds the structure elements of src to the structure
+// tree root, with src's structure parents numbered from offset
+// - §14.7.2
+func (m *merger) addStructure(c *Copier, src *Document, srcRoot pdf.Dictionary, offset int) error {
+	root, ok := m.dst.File.Get(m.structRoot).(pdf.Dictionary)
+	if !ok {
+		return fmt.Errorf("StructTreeRoot %v is not a dictionary", m.structRoot)
+	}
+
+	kids := pdf.Array{}
+	switch existing := m.dst.resolve(root[pdf.Name("K")]).(type) {
+	case pdf.Array:
+		kids = append(kids, existing...)
+	case nil:
+	default:
+		kids = append(kids, root[pdf.Name("K")])
+	}
+
+	srcKids := pdf.Array{srcRoot[pdf.Name("K")]}
+	if array, ok := src.resolve(srcRoot[pdf.Name("K")]).(pdf.Array); ok {
+		srcKids = array
+	}
+	for _, kid := range srcKids {
+		if kid == nil {
+			continue
+		}
+		copied, err := c.Copy(kid)
+		if err != nil {
+			return err
+		}
+		kids = append(kids, copied)
+	}
+	root[pdf.Name("K")] = kids
+
+	for _, name := range []pdf.Name{"RoleMap", "ClassMap"} {
+		srcMap, ok := src.resolve(srcRoot[name]).(pdf.Dictionary)
+		if !ok {
+			continue
+		}
+		copied, err := c.Copy(srcMap)
+		if err != nil {
+			return err
+		}
+
+		merged := copied.(pdf.Dictionary)
+		if existing, ok := m.dst.resolve(root[name]).(pdf.Dictionary); ok {
+			for key, value := range existing {
+				merged[key] = value
+			}
+		}
+		root[name] = merged
+	}
+
+	err := m.dst.set(m.structRoot, root)
+	if err != nil {
+		return err
+	}
+
+	next := 0
+	if tree, ok := srcRoot[pdf.Name("ParentTree")]; ok {
+		entries, err := src.numberTree(tree)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range entries {
+			copied, err := c.Copy(value)
+			if err != nil {
+				return err
+			}
+			m.parentTree[key+offset] = copied
+
+			if key >= next {
+				next = key + 1
+			}
+		}
+	}
+	if n, ok := src.resolve(srcRoot[pdf.Name("ParentTreeNextKey")]).(pdf.Integer); ok && int(n) > next {
+		next = int(n)
+	}
+	m.nextKey = offset + next
+
+	if tree, ok := srcRoot[pdf.Name("IDTree")]; ok {
+		entries, err := src.nameTree(tree)
+		if err != nil {
+			return err
+		}
+
+		for id, value := range entries {
+			// elements refer to their IDs, so they cannot be renamed
+			if _, ok := m.ids[id]; ok {
+				continue
+			}
+			copied, err := c.Copy(value)
+			if err != nil {
+				return err
+			}
+			m.ids[id] = copied
+		}
+	}
+
+	return nil
+}
+
+// addOptionalContent adds src's optional content groups
+// and their default configuration
+// - §8.11.4
+func (m *merger) addOptionalContent(c *Copier, srcProperties pdf.Dictionary) error {
+	copied, err := c.Copy(srcProperties)
+	if err != nil {
+		return err
+	}
+	properties := copied.(pdf.Dictionary)
+
+	if m.ocProperties == nil {
+		m.ocProperties = properties
+		return nil
+	}
+
+	merged := pdf.Dictionary{}
+	for name, value := range m.ocProperties {
+		merged[name] = value
+	}
+	appendArrays(m.dst, merged, properties, "OCGs", "Configs")
+
+	config, _ := m.dst.resolve(merged[pdf.Name("D")]).(pdf.Dictionary)
+	srcConfig, ok := m.dst.resolve(properties[pdf.Name("D")]).(pdf.Dictionary)
+	if ok {
+		mergedConfig := pdf.Dictionary{}
+		for name, value := range config {
+			mergedConfig[name] = value
+		}
+		appendArrays(m.dst, mergedConfig, srcConfig, "ON", "OFF", "Order", "RBGroups", "Locked", "AS")
+		merged[pdf.Name("D")] = mergedConfig
+	}
+
+	m.ocProperties = merged
+	return nil
+}
+
+// appendArrays appends the arrays in src to those in dst
+func appendArrays(d *Document, dst, src pdf.Dictionary, names ...pdf.Name) {
+	for _, name := range names {
+		srcArray, ok := d.resolve(src[name]).(pdf.Array)
+		if !ok {
+			continue
+		}
+		dstArray, _ := d.resolve(dst[name]).(pdf.Array)
+		dst[name] = append(append(pdf.Array{}, dstArray...), srcArray...)
+	}
+}
+
+// finish writes the merged structures and the catalog
+func (m *merger) finish() error {
+	catalog := m.catalog
+
+	names := pdf.Dictionary{}
+	for _, key := range sortedTrees(m.names) {
+		ref, err := writeNameTree(m.dst.File, m.names[key])
+		if err != nil {
+			return err
+		}
+		names[key] = ref
+	}
+	if len(names) > 0 {
+		catalog.Names = names
+	}
+
+	if len(m.dests) > 0 {
+		catalog.Dests = m.dests
+	}
+
+	if m.hasLabels {
+		ref, err := writeNumberTree(m.dst.File, m.labels)
+		if err != nil {
+			return err
+		}
+		catalog.PageLabels = ref
+	}
+
+	if m.outlines != (pdf.ObjectReference{}) {
+		catalog.Outlines = m.outlines
+	}
+
+	if m.form != nil {
+		catalog.AcroForm = m.form
+	}
+
+	if m.structRoot != (pdf.ObjectReference{}) {
+		root, ok := m.dst.File.Get(m.structRoot).(pdf.Dictionary)
+		if !ok {
+			return fmt.Errorf("StructTreeRoot %v is not a dictionary", m.structRoot)
+		}
+
+		if len(m.parentTree) > 0 {
+			ref, err := writeNumberTree(m.dst.File, m.parentTree)
+			if err != nil {
+				return err
+			}
+			root[pdf.Name("ParentTree")] = ref
+			root[pdf.Name("ParentTreeNextKey")] = pdf.Integer(m.nextKey)
+		}
+
+		if len(m.ids) > 0 {
+			ref, err := writeNameTree(m.dst.File, m.ids)
+			if err != nil {
+				return err
+			}
+			root[pdf.Name("IDTree")] = ref
+		}
+
+		err := m.dst.set(m.structRoot, root)
+		if err != nil {
+			return err
+		}
+		catalog.StructTreeRoot = m.structRoot
+	}
+
+	if m.ocProperties != nil {
+		catalog.OCProperties = m.ocProperties
+	}
+
+	return m.dst.SetCatalog(catalog)
+}
+
+func sortedTrees(trees map[pdf.Name]map[string]pdf.Object) []pdf.Name {
+	keys := make([]pdf.Name, 0, len(trees))
+	for key, entries := range trees {
+		if len(entries) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
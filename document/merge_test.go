@@ -0,0 +1,316 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document with two pages, an outline, a named destination
+// linked to from the first page, a form field, page labels and a
+// structure tree
+func createMergeSource(t *testing.T) (*Document, func()) {
+	var annots []pdf.ObjectReference
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		kids := pdf.Array{}
+		for i := 0; i < 2; i++ {
+			page := add(t, file, pdf.ObjectReference{}, pdf.Null{})
+			annot := add(t, file, pdf.ObjectReference{}, pdf.Null{})
+			add(t, file, page, pdf.Dictionary{
+				pdf.Name("Type"):          pdf.Name("Page"),
+				pdf.Name("Parent"):        root,
+				pdf.Name("Annots"):        pdf.Array{annot},
+				pdf.Name("StructParents"): pdf.Integer(i),
+			})
+			annots = append(annots, annot)
+			kids = append(kids, page)
+		}
+
+		return pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Pages"),
+			pdf.Name("Kids"):     kids,
+			pdf.Name("Count"):    pdf.Integer(len(kids)),
+			pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(100)},
+		}
+	})
+
+	catalog, err := d.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := d.File.Get(catalog.Pages).(pdf.Dictionary)[pdf.Name("Kids")].(pdf.Array)
+
+	// a link to a named destination and a form field
+	add(t, d.File, annots[0], pdf.Dictionary{
+		pdf.Name("Type"):    pdf.Name("Annot"),
+		pdf.Name("Subtype"): pdf.Name("Link"),
+		pdf.Name("P"):       pages[0],
+		pdf.Name("Dest"):    pdf.String("chapter"),
+	})
+	add(t, d.File, annots[1], pdf.Dictionary{
+		pdf.Name("Type"):    pdf.Name("Annot"),
+		pdf.Name("Subtype"): pdf.Name("Widget"),
+		pdf.Name("P"):       pages[1],
+		pdf.Name("FT"):      pdf.Name("Tx"),
+		pdf.Name("T"):       pdf.String("name"),
+	})
+	catalog.AcroForm = pdf.Dictionary{
+		pdf.Name("Fields"): pdf.Array{annots[1]},
+		pdf.Name("DR"): pdf.Dictionary{
+			pdf.Name("Font"): pdf.Dictionary{pdf.Name("Helv"): pdf.Name("Helvetica")},
+		},
+	}
+
+	dests := add(t, d.File, pdf.ObjectReference{}, pdf.Dictionary{
+		pdf.Name("Names"): pdf.Array{pdf.String("chapter"), pdf.Array{pages[1], pdf.Name("Fit")}},
+	})
+	catalog.Names = pdf.Dictionary{pdf.Name("Dests"): dests}
+
+	outlines := add(t, d.File, pdf.ObjectReference{}, pdf.Null{})
+	item := add(t, d.File, pdf.ObjectReference{}, pdf.Dictionary{
+		pdf.Name("Title"):  pdf.String("Chapter"),
+		pdf.Name("Parent"): outlines,
+		pdf.Name("Dest"):   pdf.String("chapter"),
+	})
+	add(t, d.File, outlines, pdf.Dictionary{
+		pdf.Name("Type"):  pdf.Name("Outlines"),
+		pdf.Name("First"): item,
+		pdf.Name("Last"):  item,
+		pdf.Name("Count"): pdf.Integer(1),
+	})
+	catalog.Outlines = outlines
+
+	catalog.PageLabels = pdf.Dictionary{
+		pdf.Name("Nums"): pdf.Array{pdf.Integer(0), pdf.Dictionary{pdf.Name("S"): pdf.Name("r")}},
+	}
+
+	structRoot := add(t, d.File, pdf.ObjectReference{}, pdf.Null{})
+	element := add(t, d.File, pdf.ObjectReference{}, pdf.Dictionary{
+		pdf.Name("Type"): pdf.Name("StructElem"),
+		pdf.Name("S"):    pdf.Name("Document"),
+		pdf.Name("P"):    structRoot,
+	})
+	add(t, d.File, structRoot, pdf.Dictionary{
+		pdf.Name("Type"): pdf.Name("StructTreeRoot"),
+		pdf.Name("K"):    element,
+		pdf.Name("ParentTree"): pdf.Dictionary{
+			pdf.Name("Nums"): pdf.Array{
+				pdf.Integer(0), pdf.Array{element},
+				pdf.Integer(1), pdf.Array{element},
+			},
+		},
+		pdf.Name("ParentTreeNextKey"): pdf.Integer(2),
+	})
+	catalog.StructTreeRoot = structRoot
+
+	err = d.SetCatalog(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d, cleanup
+}
+
+func TestMerge(t *testing.T) {
+	src1, cleanup1 := createMergeSource(t)
+	defer cleanup1()
+	src2, cleanup2 := createMergeSource(t)
+	defer cleanup2()
+
+	dst, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Pages"),
+			pdf.Name("Kids"):  pdf.Array{},
+			pdf.Name("Count"): pdf.Integer(0),
+		}
+	})
+	defer cleanup()
+
+	err := Merge(dst, src1, src2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []Page{}
+	err = dst.Pages(func(page Page) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 4 {
+		t.Fatalf("expected 4 pages, got %d", len(pages))
+	}
+	for i, page := range pages {
+		// inherited from the sources' page trees
+		if page.MediaBox != (Rectangle{0, 0, 100, 100}) {
+			t.Errorf("%d: incorrect MediaBox %v", i, page.MediaBox)
+		}
+		if page.Dictionary[pdf.Name("StructParents")] != pdf.Integer(i) {
+			t.Errorf("%d: expected StructParents %d, got %v", i, i, page.Dictionary[pdf.Name("StructParents")])
+		}
+	}
+
+	catalog, err := dst.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second document's destination is renamed
+	dests, err := dst.nameTree(catalog.Names.(pdf.Dictionary)[pdf.Name("Dests")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDests := map[string]pdf.Object{
+		"chapter":   pdf.Array{pages[1].ObjectReference, pdf.Name("Fit")},
+		"chapter-2": pdf.Array{pages[3].ObjectReference, pdf.Name("Fit")},
+	}
+	if !reflect.DeepEqual(dests, expectedDests) {
+		t.Errorf("expected Dests %v, got %v", expectedDests, dests)
+	}
+
+	link := dst.File.Get(pages[2].Dictionary[pdf.Name("Annots")].(pdf.Array)[0].(pdf.ObjectReference)).(pdf.Dictionary)
+	if dest, ok := link[pdf.Name("Dest")].(pdf.String); !ok || string(dest) != "chapter-2" {
+		t.Errorf("expected the link to be renamed, got %v", link[pdf.Name("Dest")])
+	}
+	if link[pdf.Name("P")] != pages[2].ObjectReference {
+		t.Errorf("expected the link's page to be %v, got %v", pages[2].ObjectReference, link[pdf.Name("P")])
+	}
+
+	// the second document's field is renamed
+	form := catalog.AcroForm.(pdf.Dictionary)
+	names := []string{}
+	for _, field := range form[pdf.Name("Fields")].(pdf.Array) {
+		name, _ := fieldName(dst, field)
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(names, []string{"name", "name-2"}) {
+		t.Errorf("expected fields name and name-2, got %v", names)
+	}
+
+	// both outlines are kept, with the second item's destination renamed
+	outlines := dst.File.Get(catalog.Outlines.(pdf.ObjectReference)).(pdf.Dictionary)
+	if outlines[pdf.Name("Count")] != pdf.Integer(2) {
+		t.Errorf("expected outline Count 2, got %v", outlines[pdf.Name("Count")])
+	}
+	first := dst.File.Get(outlines[pdf.Name("First")].(pdf.ObjectReference)).(pdf.Dictionary)
+	if first[pdf.Name("Next")] != outlines[pdf.Name("Last")] {
+		t.Errorf("expected the first item to be followed by the last")
+	}
+	last := dst.File.Get(outlines[pdf.Name("Last")].(pdf.ObjectReference)).(pdf.Dictionary)
+	if string(last[pdf.Name("Dest")].(pdf.String)) != "chapter-2" {
+		t.Errorf("expected the item's Dest to be renamed, got %v", last[pdf.Name("Dest")])
+	}
+	for _, item := range []pdf.Dictionary{first, last} {
+		if item[pdf.Name("Parent")] != catalog.Outlines {
+			t.Errorf("expected the item's Parent to be the outline root, got %v", item[pdf.Name("Parent")])
+		}
+	}
+
+	labels, err := dst.numberTree(catalog.PageLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roman := pdf.Dictionary{pdf.Name("S"): pdf.Name("r")}
+	if !reflect.DeepEqual(labels, map[int]pdf.Object{0: roman, 2: roman}) {
+		t.Errorf("incorrect page labels %v", labels)
+	}
+
+	structRoot := dst.File.Get(catalog.StructTreeRoot.(pdf.ObjectReference)).(pdf.Dictionary)
+	kids := structRoot[pdf.Name("K")].(pdf.Array)
+	if len(kids) != 2 {
+		t.Fatalf("expected 2 structure elements, got %v", kids)
+	}
+	for _, kid := range kids {
+		element := dst.File.Get(kid.(pdf.ObjectReference)).(pdf.Dictionary)
+		if element[pdf.Name("P")] != catalog.StructTreeRoot {
+			t.Errorf("expected the element's parent to be the root, got %v", element[pdf.Name("P")])
+		}
+	}
+	parentTree, err := dst.numberTree(structRoot[pdf.Name("ParentTree")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedParents := map[int]pdf.Object{
+		0: pdf.Array{kids[0]},
+		1: pdf.Array{kids[0]},
+		2: pdf.Array{kids[1]},
+		3: pdf.Array{kids[1]},
+	}
+	if !reflect.DeepEqual(parentTree, expectedParents) {
+		t.Errorf("expected parent tree %v, got %v", expectedParents, parentTree)
+	}
+	if structRoot[pdf.Name("ParentTreeNextKey")] != pdf.Integer(4) {
+		t.Errorf("expected ParentTreeNextKey 4, got %v", structRoot[pdf.Name("ParentTreeNextKey")])
+	}
+
+	err = dst.File.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeBookmarks(t *testing.T) {
+	src1, cleanup1 := createMergeSource(t)
+	defer cleanup1()
+	src2, cleanup2 := createMergeSource(t)
+	defer cleanup2()
+
+	dst, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Pages"),
+			pdf.Name("Kids"):  pdf.Array{},
+			pdf.Name("Count"): pdf.Integer(0),
+		}
+	})
+	defer cleanup()
+
+	err := MergeOptions{Bookmarks: true, Titles: []string{"First"}}.Merge(dst, src1, src2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := dst.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlines := dst.File.Get(catalog.Outlines.(pdf.ObjectReference)).(pdf.Dictionary)
+
+	titles := []string{"First", "Document 2"}
+	firstPages := []int{0, 2}
+	item := outlines[pdf.Name("First")]
+	for i, title := range titles {
+		ref := item.(pdf.ObjectReference)
+		bookmark := dst.File.Get(ref).(pdf.Dictionary)
+		if string(bookmark[pdf.Name("Title")].(pdf.String)) != title {
+			t.Errorf("%d: expected %q, got %v", i, title, bookmark[pdf.Name("Title")])
+		}
+		if bookmark[pdf.Name("Count")] != pdf.Integer(-1) {
+			t.Errorf("%d: expected a closed bookmark with one item, got Count %v", i, bookmark[pdf.Name("Count")])
+		}
+
+		page, err := dst.Page(firstPages[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := bookmark[pdf.Name("Dest")].(pdf.Array)
+		if dest[0] != page.ObjectReference {
+			t.Errorf("%d: expected a destination of %v, got %v", i, page.ObjectReference, dest[0])
+		}
+
+		child := dst.File.Get(bookmark[pdf.Name("First")].(pdf.ObjectReference)).(pdf.Dictionary)
+		if child[pdf.Name("Parent")] != ref {
+			t.Errorf("%d: expected the item's Parent to be the bookmark", i)
+		}
+
+		item = bookmark[pdf.Name("Next")]
+	}
+	if item != nil {
+		t.Errorf("expected 2 bookmarks")
+	}
+	if outlines[pdf.Name("Count")] != pdf.Integer(2) {
+		t.Errorf("expected outline Count 2, got %v", outlines[pdf.Name("Count")])
+	}
+}
@@ -0,0 +1,314 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nathankerr/pdf"
+)
+
+// attributes a page inherits from its ancestors in the page tree
+// - §7.7.3.4
+var inheritable = []pdf.Name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// the MediaBox used when neither a page nor its ancestors have one
+var defaultMediaBox = Rectangle{0, 0, 612, 792}
+
+// Page is a page object and the attributes it inherits.
+// - §7.7.3.3
+type Page struct {
+	pdf.ObjectReference
+
+	// Dictionary is the page object as it is stored.
+	Dictionary pdf.Dictionary
+
+	// Resources are required by the page's contents.
+	Resources pdf.Dictionary
+
+	// MediaBox is the boundary of the physical medium. When neither the
+	// page nor its ancestors have one, US Letter is used.
+	MediaBox Rectangle
+
+	// CropBox is the visible region of the page, defaulting
+	// to the MediaBox.
+	CropBox Rectangle
+
+	// Rotate is the clockwise rotation of the page when it is
+	// displayed, one of 0, 90, 180 or 270.
+	Rotate int
+}
+
+// PageCount returns the number of pages in the document.
+func (d *Document) PageCount() (int, error) {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return 0, err
+	}
+
+	root, ok := d.File.Get(catalog.Pages).(pdf.Dictionary)
+	if !ok {
+		return 0, fmt.Errorf("page tree root %v is not a dictionary", catalog.Pages)
+	}
+
+	count, ok := d.resolve(root[pdf.Name("Count")]).(pdf.Integer)
+	if !ok || count < 0 {
+		return 0, fmt.Errorf("invalid page tree Count: %v", root[pdf.Name("Count")])
+	}
+
+	return int(count), nil
+}
+
+// Pages calls fn for each page in the document in page order
+// until fn returns false.
+func (d *Document) Pages(fn func(page Page) bool) error {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.walk(catalog.Pages, pdf.Dictionary{}, map[pdf.ObjectReference]bool{}, func(ref pdf.ObjectReference, node, inherited pdf.Dictionary) (bool, error) {
+		page, err := d.newPage(ref, node, inherited)
+		if err != nil {
+			return false, err
+		}
+		return fn(page), nil
+	})
+	return err
+}
+
+// walk calls fn with each page object under the page tree node at ref
+// and the attributes it inherits, returning false when fn does. The
+// nodes walked are added to visited.
+func (d *Document) walk(ref pdf.ObjectReference, inherited pdf.Dictionary, visited map[pdf.ObjectReference]bool, fn func(ref pdf.ObjectReference, page, inherited pdf.Dictionary) (bool, error)) (bool, error) {
+	if visited[ref] {
+		return false, fmt.Errorf("page tree node %v is its own ancestor", ref)
+	}
+	visited[ref] = true
+
+	node, ok := d.File.Get(ref).(pdf.Dictionary)
+	if !ok {
+		return false, fmt.Errorf("page tree node %v is not a dictionary", ref)
+	}
+
+	switch node[pdf.Name("Type")] {
+	case pdf.Name("Pages"):
+		inherited = inherit(inherited, node)
+
+		kids, ok := d.resolve(node[pdf.Name("Kids")]).(pdf.Array)
+		if !ok {
+			return false, fmt.Errorf("page tree node %v does not have Kids", ref)
+		}
+
+		for _, kid := range kids {
+			kidRef, ok := kid.(pdf.ObjectReference)
+			if !ok {
+				return false, fmt.Errorf("kid of page tree node %v is not an indirect reference", ref)
+			}
+
+			more, err := d.walk(kidRef, inherited, visited, fn)
+			if err != nil || !more {
+				return false, err
+			}
+		}
+		return true, nil
+	case pdf.Name("Page"):
+		return fn(ref, node, inherited)
+	}
+
+	return false, fmt.Errorf("page tree node %v has Type %v", ref, node[pdf.Name("Type")])
+}
+
+// Page returns the page at index, the first page being 0. Subtrees
+// that do not contain the page are skipped using their Count.
+func (d *Document) Page(index int) (Page, error) {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return Page{}, err
+	}
+
+	if index < 0 {
+		return Page{}, fmt.Errorf("page %d does not exist", index)
+	}
+
+	ref := catalog.Pages
+	inherited := pdf.Dictionary{}
+	visited := map[pdf.ObjectReference]bool{}
+	for {
+		if visited[ref] {
+			return Page{}, fmt.Errorf("page tree node %v is its own ancestor", ref)
+		}
+		visited[ref] = true
+
+		node, ok := d.File.Get(ref).(pdf.Dictionary)
+		if !ok {
+			return Page{}, fmt.Errorf("page tree node %v is not a dictionary", ref)
+		}
+
+		if node[pdf.Name("Type")] == pdf.Name("Page") {
+			if index != 0 {
+				return Page{}, errors.New("page tree Count is incorrect")
+			}
+			return d.newPage(ref, node, inherited)
+		}
+		inherited = inherit(inherited, node)
+
+		kids, ok := d.resolve(node[pdf.Name("Kids")]).(pdf.Array)
+		if !ok {
+			return Page{}, fmt.Errorf("page tree node %v does not have Kids", ref)
+		}
+
+		found := false
+		for _, kid := range kids {
+			kidRef, ok := kid.(pdf.ObjectReference)
+			if !ok {
+				return Page{}, fmt.Errorf("kid of page tree node %v is not an indirect reference", ref)
+			}
+
+			count, err := d.count(kidRef)
+			if err != nil {
+				return Page{}, err
+			}
+
+			if index < count {
+				ref = kidRef
+				found = true
+				break
+			}
+			index -= count
+		}
+
+		if !found {
+			return Page{}, errors.New("page does not exist")
+		}
+	}
+}
+
+// count returns the number of pages under the page tree node at ref
+func (d *Document) count(ref pdf.ObjectReference) (int, error) {
+	node, ok := d.File.Get(ref).(pdf.Dictionary)
+	if !ok {
+		return 0, fmt.Errorf("page tree node %v is not a dictionary", ref)
+	}
+	return d.nodeCount(ref, node)
+}
+
+// nodeCount returns the number of pages under node, which is at ref
+func (d *Document) nodeCount(ref pdf.ObjectReference, node pdf.Dictionary) (int, error) {
+	if node[pdf.Name("Type")] == pdf.Name("Page") {
+		return 1, nil
+	}
+
+	count, ok := d.resolve(node[pdf.Name("Count")]).(pdf.Integer)
+	if !ok || count < 0 {
+		return 0, fmt.Errorf("invalid Count for page tree node %v: %v", ref, node[pdf.Name("Count")])
+	}
+
+	return int(count), nil
+}
+
+// inherit returns the inheritable attributes of node,
+// filled in from those node inherits
+func inherit(inherited, node pdf.Dictionary) pdf.Dictionary {
+	attributes := pdf.Dictionary{}
+	for _, name := range inheritable {
+		if value, ok := node[name]; ok {
+			attributes[name] = value
+		} else if value, ok := inherited[name]; ok {
+			attributes[name] = value
+		}
+	}
+	return attributes
+}
+
+// newPage resolves the attributes of the page object at ref
+func (d *Document) newPage(ref pdf.ObjectReference, node, inherited pdf.Dictionary) (Page, error) {
+	attributes := inherit(inherited, node)
+
+	page := Page{
+		ObjectReference: ref,
+		Dictionary:      node,
+		MediaBox:        defaultMediaBox,
+	}
+
+	if resources, ok := attributes[pdf.Name("Resources")]; ok {
+		page.Resources, ok = d.resolve(resources).(pdf.Dictionary)
+		if !ok {
+			return Page{}, fmt.Errorf("Resources of page %v is not a dictionary", ref)
+		}
+	}
+
+	if mediaBox, ok := attributes[pdf.Name("MediaBox")]; ok {
+		var err error
+		page.MediaBox, err = d.rectangle(mediaBox)
+		if err != nil {
+			return Page{}, fmt.Errorf("MediaBox of page %v: %v", ref, err)
+		}
+	}
+
+	page.CropBox = page.MediaBox
+	if cropBox, ok := attributes[pdf.Name("CropBox")]; ok {
+		var err error
+		page.CropBox, err = d.rectangle(cropBox)
+		if err != nil {
+			return Page{}, fmt.Errorf("CropBox of page %v: %v", ref, err)
+		}
+	}
+
+	if rotate, ok := attributes[pdf.Name("Rotate")]; ok {
+		degrees, ok := d.resolve(rotate).(pdf.Integer)
+		if !ok || degrees%90 != 0 {
+			return Page{}, fmt.Errorf("invalid Rotate for page %v: %v", ref, rotate)
+		}
+		page.Rotate = int((degrees%360 + 360) % 360)
+	}
+
+	return page, nil
+}
+
+// Rectangle is a rectangle in default user space, given by the
+// coordinates of its lower-left and upper-right corners.
+// - §7.9.5
+type Rectangle struct {
+	LLX, LLY, URX, URY float64
+}
+
+// Width of the rectangle.
+func (r Rectangle) Width() float64 {
+	return r.URX - r.LLX
+}
+
+// Height of the rectangle.
+func (r Rectangle) Height() float64 {
+	return r.URY - r.LLY
+}
+
+// Array returns the rectangle as it is stored in a File.
+func (r Rectangle) Array() pdf.Array {
+	return pdf.Array{pdf.Real(r.LLX), pdf.Real(r.LLY), pdf.Real(r.URX), pdf.Real(r.URY)}
+}
+
+// rectangle normalizes the rectangle in obj so that the first
+// corner is lower-left and the second upper-right
+func (d *Document) rectangle(obj pdf.Object) (Rectangle, error) {
+	array, ok := d.resolve(obj).(pdf.Array)
+	if !ok || len(array) != 4 {
+		return Rectangle{}, fmt.Errorf("invalid rectangle: %v", obj)
+	}
+
+	var values [4]float64
+	for i, value := range array {
+		values[i], ok = number(d.resolve(value))
+		if !ok {
+			return Rectangle{}, fmt.Errorf("invalid rectangle: %v", obj)
+		}
+	}
+
+	r := Rectangle{values[0], values[1], values[2], values[3]}
+	if r.LLX > r.URX {
+		r.LLX, r.URX = r.URX, r.LLX
+	}
+	if r.LLY > r.URY {
+		r.LLY, r.URY = r.URY, r.LLY
+	}
+	return r, nil
+}
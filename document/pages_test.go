@@ -0,0 +1,161 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// a page tree with attributes at different levels:
+//
+//	root (MediaBox, Resources)
+//	├── 0
+//	└── node (Rotate, CropBox)
+//	    ├── 1 (MediaBox)
+//	    └── 2 (Rotate)
+func createInheritanceDocument(t *testing.T) (*Document, []pdf.ObjectReference, func()) {
+	var pages []pdf.ObjectReference
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		node := add(t, file, pdf.ObjectReference{}, pdf.Null{})
+
+		page := func(parent pdf.ObjectReference, attributes pdf.Dictionary) pdf.ObjectReference {
+			attributes[pdf.Name("Type")] = pdf.Name("Page")
+			attributes[pdf.Name("Parent")] = parent
+			ref := add(t, file, pdf.ObjectReference{}, attributes)
+			pages = append(pages, ref)
+			return ref
+		}
+
+		kids := pdf.Array{page(root, pdf.Dictionary{})}
+		nodeKids := pdf.Array{
+			page(node, pdf.Dictionary{pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(200), pdf.Integer(100)}}),
+			page(node, pdf.Dictionary{pdf.Name("Rotate"): pdf.Integer(-90)}),
+		}
+		add(t, file, node, pdf.Dictionary{
+			pdf.Name("Type"):    pdf.Name("Pages"),
+			pdf.Name("Parent"):  root,
+			pdf.Name("Kids"):    nodeKids,
+			pdf.Name("Count"):   pdf.Integer(2),
+			pdf.Name("Rotate"):  pdf.Integer(90),
+			pdf.Name("CropBox"): pdf.Array{pdf.Integer(10), pdf.Integer(10), pdf.Integer(50), pdf.Integer(50)},
+		})
+		kids = append(kids, node)
+
+		resources := add(t, file, pdf.ObjectReference{}, pdf.Dictionary{pdf.Name("Font"): pdf.Dictionary{}})
+		return pdf.Dictionary{
+			pdf.Name("Type"):      pdf.Name("Pages"),
+			pdf.Name("Kids"):      kids,
+			pdf.Name("Count"):     pdf.Integer(3),
+			pdf.Name("MediaBox"):  pdf.Array{pdf.Real(612), pdf.Real(792), pdf.Integer(0), pdf.Integer(0)},
+			pdf.Name("Resources"): resources,
+		}
+	})
+
+	return d, pages, cleanup
+}
+
+// §7.7.3.4
+func TestInheritedAttributes(t *testing.T) {
+	d, refs, cleanup := createInheritanceDocument(t)
+	defer cleanup()
+
+	letter := Rectangle{0, 0, 612, 792}
+	crop := Rectangle{10, 10, 50, 50}
+	expected := []Page{
+		{MediaBox: letter, CropBox: letter},
+		{MediaBox: Rectangle{0, 0, 200, 100}, CropBox: crop, Rotate: 90},
+		{MediaBox: letter, CropBox: crop, Rotate: 270},
+	}
+	resources := pdf.Dictionary{pdf.Name("Font"): pdf.Dictionary{}}
+
+	check := func(i int, page Page) {
+		if page.ObjectReference != refs[i] {
+			t.Errorf("%d: expected %v, got %v", i, refs[i], page.ObjectReference)
+		}
+		if !reflect.DeepEqual(page.Resources, resources) {
+			t.Errorf("%d: expected Resources %v, got %v", i, resources, page.Resources)
+		}
+		if page.MediaBox != expected[i].MediaBox {
+			t.Errorf("%d: expected MediaBox %v, got %v", i, expected[i].MediaBox, page.MediaBox)
+		}
+		if page.CropBox != expected[i].CropBox {
+			t.Errorf("%d: expected CropBox %v, got %v", i, expected[i].CropBox, page.CropBox)
+		}
+		if page.Rotate != expected[i].Rotate {
+			t.Errorf("%d: expected Rotate %v, got %v", i, expected[i].Rotate, page.Rotate)
+		}
+	}
+
+	i := 0
+	err := d.Pages(func(page Page) bool {
+		check(i, page)
+		i++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d pages, got %d", len(expected), i)
+	}
+
+	for i := range expected {
+		page, err := d.Page(i)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		check(i, page)
+	}
+
+	_, err = d.Page(len(expected))
+	if err == nil {
+		t.Error("expected an error for a page past the end")
+	}
+
+	count, err := d.PageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expected) {
+		t.Errorf("expected %d pages, got %d", len(expected), count)
+	}
+}
+
+func TestPagesStop(t *testing.T) {
+	d, _, cleanup := createInheritanceDocument(t)
+	defer cleanup()
+
+	i := 0
+	err := d.Pages(func(page Page) bool {
+		i++
+		return i < 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 2 {
+		t.Errorf("expected to stop after 2 pages, got %d", i)
+	}
+}
+
+func TestPageTreeLoop(t *testing.T) {
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		return pdf.Dictionary{
+			pdf.Name("Type"):  pdf.Name("Pages"),
+			pdf.Name("Kids"):  pdf.Array{root},
+			pdf.Name("Count"): pdf.Integer(1),
+		}
+	})
+	defer cleanup()
+
+	err := d.Pages(func(page Page) bool { return true })
+	if err == nil {
+		t.Error("expected the loop to be detected")
+	}
+
+	_, err = d.Page(0)
+	if err == nil {
+		t.Error("expected the loop to be detected")
+	}
+}
@@ -0,0 +1,540 @@
+package document
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nathankerr/pdf"
+)
+
+// how deeply form XObjects drawing form XObjects are followed
+const maxFormDepth = 16
+
+// how many instructions are interpreted for the text of a page,
+// counting those of a form XObject each time it is drawn, so that
+// forms that draw other forms many times cannot take forever
+const maxTextInstructions = 1 << 20
+
+var errTooManyInstructions = fmt.Errorf("more than %d instructions, including those of form XObjects", maxTextInstructions)
+
+// TJ adjustments of at least this many thousandths of
+// the font size are taken to be spaces between words
+const spaceAdjustment = 200
+
+// in the reading order, runs on a line whose baselines are within this
+// fraction of the font size are on the same line, and runs further
+// apart than this fraction of the font size are separated by a space
+const (
+	lineTolerance = 0.5
+	spaceGap      = 0.15
+)
+
+// A TextRun is the text shown by one text-showing
+// operator (Tj, TJ, ' or ") on a page.
+type TextRun struct {
+	Text string
+
+	// Font is the BaseFont of the font the text
+	// is shown with, which might not be known.
+	Font pdf.Name
+
+	// Size is the font size in default user space, which is the
+	// size set by Tf scaled by the text and transformation matrices.
+	Size float64
+
+	// Box encloses the glyphs in default user space,
+	// from the font's descent to its ascent.
+	Box Rectangle
+
+	// the baseline in default user space
+	start, end point
+}
+
+type point struct {
+	x, y float64
+}
+
+// matrix [a b c d e f]
+// - §8.3.4
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// multiply returns m × n
+func (m matrix) multiply(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// transform returns (x, y) transformed by m
+func (m matrix) transform(x, y float64) point {
+	return point{m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]}
+}
+
+// toMatrix returns the matrix in array, or identity when it is not one
+func (d *Document) toMatrix(array pdf.Array) matrix {
+	if len(array) != 6 {
+		return identity
+	}
+	var m matrix
+	for i, value := range array {
+		var ok bool
+		m[i], ok = number(d.resolve(value))
+		if !ok {
+			return identity
+		}
+	}
+	return m
+}
+
+// the parts of the graphics state that text is positioned with
+// - §8.4
+// - §9.3
+type textState struct {
+	ctm matrix
+
+	charSpacing float64
+	wordSpacing float64
+	scale       float64
+	leading     float64
+	rise        float64
+	font        *font
+	size        float64
+}
+
+// textExtractor interprets content streams to find
+// the text shown and where it is shown
+type textExtractor struct {
+	d     *Document
+	fonts map[pdf.ObjectReference]*font
+
+	// used when the font set by Tf is not found
+	missing *font
+
+	// the form XObjects being interpreted, which are not
+	// interpreted again when they draw themselves
+	forms map[pdf.ObjectReference]bool
+
+	// the parsed contents of the form XObjects that were drawn,
+	// which are kept for when they are drawn again
+	parsed map[pdf.ObjectReference]parsedContent
+
+	// instructions interpreted, up to maxTextInstructions
+	instructions int
+
+	state  textState
+	saved  []textState
+	tm     matrix
+	tlm    matrix
+	runs   []TextRun
+	inText bool
+}
+
+// TextRuns returns the text shown on the page at index, the first page
+// being 0, in the order it is shown, including that of the form XObjects
+// the page draws. The glyphs are decoded to Unicode with the font's
+// ToUnicode CMap, or else with its Encoding and Differences, glyphs that
+// cannot be decoded being U+FFFD. Type 3 glyph descriptions, annotations
+// and text in images are not read.
+//
+// When the contents are malformed, the text found before the
+// error is returned with it.
+// - §9.4
+// - §9.10
+func (d *Document) TextRuns(index int) ([]TextRun, error) {
+	page, err := d.Page(index)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.decodedContents(page)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &textExtractor{
+		d:       d,
+		fonts:   map[pdf.ObjectReference]*font{},
+		missing: d.loadFont(pdf.Dictionary{}),
+		forms:   map[pdf.ObjectReference]bool{},
+		parsed:  map[pdf.ObjectReference]parsedContent{},
+		state:   textState{ctm: identity, scale: 1},
+	}
+	err = e.interpret(parse(data), page.Resources, 0)
+	if err != nil {
+		return e.runs, fmt.Errorf("Contents of page %v: %v", page.ObjectReference, err)
+	}
+	return e.runs, nil
+}
+
+// Text returns the text on the page at index, the first page being 0,
+// in reading order: the runs are put into lines by their baselines as
+// the page is displayed, from top to bottom, with the runs on each line
+// from left to right. Lines are separated by newlines and runs that
+// are apart by spaces. See TextRuns.
+func (d *Document) Text(index int) (string, error) {
+	runs, err := d.TextRuns(index)
+	if err != nil && runs == nil {
+		return "", err
+	}
+
+	page, pageErr := d.Page(index)
+	if pageErr != nil {
+		return "", pageErr
+	}
+	box, pageErr := page.visible()
+	if pageErr != nil {
+		return "", pageErr
+	}
+	displayed := d.toMatrix(rotation(box, page.Rotate))
+
+	type displayedRun struct {
+		TextRun
+		start, end point
+	}
+	sorted := []displayedRun{}
+	for _, run := range runs {
+		if run.Text == "" {
+			continue
+		}
+		sorted = append(sorted, displayedRun{
+			TextRun: run,
+			start:   displayed.transform(run.start.x, run.start.y),
+			end:     displayed.transform(run.end.x, run.end.y),
+		})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].start.y > sorted[j].start.y
+	})
+
+	lines := [][]displayedRun{}
+	var baseline, size float64
+	for _, run := range sorted {
+		if len(lines) == 0 || math.Abs(baseline-run.start.y) > lineTolerance*math.Max(size, run.Size) {
+			lines = append(lines, nil)
+			baseline, size = run.start.y, run.Size
+		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], run)
+	}
+
+	text := &strings.Builder{}
+	for i, line := range lines {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+
+		sort.SliceStable(line, func(i, j int) bool {
+			return line[i].start.x < line[j].start.x
+		})
+		for j, run := range line {
+			if j > 0 {
+				previous := line[j-1]
+				gap := run.start.x - previous.end.x
+				if gap > spaceGap*math.Max(previous.Size, run.Size) &&
+					!strings.HasSuffix(previous.Text, " ") && !strings.HasPrefix(run.Text, " ") {
+					text.WriteString(" ")
+				}
+			}
+			text.WriteString(run.Text)
+		}
+	}
+
+	return text.String(), err
+}
+
+// the instructions of a content stream, and the
+// error found after them when it is malformed
+type parsedContent struct {
+	instructions []pdf.Instruction
+	err          error
+}
+
+func parse(data []byte) parsedContent {
+	instructions, err := pdf.ParseContent(data)
+	return parsedContent{instructions: instructions, err: err}
+}
+
+// interpret finds the text shown by the content,
+// whose named resources are in resources
+func (e *textExtractor) interpret(content parsedContent, resources pdf.Dictionary, depth int) error {
+	for _, instruction := range content.instructions {
+		if e.instructions == maxTextInstructions {
+			return errTooManyInstructions
+		}
+		e.instructions++
+
+		err := e.execute(instruction, resources, depth)
+		if err != nil {
+			return err
+		}
+	}
+	return content.err
+}
+
+// execute updates the state or records the text for an instruction.
+// Instructions with the wrong operands are ignored; the error is
+// errTooManyInstructions from the forms the instruction draws.
+func (e *textExtractor) execute(instruction pdf.Instruction, resources pdf.Dictionary, depth int) error {
+	operands := instruction.Operands
+	numbers, ok := e.numbers(operands)
+	state := &e.state
+
+	switch instruction.Operator {
+	case "q":
+		e.saved = append(e.saved, e.state)
+	case "Q":
+		if len(e.saved) > 0 {
+			e.state = e.saved[len(e.saved)-1]
+			e.saved = e.saved[:len(e.saved)-1]
+		}
+	case "cm":
+		if ok && len(numbers) == 6 {
+			state.ctm = matrix(numbers).multiply(state.ctm)
+		}
+	case "gs":
+		// the Font entry is [font size]
+		name, _ := operandName(operands)
+		gs := e.d.dictionary(e.d.resource(resources, "ExtGState", name))
+		if value, ok := e.d.resolve(gs[pdf.Name("Font")]).(pdf.Array); ok && len(value) == 2 {
+			if size, ok := number(e.d.resolve(value[1])); ok {
+				state.font, state.size = e.font(value[0]), size
+			}
+		}
+	case "Do":
+		name, _ := operandName(operands)
+		return e.form(e.d.resource(resources, "XObject", name), resources, depth)
+
+	case "BT":
+		e.inText = true
+		e.tm, e.tlm = identity, identity
+	case "ET":
+		e.inText = false
+
+	case "Tc":
+		if ok && len(numbers) == 1 {
+			state.charSpacing = numbers[0]
+		}
+	case "Tw":
+		if ok && len(numbers) == 1 {
+			state.wordSpacing = numbers[0]
+		}
+	case "Tz":
+		if ok && len(numbers) == 1 {
+			state.scale = numbers[0] / 100
+		}
+	case "TL":
+		if ok && len(numbers) == 1 {
+			state.leading = numbers[0]
+		}
+	case "Ts":
+		if ok && len(numbers) == 1 {
+			state.rise = numbers[0]
+		}
+	case "Tf":
+		if len(operands) == 2 {
+			name, _ := operands[0].(pdf.Name)
+			size, ok := number(operands[1])
+			if ok {
+				state.font = e.font(e.d.resource(resources, "Font", name))
+				state.size = size
+			}
+		}
+
+	case "Td":
+		if ok && len(numbers) == 2 {
+			e.moveText(numbers[0], numbers[1])
+		}
+	case "TD":
+		if ok && len(numbers) == 2 {
+			state.leading = -numbers[1]
+			e.moveText(numbers[0], numbers[1])
+		}
+	case "Tm":
+		if ok && len(numbers) == 6 {
+			e.tm, e.tlm = matrix(numbers), matrix(numbers)
+		}
+	case "T*":
+		e.moveText(0, -state.leading)
+
+	case "Tj":
+		if len(operands) == 1 {
+			e.show(pdf.Array{operands[0]})
+		}
+	case "'":
+		if len(operands) == 1 {
+			e.moveText(0, -state.leading)
+			e.show(pdf.Array{operands[0]})
+		}
+	case "\"":
+		if len(operands) == 3 {
+			state.wordSpacing, _ = number(operands[0])
+			state.charSpacing, _ = number(operands[1])
+			e.moveText(0, -state.leading)
+			e.show(pdf.Array{operands[2]})
+		}
+	case "TJ":
+		if len(operands) == 1 {
+			if array, ok := operands[0].(pdf.Array); ok {
+				e.show(array)
+			}
+		}
+	}
+	return nil
+}
+
+// numbers returns the operands as numbers, when they all are
+func (e *textExtractor) numbers(operands []pdf.Object) ([]float64, bool) {
+	numbers := make([]float64, len(operands))
+	for i, operand := range operands {
+		var ok bool
+		numbers[i], ok = number(operand)
+		if !ok {
+			return nil, false
+		}
+	}
+	return numbers, true
+}
+
+// operandName returns the only operand when it is a name
+func operandName(operands []pdf.Object) (pdf.Name, bool) {
+	if len(operands) != 1 {
+		return "", false
+	}
+	name, ok := operands[0].(pdf.Name)
+	return name, ok
+}
+
+// resource returns the resource with name in category of resources
+func (d *Document) resource(resources pdf.Dictionary, category, name pdf.Name) pdf.Object {
+	return d.dictionary(resources[category])[name]
+}
+
+// moveText starts a new line offset by (x, y) from the start of the current line
+// - §9.4.2
+func (e *textExtractor) moveText(x, y float64) {
+	e.tlm = matrix{1, 0, 0, 1, x, y}.multiply(e.tlm)
+	e.tm = e.tlm
+}
+
+// form interprets the form XObject at obj, which
+// inherits resources when it does not have its own.
+// Errors in the form are ignored, except for
+// errTooManyInstructions.
+// - §8.10
+func (e *textExtractor) form(obj pdf.Object, resources pdf.Dictionary, depth int) error {
+	stream, ok := e.d.resolve(obj).(pdf.Stream)
+	if !ok || stream.Dictionary[pdf.Name("Subtype")] != pdf.Name("Form") || depth >= maxFormDepth {
+		return nil
+	}
+	ref, isRef := obj.(pdf.ObjectReference)
+	if isRef {
+		if e.forms[ref] {
+			return nil
+		}
+		e.forms[ref] = true
+		defer delete(e.forms, ref)
+	}
+
+	content, ok := e.parsed[ref]
+	if !ok || !isRef {
+		data, err := e.d.File.Decode(stream)
+		if err != nil {
+			return nil
+		}
+		content = parse(data)
+		if isRef {
+			e.parsed[ref] = content
+		}
+	}
+	if own, ok := e.d.resolve(stream.Dictionary[pdf.Name("Resources")]).(pdf.Dictionary); ok {
+		resources = own
+	}
+	array, _ := e.d.resolve(stream.Dictionary[pdf.Name("Matrix")]).(pdf.Array)
+
+	// the form is drawn as if surrounded by q and Q,
+	// and text objects do not continue into it
+	saved, savedStack := e.state, e.saved
+	tm, tlm, inText := e.tm, e.tlm, e.inText
+	e.saved = nil
+	e.state.ctm = e.d.toMatrix(array).multiply(e.state.ctm)
+
+	err := e.interpret(content, resources, depth+1)
+
+	e.state, e.saved = saved, savedStack
+	e.tm, e.tlm, e.inText = tm, tlm, inText
+
+	if err == errTooManyInstructions {
+		return err
+	}
+	return nil
+}
+
+// show records the run shown by the strings in array, which are
+// separated by adjustments in thousandths of the font size
+// - §9.4.3
+func (e *textExtractor) show(array pdf.Array) {
+	state := e.state
+	f := state.font
+	if f == nil {
+		f = e.missing
+	}
+
+	// text space to device space, without the text matrix
+	// - §9.4.4
+	trs := matrix{state.size * state.scale, 0, 0, state.size, 0, state.rise}
+
+	text := &strings.Builder{}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	run := TextRun{
+		Font:  f.name,
+		start: trs.multiply(e.tm).multiply(state.ctm).transform(0, 0),
+	}
+
+	for _, element := range array {
+		switch typed := element.(type) {
+		case pdf.String:
+			for _, g := range f.glyphs(typed) {
+				trm := trs.multiply(e.tm).multiply(state.ctm)
+				for _, corner := range [4]point{{0, f.descent}, {g.width, f.descent}, {0, f.ascent}, {g.width, f.ascent}} {
+					p := trm.transform(corner.x, corner.y)
+					minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+					minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+				}
+				text.WriteString(g.text)
+
+				tx := g.width*state.size + state.charSpacing
+				if g.space {
+					tx += state.wordSpacing
+				}
+				e.tm = matrix{1, 0, 0, 1, tx * state.scale, 0}.multiply(e.tm)
+			}
+		case pdf.Integer, pdf.Real:
+			adjustment, _ := number(typed)
+			if -adjustment >= spaceAdjustment && text.Len() > 0 && !strings.HasSuffix(text.String(), " ") {
+				text.WriteString(" ")
+			}
+			e.tm = matrix{1, 0, 0, 1, -adjustment / 1000 * state.size * state.scale, 0}.multiply(e.tm)
+		}
+	}
+
+	if text.Len() == 0 {
+		return
+	}
+
+	// the size is how tall the font is drawn
+	m := e.tm.multiply(state.ctm)
+	run.Size = math.Hypot(m[2], m[3]) * state.size
+	run.Text = text.String()
+	run.Box = Rectangle{LLX: minX, LLY: minY, URX: maxX, URY: maxY}
+	run.end = trs.multiply(e.tm).multiply(state.ctm).transform(0, 0)
+	e.runs = append(e.runs, run)
+}
@@ -0,0 +1,410 @@
+package document
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// the fonts and form XObjects that pages of text documents can use
+type textResources struct {
+	simple, composite, form, fanOut pdf.ObjectReference
+}
+
+// a page of a text document, whose content is drawn by
+// draw and then has suffix, which can make it malformed
+type textPage struct {
+	rotate int
+	draw   func(c *pdf.ContentBuilder, resources textResources)
+	suffix string
+}
+
+// toUnicode maps 1 to "Hi", 2 through 4 to α through γ,
+// and 5 and 6 to 😀 and !
+const toUnicode = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+1 beginbfchar <0001> <00480069> endbfchar
+1 beginbfrange <0002> <0004> <03B1> endbfrange
+1 beginbfrange <0005> <0006> [<D83DDE00> (\000!)] endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+// creates a document with the pages, which are 612 by 792, and:
+//   - a simple font whose glyphs are 500 wide, except for 1 and 2,
+//     which are fi and é from its Differences and are 600 wide;
+//   - a composite font whose CIDs 1 and 2 are 400 and 600
+//     wide, the others being 1000, with toUnicode;
+//   - a form XObject that shows "form" at (50, 50) and draws itself;
+//   - a form XObject that draws another 30 times, which draws
+//     another 30 times, and so on for 7 forms, the last showing "deep".
+func createTextDocument(t *testing.T, pages ...textPage) (*Document, func()) {
+	return createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		widths := pdf.Array{}
+		for code := 32; code < 127; code++ {
+			widths = append(widths, pdf.Integer(500))
+		}
+		simple := add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+			pdf.Name("Type"):      pdf.Name("Font"),
+			pdf.Name("Subtype"):   pdf.Name("Type1"),
+			pdf.Name("BaseFont"):  pdf.Name("Simple"),
+			pdf.Name("FirstChar"): pdf.Integer(32),
+			pdf.Name("Widths"):    widths,
+			pdf.Name("Encoding"): pdf.Dictionary{
+				pdf.Name("BaseEncoding"): pdf.Name("WinAnsiEncoding"),
+				pdf.Name("Differences"):  pdf.Array{pdf.Integer(1), pdf.Name("f_i"), pdf.Name("uni00E9")},
+			},
+			pdf.Name("FontDescriptor"): pdf.Dictionary{
+				pdf.Name("Ascent"):       pdf.Integer(700),
+				pdf.Name("Descent"):      pdf.Integer(-300),
+				pdf.Name("MissingWidth"): pdf.Integer(600),
+			},
+		})
+
+		cmap := add(t, file, pdf.ObjectReference{}, pdf.Stream{
+			Dictionary: pdf.Dictionary{},
+			Stream:     []byte(toUnicode),
+		})
+		composite := add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Font"),
+			pdf.Name("Subtype"):  pdf.Name("Type0"),
+			pdf.Name("BaseFont"): pdf.Name("Composite"),
+			pdf.Name("Encoding"): pdf.Name("Identity-H"),
+			pdf.Name("DescendantFonts"): pdf.Array{pdf.Dictionary{
+				pdf.Name("Type"):     pdf.Name("Font"),
+				pdf.Name("Subtype"):  pdf.Name("CIDFontType2"),
+				pdf.Name("BaseFont"): pdf.Name("Composite"),
+				pdf.Name("W"):        pdf.Array{pdf.Integer(1), pdf.Array{pdf.Integer(400), pdf.Integer(600)}},
+			}},
+			pdf.Name("ToUnicode"): cmap,
+		})
+
+		form, err := file.Add(pdf.Null{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(t, file, form, pdf.Stream{
+			Dictionary: pdf.Dictionary{
+				pdf.Name("Type"):    pdf.Name("XObject"),
+				pdf.Name("Subtype"): pdf.Name("Form"),
+				pdf.Name("BBox"):    pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(100)},
+				pdf.Name("Matrix"):  pdf.Array{pdf.Integer(1), pdf.Integer(0), pdf.Integer(0), pdf.Integer(1), pdf.Integer(50), pdf.Integer(50)},
+				pdf.Name("Resources"): pdf.Dictionary{
+					pdf.Name("Font"):    pdf.Dictionary{pdf.Name("F1"): simple},
+					pdf.Name("XObject"): pdf.Dictionary{pdf.Name("Fm"): form},
+				},
+			},
+			Stream: []byte("BT /F1 10 Tf (form) Tj ET /Fm Do"),
+		})
+
+		fanOut := add(t, file, pdf.ObjectReference{}, pdf.Stream{
+			Dictionary: pdf.Dictionary{
+				pdf.Name("Subtype"):   pdf.Name("Form"),
+				pdf.Name("BBox"):      pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(100)},
+				pdf.Name("Resources"): pdf.Dictionary{pdf.Name("Font"): pdf.Dictionary{pdf.Name("F1"): simple}},
+			},
+			Stream: []byte("BT /F1 10 Tf (deep) Tj ET"),
+		})
+		for i := 1; i < 7; i++ {
+			fanOut = add(t, file, pdf.ObjectReference{}, pdf.Stream{
+				Dictionary: pdf.Dictionary{
+					pdf.Name("Subtype"):   pdf.Name("Form"),
+					pdf.Name("BBox"):      pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(100), pdf.Integer(100)},
+					pdf.Name("Resources"): pdf.Dictionary{pdf.Name("XObject"): pdf.Dictionary{pdf.Name("Fm"): fanOut}},
+				},
+				Stream: []byte(strings.Repeat("/Fm Do ", 30)),
+			})
+		}
+
+		resources := textResources{simple: simple, composite: composite, form: form, fanOut: fanOut}
+		kids := pdf.Array{}
+		for _, page := range pages {
+			c := pdf.NewContentBuilder(nil)
+			page.draw(c, resources)
+			contents, err := c.Stream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents.Stream = append(contents.Stream, page.suffix...)
+
+			kids = append(kids, add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+				pdf.Name("Type"):      pdf.Name("Page"),
+				pdf.Name("Parent"):    root,
+				pdf.Name("Rotate"):    pdf.Integer(page.rotate),
+				pdf.Name("Resources"): c.Resources(),
+				pdf.Name("Contents"):  add(t, file, pdf.ObjectReference{}, contents),
+			}))
+		}
+
+		return pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Pages"),
+			pdf.Name("Kids"):     kids,
+			pdf.Name("Count"):    pdf.Integer(len(kids)),
+			pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(612), pdf.Integer(792)},
+		}
+	})
+}
+
+func TestTextRuns(t *testing.T) {
+	d, cleanup := createTextDocument(t, textPage{draw: func(c *pdf.ContentBuilder, resources textResources) {
+		c.BeginText()
+		c.SetFont(resources.simple, 10)
+		c.MoveText(100, 700)
+		c.ShowText(pdf.String("Hello"))
+
+		// the adjustment of 250 is a space
+		c.SetHorizontalScaling(200)
+		c.SetCharSpacing(1)
+		c.ShowTextAdjusted(pdf.Array{pdf.String("a"), pdf.Integer(-250), pdf.String("b\x01\x02")})
+
+		// rotated by 90 degrees and twice as large
+		c.SetHorizontalScaling(100)
+		c.SetCharSpacing(0)
+		c.SetTextRise(5)
+		c.SetTextMatrix(0, 2, -2, 0, 300, 300)
+		c.SetFont(resources.composite, 12)
+		c.ShowText(pdf.String("\x00\x01\x00\x02\x00\x05\x00\x06"))
+		c.EndText()
+
+		c.Save()
+		c.Transform(2, 0, 0, 2, 0, 0)
+		c.DrawXObject(resources.form)
+		c.Restore()
+	}})
+	defer cleanup()
+
+	runs, err := d.TextRuns(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TextRun{
+		{"Hello", "Simple", 10, Rectangle{100, 697, 125, 707}, point{}, point{}},
+		// a and b are 12 wide with the spacing, the adjustment
+		// is 5, fi is 14 with the spacing and é is 12 wide
+		{"a bfié", "Simple", 10, Rectangle{125, 697, 180, 707}, point{}, point{}},
+		{"Hiα😀!", "Composite", 24, Rectangle{270.8, 300, 294.8, 372}, point{}, point{}},
+		// the form inherits the rise, which is doubled
+		{"form", "Simple", 20, Rectangle{100, 104, 140, 124}, point{}, point{}},
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %d runs, got %d: %v", len(expected), len(runs), runs)
+	}
+	for i, run := range runs {
+		if run.Text != expected[i].Text || run.Font != expected[i].Font || !nearly(run.Size, expected[i].Size) ||
+			!nearly(run.Box.LLX, expected[i].Box.LLX) || !nearly(run.Box.LLY, expected[i].Box.LLY) ||
+			!nearly(run.Box.URX, expected[i].Box.URX) || !nearly(run.Box.URY, expected[i].Box.URY) {
+			t.Errorf("%d: expected %q in %s at %v, %v, got %q in %s at %v, %v", i,
+				expected[i].Text, expected[i].Font, expected[i].Size, expected[i].Box,
+				run.Text, run.Font, run.Size, run.Box)
+		}
+	}
+}
+
+// reports whether a and b are equal but for rounding
+func nearly(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestText(t *testing.T) {
+	d, cleanup := createTextDocument(t,
+		textPage{draw: func(c *pdf.ContentBuilder, resources textResources) {
+			c.BeginText()
+			c.SetFont(resources.simple, 10)
+
+			// shown out of order, on baselines that are not quite the same
+			c.SetTextMatrix(1, 0, 0, 1, 200, 700)
+			c.ShowText(pdf.String("world"))
+			c.SetTextMatrix(1, 0, 0, 1, 100, 700.5)
+			c.ShowText(pdf.String("Hello"))
+
+			c.SetTextMatrix(1, 0, 0, 1, 100, 680)
+			c.ShowText(pdf.String("second"))
+			c.SetTextMatrix(1, 0, 0, 1, 100, 660)
+			c.ShowTextAdjusted(pdf.Array{pdf.String("a"), pdf.Integer(-300), pdf.String("b")})
+
+			// runs that touch are not separated
+			c.SetTextMatrix(1, 0, 0, 1, 100, 640)
+			c.ShowText(pdf.String("foo"))
+			c.ShowText(pdf.String("bar"))
+			c.EndText()
+		}},
+		textPage{rotate: 90, draw: func(c *pdf.ContentBuilder, resources textResources) {
+			// lines that are horizontal when the page is displayed
+			c.BeginText()
+			c.SetFont(resources.simple, 10)
+			c.SetTextMatrix(0, 1, -1, 0, 120, 100)
+			c.ShowText(pdf.String("below"))
+			c.SetTextMatrix(0, 1, -1, 0, 100, 100)
+			c.ShowText(pdf.String("above"))
+			c.EndText()
+		}},
+		textPage{
+			// strings are decoded once, whether literal or hexadecimal
+			draw: func(c *pdf.ContentBuilder, resources textResources) {
+				c.BeginText()
+				c.SetFont(resources.simple, 10)
+				c.SetTextMatrix(1, 0, 0, 1, 100, 700)
+				c.ShowText(pdf.String(`(\n)`))
+				c.EndText()
+			},
+			suffix: " BT /F1 10 Tf 100 680 Td <5C6E41> Tj ET",
+		},
+	)
+	defer cleanup()
+
+	for index, expected := range []string{
+		"Hello world\nsecond\na b\nfoobar",
+		"above\nbelow",
+		"(\\n)\n\\nA",
+	} {
+		text, err := d.Text(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != expected {
+			t.Errorf("page %d: expected %q, got %q", index, expected, text)
+		}
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	d, cleanup := createTextDocument(t, textPage{
+		draw: func(c *pdf.ContentBuilder, resources textResources) {
+			c.BeginText()
+			c.SetFont(resources.simple, 10)
+			c.ShowText(pdf.String("found"))
+			c.EndText()
+		},
+		suffix: " ] BT (lost) Tj ET",
+	})
+	defer cleanup()
+
+	// the text before the error is returned with it
+	text, err := d.Text(0)
+	if err == nil || !strings.Contains(err.Error(), "unexpected ']'") {
+		t.Errorf("expected an error about ], got %v", err)
+	}
+	if text != "found" {
+		t.Errorf("expected the text before the error, got %q", text)
+	}
+
+	_, err = d.TextRuns(1)
+	if err == nil {
+		t.Error("expected an error for a page that does not exist")
+	}
+}
+
+func TestTextFanOut(t *testing.T) {
+	d, cleanup := createTextDocument(t, textPage{
+		draw: func(c *pdf.ContentBuilder, resources textResources) {
+			c.DrawXObject(resources.fanOut)
+		},
+	})
+	defer cleanup()
+
+	// the forms would show "deep" 30^6 times, so
+	// interpreting stops after maxTextInstructions
+	runs, err := d.TextRuns(0)
+	if err == nil || !strings.Contains(err.Error(), "instructions") {
+		t.Errorf("expected an error about instructions, got %v", err)
+	}
+	if len(runs) == 0 || runs[0].Text != "deep" {
+		t.Errorf("expected the text before the error, got %d runs", len(runs))
+	}
+}
+
+func TestGlyphText(t *testing.T) {
+	for name, expected := range map[string]string{
+		"A":             "A",
+		"eacute":        "é",
+		"A.swash":       "A",
+		"uni0041":       "A",
+		"uni00410042":   "AB",
+		"uniD83DDE00":   "😀",
+		"u1F600":        "😀",
+		"f_f_i":         "ffi",
+		"notaglyphname": "",
+	} {
+		text := glyphText(name)
+		if text != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, text)
+		}
+	}
+}
+
+// ranges of CIDs in W are not expanded
+func TestCIDWidths(t *testing.T) {
+	w := pdf.Array{pdf.Integer(1), pdf.Array{pdf.Integer(400), pdf.Integer(600)}}
+	for i := 0; i < 1000; i++ {
+		w = append(w, pdf.Integer(10), pdf.Integer(math.MaxInt32), pdf.Integer(300))
+	}
+	w = append(w, pdf.Integer(20), pdf.Integer(30), pdf.Integer(700))
+
+	f := &font{widths: map[int]float64{}}
+	(&Document{}).loadCIDWidths(f, pdf.Dictionary{pdf.Name("W"): w, pdf.Name("DW"): pdf.Integer(900)})
+
+	if len(f.widths) != 2 || len(f.widthRanges) != 1001 {
+		t.Errorf("expected 2 widths and 1001 ranges, got %d and %d", len(f.widths), len(f.widthRanges))
+	}
+	for cid, expected := range map[int]float64{0: 0.9, 1: 0.4, 2: 0.6, 3: 0.9, 10: 0.3, 25: 0.7, math.MaxInt32: 0.3} {
+		if width := f.width(cid); !nearly(width, expected) {
+			t.Errorf("CID %d: expected a width of %v, got %v", cid, expected, width)
+		}
+	}
+}
+
+// the content streams of a page are joined
+func TestTextContentsArray(t *testing.T) {
+	d, cleanup := createTextDocument(t, textPage{draw: func(c *pdf.ContentBuilder, resources textResources) {
+		c.BeginText()
+		c.SetFont(resources.simple, 10)
+		c.ShowText(pdf.String("joined"))
+		c.EndText()
+	}})
+	defer cleanup()
+
+	page, err := d.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := d.File.Get(page.Dictionary[pdf.Name("Contents")].(pdf.ObjectReference)).(pdf.Stream)
+	data, err := d.File.Decode(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// split before the string, the second part being compressed
+	split := strings.Index(string(data), "(joined)")
+	second, err := pdf.NewStream(data[split:], pdf.Filter{Name: pdf.Name("FlateDecode")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict := d.File.Get(page.ObjectReference).(pdf.Dictionary)
+	dict[pdf.Name("Contents")] = pdf.Array{
+		add(t, d.File, pdf.ObjectReference{}, pdf.Stream{Dictionary: pdf.Dictionary{}, Stream: data[:split]}),
+		add(t, d.File, pdf.ObjectReference{}, second),
+	}
+	add(t, d.File, page.ObjectReference, dict)
+
+	page, err = d.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := page.Dictionary[pdf.Name("Contents")].(pdf.Array); !ok {
+		t.Fatalf("expected an array of contents, got %v", page.Dictionary[pdf.Name("Contents")])
+	}
+
+	text, err := d.Text(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "joined" {
+		t.Errorf("expected %q, got %q", "joined", text)
+	}
+}
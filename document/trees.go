@@ -0,0 +1,185 @@
+package document
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nathankerr/pdf"
+)
+
+// maximum number of entries or Kids in the nodes of
+// name and number trees that are written
+const maxTreeEntries = 64
+
+// nameTree returns the entries of the name tree at obj
+// - §7.9.6
+func (d *Document) nameTree(obj pdf.Object) (map[string]pdf.Object, error) {
+	entries := map[string]pdf.Object{}
+	err := d.tree(obj, pdf.Name("Names"), map[pdf.ObjectReference]bool{}, func(key, value pdf.Object) error {
+		name, ok := d.resolve(key).(pdf.String)
+		if !ok {
+			return fmt.Errorf("name tree key %v is not a string", key)
+		}
+		entries[string(name)] = value
+		return nil
+	})
+	return entries, err
+}
+
+// numberTree returns the entries of the number tree at obj
+// - §7.9.7
+func (d *Document) numberTree(obj pdf.Object) (map[int]pdf.Object, error) {
+	entries := map[int]pdf.Object{}
+	err := d.tree(obj, pdf.Name("Nums"), map[pdf.ObjectReference]bool{}, func(key, value pdf.Object) error {
+		number, ok := d.resolve(key).(pdf.Integer)
+		if !ok {
+			return fmt.Errorf("number tree key %v is not an integer", key)
+		}
+		entries[int(number)] = value
+		return nil
+	})
+	return entries, err
+}
+
+// tree calls fn with the entries in the leaves of the tree at obj,
+// which are stored as pairs in the leafKey arrays
+func (d *Document) tree(obj pdf.Object, leafKey pdf.Name, visited map[pdf.ObjectReference]bool, fn func(key, value pdf.Object) error) error {
+	if ref, ok := obj.(pdf.ObjectReference); ok {
+		if visited[ref] {
+			return fmt.Errorf("tree node %v is its own ancestor", ref)
+		}
+		visited[ref] = true
+	}
+
+	node, ok := d.resolve(obj).(pdf.Dictionary)
+	if !ok {
+		return fmt.Errorf("tree node %v is not a dictionary", obj)
+	}
+
+	if pairs, ok := d.resolve(node[leafKey]).(pdf.Array); ok {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			err := fn(pairs[i], pairs[i+1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if kids, ok := d.resolve(node[pdf.Name("Kids")]).(pdf.Array); ok {
+		for _, kid := range kids {
+			err := d.tree(kid, leafKey, visited, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeNameTree adds a name tree with entries to file
+func writeNameTree(file *pdf.File, entries map[string]pdf.Object) (pdf.ObjectReference, error) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make([]pdf.Object, len(names))
+	values := make([]pdf.Object, len(names))
+	for i, name := range names {
+		keys[i] = pdf.String(name)
+		values[i] = entries[name]
+	}
+
+	return writeTree(file, pdf.Name("Names"), keys, values)
+}
+
+// writeNumberTree adds a number tree with entries to file
+func writeNumberTree(file *pdf.File, entries map[int]pdf.Object) (pdf.ObjectReference, error) {
+	numbers := make([]int, 0, len(entries))
+	for number := range entries {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	keys := make([]pdf.Object, len(numbers))
+	values := make([]pdf.Object, len(numbers))
+	for i, number := range numbers {
+		keys[i] = pdf.Integer(number)
+		values[i] = entries[number]
+	}
+
+	return writeTree(file, pdf.Name("Nums"), keys, values)
+}
+
+// writeTree adds a balanced tree with the sorted keys and their
+// values to file, returning the reference of its root
+func writeTree(file *pdf.File, leafKey pdf.Name, keys, values []pdf.Object) (pdf.ObjectReference, error) {
+	pairs := func(start, end int) pdf.Array {
+		array := make(pdf.Array, 0, 2*(end-start))
+		for i := start; i < end; i++ {
+			array = append(array, keys[i], values[i])
+		}
+		return array
+	}
+
+	if len(keys) <= maxTreeEntries {
+		return file.Add(pdf.Dictionary{leafKey: pairs(0, len(keys))})
+	}
+
+	// the nodes of a level and the range of keys they contain
+	type limited struct {
+		ref         pdf.ObjectReference
+		first, last pdf.Object
+	}
+
+	level := []limited{}
+	for start := 0; start < len(keys); start += maxTreeEntries {
+		end := start + maxTreeEntries
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		ref, err := file.Add(pdf.Dictionary{
+			leafKey:            pairs(start, end),
+			pdf.Name("Limits"): pdf.Array{keys[start], keys[end-1]},
+		})
+		if err != nil {
+			return pdf.ObjectReference{}, err
+		}
+		level = append(level, limited{ref, keys[start], keys[end-1]})
+	}
+
+	for len(level) > maxTreeEntries {
+		parents := []limited{}
+		for start := 0; start < len(level); start += maxTreeEntries {
+			end := start + maxTreeEntries
+			if end > len(level) {
+				end = len(level)
+			}
+
+			kids := pdf.Array{}
+			for _, kid := range level[start:end] {
+				kids = append(kids, kid.ref)
+			}
+
+			first, last := level[start].first, level[end-1].last
+			ref, err := file.Add(pdf.Dictionary{
+				pdf.Name("Kids"):   kids,
+				pdf.Name("Limits"): pdf.Array{first, last},
+			})
+			if err != nil {
+				return pdf.ObjectReference{}, err
+			}
+			parents = append(parents, limited{ref, first, last})
+		}
+		level = parents
+	}
+
+	kids := pdf.Array{}
+	for _, kid := range level {
+		kids = append(kids, kid.ref)
+	}
+	return file.Add(pdf.Dictionary{pdf.Name("Kids"): kids})
+}
@@ -0,0 +1,223 @@
+package document
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nathankerr/pdf"
+)
+
+// ImportPageAsXObject adds the page of src at index, the first page
+// being 0, to dst as a form XObject, returning its reference. See
+// Copier.ImportPage.
+//
+// When dst and src are different, the streams of the copied objects
+// share their data with src, so src must remain open until dst
+// is saved.
+func ImportPageAsXObject(dst, src *Document, index int) (pdf.ObjectReference, error) {
+	page, err := src.Page(index)
+	if err != nil {
+		return pdf.ObjectReference{}, err
+	}
+
+	return NewCopier(dst.File, src.File).ImportPage(page)
+}
+
+// ImportPage adds page, which is from the source, to the destination
+// as a form XObject, returning its reference. The XObject draws the
+// page's contents as the page is displayed: its BBox is the visible
+// region of the page and its Matrix rotates the page by its Rotate and
+// moves the lower-left corner of the visible region to the origin. So
+// the XObject is Size wide and high. Annotations are not included.
+//
+// The Resources the page inherits are used. When the page has more
+// than one content stream, they are decoded and combined into one.
+// - §8.10
+func (c *Copier) ImportPage(page Page) (pdf.ObjectReference, error) {
+	box, err := page.visible()
+	if err != nil {
+		return pdf.ObjectReference{}, err
+	}
+
+	src := New(c.src)
+	contents, err := src.contents(page)
+	if err != nil {
+		return pdf.ObjectReference{}, err
+	}
+
+	dict := pdf.Dictionary{}
+	for name, value := range contents.Dictionary {
+		dict[name] = value
+	}
+
+	resources := page.Resources
+	if resources == nil {
+		resources = pdf.Dictionary{}
+	}
+	dict[pdf.Name("Resources")] = resources
+
+	// the transparency group the contents are drawn in
+	if group, ok := page.Dictionary[pdf.Name("Group")]; ok {
+		dict[pdf.Name("Group")] = group
+	}
+
+	copied, err := c.Copy(dict)
+	if err != nil {
+		return pdf.ObjectReference{}, err
+	}
+	dict = copied.(pdf.Dictionary)
+
+	dict[pdf.Name("Type")] = pdf.Name("XObject")
+	dict[pdf.Name("Subtype")] = pdf.Name("Form")
+	dict[pdf.Name("BBox")] = box.Array()
+	dict[pdf.Name("Matrix")] = rotation(box, page.Rotate)
+
+	return c.dst.Add(pdf.Stream{
+		Dictionary: dict,
+		Stream:     contents.Stream,
+	})
+}
+
+// Size returns the width and height of the page as it is displayed,
+// which is its CropBox rotated by its Rotate. They are zero when the
+// CropBox is outside the MediaBox.
+func (page Page) Size() (width, height float64) {
+	box, err := page.visible()
+	if err != nil {
+		return 0, 0
+	}
+
+	if page.Rotate == 90 || page.Rotate == 270 {
+		return box.Height(), box.Width()
+	}
+	return box.Width(), box.Height()
+}
+
+// visible returns the region of the page that is displayed,
+// which is the CropBox clipped to the MediaBox
+// - §14.11.2
+func (page Page) visible() (Rectangle, error) {
+	box := Rectangle{
+		LLX: math.Max(page.CropBox.LLX, page.MediaBox.LLX),
+		LLY: math.Max(page.CropBox.LLY, page.MediaBox.LLY),
+		URX: math.Min(page.CropBox.URX, page.MediaBox.URX),
+		URY: math.Min(page.CropBox.URY, page.MediaBox.URY),
+	}
+	if box.LLX >= box.URX || box.LLY >= box.URY {
+		return Rectangle{}, fmt.Errorf("CropBox of page %v is outside its MediaBox", page.ObjectReference)
+	}
+	return box, nil
+}
+
+// rotation returns the matrix that rotates box clockwise by degrees
+// and moves its lower-left corner to the origin
+func rotation(box Rectangle, degrees int) pdf.Array {
+	var m [6]float64
+	switch degrees {
+	case 90:
+		m = [6]float64{0, -1, 1, 0, -box.LLY, box.URX}
+	case 180:
+		m = [6]float64{-1, 0, 0, -1, box.URX, box.URY}
+	case 270:
+		m = [6]float64{0, 1, -1, 0, box.URY, -box.LLX}
+	default:
+		m = [6]float64{1, 0, 0, 1, -box.LLX, -box.LLY}
+	}
+
+	matrix := make(pdf.Array, len(m))
+	for i, value := range m {
+		if value == float64(int(value)) {
+			matrix[i] = pdf.Integer(value)
+		} else {
+			matrix[i] = pdf.Real(value)
+		}
+	}
+	return matrix
+}
+
+// contents returns the page's contents as one stream. A single content
+// stream is returned as it is, while several are decoded, joined and
+// encoded with FlateDecode. Only the stream's Filter and DecodeParms
+// are kept in its dictionary.
+// - §7.8.2
+func (d *Document) contents(page Page) (pdf.Stream, error) {
+	streams, err := d.contentStreams(page)
+	if err != nil {
+		return pdf.Stream{}, err
+	}
+
+	if len(streams) == 1 && !streams[0].IsExternal() {
+		dict := pdf.Dictionary{}
+		for _, name := range []pdf.Name{"Filter", "DecodeParms"} {
+			if value, ok := streams[0].Dictionary[name]; ok {
+				dict[name] = value
+			}
+		}
+		return pdf.Stream{Dictionary: dict, Stream: streams[0].Stream}, nil
+	}
+
+	joined, err := d.joinContents(page, streams)
+	if err != nil {
+		return pdf.Stream{}, err
+	}
+	return pdf.NewStream(joined, pdf.Filter{Name: pdf.Name("FlateDecode")})
+}
+
+// decodedContents returns the decoded data of the page's contents,
+// the content streams being joined without encoding them again.
+func (d *Document) decodedContents(page Page) ([]byte, error) {
+	streams, err := d.contentStreams(page)
+	if err != nil {
+		return nil, err
+	}
+	return d.joinContents(page, streams)
+}
+
+// contentStreams returns the content streams of the page
+func (d *Document) contentStreams(page Page) ([]pdf.Stream, error) {
+	var refs pdf.Array
+	switch typed := page.Dictionary[pdf.Name("Contents")].(type) {
+	case nil:
+		// a page without contents is blank
+	case pdf.ObjectReference:
+		if array, ok := d.File.Get(typed).(pdf.Array); ok {
+			refs = array
+		} else {
+			refs = pdf.Array{typed}
+		}
+	case pdf.Array:
+		refs = typed
+	default:
+		return nil, fmt.Errorf("Contents of page %v is not a stream or array", page.ObjectReference)
+	}
+
+	streams := []pdf.Stream{}
+	for _, ref := range refs {
+		stream, ok := d.resolve(ref).(pdf.Stream)
+		if !ok {
+			return nil, fmt.Errorf("Contents of page %v has %v, which is not a stream", page.ObjectReference, ref)
+		}
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+// joinContents decodes the content streams and joins them
+func (d *Document) joinContents(page Page, streams []pdf.Stream) ([]byte, error) {
+	// the streams are split at token boundaries,
+	// so they are separated by white space
+	joined := []byte{}
+	for i, stream := range streams {
+		decoded, err := d.File.Decode(stream)
+		if err != nil {
+			return nil, fmt.Errorf("Contents of page %v: %v", page.ObjectReference, err)
+		}
+		if i > 0 {
+			joined = append(joined, '\n')
+		}
+		joined = append(joined, decoded...)
+	}
+
+	return joined, nil
+}
@@ -0,0 +1,172 @@
+package document
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nathankerr/pdf"
+)
+
+// creates a document whose first page is rotated, cropped, inherits its
+// Resources and has two content streams, and whose second page has one
+func createXObjectSource(t *testing.T) (*Document, pdf.ObjectReference, func()) {
+	var font pdf.ObjectReference
+	d, cleanup := createTestDocument(t, func(file *pdf.File, root pdf.ObjectReference) pdf.Dictionary {
+		font = add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+			pdf.Name("Type"):     pdf.Name("Font"),
+			pdf.Name("Subtype"):  pdf.Name("Type1"),
+			pdf.Name("BaseFont"): pdf.Name("Helvetica"),
+		})
+
+		encoded, err := pdf.NewStream([]byte("q 1 0 0 1 0 0 cm"), pdf.Filter{Name: pdf.Name("FlateDecode")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := add(t, file, pdf.ObjectReference{}, encoded)
+		second := add(t, file, pdf.ObjectReference{}, pdf.Stream{
+			Dictionary: pdf.Dictionary{},
+			Stream:     []byte("Q"),
+		})
+
+		return pdf.Dictionary{
+			pdf.Name("Type"): pdf.Name("Pages"),
+			pdf.Name("Kids"): pdf.Array{
+				add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+					pdf.Name("Type"):     pdf.Name("Page"),
+					pdf.Name("Parent"):   root,
+					pdf.Name("CropBox"):  pdf.Array{pdf.Integer(10), pdf.Integer(10), pdf.Integer(190), pdf.Integer(90)},
+					pdf.Name("Contents"): pdf.Array{first, second},
+				}),
+				add(t, file, pdf.ObjectReference{}, pdf.Dictionary{
+					pdf.Name("Type"):     pdf.Name("Page"),
+					pdf.Name("Parent"):   root,
+					pdf.Name("Rotate"):   pdf.Integer(0),
+					pdf.Name("Contents"): first,
+				}),
+			},
+			pdf.Name("Count"):    pdf.Integer(2),
+			pdf.Name("MediaBox"): pdf.Array{pdf.Integer(0), pdf.Integer(0), pdf.Integer(200), pdf.Integer(100)},
+			pdf.Name("Rotate"):   pdf.Integer(90),
+			pdf.Name("Resources"): pdf.Dictionary{
+				pdf.Name("Font"): pdf.Dictionary{pdf.Name("F1"): font},
+			},
+		}
+	})
+
+	return d, font, cleanup
+}
+
+func TestImportPageAsXObject(t *testing.T) {
+	src, font, cleanupSrc := createXObjectSource(t)
+	defer cleanupSrc()
+	dst, cleanup := createEmptyDocument(t)
+	defer cleanup()
+
+	ref, err := ImportPageAsXObject(dst, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xobject := dst.File.Get(ref).(pdf.Stream)
+
+	for name, expected := range map[pdf.Name]pdf.Object{
+		"Type":    pdf.Name("XObject"),
+		"Subtype": pdf.Name("Form"),
+		"BBox":    pdf.Array{pdf.Real(10), pdf.Real(10), pdf.Real(190), pdf.Real(90)},
+		"Matrix":  pdf.Array{pdf.Integer(0), pdf.Integer(-1), pdf.Integer(1), pdf.Integer(0), pdf.Integer(-10), pdf.Integer(190)},
+	} {
+		if !reflect.DeepEqual(xobject.Dictionary[name], expected) {
+			t.Errorf("expected %s %v, got %v", name, expected, xobject.Dictionary[name])
+		}
+	}
+
+	// the inherited font is copied
+	fonts := xobject.Dictionary[pdf.Name("Resources")].(pdf.Dictionary)[pdf.Name("Font")].(pdf.Dictionary)
+	copied, ok := fonts[pdf.Name("F1")].(pdf.ObjectReference)
+	if !ok {
+		t.Fatalf("expected the font to be an indirect reference, got %v", fonts[pdf.Name("F1")])
+	}
+	if !reflect.DeepEqual(dst.File.Get(copied), src.File.Get(font)) {
+		t.Errorf("expected the font to be copied, got %v", dst.File.Get(copied))
+	}
+
+	// the content streams are decoded before they are joined
+	decoded, err := xobject.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "q 1 0 0 1 0 0 cm\nQ" {
+		t.Errorf("incorrect contents %q", decoded)
+	}
+
+	page, err := src.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width, height := page.Size(); width != 80 || height != 180 {
+		t.Errorf("expected the rotated size to be 80x180, got %vx%v", width, height)
+	}
+}
+
+func TestImportPageSingleStream(t *testing.T) {
+	src, font, cleanupSrc := createXObjectSource(t)
+	defer cleanupSrc()
+
+	// importing into the same file does not copy the resources
+	ref, err := ImportPageAsXObject(src, src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xobject := src.File.Get(ref).(pdf.Stream)
+
+	fonts := xobject.Dictionary[pdf.Name("Resources")].(pdf.Dictionary)[pdf.Name("Font")].(pdf.Dictionary)
+	if fonts[pdf.Name("F1")] != font {
+		t.Errorf("expected the font %v, got %v", font, fonts[pdf.Name("F1")])
+	}
+
+	// the stream is used as it is
+	page, err := src.Page(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := src.File.Get(page.Dictionary[pdf.Name("Contents")].(pdf.ObjectReference)).(pdf.Stream)
+	if !reflect.DeepEqual(xobject.Stream, contents.Stream) {
+		t.Errorf("expected the encoded contents to be kept")
+	}
+	if xobject.Dictionary[pdf.Name("Filter")] != contents.Dictionary[pdf.Name("Filter")] {
+		t.Errorf("expected Filter %v, got %v", contents.Dictionary[pdf.Name("Filter")], xobject.Dictionary[pdf.Name("Filter")])
+	}
+	if !reflect.DeepEqual(xobject.Dictionary[pdf.Name("Matrix")], pdf.Array{pdf.Integer(1), pdf.Integer(0), pdf.Integer(0), pdf.Integer(1), pdf.Integer(0), pdf.Integer(0)}) {
+		t.Errorf("expected the identity Matrix, got %v", xobject.Dictionary[pdf.Name("Matrix")])
+	}
+}
+
+func TestRotation(t *testing.T) {
+	box := Rectangle{10, 20, 110, 70}
+
+	// where the corners of box are after rotating it clockwise
+	for _, test := range []struct {
+		degrees               int
+		lowerLeft, upperRight [2]float64
+	}{
+		{0, [2]float64{0, 0}, [2]float64{100, 50}},
+		{90, [2]float64{0, 100}, [2]float64{50, 0}},
+		{180, [2]float64{100, 50}, [2]float64{0, 0}},
+		{270, [2]float64{50, 0}, [2]float64{0, 100}},
+	} {
+		matrix := rotation(box, test.degrees)
+		m := make([]float64, len(matrix))
+		for i, value := range matrix {
+			m[i], _ = number(value)
+		}
+		transform := func(x, y float64) [2]float64 {
+			return [2]float64{m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]}
+		}
+
+		if corner := transform(box.LLX, box.LLY); corner != test.lowerLeft {
+			t.Errorf("%d: expected the lower-left corner at %v, got %v", test.degrees, test.lowerLeft, corner)
+		}
+		if corner := transform(box.URX, box.URY); corner != test.upperRight {
+			t.Errorf("%d: expected the upper-right corner at %v, got %v", test.degrees, test.upperRight, corner)
+		}
+	}
+}
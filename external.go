@@ -0,0 +1,169 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// External file streams have their data in an external file instead
+// of in the PDF file. The F entry of the stream dictionary specifies
+// the file, FFilter and FDecodeParms are used instead of Filter and
+// DecodeParms. The data in the PDF file is ignored.
+// - §7.3.8.2
+
+// IsExternal reports whether the stream's data is in an external file.
+func (s Stream) IsExternal() bool {
+	_, ok := s.Dictionary[Name("F")]
+	return ok
+}
+
+// NewExternalStream encodes data with the filters and writes it to
+// the external file name, which is relative to the File's location
+// unless absolute. The returned stream refers to the external file
+// and can be added to the File.
+func (f *File) NewExternalStream(name string, data []byte, filters ...Filter) (Stream, error) {
+	encoded := &bytes.Buffer{}
+	w, err := f.NewEncoder(encoded, filters...)
+	if err != nil {
+		return Stream{}, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return Stream{}, err
+	}
+	err = w.Close()
+	if err != nil {
+		return Stream{}, err
+	}
+
+	file, err := os.Create(f.externalPath(name))
+	if err != nil {
+		return Stream{}, err
+	}
+	_, err = encoded.WriteTo(file)
+	if err != nil {
+		file.Close()
+		return Stream{}, err
+	}
+	err = file.Close()
+	if err != nil {
+		return Stream{}, err
+	}
+
+	dict := Dictionary{
+		Name("F"):  String(filepath.ToSlash(name)),
+		Name("DL"): Integer(len(data)),
+	}
+	setFilters(dict, filters)
+
+	return Stream{Dictionary: dict}, nil
+}
+
+// externalPath returns the path to the file name,
+// relative to the File's location
+func (f *File) externalPath(name string) string {
+	name = filepath.FromSlash(name)
+	if f == nil || f.filename == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(f.filename), name)
+}
+
+// ExternalFilesIn returns an ExternalFile function for a File that
+// opens the files in dir, which is usually the directory of the PDF
+// file. Names that are absolute or that use ".." to leave dir are
+// rejected.
+func ExternalFilesIn(dir string) func(name string) (io.ReadCloser, error) {
+	return func(name string) (io.ReadCloser, error) {
+		name = filepath.FromSlash(name)
+		if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, string(filepath.Separator)) {
+			return nil, fmt.Errorf("external file %q is not relative", name)
+		}
+		name = filepath.Clean(name)
+		if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("external file %q is outside of %s", name, dir)
+		}
+
+		return os.Open(filepath.Join(dir, name))
+	}
+}
+
+// openExternal opens the external file of the stream
+// using the File's ExternalFile, file may be nil
+func (s Stream) openExternal(file *File) (io.ReadCloser, error) {
+	name, err := fileSpecificationName(file, s.Dictionary[Name("F")])
+	if err != nil {
+		return nil, err
+	}
+
+	// the name can be any file, so files are only
+	// opened when the caller says how to open them
+	if file == nil || file.ExternalFile == nil {
+		return nil, fmt.Errorf("cannot read external file %q without an ExternalFile", name)
+	}
+	return file.ExternalFile(name)
+}
+
+// fileSpecificationName returns the file name from a file
+// specification string or dictionary, file may be nil
+// - §7.11
+func fileSpecificationName(file *File, spec Object) (string, error) {
+	spec = file.resolve(spec)
+
+	switch typed := spec.(type) {
+	case String:
+		return string(typed), nil
+	case Dictionary:
+		if fs, ok := file.resolve(typed[Name("FS")]).(Name); ok && fs == Name("URL") {
+			return "", errors.New("URL file specifications are not supported")
+		}
+
+		// prefer the unicode name, which is a text string
+		if name, ok := file.resolve(typed[Name("UF")]).(String); ok {
+			return decodeTextString(name), nil
+		}
+
+		// then the platform independent one
+		for _, key := range []Name{"F", "Unix", "DOS", "Mac"} {
+			if name, ok := file.resolve(typed[key]).(String); ok {
+				return string(name), nil
+			}
+		}
+		return "", errors.New("file specification without a file name")
+	}
+
+	return "", fmt.Errorf("invalid file specification: %T", spec)
+}
+
+// closes the underlying reader at the end of the data
+type closeAtEOF struct {
+	r      io.ReadCloser
+	closed bool
+}
+
+func (c *closeAtEOF) Read(p []byte) (int, error) {
+	if c.closed {
+		return 0, io.EOF
+	}
+
+	n, err := c.r.Read(p)
+	if err != nil {
+		c.Close()
+	}
+	return n, err
+}
+
+// Close closes the underlying reader unless it already is,
+// c may be nil
+func (c *closeAtEOF) Close() error {
+	if c == nil || c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.r.Close()
+}
@@ -0,0 +1,206 @@
+package pdf
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// §7.3.8.2
+func TestExternalStream(t *testing.T) {
+	filename, cleanup := createTestFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("external data\n"), 100)
+	stream, err := file.NewExternalStream("data.bin", data, Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := file.Add(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stream = file.Get(ref).(Stream)
+	err = compare(stream.Dictionary, Dictionary{
+		Name("F"):       String("data.bin"),
+		Name("FFilter"): Name("FlateDecode"),
+		Name("DL"):      Integer(len(data)),
+		Name("Length"):  Integer(0),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// external files are not read by default
+	_, err = file.Decode(stream)
+	if err == nil {
+		t.Error("expected an error without an ExternalFile")
+	}
+
+	file.ExternalFile = ExternalFilesIn(filepath.Dir(filename))
+	decoded, err := file.Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected %d bytes, got %d", len(data), len(decoded))
+	}
+
+	encoded, err := ioutil.ReadFile(filepath.Join(filepath.Dir(filename), "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasZlibHeader(encoded) {
+		t.Errorf("the external file was not encoded")
+	}
+
+	// the filters would not be applied to the external file
+	_, err = stream.Encode(Filter{Name: "ASCIIHexDecode"})
+	if err == nil {
+		t.Error("expected an error encoding an external file stream")
+	}
+	if stream.Dictionary[Name("FFilter")] != Name("FlateDecode") || stream.Dictionary[Name("Filter")] != nil {
+		t.Errorf("expected the filters to be unchanged, got %v", stream.Dictionary)
+	}
+}
+
+func TestExternalFileResolver(t *testing.T) {
+	stream := Stream{
+		Dictionary: Dictionary{
+			Name("F"): Dictionary{
+				Name("Type"): Name("Filespec"),
+				Name("F"):    String("data.hex"),
+				// UTF-16BE text string
+				Name("UF"): String("\xfe\xff\x00d\x00o\x00n\x00n\x00\xe9\x00e\x00s\x00.\x00h\x00e\x00x"),
+			},
+			Name("FFilter"): Name("ASCIIHexDecode"),
+			// the filters for the data in the PDF file are not used
+			Name("Filter"): Name("FlateDecode"),
+		},
+		Stream: []byte("ignored"),
+	}
+
+	opened := ""
+	file := &File{
+		ExternalFile: func(name string) (io.ReadCloser, error) {
+			opened = name
+			return ioutil.NopCloser(bytes.NewReader([]byte("48656C6C6F>"))), nil
+		},
+	}
+
+	decoded, err := file.Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Hello" {
+		t.Errorf("expected %q, got %q", "Hello", decoded)
+	}
+	if opened != "données.hex" {
+		t.Errorf("expected the UF name to be used, got %q", opened)
+	}
+}
+
+// records whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// external files are closed when decoding fails
+func TestExternalFileClosed(t *testing.T) {
+	tests := map[string]Dictionary{
+		"no decoder": {Name("FFilter"): Name("UnknownDecode")},
+		"setup": {
+			Name("FFilter"):      Array{Name("ASCIIHexDecode"), Name("LZWDecode")},
+			Name("FDecodeParms"): Array{Null{}, Dictionary{Name("EarlyChange"): Integer(2)}},
+		},
+		"data": {Name("FFilter"): Name("ASCIIHexDecode")},
+	}
+
+	for name, dict := range tests {
+		var opened *closeRecorder
+		file := &File{
+			ExternalFile: func(name string) (io.ReadCloser, error) {
+				// invalid hexadecimal data followed by more data
+				opened = &closeRecorder{Reader: bytes.NewReader(append([]byte("zz"), make([]byte, 100)...))}
+				return opened, nil
+			},
+		}
+		dict[Name("F")] = String("data.hex")
+		stream := Stream{Dictionary: dict}
+
+		_, err := file.Decode(stream)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if opened == nil || !opened.closed {
+			t.Errorf("%s: the external file was not closed", name)
+		}
+	}
+}
+
+func TestExternalFilesIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "sub", "data.txt"), []byte("data"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := ExternalFilesIn(filepath.Join(dir, "sub"))
+	for _, name := range []string{"data.txt", "./data.txt", "a/../data.txt"} {
+		r, err := open(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		r.Close()
+	}
+
+	for _, name := range []string{"/etc/passwd", "../data.txt", "../sub/data.txt", "..", "a/../../data.txt", "/sub/data.txt"} {
+		r, err := open(name)
+		if err == nil {
+			r.Close()
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -6,12 +6,14 @@ package pdf
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/edsrzf/mmap-go"
 	"io"
 	"os"
 	"sort"
+	"sync"
 )
 
 type freeObject uint // generation number for next use of the object number where this is stored
@@ -19,6 +21,10 @@ type freeObject uint // generation number for next use of the object number wher
 // File manages access to objects stored in a PDF file.
 // Contains the non-managed keys from the file trailer.
 type File struct {
+	// bytes decoded from the streams, counted against MaxMemory,
+	// first for 64-bit alignment of atomic operations
+	decoded int64
+
 	filename string
 	file     *os.File
 	mmap     mmap.MMap
@@ -34,6 +40,17 @@ type File struct {
 
 	prev Integer
 
+	// the ID has been written to the file,
+	// so saving changes its second half
+	saved bool
+
+	// merged trailer of the cross-reference sections
+	trailer Dictionary
+
+	// decoded object streams, most recently used last
+	objectStreams     []*objectStream
+	objectStreamsLock sync.Mutex
+
 	// The catalog dictionary for the PDF document contained in the file.
 	Root ObjectReference
 
@@ -43,15 +60,49 @@ type File struct {
 	// The document's information dictionary
 	Info ObjectReference
 
-	// An array of two byte-strings constituting a file identifier for the file.
-	ID Array
+	// The file identifier, generated for new files and
+	// updated on each Save.
+	ID FileIdentifier
+
+	// RealDecimals limits the number of digits written after the
+	// decimal point of Reals on Save. When zero or less, Reals are
+	// written with as many digits as needed to read them back exactly.
+	RealDecimals int
+
+	// CompressThreshold is the size in bytes above which streams
+	// without filters are compressed with FlateDecode on Save.
+	// When zero or less, streams are written as they are.
+	CompressThreshold int
+
+	// Lenient allows damaged streams to be partially decoded.
+	Lenient bool
+
+	// ExternalFile opens the files of external file streams given
+	// the file name from their file specification. When nil, the
+	// data of external file streams cannot be read, as the name can
+	// be any file. ExternalFilesIn opens the files next to the PDF.
+	ExternalFile func(name string) (io.ReadCloser, error)
+
+	// Limits restrict the resources used when reading the file.
+	// Limits on the cross-references apply when opening the file.
+	Limits Limits
 }
 
-// Open opens a PDF file for manipulation of its objects.
+// Open opens a PDF file for manipulation of its objects
+// using DefaultLimits.
 func Open(filename string) (*File, error) {
+	return OpenContext(context.Background(), filename, DefaultLimits)
+}
+
+// OpenContext opens a PDF file for manipulation of its objects,
+// restricting the resources used to the limits. Loading the
+// cross-references stops when ctx is done.
+func OpenContext(ctx context.Context, filename string, limits Limits) (*File, error) {
 	file := &File{
 		filename: filename,
 		objects:  map[uint]interface{}{},
+		saved:    true,
+		Limits:   limits,
 	}
 
 	var err error
@@ -78,7 +129,7 @@ func Open(filename string) (*File, error) {
 		return nil, errors.New("file does not have PDF header")
 	}
 
-	err = file.loadReferences()
+	err = file.loadReferences(ctx)
 	if err != nil {
 		err2 := file.Close()
 		if err2 != nil {
@@ -97,6 +148,7 @@ func Create(filename string) (*File, error) {
 		objects:  map[uint]interface{}{},
 		created:  true,
 		size:     1,
+		Limits:   DefaultLimits,
 	}
 
 	// create enough of the pdf so that
@@ -117,6 +169,9 @@ func Create(filename string) (*File, error) {
 		return nil, err
 	}
 
+	id := file.newIdentifier()
+	file.ID = FileIdentifier{Permanent: id, Changing: id}
+
 	return file, nil
 }
 
@@ -138,9 +193,12 @@ func (f *File) Get(ref ObjectReference) Object {
 			return Null{fmt.Errorf("%s is a free object", ref)}
 		case 1: // normal
 			offset := typed[1] - 1
-			obj, _, err := parseIndirectObject(f.mmap[offset:])
+			if typed[1] == 0 || offset >= uint64(len(f.mmap)) {
+				return Null{fmt.Errorf("%v is at %d, which is outside of the file", ref, typed[1])}
+			}
+			obj, _, err := parseNestedIndirectObject(f.mmap[offset:], f.maxDepth())
 			if err != nil {
-				return Null{fmt.Errorf("Error parsing %s", ref)}
+				return Null{fmt.Errorf("Error parsing %s: %v", ref, err)}
 			}
 
 			iobj, ok := obj.(IndirectObject)
@@ -153,57 +211,14 @@ func (f *File) Get(ref ObjectReference) Object {
 			}
 			object = iobj.Object
 		case 2: // in object stream
-			// get the object stream
-			objectStreamRef := ObjectReference{ObjectNumber: typed[1]}
-			objectStream, ok := f.Get(objectStreamRef).(Stream)
-			if !ok {
-				return Null{fmt.Errorf("%v should be in object stream %v, but %v is not a stream", ref, objectStreamRef, objectStreamRef)}
-			}
-
-			// parse the index (object number and offset pairs)
-			index := []Integer{}
-			N := int(objectStream.Dictionary[Name("N")].(Integer))
-			stream, err := objectStream.Decode()
+			obj, err := f.getFromObjectStream(ref, uint(typed[1]), int(typed[2]))
 			if err != nil {
-				return Null{fmt.Errorf("could not decode %v", objectStreamRef)}
-			}
-
-			offset := 0
-			for i := 0; i < N*2; i++ {
-				obj, n, err := parseNumeric(stream[offset:])
-				if err != nil {
-					return Null{fmt.Errorf("unable to parse numeric %v", stream[offset:])}
-				}
-
-				index = append(index, obj.(Integer))
-				offset += n
-			}
-
-			// find the offset for the object we are looking for
-			start := typed[2] * 2
-			objectNumber := index[start]
-			offset = int(index[start+1])
-
-			// if the index from the cross reference is wrong,
-			// find the correct offset
-			if objectNumber != Integer(ref.ObjectNumber) {
-				objectNumber = Integer(ref.ObjectNumber)
-				for i := 0; i < len(index); i += 2 {
-					if index[i] == objectNumber {
-						offset = int(index[i+1])
-						break
-					}
-				}
-			}
-
-			// grab the object
-			first := int(objectStream.Dictionary[Name("First")].(Integer))
-			object, _, err = parseObject(stream[first+offset:])
-			if err != nil {
-				return Null{fmt.Errorf("unable to parse object %v", stream[first+offset:])}
+				return Null{err}
 			}
+			object = obj
 		default:
-			panic(typed[0])
+			// other types are references to the null object (§7.5.8.3)
+			return Null{fmt.Errorf("%v has cross-reference type %d", ref, typed[0])}
 		}
 	case IndirectObject: // new object
 		if typed.Object == nil {
@@ -219,7 +234,10 @@ func (f *File) Get(ref ObjectReference) Object {
 	// deal with streams that have refs to lengths
 	if streamObj, ok := object.(Stream); ok {
 		if lengthRef, ok := streamObj.Dictionary["Length"].(ObjectReference); ok {
-			length := f.Get(lengthRef).(Integer)
+			length, ok := f.Get(lengthRef).(Integer)
+			if !ok || length < 0 || int64(length) > int64(len(streamObj.Stream)) {
+				return Null{fmt.Errorf("%v has an invalid Length", ref)}
+			}
 			streamObj.Dictionary["Length"] = length
 			streamObj.Stream = streamObj.Stream[:int(length)]
 		}
@@ -229,6 +247,212 @@ func (f *File) Get(ref ObjectReference) Object {
 	return object
 }
 
+var errNotInObjectStream = errors.New("object not found")
+
+// getFromObjectStream returns the object ref from the object stream
+// with the given number, where it should be at index. When the object
+// is not in the stream, the object streams it Extends are searched.
+// - §7.5.7
+func (f *File) getFromObjectStream(ref ObjectReference, number uint, index int) (Object, error) {
+	visited := map[uint]bool{}
+	for {
+		objectStreamRef := ObjectReference{ObjectNumber: number}
+		if visited[number] {
+			return nil, fmt.Errorf("%v not found, object stream %v Extends itself", ref, objectStreamRef)
+		}
+		visited[number] = true
+
+		// object streams cannot be in object streams,
+		// which would allow Get to recurse endlessly
+		if xref, ok := f.objects[number].(crossReference); ok && xref[0] == 2 {
+			return nil, fmt.Errorf("object stream %v is in an object stream", objectStreamRef)
+		}
+
+		objectStream, ok := f.Get(objectStreamRef).(Stream)
+		if !ok {
+			return nil, fmt.Errorf("%v should be in object stream %v, but %v is not a stream", ref, objectStreamRef, objectStreamRef)
+		}
+
+		decoded, err := f.objectStream(number, objectStream)
+		if err != nil {
+			return nil, fmt.Errorf("%v in object stream %v: %v", ref, objectStreamRef, err)
+		}
+		object, err := f.objectFromStream(decoded, ref, index)
+		if err == nil {
+			return object, nil
+		}
+		if err != errNotInObjectStream {
+			return nil, fmt.Errorf("%v in object stream %v: %v", ref, objectStreamRef, err)
+		}
+
+		extends, ok := objectStream.Dictionary[Name("Extends")].(ObjectReference)
+		if !ok {
+			return nil, fmt.Errorf("%v not found in object stream %v", ref, objectStreamRef)
+		}
+		number = extends.ObjectNumber
+		index = -1
+	}
+}
+
+// number of decoded object streams kept by a File, so that
+// getting each of their objects does not decode them again
+const objectStreamCacheSize = 4
+
+// an object stream (§7.5.7) that has been decoded
+type objectStream struct {
+	number uint
+	data   []byte
+	first  int
+
+	// object number and offset pairs from the header
+	pairs []Integer
+}
+
+// decodeObjectStream decodes an object stream and parses its header.
+func (f *File) decodeObjectStream(stream Stream) (*objectStream, error) {
+	N, ok := stream.Dictionary[Name("N")].(Integer)
+	if !ok || N < 0 {
+		return nil, fmt.Errorf("invalid N: %v", stream.Dictionary[Name("N")])
+	}
+	first, ok := stream.Dictionary[Name("First")].(Integer)
+	if !ok || first < 0 {
+		return nil, fmt.Errorf("invalid First: %v", stream.Dictionary[Name("First")])
+	}
+	if max := f.Limits.MaxStreamSize; max > 0 && int64(first) > max {
+		return nil, &LimitError{Limit: "MaxStreamSize", Max: max}
+	}
+
+	data, err := f.Decode(stream)
+	if err != nil && !(f.Lenient && len(data) > 0 && !isLimit(err)) {
+		return nil, err
+	}
+
+	// First is checked against the decoded data,
+	// so the header is not allocated from it
+	if int64(len(data)) < int64(first) {
+		return nil, fmt.Errorf("could not read header: First is %d, but the stream has %d bytes", first, len(data))
+	}
+	header := data[:first]
+
+	pairs := []Integer{}
+	position := 0
+	for i := 0; i < int(N)*2; i++ {
+		obj, n, err := parseNumeric(header[position:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse numeric %q", header[position:])
+		}
+		integer, ok := obj.(Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected an integer in the header, got %v", obj)
+		}
+
+		pairs = append(pairs, integer)
+		position += n
+	}
+
+	return &objectStream{data: data, first: int(first), pairs: pairs}, nil
+}
+
+// objectStream returns the decoded object stream with the given
+// number. Object streams that are in the file, rather than added
+// to the File, are cached.
+func (f *File) objectStream(number uint, stream Stream) (*objectStream, error) {
+	_, inFile := f.objects[number].(crossReference)
+	if !inFile {
+		return f.decodeObjectStream(stream)
+	}
+
+	f.objectStreamsLock.Lock()
+	for i, cached := range f.objectStreams {
+		if cached.number == number {
+			// move it to the end, as the most recently used
+			copy(f.objectStreams[i:], f.objectStreams[i+1:])
+			f.objectStreams[len(f.objectStreams)-1] = cached
+			f.objectStreamsLock.Unlock()
+			return cached, nil
+		}
+	}
+	f.objectStreamsLock.Unlock()
+
+	decoded, err := f.decodeObjectStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	decoded.number = number
+
+	f.objectStreamsLock.Lock()
+	if len(f.objectStreams) == objectStreamCacheSize {
+		f.objectStreams = append(f.objectStreams[:0], f.objectStreams[1:]...)
+	}
+	f.objectStreams = append(f.objectStreams, decoded)
+	f.objectStreamsLock.Unlock()
+
+	return decoded, nil
+}
+
+// objectFromStream parses the object ref from an object stream,
+// where it should be at index.
+func (f *File) objectFromStream(objectStream *objectStream, ref ObjectReference, index int) (Object, error) {
+	pairs := objectStream.pairs
+
+	// find the offset for the object we are looking for,
+	// if the index from the cross reference is wrong,
+	// find the correct offset
+	offset := Integer(-1)
+	if index >= 0 && index*2+1 < len(pairs) && pairs[index*2] == Integer(ref.ObjectNumber) {
+		offset = pairs[index*2+1]
+	} else {
+		for i := 0; i < len(pairs); i += 2 {
+			if pairs[i] == Integer(ref.ObjectNumber) {
+				offset = pairs[i+1]
+				break
+			}
+		}
+	}
+	if offset < 0 {
+		return nil, errNotInObjectStream
+	}
+
+	// the object ends where the next one starts
+	end := Integer(-1)
+	for i := 1; i < len(pairs); i += 2 {
+		if pairs[i] > offset && (end == -1 || pairs[i] < end) {
+			end = pairs[i]
+		}
+	}
+
+	data := objectStream.data[objectStream.first:]
+	if int64(offset) > int64(len(data)) {
+		return nil, fmt.Errorf("could not read to the object: %v", io.ErrUnexpectedEOF)
+	}
+	if end == -1 {
+		data = data[offset:]
+	} else if int64(end) > int64(len(data)) {
+		if !f.Lenient {
+			return nil, fmt.Errorf("could not read the object: %v", io.ErrUnexpectedEOF)
+		}
+		data = data[offset:]
+	} else {
+		data = data[offset:end]
+	}
+
+	object, _, err := parseNestedObject(data, f.maxDepth())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse object: %v", err)
+	}
+
+	return object, nil
+}
+
+// resolve returns the referenced object when obj is an ObjectReference,
+// otherwise obj is returned.
+func (f *File) resolve(obj Object) Object {
+	if ref, ok := obj.(ObjectReference); ok && f != nil {
+		return f.Get(ref)
+	}
+	return obj
+}
+
 // Add returns the object reference of the object after adding it to the file.
 // An IndirectObject's ObjectReference will be used,
 // otherwise a free ObjectReference will be used.
@@ -257,15 +481,13 @@ func (f *File) Add(obj Object) (ObjectReference, error) {
 			case crossReference: // existing object
 				switch typed[0] {
 				case 0: // free entry
-					minGenerationNumber = typed[2]
+					minGenerationNumber = uint(typed[2])
 				case 1: // normal
-					minGenerationNumber = typed[2]
-				case 2: // in object stream
+					minGenerationNumber = uint(typed[2])
+				default: // in object stream or null (§7.5.8.3)
 					// objects in object streams must have a
 					// generation number of 0
 					minGenerationNumber = 0
-				default:
-					panic(typed[0])
 				}
 			case IndirectObject: // new object
 				minGenerationNumber = typed.GenerationNumber
@@ -300,6 +522,25 @@ func (f *File) Add(obj Object) (ObjectReference, error) {
 	return ref, nil
 }
 
+// prepareForSave applies the File's settings for writing to obj.
+func (f *File) prepareForSave(obj IndirectObject) IndirectObject {
+	if f.RealDecimals > 0 {
+		obj = roundReals(obj, f.RealDecimals).(IndirectObject)
+	}
+
+	if stream, ok := obj.Object.(Stream); ok && f.CompressThreshold > 0 {
+		_, hasFilter := stream.Dictionary[Name("Filter")]
+		if !hasFilter && len(stream.Stream) > f.CompressThreshold {
+			compressed, err := stream.Encode(Filter{Name: Name("FlateDecode")})
+			if err == nil {
+				obj.Object = compressed
+			}
+		}
+	}
+
+	return obj
+}
+
 func writeLineBreakTo(w io.Writer) (int64, error) {
 	n, err := w.Write([]byte{'\n', '\n'})
 	return int64(n), err
@@ -312,8 +553,16 @@ func writeLineBreakTo(w io.Writer) (int64, error) {
 // NOTE: A new object index will be written on each save,
 // taking space in the file on disk
 func (f *File) Save() error {
+	f.updateIdentifier()
+
 	// return f.saveUsingXrefTable()
-	return f.saveUsingXrefStream()
+	err := f.saveUsingXrefStream()
+	if err != nil {
+		return err
+	}
+
+	f.saved = true
+	return nil
 }
 
 func (f *File) saveUsingXrefTable() error {
@@ -352,12 +601,36 @@ func (f *File) saveUsingXrefTable() error {
 			// no-op, don't need to write unchanged objects to file
 			// however, we do need to handle the free list
 			// xrefs[Integer(i)] = typed
-			if typed[0] == 0 {
+			if typed[0] == 0 || typed[0] > 2 {
+				// other types are the null object (§7.5.8.3)
 				free = append(free, int(i))
 			}
+
+			// without a previous section, such as when the
+			// references were reconstructed, all of them are written;
+			// objects in object streams are written out, as they
+			// cannot be in a cross-reference table
+			if f.prev == 0 && typed[0] == 1 {
+				xrefs[Integer(i)] = typed
+			}
+			if f.prev == 0 && typed[0] == 2 {
+				ref := ObjectReference{ObjectNumber: i}
+				xrefs[Integer(i)] = crossReference{1, uint64(offset - 1), 0}
+				n, err = IndirectObject{ObjectReference: ref, Object: f.Get(ref)}.writeTo(file)
+				if err != nil {
+					return err
+				}
+				offset += n
+
+				n, err = writeLineBreakTo(file)
+				if err != nil {
+					return err
+				}
+				offset += n
+			}
 		case IndirectObject:
-			xrefs[Integer(i)] = crossReference{1, uint(offset - 1), typed.GenerationNumber}
-			n, err = typed.writeTo(file)
+			xrefs[Integer(i)] = crossReference{1, uint64(offset - 1), uint64(typed.GenerationNumber)}
+			n, err = f.prepareForSave(typed).writeTo(file)
 			if err != nil {
 				return err
 			}
@@ -369,7 +642,7 @@ func (f *File) saveUsingXrefTable() error {
 			}
 			offset += n
 		case freeObject:
-			xrefs[Integer(i)] = crossReference{0, 0, uint(typed)}
+			xrefs[Integer(i)] = crossReference{0, 0, uint64(typed)}
 			free = append(free, int(i))
 		default:
 			panic(fmt.Sprintf("unhandled type: %T", typed))
@@ -380,7 +653,7 @@ func (f *File) saveUsingXrefTable() error {
 	free.Sort()
 	for i := 0; i < free.Len()-1; i++ {
 		xref := xrefs[Integer(free[i])]
-		xref[1] = uint(free[i+1])
+		xref[1] = uint64(free[i+1])
 		xrefs[Integer(free[i])] = xref
 	}
 
@@ -461,8 +734,8 @@ func (f *File) saveUsingXrefTable() error {
 	}
 
 	// ID
-	if len(f.ID) != 0 {
-		trailer[Name("ID")] = f.ID
+	if !f.ID.IsZero() {
+		trailer[Name("ID")] = f.ID.array()
 	}
 
 	_, err = trailer.writeTo(file)
@@ -472,6 +745,9 @@ func (f *File) saveUsingXrefTable() error {
 
 	fmt.Fprintf(file, "\nstartxref\n%d\n%%%%EOF", offset-1)
 
+	// the next save will be an update to this one
+	f.prev = Integer(offset - 1)
+
 	return nil
 }
 
@@ -511,12 +787,19 @@ func (f *File) saveUsingXrefStream() error {
 			// no-op, don't need to write unchanged objects to file
 			// however, we do need to handle the free list
 			// xrefs[Integer(i)] = typed
-			if typed[0] == 0 {
+			if typed[0] == 0 || typed[0] > 2 {
+				// other types are the null object (§7.5.8.3)
 				free = append(free, int(i))
 			}
+
+			// without a previous section, such as when the
+			// references were reconstructed, all of them are written
+			if f.prev == 0 && (typed[0] == 1 || typed[0] == 2) {
+				xrefs[Integer(i)] = typed
+			}
 		case IndirectObject:
-			xrefs[Integer(i)] = crossReference{1, uint(offset - 1), typed.GenerationNumber}
-			n, err = typed.writeTo(file)
+			xrefs[Integer(i)] = crossReference{1, uint64(offset - 1), uint64(typed.GenerationNumber)}
+			n, err = f.prepareForSave(typed).writeTo(file)
 			if err != nil {
 				return err
 			}
@@ -528,7 +811,7 @@ func (f *File) saveUsingXrefStream() error {
 			}
 			offset += n
 		case freeObject:
-			xrefs[Integer(i)] = crossReference{0, 0, uint(typed)}
+			xrefs[Integer(i)] = crossReference{0, 0, uint64(typed)}
 			free = append(free, int(i))
 		default:
 			panic(fmt.Sprintf("unhandled type: %T", typed))
@@ -546,7 +829,7 @@ func (f *File) saveUsingXrefStream() error {
 	// add an xref for the xrefstream
 	xrefstreamObjectNumber := uint(maxObjNum + 1)
 	maxObjNum++
-	xref := crossReference{1, uint(offset - 1), 0}
+	xref := crossReference{1, uint64(offset - 1), 0}
 	xrefs[Integer(xrefstreamObjectNumber)] = xref
 	f.objects[xrefstreamObjectNumber] = xref
 
@@ -554,7 +837,7 @@ func (f *File) saveUsingXrefStream() error {
 	free.Sort()
 	for i := 0; i < free.Len()-1; i++ {
 		xref := xrefs[Integer(free[i])]
-		xref[1] = uint(free[i+1])
+		xref[1] = uint64(free[i+1])
 		xrefs[Integer(free[i])] = xref
 	}
 
@@ -602,8 +885,8 @@ func (f *File) saveUsingXrefStream() error {
 	}
 
 	// ID
-	if len(f.ID) != 0 {
-		trailer[Name("ID")] = f.ID
+	if !f.ID.IsZero() {
+		trailer[Name("ID")] = f.ID.array()
 	}
 
 	// Add xrefstream specific things to trailer
@@ -618,7 +901,7 @@ func (f *File) saveUsingXrefStream() error {
 	trailer["Index"] = index
 
 	// layout for the stream (W)
-	maxXref := [3]uint{}
+	maxXref := [3]uint64{}
 	for _, xref := range xrefs {
 		for i := 0; i < len(xref); i++ {
 			if xref[i] > maxXref[i] {
@@ -628,7 +911,7 @@ func (f *File) saveUsingXrefStream() error {
 	}
 	nBytes := [3]int{}
 	for i := range nBytes {
-		nBytes[i] = nBytesForInt(int(maxXref[i]))
+		nBytes[i] = nBytesForInt(maxXref[i])
 	}
 	trailer["W"] = Array{Integer(nBytes[0]), Integer(nBytes[1]), Integer(nBytes[2])}
 
@@ -668,6 +951,9 @@ func (f *File) saveUsingXrefStream() error {
 
 	fmt.Fprintf(file, "\nstartxref\n%d\n%%%%EOF", offset-1)
 
+	// the next save will be an update to this one
+	f.prev = Integer(offset - 1)
+
 	return nil
 }
 
@@ -713,7 +999,9 @@ func (f *File) Free(objectNumber uint) {
 			// generation number of 0
 			f.objects[objectNumber] = freeObject(1)
 		default:
-			panic(typed[0])
+			// other types are the null object (§7.5.8.3),
+			// which is free
+			f.objects[objectNumber] = freeObject(0)
 		}
 	case IndirectObject: // new object
 		f.objects[objectNumber] = freeObject(typed.GenerationNumber + 1)
@@ -724,3 +1012,165 @@ func (f *File) Free(objectNumber uint) {
 		panic(fmt.Sprintf("unhandled type: %T", typed))
 	}
 }
+
+// ObjectState tells how an object number is used in a File.
+type ObjectState int
+
+const (
+	// ObjectFree objects are free in the file's cross-references.
+	ObjectFree ObjectState = iota
+
+	// ObjectInUse objects are stored directly in the file.
+	ObjectInUse
+
+	// ObjectInStream objects are stored in an object stream.
+	// - §7.5.7
+	ObjectInStream
+
+	// ObjectNew objects were added since the file was opened.
+	ObjectNew
+
+	// ObjectFreed objects were freed since the file was opened.
+	ObjectFreed
+)
+
+func (state ObjectState) String() string {
+	switch state {
+	case ObjectFree:
+		return "free"
+	case ObjectInUse:
+		return "in use"
+	case ObjectInStream:
+		return "in object stream"
+	case ObjectNew:
+		return "new"
+	case ObjectFreed:
+		return "freed"
+	}
+	return fmt.Sprintf("ObjectState(%d)", int(state))
+}
+
+// ObjectInfo describes an object number in a File.
+type ObjectInfo struct {
+	// ObjectReference is the object number and its generation number.
+	// For free and freed objects, the generation number is the one
+	// to use when the object number is reused.
+	ObjectReference
+
+	State ObjectState
+
+	// Offset is the byte offset of an ObjectInUse in the file.
+	Offset int64
+
+	// Stream is the object stream containing an ObjectInStream
+	// and Index its index in that stream.
+	Stream ObjectReference
+	Index  int
+}
+
+// Objects returns information about each object number in the
+// File, ordered by object number.
+func (f *File) Objects() []ObjectInfo {
+	numbers := make([]uint, 0, len(f.objects))
+	for number := range f.objects {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	infos := make([]ObjectInfo, 0, len(numbers))
+	for _, number := range numbers {
+		info := ObjectInfo{ObjectReference: ObjectReference{ObjectNumber: number}}
+
+		switch typed := f.objects[number].(type) {
+		case crossReference: // existing object
+			switch typed[0] {
+			case 0: // free entry
+				info.State = ObjectFree
+				info.GenerationNumber = uint(typed[2])
+			case 1: // normal
+				info.State = ObjectInUse
+				info.GenerationNumber = uint(typed[2])
+				info.Offset = int64(typed[1])
+			case 2: // in object stream
+				info.State = ObjectInStream
+				info.Stream = ObjectReference{ObjectNumber: uint(typed[1])}
+				info.Index = int(typed[2])
+			default:
+				// other types are the null object (§7.5.8.3)
+				info.State = ObjectFree
+			}
+		case IndirectObject: // new object
+			info.State = ObjectNew
+			info.GenerationNumber = typed.GenerationNumber
+		case freeObject: // newly freed object
+			info.State = ObjectFreed
+			info.GenerationNumber = uint(typed)
+		default:
+			panic(fmt.Sprintf("unhandled type: %T", typed))
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// Size returns one more than the largest object number in the File,
+// as in the trailer's Size entry.
+// - §7.5.5
+func (f *File) Size() uint {
+	size := f.size
+	for number := range f.objects {
+		if number >= size {
+			size = number + 1
+		}
+	}
+	return size
+}
+
+// keys of cross-reference stream dictionaries
+// that are about the stream instead of the trailer
+// - §7.3.8.2
+// - §7.5.8.2
+var xrefStreamKeys = []Name{
+	"Type", "W", "Index", "Length", "Filter", "DecodeParms",
+	"F", "FFilter", "FDecodeParms", "DL",
+}
+
+// Trailer returns the File's trailer, merged from all of its
+// cross-reference sections. Keys not managed by the File are
+// included as they were read; Size, Root, Encrypt, Info and ID
+// have the File's current values. Prev and XRefStm, which are
+// offsets in the file as it was read, are not included, nor are
+// the keys that describe cross-reference streams.
+// - §7.5.5
+// - §7.5.8.2
+func (f *File) Trailer() Dictionary {
+	trailer := Dictionary{}
+	for name, value := range f.trailer {
+		trailer[name] = value
+	}
+	if f.trailer[Name("Type")] == Name("XRef") {
+		for _, name := range xrefStreamKeys {
+			delete(trailer, name)
+		}
+	}
+	delete(trailer, Name("Prev"))
+	delete(trailer, Name("XRefStm"))
+
+	trailer[Name("Size")] = Integer(f.Size())
+
+	setOrDelete := func(name Name, value Object, isSet bool) {
+		if isSet {
+			trailer[name] = value
+		} else {
+			delete(trailer, name)
+		}
+	}
+	setOrDelete(Name("Root"), f.Root, f.Root != ObjectReference{})
+	setOrDelete(Name("Encrypt"), f.Encrypt, len(f.Encrypt) != 0)
+	setOrDelete(Name("Info"), f.Info, f.Info != ObjectReference{})
+	setOrDelete(Name("ID"), Array{f.ID.Permanent, f.ID.Changing}, !f.ID.IsZero())
+
+	return trailer
+}
@@ -0,0 +1,207 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjects(t *testing.T) {
+	filename, cleanup := createTestFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	added, err := file.Add(Dictionary{Name("Title"): String("added")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	freed, err := file.Add(Integer(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Free(freed.ObjectNumber)
+
+	objects := file.Objects()
+	// 2 is the cross-reference stream
+	states := []ObjectState{ObjectFree, ObjectInUse, ObjectInUse, ObjectNew, ObjectFreed}
+	if len(objects) != len(states) {
+		t.Fatalf("expected %d objects, got %v", len(states), objects)
+	}
+
+	for i, info := range objects {
+		if info.ObjectNumber != uint(i) {
+			t.Errorf("%d: expected object number %d, got %d", i, i, info.ObjectNumber)
+		}
+		if info.State != states[i] {
+			t.Errorf("%d: expected %v, got %v", i, states[i], info.State)
+		}
+	}
+
+	if objects[0].GenerationNumber != 65535 {
+		t.Errorf("expected the free object to have generation 65535, got %d", objects[0].GenerationNumber)
+	}
+	if objects[4].GenerationNumber != 1 {
+		t.Errorf("expected the freed object to have generation 1, got %d", objects[4].GenerationNumber)
+	}
+
+	// the offset is of the object's definition
+	offset := objects[1].Offset
+	if offset <= 0 || string(file.mmap[offset:offset+7]) != "1 0 obj" {
+		t.Errorf("incorrect offset %d for %v", offset, file.Root)
+	}
+
+	if file.Size() != added.ObjectNumber+2 {
+		t.Errorf("expected Size %d, got %d", added.ObjectNumber+2, file.Size())
+	}
+}
+
+func TestObjectsInStream(t *testing.T) {
+	file := &File{objects: map[uint]interface{}{
+		5: crossReference{2, 4, 3},
+	}}
+
+	objects := file.Objects()
+	expected := ObjectInfo{
+		ObjectReference: ObjectReference{ObjectNumber: 5},
+		State:           ObjectInStream,
+		Stream:          ObjectReference{ObjectNumber: 4},
+		Index:           3,
+	}
+	if len(objects) != 1 || objects[0] != expected {
+		t.Errorf("expected %v, got %v", expected, objects)
+	}
+
+	if file.Size() != 6 {
+		t.Errorf("expected Size 6, got %d", file.Size())
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	filename, cleanup := writeSectionsFile(t, []int{-1, 0})
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	file.Info = ObjectReference{ObjectNumber: 3}
+
+	// as in the trailer of a hybrid-reference file
+	file.trailer[Name("XRefStm")] = Integer(100)
+	file.trailer[Name("Custom")] = Name("Kept")
+
+	// the offsets of the sections are not included
+	trailer := file.Trailer()
+	err = compare(trailer, Dictionary{
+		Name("Size"):   Integer(2),
+		Name("Root"):   ObjectReference{ObjectNumber: 1},
+		Name("Info"):   ObjectReference{ObjectNumber: 3},
+		Name("Custom"): Name("Kept"),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the File's trailer is not changed
+	trailer[Name("Custom")] = Name("Changed")
+	if file.Trailer()[Name("Custom")] != Name("Kept") {
+		t.Error("Trailer returned the File's trailer")
+	}
+}
+
+// writes a file whose cross-reference stream has an unmanaged key
+// and an entry for object 3 with type 3, which is the null object
+func writeXrefStreamFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "xrefstream.pdf")
+
+	pdf := &bytes.Buffer{}
+	pdf.WriteString("%PDF-1.7\n")
+	catalog := pdf.Len()
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+	xref := pdf.Len()
+	entries := fmt.Sprintf("00000000 01%04X00 01%04X00 03000000>", catalog, xref)
+	fmt.Fprintf(pdf, "2 0 obj\n<< /Type /XRef /Size 4 /Root 1 0 R /W [1 2 1] /Index [0 4] /Custom /Kept /Filter /ASCIIHexDecode /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(entries), entries)
+	fmt.Fprintf(pdf, "startxref\n%d\n%%%%EOF\n", xref)
+
+	err = ioutil.WriteFile(filename, pdf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestTrailerXrefStream(t *testing.T) {
+	filename, cleanup := writeXrefStreamFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = compare(file.Trailer(), Dictionary{
+		Name("Size"):   Integer(4),
+		Name("Root"):   ObjectReference{ObjectNumber: 1},
+		Name("Custom"): Name("Kept"),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// §7.5.8.3
+func TestUnknownXrefType(t *testing.T) {
+	filename, cleanup := writeXrefStreamFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	null := ObjectReference{ObjectNumber: 3}
+	if _, ok := file.Get(null).(Null); !ok {
+		t.Errorf("expected %v to be null, got %v", null, file.Get(null))
+	}
+
+	objects := file.Objects()
+	if len(objects) != 4 || objects[3] != (ObjectInfo{ObjectReference: null, State: ObjectFree}) {
+		t.Errorf("expected %v to be free, got %v", null, objects)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the object number can be freed and used
+	file.Free(null.ObjectNumber)
+	_, err = file.Add(IndirectObject{ObjectReference: null, Object: Name("Used")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Get(null) != Name("Used") {
+		t.Errorf("expected %v to be used, got %v", null, file.Get(null))
+	}
+}
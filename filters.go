@@ -0,0 +1,540 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Decoding filters wrap an io.Reader of encoded data and encoding
+// filters wrap an io.Writer that receives the encoded data.
+// Closing an encoder writes any buffered data and end of data
+// markers, but does not close the io.Writer it wraps.
+
+func init() {
+	RegisterFilter(Name("ASCIIHexDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newASCIIHexReader(r), nil
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			return &asciiHexWriter{w: w}, nil
+		})
+
+	RegisterFilter(Name("ASCII85Decode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newASCII85Reader(r), nil
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			return newASCII85Writer(w), nil
+		})
+
+	RegisterFilter(Name("FlateDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			decoded, err := newFlateReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return newPredictorReader(decoded, filter.DecodeParms)
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			encoded, err := newFlateWriter(w, filter.Level)
+			if err != nil {
+				return nil, err
+			}
+			return newPredictorChain(encoded, filter.DecodeParms)
+		})
+
+	RegisterFilter(Name("LZWDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			early, err := earlyChange(filter.DecodeParms)
+			if err != nil {
+				return nil, err
+			}
+			return newPredictorReader(newLZWReader(r, early), filter.DecodeParms)
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			early, err := earlyChange(filter.DecodeParms)
+			if err != nil {
+				return nil, err
+			}
+			return newPredictorChain(newLZWWriter(w, early), filter.DecodeParms)
+		})
+
+	RegisterFilter(Name("RunLengthDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newRunLengthReader(r), nil
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			return &runLengthWriter{w: w}, nil
+		})
+
+	// image filters can only be decoded
+	RegisterFilter(Name("CCITTFaxDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newCCITTFaxReader(r, filter.DecodeParms, file)
+		}, nil)
+
+	RegisterFilter(Name("DCTDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newDCTReader(r, file)
+		}, nil)
+
+	RegisterFilter(Name("JBIG2Decode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newJBIG2Reader(r, filter.DecodeParms, file)
+		}, nil)
+
+	RegisterFilter(Name("JPXDecode"),
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			return newJPXReader(r, file)
+		}, nil)
+}
+
+// newPredictorChain applies the predictor before the encoder,
+// closing both
+func newPredictorChain(encoder io.WriteCloser, parameters Dictionary) (io.WriteCloser, error) {
+	predictor, err := newPredictorWriter(encoder, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encoderChain{
+		w:        predictor,
+		encoders: []io.WriteCloser{encoder, predictor},
+	}, nil
+}
+
+// ASCIIHexDecode §7.4.2
+type asciiHexReader struct {
+	r    *bufio.Reader
+	done bool
+}
+
+func newASCIIHexReader(r io.Reader) io.Reader {
+	return &asciiHexReader{r: bufio.NewReader(r)}
+}
+
+func (hr *asciiHexReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && !hr.done {
+		high, err := hr.digit()
+		if err != nil {
+			return n, err
+		}
+		if hr.done {
+			break
+		}
+
+		low, err := hr.digit()
+		if err != nil {
+			return n, err
+		}
+		if hr.done {
+			// a missing final digit is assumed to be 0
+			low = 0
+		}
+
+		p[n] = high<<4 | low
+		n++
+	}
+
+	if n == 0 && hr.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// digit returns the value of the next hex digit, skipping whitespace
+func (hr *asciiHexReader) digit() (byte, error) {
+	for {
+		char, err := hr.r.ReadByte()
+		if err == io.EOF || (err == nil && char == '>') {
+			hr.done = true
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case isWhitespace(char):
+			continue
+		case '0' <= char && char <= '9':
+			return char - '0', nil
+		case 'a' <= char && char <= 'f':
+			return char - 'a' + 10, nil
+		case 'A' <= char && char <= 'F':
+			return char - 'A' + 10, nil
+		}
+		return 0, fmt.Errorf("invalid character %q", char)
+	}
+}
+
+type asciiHexWriter struct {
+	w      io.Writer
+	column int // in bytes of decoded data
+}
+
+func (hw *asciiHexWriter) Write(p []byte) (int, error) {
+	const lineLength = 64 // in bytes of decoded data
+	const digits = "0123456789ABCDEF"
+
+	encoded := make([]byte, 0, 2*len(p)+len(p)/lineLength+1)
+	for _, b := range p {
+		if hw.column == lineLength {
+			encoded = append(encoded, '\n')
+			hw.column = 0
+		}
+		encoded = append(encoded, digits[b>>4], digits[b&0x0f])
+		hw.column++
+	}
+
+	_, err := hw.w.Write(encoded)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (hw *asciiHexWriter) Close() error {
+	_, err := hw.w.Write([]byte{'>'})
+	return err
+}
+
+// ASCII85Decode §7.4.3
+func newASCII85Reader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+
+	// allow the prefix used by PostScript
+	for {
+		next, err := br.Peek(1)
+		if err != nil || !isWhitespace(next[0]) {
+			break
+		}
+		br.ReadByte()
+	}
+	if prefix, _ := br.Peek(2); string(prefix) == "<~" {
+		br.Discard(2)
+	}
+
+	// the end of data marker is optional,
+	// whitespace is ignored by the decoder
+	return ascii85.NewDecoder(&untilReader{r: br, end: '~'})
+}
+
+// untilReader reads from r until the end byte
+type untilReader struct {
+	r    io.Reader
+	end  byte
+	done bool
+}
+
+func (ur *untilReader) Read(p []byte) (int, error) {
+	if ur.done {
+		return 0, io.EOF
+	}
+
+	n, err := ur.r.Read(p)
+	if i := bytes.IndexByte(p[:n], ur.end); i != -1 {
+		ur.done = true
+		return i, nil
+	}
+	return n, err
+}
+
+type ascii85Writer struct {
+	w       io.Writer
+	encoder io.WriteCloser
+}
+
+func newASCII85Writer(w io.Writer) io.WriteCloser {
+	return &ascii85Writer{w: w, encoder: ascii85.NewEncoder(w)}
+}
+
+func (aw *ascii85Writer) Write(p []byte) (int, error) {
+	return aw.encoder.Write(p)
+}
+
+func (aw *ascii85Writer) Close() error {
+	err := aw.encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = aw.w.Write([]byte("~>"))
+	return err
+}
+
+// FlateDecode §7.4.4
+// The data should be in the zlib format (RFC 1950), though raw deflate
+// data (RFC 1951) is also accepted. On errors, the data that could be
+// inflated has already been returned by the reader.
+func newFlateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header, _ := br.Peek(2)
+	if hasZlibHeader(header) {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// checks the compression method and check bits (RFC 1950 §2.2)
+func hasZlibHeader(encoded []byte) bool {
+	if len(encoded) < 2 {
+		return false
+	}
+
+	cmf, flg := uint(encoded[0]), uint(encoded[1])
+	return cmf&0x0f == 8 && (cmf<<8|flg)%31 == 0
+}
+
+func newFlateWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	return zlib.NewWriterLevel(w, level)
+}
+
+// RunLengthDecode §7.4.5
+type runLengthReader struct {
+	r       *bufio.Reader
+	pending []byte // decoded, but not yet read
+	err     error
+}
+
+func newRunLengthReader(r io.Reader) io.Reader {
+	return &runLengthReader{r: bufio.NewReader(r)}
+}
+
+func (rr *runLengthReader) Read(p []byte) (int, error) {
+	for len(rr.pending) == 0 {
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		rr.err = rr.nextRun()
+	}
+
+	n := copy(p, rr.pending)
+	rr.pending = rr.pending[n:]
+	return n, nil
+}
+
+// nextRun decodes the next run into pending
+func (rr *runLengthReader) nextRun() error {
+	length, err := rr.r.ReadByte()
+	if err != nil {
+		// tolerate a missing end of data marker
+		return err
+	}
+
+	switch {
+	case length == 128: // end of data
+		return io.EOF
+	case length < 128: // copy the next length+1 bytes
+		rr.pending = make([]byte, int(length)+1)
+		n, err := io.ReadFull(rr.r, rr.pending)
+		if err != nil {
+			rr.pending = rr.pending[:n]
+			return fmt.Errorf("run extends past the end of the data")
+		}
+	default: // repeat the next byte 257-length times
+		b, err := rr.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("run extends past the end of the data")
+		}
+		rr.pending = bytes.Repeat([]byte{b}, 257-int(length))
+	}
+
+	return nil
+}
+
+type runLengthWriter struct {
+	w       io.Writer
+	buffer  []byte // not yet encoded
+	literal []byte
+}
+
+func (rw *runLengthWriter) Write(p []byte) (int, error) {
+	rw.buffer = append(rw.buffer, p...)
+
+	err := rw.encode(false)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *runLengthWriter) Close() error {
+	return rw.encode(true)
+}
+
+// encode writes the buffered data, keeping back enough data to
+// measure the longest run unless this is the end of the data
+func (rw *runLengthWriter) encode(final bool) error {
+	const maxRun = 128
+
+	encoded := []byte{}
+	flushLiteral := func() {
+		if len(rw.literal) > 0 {
+			encoded = append(encoded, byte(len(rw.literal)-1))
+			encoded = append(encoded, rw.literal...)
+			rw.literal = rw.literal[:0]
+		}
+	}
+
+	buffer := rw.buffer
+	i := 0
+	for i < len(buffer) && (final || len(buffer)-i > maxRun) {
+		// measure the run of identical bytes at i
+		run := 1
+		for i+run < len(buffer) && run < maxRun && buffer[i+run] == buffer[i] {
+			run++
+		}
+
+		if run > 1 {
+			flushLiteral()
+			encoded = append(encoded, byte(257-run), buffer[i])
+			i += run
+			continue
+		}
+
+		rw.literal = append(rw.literal, buffer[i])
+		if len(rw.literal) == maxRun {
+			flushLiteral()
+		}
+		i++
+	}
+	rw.buffer = append(buffer[:0], buffer[i:]...)
+
+	if final {
+		flushLiteral()
+		encoded = append(encoded, 128)
+	}
+
+	_, err := rw.w.Write(encoded)
+	return err
+}
+
+// DCTDecode §7.4.8
+// The JPEG data is decoded to interleaved 8 bit samples:
+// gray, RGB or CMYK depending on the number of components.
+// As JPEG images cannot be decoded incrementally,
+// the whole image is decoded when the reader is created,
+// after checking its size against the file's MaxStreamSize.
+func newDCTReader(r io.Reader, file *File) (io.Reader, error) {
+	encoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if file != nil && file.Limits.MaxStreamSize > 0 {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(encoded))
+		if err != nil {
+			return nil, err
+		}
+
+		components := int64(3)
+		switch config.ColorModel {
+		case color.GrayModel:
+			components = 1
+		case color.CMYKModel:
+			components = 4
+		}
+
+		if int64(config.Width)*int64(config.Height)*components > file.Limits.MaxStreamSize {
+			return nil, &LimitError{Limit: "MaxStreamSize", Max: file.Limits.MaxStreamSize}
+		}
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	switch typed := img.(type) {
+	case *image.Gray:
+		decoded := make([]byte, 0, bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			start := typed.PixOffset(bounds.Min.X, y)
+			decoded = append(decoded, typed.Pix[start:start+bounds.Dx()]...)
+		}
+		return bytes.NewReader(decoded), nil
+	case *image.CMYK:
+		decoded := make([]byte, 0, 4*bounds.Dx()*bounds.Dy())
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			start := typed.PixOffset(bounds.Min.X, y)
+			decoded = append(decoded, typed.Pix[start:start+4*bounds.Dx()]...)
+		}
+		return bytes.NewReader(decoded), nil
+	}
+
+	decoded := make([]byte, 0, 3*bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			decoded = append(decoded, c.R, c.G, c.B)
+		}
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+// CCITTFaxDecode §7.4.6
+// Decodes to 1 bit per pixel rows, where 0 is black unless BlackIs1.
+// Decoding stops at Rows rows, at the end of block pattern or at the
+// end of the data. Uncompressed mode is not supported.
+func newCCITTFaxReader(r io.Reader, parameters Dictionary, file *File) (io.Reader, error) {
+	integer := func(name Name, value int) int {
+		if i, ok := parameters[name].(Integer); ok {
+			return int(i)
+		}
+		return value
+	}
+	boolean := func(name Name) bool {
+		b, _ := parameters[name].(Boolean)
+		return bool(b)
+	}
+
+	columns := integer(Name("Columns"), 1728)
+	if columns < 1 {
+		return nil, fmt.Errorf("invalid Columns: %d", columns)
+	}
+	rows := integer(Name("Rows"), 0)
+
+	// the size of the decoded rows, or of one row when
+	// the number of rows is not known
+	if file != nil && file.Limits.MaxStreamSize > 0 {
+		size := (int64(columns) + 7) / 8
+		if rows > 0 && size > math.MaxInt64/int64(rows) {
+			return nil, &LimitError{Limit: "MaxStreamSize", Max: file.Limits.MaxStreamSize}
+		}
+		if rows > 0 {
+			size *= int64(rows)
+		}
+		if size > file.Limits.MaxStreamSize {
+			return nil, &LimitError{Limit: "MaxStreamSize", Max: file.Limits.MaxStreamSize}
+		}
+	}
+
+	return newCCITTReader(r, ccittParameters{
+		k:                      integer(Name("K"), 0),
+		columns:                columns,
+		rows:                   rows,
+		encodedByteAlign:       boolean(Name("EncodedByteAlign")),
+		endOfLine:              boolean(Name("EndOfLine")),
+		blackIs1:               boolean(Name("BlackIs1")),
+		damagedRowsBeforeError: integer(Name("DamagedRowsBeforeError"), 0),
+	})
+}
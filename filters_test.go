@@ -0,0 +1,263 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// decodes with a single filter
+func decodeFilter(name Name, encoded []byte, parameters Dictionary) ([]byte, error) {
+	r, err := registry[name].decoder(bytes.NewReader(encoded), Filter{Name: name, DecodeParms: parameters}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// encodes with a single filter
+func encodeFilter(decoded []byte, filter Filter) ([]byte, error) {
+	encoded := &bytes.Buffer{}
+	w, err := registry[filter.Name].encoder(encoded, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(decoded)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return encoded.Bytes(), err
+}
+
+func TestDecoders(t *testing.T) {
+	tests := []struct {
+		filter  Name
+		encoded string
+		decoded string
+	}{
+		{"ASCIIHexDecode", "48656C6C6F>", "Hello"},
+		{"ASCIIHexDecode", "48 65 6c\n6c 6f", "Hello"},
+		{"ASCIIHexDecode", "48656C6C6F7>", "Hellop"},
+		{"ASCII85Decode", "87cURDZ~>", "Hello"},
+		{"ASCII85Decode", "87cURDZ", "Hello"},
+		{"ASCII85Decode", "87c\nUR DZ\r\n~>\n", "Hello"},
+		{"ASCII85Decode", "<~87cURDZ~>", "Hello"},
+		{"ASCII85Decode", "z~>", "\x00\x00\x00\x00"},
+		{"RunLengthDecode", "\x04Hello\xfd!\x80", "Hello!!!!"},
+		{"RunLengthDecode", "\x04Hello", "Hello"},
+	}
+
+	for n, test := range tests {
+		decoded, err := decodeFilter(test.filter, []byte(test.encoded), Dictionary{})
+		if err != nil {
+			t.Errorf("%d %s: %v", n, test.filter, err)
+			continue
+		}
+
+		if string(decoded) != test.decoded {
+			t.Errorf("%d %s: expected %q, got %q", n, test.filter, test.decoded, decoded)
+		}
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := [][]byte{
+		[]byte{},
+		[]byte("Hello"),
+		bytes.Repeat([]byte{'a'}, 300),
+		append(bytes.Repeat([]byte{0}, 10), random...),
+		random,
+	}
+
+	for filter, registered := range registry {
+		if registered.encoder == nil {
+			continue
+		}
+
+		for n, input := range inputs {
+			encoded, err := encodeFilter(input, Filter{Name: filter})
+			if err != nil {
+				t.Errorf("%s %d: %v", filter, n, err)
+				continue
+			}
+
+			decoded, err := decodeFilter(filter, encoded, Dictionary{})
+			if err != nil {
+				t.Errorf("%s %d: %v", filter, n, err)
+				continue
+			}
+
+			if !bytes.Equal(decoded, input) {
+				t.Errorf("%s %d: round trip failed\n\t%q\n\t%q", filter, n, input, decoded)
+			}
+		}
+	}
+}
+
+func TestFlateDecode(t *testing.T) {
+	data := bytes.Repeat([]byte("BT /F1 12 Tf (Hello) Tj ET\n"), 100)
+
+	zlibbed := &bytes.Buffer{}
+	zw := zlib.NewWriter(zlibbed)
+	zw.Write(data)
+	zw.Close()
+
+	raw := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(raw, flate.DefaultCompression)
+	fw.Write(data)
+	fw.Close()
+
+	badChecksum := append([]byte{}, zlibbed.Bytes()...)
+	badChecksum[len(badChecksum)-1]++
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		valid   bool
+	}{
+		{"zlib", zlibbed.Bytes(), true},
+		{"raw deflate", raw.Bytes(), true},
+		{"missing checksum", zlibbed.Bytes()[:zlibbed.Len()-4], false},
+		{"bad checksum", badChecksum, false},
+		{"truncated", zlibbed.Bytes()[:zlibbed.Len()/2], false},
+	}
+
+	for _, test := range tests {
+		stream := Stream{
+			Dictionary: Dictionary{Name("Filter"): Name("FlateDecode")},
+			Stream:     test.encoded,
+		}
+
+		decoded, err := stream.Decode()
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("%s: decoded data does not match", test.name)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		// lenient decoding returns what could be decoded
+		lenient := &File{Lenient: true}
+		decoded, err = lenient.Decode(stream)
+		if _, ok := err.(*Warning); !ok {
+			t.Errorf("%s: expected a warning, got %v", test.name, err)
+		}
+		if len(decoded) == 0 || !bytes.HasPrefix(data, decoded) {
+			t.Errorf("%s: expected a prefix of the data, got %d bytes", test.name, len(decoded))
+		}
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	name := Name("XORDecode")
+	var seen *File
+
+	// xor with the key in DecodeParms
+	xor := func(data []byte, filter Filter) []byte {
+		key := byte(filter.DecodeParms[Name("Key")].(Integer))
+		result := make([]byte, len(data))
+		for i := range data {
+			result[i] = data[i] ^ key
+		}
+		return result
+	}
+	RegisterFilter(name,
+		func(r io.Reader, filter Filter, file *File) (io.Reader, error) {
+			seen = file
+			encoded, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(xor(encoded, filter)), nil
+		},
+		func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error) {
+			return &xorWriter{w: w, xor: func(p []byte) []byte { return xor(p, filter) }}, nil
+		})
+	defer func() {
+		registryMutex.Lock()
+		delete(registry, name)
+		registryMutex.Unlock()
+	}()
+
+	data := []byte("Hello")
+	stream, err := NewStream(data,
+		Filter{Name: "ASCIIHexDecode"},
+		Filter{Name: name, DecodeParms: Dictionary{Name("Key"): Integer(42)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{}
+	decoded, err := file.Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected %q, got %q", data, decoded)
+	}
+	if seen != file {
+		t.Errorf("the decoder was not given the file")
+	}
+
+	RegisterFilter(name, nil, nil)
+	_, err = stream.Decode()
+	if err == nil {
+		t.Errorf("expected an error for a filter without a decoder")
+	}
+}
+
+// parameters that cannot be decoded are rejected before decoding
+func TestCCITTFaxParameters(t *testing.T) {
+	data := make([]byte, 16)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	file := &File{Limits: Limits{MaxStreamSize: 1 << 20}}
+	for _, test := range []struct {
+		parameters Dictionary
+		err        string
+	}{
+		{Dictionary{Name("K"): Integer(-1), Name("Columns"): Integer(0)}, "invalid Columns: 0"},
+		{Dictionary{Name("K"): Integer(-1), Name("Columns"): Integer(-8)}, "invalid Columns: -8"},
+		{Dictionary{Name("Columns"): Integer(1 << 24), Name("Rows"): Integer(1)}, "exceeded MaxStreamSize"},
+		{Dictionary{Name("Columns"): Integer(1 << 16), Name("Rows"): Integer(1 << 16)}, "exceeded MaxStreamSize"},
+		{Dictionary{Name("Columns"): Integer(1 << 30), Name("Rows"): Integer(1 << 40)}, "exceeded MaxStreamSize"},
+		{Dictionary{Name("Columns"): Integer(1 << 24)}, "exceeded MaxStreamSize"},
+	} {
+		filter := Filter{Name: Name("CCITTFaxDecode"), DecodeParms: test.parameters}
+		_, err := registry[filter.Name].decoder(bytes.NewReader(data), filter, file)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected an error containing %q, got %v", test.parameters, test.err, err)
+		}
+	}
+}
+
+type xorWriter struct {
+	w   io.Writer
+	xor func([]byte) []byte
+}
+
+func (xw *xorWriter) Write(p []byte) (int, error) {
+	return xw.w.Write(xw.xor(p))
+}
+
+func (xw *xorWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package pdf
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// FileIdentifier holds the two byte strings of the trailer's ID entry.
+// - §14.4
+type FileIdentifier struct {
+	// Permanent is set when the file is first written and never changes.
+	Permanent String
+
+	// Changing is set to a new value each time the file is updated.
+	Changing String
+}
+
+// IsZero reports whether the identifier has not been set.
+func (id FileIdentifier) IsZero() bool {
+	return len(id.Permanent) == 0 && len(id.Changing) == 0
+}
+
+// the ID entry as it is stored in the trailer
+func (id FileIdentifier) array() Array {
+	return Array{hexString(id.Permanent), hexString(id.Changing)}
+}
+
+// hexString serializes a String as a hexadecimal string,
+// which is safe for arbitrary binary data.
+type hexString String
+
+// writeTo serializes the hexString according to the rules in
+// §7.3.4.3
+func (s hexString) writeTo(w io.Writer) (int64, error) {
+	buf := newBuffer()
+
+	buf.Printf("<%x>", []byte(s))
+
+	return buf.WriteTo(w)
+}
+
+// parses the ID entry from a trailer,
+// ids that are not two strings are ignored
+func parseFileIdentifier(obj Object) FileIdentifier {
+	id := FileIdentifier{}
+
+	array, ok := obj.(Array)
+	if !ok || len(array) != 2 {
+		return id
+	}
+
+	permanent, ok := array[0].(String)
+	if !ok {
+		return id
+	}
+
+	changing, ok := array[1].(String)
+	if !ok {
+		return id
+	}
+
+	id.Permanent = permanent
+	id.Changing = changing
+	return id
+}
+
+// newIdentifier computes a new identifier using the method suggested in
+// §14.4: an MD5 hash of the current time, the file's location, its size
+// and the values in the information dictionary.
+func (f *File) newIdentifier() String {
+	h := md5.New()
+
+	fmt.Fprintf(h, "%d", time.Now().UnixNano())
+	io.WriteString(h, f.filename)
+
+	if info, err := os.Stat(f.filename); err == nil {
+		fmt.Fprintf(h, "%d", info.Size())
+	}
+
+	if f.Info.ObjectNumber != 0 {
+		if info, ok := f.Get(f.Info).(Dictionary); ok {
+			info.writeTo(h)
+		}
+	}
+
+	return String(h.Sum(nil))
+}
+
+// updateIdentifier sets the identifier for the next write.
+// When a file is first written both halves are the same,
+// later updates only change the second half.
+func (f *File) updateIdentifier() {
+	if len(f.ID.Permanent) == 0 {
+		id := f.newIdentifier()
+		f.ID = FileIdentifier{Permanent: id, Changing: id}
+		return
+	}
+
+	// first write of a created file
+	if !f.saved {
+		return
+	}
+
+	f.ID.Changing = f.newIdentifier()
+}
@@ -0,0 +1,138 @@
+package pdf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// creates a minimal file in a temporary directory
+// returns the filename and a cleanup function
+func createTestFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.pdf")
+
+	file, err := Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Root, err = file.Add(Dictionary{
+		Name("Type"): Name("Catalog"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+// §14.4
+func TestFileIdentifier(t *testing.T) {
+	filename, cleanup := createTestFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := file.ID
+	if len(created.Permanent) != 16 {
+		t.Fatalf("expected a 16 byte identifier, got %#v", created)
+	}
+	if !bytes.Equal(created.Permanent, created.Changing) {
+		t.Errorf("identifiers of a new file should be equal, got %#v", created)
+	}
+
+	_, err = file.Add(Dictionary{Name("Title"): String("updated")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	updated := file.ID
+	if !bytes.Equal(updated.Permanent, created.Permanent) {
+		t.Errorf("permanent identifier changed from %x to %x", created.Permanent, updated.Permanent)
+	}
+	if bytes.Equal(updated.Changing, created.Changing) {
+		t.Errorf("changing identifier was not updated")
+	}
+}
+
+// the ID is updated when the cross-references were reconstructed
+func TestFileIdentifierReconstructed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "damaged.pdf")
+	pdf := "%PDF-1.7\n" +
+		"1 0 obj\n<< /Type /Catalog >>\nendobj\n" +
+		"trailer\n<< /Root 1 0 R /ID [<00112233> <00112233>] >>\n" +
+		"startxref\n999999\n%%EOF\n"
+	err = ioutil.WriteFile(filename, []byte(pdf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := file.ID
+	if !bytes.Equal(original.Permanent, []byte{0x00, 0x11, 0x22, 0x33}) {
+		t.Fatalf("expected the identifier from the trailer, got %#v", original)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if !bytes.Equal(file.ID.Permanent, original.Permanent) {
+		t.Errorf("permanent identifier changed from %x to %x", original.Permanent, file.ID.Permanent)
+	}
+	if bytes.Equal(file.ID.Changing, original.Changing) {
+		t.Errorf("changing identifier was not updated")
+	}
+}
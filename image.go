@@ -0,0 +1,503 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// filters that produce image data in their own format
+var imageFilters = map[Name]bool{
+	Name("DCTDecode"):      true,
+	Name("JPXDecode"):      true,
+	Name("CCITTFaxDecode"): true,
+	Name("JBIG2Decode"):    true,
+}
+
+// RawImage returns the data of an image XObject (§8.9.5) still encoded
+// with its image filter (e.g., JPEG data for DCTDecode, JPEG 2000 for
+// JPXDecode), along with the name of that filter. Other filters are
+// decoded. When the image does not use an image filter, the decoded
+// samples are returned with an empty Name.
+func (f *File) RawImage(s Stream) ([]byte, Name, error) {
+	filters, err := s.Filters()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(filters) == 0 || !imageFilters[filters[len(filters)-1].Name] {
+		data, err := f.Decode(s)
+		return data, "", err
+	}
+
+	last := filters[len(filters)-1]
+	dict := Dictionary{}
+	for name, value := range s.Dictionary {
+		dict[name] = value
+	}
+	setFilters(dict, filters[:len(filters)-1])
+
+	data, err := f.Decode(Stream{Dictionary: dict, Stream: s.Stream})
+	return data, last.Name, err
+}
+
+// Image decodes an image XObject (§8.9.5) into an image.Image.
+//
+// Supported color spaces are DeviceGray, DeviceRGB, DeviceCMYK,
+// CalGray, CalRGB, ICCBased (using the number of components) and
+// Indexed with one of those as its base. The Decode array, image
+// masks and alpha from SMask or color key masking are applied.
+//
+// JBIG2Decode images are decoded by the built-in decoder, which does
+// not support every JBIG2 coding (see the JBIG2Decode filter).
+// JPXDecode images are decoded by the built-in JPEG 2000 decoder,
+// ignoring BitsPerComponent. Without a ColorSpace, the color space is
+// DeviceGray, DeviceRGB or DeviceCMYK depending on the number of
+// colour channels of the JPEG 2000 data, and the Decode array is
+// ignored. Its opacity channel is used for alpha when SMaskInData is
+// not 0 and there is no SMask, without dividing the colors by it when
+// they are premultiplied.
+func (f *File) Image(s Stream) (image.Image, error) {
+	return f.image(s, true)
+}
+
+// image decodes s, using its SMask for alpha when smask is true.
+// An SMask is itself decoded without its SMask, so that it cannot
+// refer back to the image.
+func (f *File) image(s Stream, smask bool) (image.Image, error) {
+	dict := s.Dictionary
+
+	width, ok := f.resolve(dict[Name("Width")]).(Integer)
+	if !ok || width <= 0 {
+		return nil, fmt.Errorf("invalid Width: %v", dict[Name("Width")])
+	}
+	height, ok := f.resolve(dict[Name("Height")]).(Integer)
+	if !ok || height <= 0 {
+		return nil, fmt.Errorf("invalid Height: %v", dict[Name("Height")])
+	}
+
+	filters, err := s.Filters()
+	if err != nil {
+		return nil, err
+	}
+
+	bpc := 8
+	var jpx *jpxImage
+	if len(filters) > 0 {
+		switch filters[len(filters)-1].Name {
+		case Name("CCITTFaxDecode"):
+			bpc = 1
+		case Name("JBIG2Decode"):
+			bpc = 1
+			if _, ok := lookupDecoder(Name("JBIG2Decode")); !ok {
+				return nil, errors.New("cannot decode images using JBIG2Decode, no decoder is registered")
+			}
+		case Name("JPXDecode"):
+			// the channels of the JPEG 2000 data give the
+			// bits per component and the color space
+			data, _, err := f.RawImage(s)
+			if err != nil {
+				return nil, err
+			}
+			jpx, err = decodeJPX(data, f)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if integer, ok := f.resolve(dict[Name("BitsPerComponent")]).(Integer); ok && jpx == nil {
+		bpc = int(integer)
+	}
+
+	var cs colorSpace
+	imageMask, _ := f.resolve(dict[Name("ImageMask")]).(Boolean)
+	_, hasColorSpace := dict[Name("ColorSpace")]
+	switch {
+	case bool(imageMask):
+		bpc = 1
+		cs = colorSpace{family: Name("DeviceGray"), components: 1}
+	case jpx != nil && !hasColorSpace:
+		colors := len(jpx.channels)
+		if jpx.alpha {
+			colors--
+		}
+		switch colors {
+		case 1:
+			cs = colorSpace{family: Name("DeviceGray"), components: 1}
+		case 3:
+			cs = colorSpace{family: Name("DeviceRGB"), components: 3}
+		case 4:
+			cs = colorSpace{family: Name("DeviceCMYK"), components: 4}
+		default:
+			return nil, fmt.Errorf("no color space for JPEG 2000 data with %d channels", colors)
+		}
+	default:
+		cs, err = f.colorSpace(dict[Name("ColorSpace")])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if jpx != nil {
+		if cs.components > len(jpx.channels) {
+			return nil, fmt.Errorf("expected %d channels in JPEG 2000 data, got %d", cs.components, len(jpx.channels))
+		}
+		bpc = jpx.bitsPerSample(cs.components)
+	}
+
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Errorf("invalid BitsPerComponent: %d", bpc)
+	}
+
+	// default ranges for the samples
+	maxSample := float64(int(1)<<uint(bpc) - 1)
+	decode := make([]float64, 0, 2*cs.components)
+	for i := 0; i < cs.components; i++ {
+		if cs.family == Name("Indexed") {
+			decode = append(decode, 0, maxSample)
+		} else {
+			decode = append(decode, 0, 1)
+		}
+	}
+	if imageMask {
+		// sample values of 0 paint the mask, use black for painted areas
+		decode = []float64{0, 1}
+	}
+	if array, ok := f.resolve(dict[Name("Decode")]).(Array); ok && len(array) == len(decode) && (jpx == nil || hasColorSpace) {
+		for i := range array {
+			decode[i] = number(array[i])
+		}
+	}
+
+	size, err := f.imageSize(int64(width), int64(height), cs.components*bpc)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := int(width), int(height)
+	var samples []byte
+	if jpx != nil {
+		if jpx.width != w || jpx.height != h {
+			return nil, fmt.Errorf("JPEG 2000 image of %d by %d, expected %d by %d", jpx.width, jpx.height, w, h)
+		}
+		samples, _ = jpx.samples(cs.components, cs.family != Name("Indexed"))
+	} else {
+		samples, err = f.Decode(s)
+		if err != nil && !isWarning(err) {
+			return nil, err
+		}
+	}
+
+	rowLength := (w*cs.components*bpc + 7) / 8
+	if len(samples) < size {
+		return nil, fmt.Errorf("expected %d bytes of samples, got %d", size, len(samples))
+	}
+
+	// color key masking uses the unscaled samples
+	var colorKey []int
+	if array, ok := f.resolve(dict[Name("Mask")]).(Array); ok && len(array) == 2*cs.components {
+		for _, value := range array {
+			colorKey = append(colorKey, int(number(value)))
+		}
+	}
+
+	var alpha *image.Gray
+	if mask, ok := f.resolve(dict[Name("SMask")]).(Stream); ok && smask {
+		img, err := f.image(mask, false)
+		if err != nil {
+			return nil, errors.New("SMask: " + err.Error())
+		}
+		alpha = toGray(img, w, h)
+	} else if jpx != nil && smask && len(jpx.channels) > cs.components {
+		if inData, _ := f.resolve(dict[Name("SMaskInData")]).(Integer); inData != 0 {
+			alpha = jpx.opacity()
+		}
+	}
+
+	bounds := image.Rect(0, 0, w, h)
+	var gray *image.Gray
+	var rgba *image.RGBA
+	var cmyk *image.CMYK
+	var nrgba *image.NRGBA
+	switch {
+	case alpha != nil || colorKey != nil:
+		nrgba = image.NewNRGBA(bounds)
+	case cs.output() == 1:
+		gray = image.NewGray(bounds)
+	case cs.output() == 3:
+		rgba = image.NewRGBA(bounds)
+	default:
+		cmyk = image.NewCMYK(bounds)
+	}
+
+	raw := make([]int, cs.components)
+	values := make([]float64, cs.components)
+	for y := 0; y < h; y++ {
+		row := samples[y*rowLength : (y+1)*rowLength]
+		for x := 0; x < w; x++ {
+			masked := colorKey != nil
+			for i := range raw {
+				raw[i] = getSample(row, x*cs.components+i, uint(bpc))
+				if colorKey != nil && (raw[i] < colorKey[2*i] || raw[i] > colorKey[2*i+1]) {
+					masked = false
+				}
+
+				dmin, dmax := decode[2*i], decode[2*i+1]
+				values[i] = dmin + float64(raw[i])*(dmax-dmin)/maxSample
+			}
+
+			c := cs.color(values)
+			switch {
+			case nrgba != nil:
+				r, g, b, _ := c.RGBA()
+				a := uint8(255)
+				if alpha != nil {
+					a = alpha.GrayAt(x, y).Y
+				}
+				if masked {
+					a = 0
+				}
+				nrgba.SetNRGBA(x, y, color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), a})
+			case gray != nil:
+				gray.SetGray(x, y, c.(color.Gray))
+			case rgba != nil:
+				rgba.SetRGBA(x, y, c.(color.RGBA))
+			default:
+				cmyk.SetCMYK(x, y, c.(color.CMYK))
+			}
+		}
+	}
+
+	switch {
+	case nrgba != nil:
+		return nrgba, nil
+	case gray != nil:
+		return gray, nil
+	case rgba != nil:
+		return rgba, nil
+	}
+	return cmyk, nil
+}
+
+// colorSpace describes the color spaces that images can be decoded in
+// - §8.6
+type colorSpace struct {
+	family     Name // DeviceGray, DeviceRGB, DeviceCMYK or Indexed
+	components int  // in the image data
+
+	// for Indexed
+	base   *colorSpace
+	hival  int
+	lookup []byte
+}
+
+// number of components in the decoded color
+func (cs colorSpace) output() int {
+	if cs.base != nil {
+		return cs.base.components
+	}
+	return cs.components
+}
+
+// color converts component values in the range 0 to 1
+// (or the index for Indexed) to a color
+func (cs colorSpace) color(values []float64) color.Color {
+	if cs.base != nil {
+		index := int(math.Floor(values[0] + 0.5))
+		if index < 0 {
+			index = 0
+		}
+		if index > cs.hival {
+			index = cs.hival
+		}
+
+		n := cs.base.components
+		base := make([]float64, n)
+		for i := range base {
+			offset := index*n + i
+			if offset < len(cs.lookup) {
+				base[i] = float64(cs.lookup[offset]) / 255
+			}
+		}
+		return cs.base.color(base)
+	}
+
+	switch cs.components {
+	case 1:
+		return color.Gray{toByte(values[0])}
+	case 3:
+		return color.RGBA{toByte(values[0]), toByte(values[1]), toByte(values[2]), 255}
+	}
+	return color.CMYK{toByte(values[0]), toByte(values[1]), toByte(values[2]), toByte(values[3])}
+}
+
+func toByte(value float64) uint8 {
+	switch {
+	case value <= 0:
+		return 0
+	case value >= 1:
+		return 255
+	}
+	return uint8(value*255 + 0.5)
+}
+
+// imageSize returns the number of bytes of samples in an image
+// of width by height pixels with bits per pixel. It checks that the
+// samples are within the MaxStreamSize of the File, and that the
+// decoded image, with up to 4 bytes per pixel, is within MaxMemory.
+func (f *File) imageSize(width, height int64, bits int) (int, error) {
+	tooLarge := fmt.Errorf("image of %d by %d pixels is too large", width, height)
+	if width > math.MaxInt64/int64(bits) {
+		return 0, tooLarge
+	}
+	rowLength := (width*int64(bits) + 7) / 8
+	if rowLength > math.MaxInt64/height || width > math.MaxInt64/4/height {
+		return 0, tooLarge
+	}
+	size, pixels := rowLength*height, 4*width*height
+
+	if max := f.Limits.MaxStreamSize; max > 0 && size > max {
+		return 0, &LimitError{Limit: "MaxStreamSize", Max: max}
+	}
+	if max := f.Limits.MaxMemory; max > 0 && pixels > max {
+		return 0, &LimitError{Limit: "MaxMemory", Max: max}
+	}
+	if int64(int(pixels)) != pixels {
+		return 0, tooLarge
+	}
+	return int(size), nil
+}
+
+// maximum nesting of color spaces, for an Indexed
+// color space whose base is ICCBased with an Alternate
+const maxColorSpaceDepth = 2
+
+// colorSpace interprets a ColorSpace entry
+func (f *File) colorSpace(obj Object) (colorSpace, error) {
+	return f.nestedColorSpace(obj, maxColorSpaceDepth)
+}
+
+// interprets a ColorSpace entry, which can have depth
+// levels of color spaces nested in it
+func (f *File) nestedColorSpace(obj Object, depth int) (colorSpace, error) {
+	if depth < 0 {
+		return colorSpace{}, errors.New("color spaces are nested too deeply")
+	}
+	obj = f.resolve(obj)
+
+	family, ok := obj.(Name)
+	var array Array
+	if !ok {
+		array, ok = obj.(Array)
+		if !ok || len(array) == 0 {
+			return colorSpace{}, fmt.Errorf("invalid ColorSpace: %v", obj)
+		}
+
+		family, ok = f.resolve(array[0]).(Name)
+		if !ok {
+			return colorSpace{}, fmt.Errorf("invalid ColorSpace family: %v", array[0])
+		}
+	}
+
+	switch family {
+	case Name("DeviceGray"), Name("CalGray"), Name("G"):
+		return colorSpace{family: Name("DeviceGray"), components: 1}, nil
+	case Name("DeviceRGB"), Name("CalRGB"), Name("RGB"):
+		return colorSpace{family: Name("DeviceRGB"), components: 3}, nil
+	case Name("DeviceCMYK"), Name("CMYK"):
+		return colorSpace{family: Name("DeviceCMYK"), components: 4}, nil
+	case Name("ICCBased"):
+		if len(array) < 2 {
+			return colorSpace{}, errors.New("ICCBased color space without a profile")
+		}
+		profile, ok := f.resolve(array[1]).(Stream)
+		if !ok {
+			return colorSpace{}, errors.New("ICCBased profile is not a stream")
+		}
+
+		// use the device color space with the same number of components
+		switch n, _ := f.resolve(profile.Dictionary[Name("N")]).(Integer); n {
+		case 1:
+			return f.colorSpace(Name("DeviceGray"))
+		case 3:
+			return f.colorSpace(Name("DeviceRGB"))
+		case 4:
+			return f.colorSpace(Name("DeviceCMYK"))
+		}
+
+		if alternate, ok := profile.Dictionary[Name("Alternate")]; ok {
+			return f.nestedColorSpace(alternate, depth-1)
+		}
+		return colorSpace{}, fmt.Errorf("invalid N in ICCBased profile: %v", profile.Dictionary[Name("N")])
+	case Name("Indexed"), Name("I"):
+		if len(array) != 4 {
+			return colorSpace{}, fmt.Errorf("invalid Indexed color space: %v", array)
+		}
+
+		base, err := f.nestedColorSpace(array[1], depth-1)
+		if err != nil {
+			return colorSpace{}, err
+		}
+		if base.base != nil {
+			return colorSpace{}, errors.New("the base of an Indexed color space cannot be Indexed")
+		}
+
+		hival, ok := f.resolve(array[2]).(Integer)
+		if !ok {
+			return colorSpace{}, fmt.Errorf("invalid hival: %v", array[2])
+		}
+
+		var lookup []byte
+		switch typed := f.resolve(array[3]).(type) {
+		case String:
+			lookup = typed
+		case Stream:
+			var err error
+			lookup, err = f.Decode(typed)
+			if err != nil && !isWarning(err) {
+				return colorSpace{}, err
+			}
+		default:
+			return colorSpace{}, fmt.Errorf("invalid lookup: %T", typed)
+		}
+
+		return colorSpace{
+			family:     Name("Indexed"),
+			components: 1,
+			base:       &base,
+			hival:      int(hival),
+			lookup:     lookup,
+		}, nil
+	}
+
+	return colorSpace{}, errors.New("unsupported color space: " + string(family))
+}
+
+// toGray converts img to gray, scaled to width by height
+// using the nearest pixels
+func toGray(img image.Image, width, height int) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/width
+			sy := bounds.Min.Y + y*bounds.Dy()/height
+			gray.Set(x, y, color.GrayModel.Convert(img.At(sx, sy)))
+		}
+	}
+	return gray
+}
+
+// number returns the value of an Integer or Real
+func number(obj Object) float64 {
+	switch typed := obj.(type) {
+	case Integer:
+		return float64(typed)
+	case Real:
+		return float64(typed)
+	}
+	return 0
+}
@@ -0,0 +1,278 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func newTestImage(t *testing.T, samples []byte, dict Dictionary, filters ...Filter) Stream {
+	stream, err := NewStream(samples, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Dictionary[Name("Type")] = Name("XObject")
+	stream.Dictionary[Name("Subtype")] = Name("Image")
+	for name, value := range dict {
+		stream.Dictionary[name] = value
+	}
+	return stream
+}
+
+func TestImage(t *testing.T) {
+	file := &File{objects: map[uint]interface{}{}}
+
+	smask, err := file.Add(newTestImage(t, []byte{255, 128, 0, 64}, Dictionary{
+		Name("Width"):            Integer(2),
+		Name("Height"):           Integer(2),
+		Name("ColorSpace"):       Name("DeviceGray"),
+		Name("BitsPerComponent"): Integer(8),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		stream   Stream
+		expected image.Image
+	}{
+		{
+			name: "DeviceRGB",
+			stream: newTestImage(t, []byte{255, 0, 0, 0, 255, 0, 0, 0, 255, 10, 20, 30}, Dictionary{
+				Name("Width"):            Integer(2),
+				Name("Height"):           Integer(2),
+				Name("ColorSpace"):       Name("DeviceRGB"),
+				Name("BitsPerComponent"): Integer(8),
+			}, Filter{Name: "FlateDecode"}),
+			expected: &image.RGBA{
+				Pix:    []byte{255, 0, 0, 255, 0, 255, 0, 255, 0, 0, 255, 255, 10, 20, 30, 255},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 2, 2),
+			},
+		},
+		{
+			name: "DeviceGray with Decode",
+			stream: newTestImage(t, []byte{0x1b}, Dictionary{
+				Name("Width"):            Integer(4),
+				Name("Height"):           Integer(1),
+				Name("ColorSpace"):       Name("DeviceGray"),
+				Name("BitsPerComponent"): Integer(2),
+				Name("Decode"):           Array{Integer(1), Integer(0)},
+			}),
+			expected: &image.Gray{
+				Pix:    []byte{255, 170, 85, 0},
+				Stride: 4,
+				Rect:   image.Rect(0, 0, 4, 1),
+			},
+		},
+		{
+			name: "Indexed",
+			stream: newTestImage(t, []byte{0x40, 0x80}, Dictionary{
+				Name("Width"):            Integer(3),
+				Name("Height"):           Integer(2),
+				Name("ColorSpace"):       Array{Name("Indexed"), Name("DeviceRGB"), Integer(1), String{1, 2, 3, 4, 5, 6}},
+				Name("BitsPerComponent"): Integer(1),
+			}),
+			expected: &image.RGBA{
+				Pix: []byte{
+					1, 2, 3, 255, 4, 5, 6, 255, 1, 2, 3, 255,
+					4, 5, 6, 255, 1, 2, 3, 255, 1, 2, 3, 255,
+				},
+				Stride: 12,
+				Rect:   image.Rect(0, 0, 3, 2),
+			},
+		},
+		{
+			name: "SMask",
+			stream: newTestImage(t, []byte{0, 50, 100, 150}, Dictionary{
+				Name("Width"):            Integer(2),
+				Name("Height"):           Integer(2),
+				Name("ColorSpace"):       Name("DeviceGray"),
+				Name("BitsPerComponent"): Integer(8),
+				Name("SMask"):            smask,
+			}),
+			expected: &image.NRGBA{
+				Pix:    []byte{0, 0, 0, 255, 50, 50, 50, 128, 100, 100, 100, 0, 150, 150, 150, 64},
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 2, 2),
+			},
+		},
+		{
+			name: "ImageMask",
+			stream: newTestImage(t, []byte{0x50}, Dictionary{
+				Name("Width"):     Integer(4),
+				Name("Height"):    Integer(1),
+				Name("ImageMask"): Boolean(true),
+			}),
+			expected: &image.Gray{
+				Pix:    []byte{0, 255, 0, 255},
+				Stride: 4,
+				Rect:   image.Rect(0, 0, 4, 1),
+			},
+		},
+		{
+			// two all white rows in Group 4
+			name: "CCITTFaxDecode",
+			stream: newTestImage(t, []byte{0xc0, 0x04, 0x00, 0x40}, Dictionary{
+				Name("Width"):       Integer(8),
+				Name("Height"):      Integer(2),
+				Name("ColorSpace"):  Name("DeviceGray"),
+				Name("Filter"):      Name("CCITTFaxDecode"),
+				Name("DecodeParms"): Dictionary{Name("K"): Integer(-1), Name("Columns"): Integer(8), Name("Rows"): Integer(2)},
+			}),
+			expected: &image.Gray{
+				Pix:    bytes.Repeat([]byte{255}, 16),
+				Stride: 8,
+				Rect:   image.Rect(0, 0, 8, 2),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		img, err := file.Image(test.stream)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		err = compare(img, test.expected)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+func TestDCTImage(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range gray.Pix {
+		gray.Pix[i] = 200
+	}
+
+	encoded := &bytes.Buffer{}
+	err := jpeg.Encode(encoded, gray, &jpeg.Options{Quality: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := newTestImage(t, encoded.Bytes(), Dictionary{
+		Name("Width"):            Integer(16),
+		Name("Height"):           Integer(16),
+		Name("ColorSpace"):       Name("DeviceGray"),
+		Name("BitsPerComponent"): Integer(8),
+		Name("Filter"):           Name("DCTDecode"),
+	})
+	stream, err = stream.Encode(Filter{Name: "ASCIIHexDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{}
+
+	raw, filter, err := file.RawImage(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter != Name("DCTDecode") || !bytes.Equal(raw, encoded.Bytes()) {
+		t.Errorf("expected the JPEG data unchanged, got %d bytes for %q", len(raw), filter)
+	}
+
+	img, err := file.Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := img.At(5, 5).(color.Gray); c.Y < 198 || c.Y > 202 {
+		t.Errorf("expected a gray of about 200, got %v", c)
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	file := &File{objects: map[uint]interface{}{}}
+
+	// an image that is its own SMask
+	self, err := file.Add(Null{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Add(IndirectObject{
+		ObjectReference: self,
+		Object: newTestImage(t, []byte{0, 255}, Dictionary{
+			Name("Width"):            Integer(2),
+			Name("Height"):           Integer(1),
+			Name("ColorSpace"):       Name("DeviceGray"),
+			Name("BitsPerComponent"): Integer(8),
+			Name("SMask"):            self,
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := file.Image(file.Get(self).(Stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.NRGBA); !ok {
+		t.Errorf("expected an image with alpha, got %T", img)
+	}
+
+	// an ICCBased color space that is its own Alternate
+	iccBased, err := file.Add(Null{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Add(IndirectObject{
+		ObjectReference: iccBased,
+		Object: Array{Name("ICCBased"), Stream{
+			Dictionary: Dictionary{Name("N"): Integer(2), Name("Alternate"): iccBased},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		limits Limits
+		dict   Dictionary
+		err    string
+	}{
+		{
+			name: "Alternate",
+			dict: Dictionary{Name("Width"): Integer(1), Name("Height"): Integer(1), Name("ColorSpace"): iccBased},
+			err:  "nested too deeply",
+		},
+		{
+			name: "overflow",
+			dict: Dictionary{Name("Width"): Integer(1 << 60), Name("Height"): Integer(1), Name("ColorSpace"): Name("DeviceGray")},
+			err:  "too large",
+		},
+		{
+			name: "large",
+			dict: Dictionary{Name("Width"): Integer(1 << 40), Name("Height"): Integer(1 << 22), Name("ColorSpace"): Name("DeviceGray")},
+			err:  "too large",
+		},
+		{
+			name:   "MaxStreamSize",
+			limits: Limits{MaxStreamSize: 100},
+			dict:   Dictionary{Name("Width"): Integer(101), Name("Height"): Integer(1), Name("ColorSpace"): Name("DeviceGray")},
+			err:    "exceeded MaxStreamSize of 100",
+		},
+		{
+			name:   "MaxMemory",
+			limits: Limits{MaxMemory: 1000},
+			dict:   Dictionary{Name("Width"): Integer(1000), Name("Height"): Integer(1), Name("ColorSpace"): Name("DeviceGray"), Name("BitsPerComponent"): Integer(1)},
+			err:    "exceeded MaxMemory of 1000",
+		},
+	} {
+		file.Limits = test.limits
+		_, err := file.Image(newTestImage(t, []byte{0}, test.dict))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,933 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// JBIG2Decode §7.4.7
+// The data is embedded JBIG2 (ITU-T T.88 Annex D.3), whose segments
+// follow those of the JBIG2Globals stream in DecodeParms. Its first
+// page is decoded to 1 bit per pixel rows, where 0 is black as in
+// DeviceGray images. As the segments refer to each other, the whole
+// page is decoded when the reader is created, checking the size of
+// its bitmaps, at 1 bit per pixel, against the file's MaxStreamSize.
+//
+// Generic regions, symbol dictionaries and text regions are
+// decoded when they use arithmetic coding, and generic regions
+// also when they use MMR coding. Huffman coding, refinement,
+// halftone regions and the extended template of T.88 Amendment 2
+// are not supported.
+func newJBIG2Reader(r io.Reader, parameters Dictionary, file *File) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newJBIG2Decoder(file)
+
+	if obj, ok := parameters[Name("JBIG2Globals")]; ok {
+		stream, ok := file.resolve(obj).(Stream)
+		if !ok {
+			return nil, fmt.Errorf("JBIG2Globals is not a stream: %v", obj)
+		}
+
+		var globals []byte
+		if file != nil {
+			globals, err = file.Decode(stream)
+		} else {
+			globals, err = stream.Decode()
+		}
+		if err != nil && !isWarning(err) {
+			return nil, errors.New("JBIG2Globals: " + err.Error())
+		}
+
+		err = d.decode(globals)
+		if err != nil && !isLimit(err) {
+			return nil, errors.New("JBIG2Globals: " + err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = d.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if d.page == nil {
+		return nil, errors.New("JBIG2 data without page information")
+	}
+
+	return bytes.NewReader(d.page.packed()), nil
+}
+
+// jbig2Bitmap has a byte per pixel, 1 for black and 0 for white
+type jbig2Bitmap struct {
+	width, height int
+	pixels        []byte
+}
+
+// at returns the pixel at x, y, pixels outside of the bitmap are 0
+func (b *jbig2Bitmap) at(x, y int) byte {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return 0
+	}
+	return b.pixels[y*b.width+x]
+}
+
+// the combination operators of 7.4.1.5 and 7.4.3.1.1
+const (
+	jbig2Or = iota
+	jbig2And
+	jbig2Xor
+	jbig2Xnor
+	jbig2Replace
+)
+
+// compose combines src into the bitmap with its top left corner
+// at x, y, using the combination operator op
+func (b *jbig2Bitmap) compose(src *jbig2Bitmap, x, y int, op int) {
+	for sy := 0; sy < src.height; sy++ {
+		dy := y + sy
+		if dy < 0 || dy >= b.height {
+			continue
+		}
+		for sx := 0; sx < src.width; sx++ {
+			dx := x + sx
+			if dx < 0 || dx >= b.width {
+				continue
+			}
+
+			s, d := src.pixels[sy*src.width+sx], &b.pixels[dy*b.width+dx]
+			switch op {
+			case jbig2Or:
+				*d |= s
+			case jbig2And:
+				*d &= s
+			case jbig2Xor:
+				*d ^= s
+			case jbig2Xnor:
+				*d = 1 ^ *d ^ s
+			case jbig2Replace:
+				*d = s
+			}
+		}
+	}
+}
+
+// packed returns the rows of the bitmap at 1 bit per pixel,
+// with 0 for black as in PDF images
+func (b *jbig2Bitmap) packed() []byte {
+	stride := (b.width + 7) / 8
+	packed := bytes.Repeat([]byte{0xff}, stride*b.height)
+	for y := 0; y < b.height; y++ {
+		row := packed[y*stride:]
+		for x, pixel := range b.pixels[y*b.width : (y+1)*b.width] {
+			if pixel != 0 {
+				row[x/8] &^= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return packed
+}
+
+// jbig2Segment is a segment header and the segment's data, 7.2
+type jbig2Segment struct {
+	number   uint32
+	kind     int
+	referred []uint32
+	page     uint32
+	data     []byte
+
+	// the data length was unknown and the data ends with a row count
+	unknownLength bool
+}
+
+// the segment types of 7.3 that are decoded
+const (
+	jbig2SymbolDictionary         = 0
+	jbig2ImmediateTextRegion      = 6
+	jbig2ImmediateLosslessText    = 7
+	jbig2ImmediateGenericRegion   = 38
+	jbig2ImmediateLosslessGeneric = 39
+	jbig2PageInformation          = 48
+	jbig2EndOfPage                = 49
+	jbig2EndOfStripe              = 50
+	jbig2EndOfFile                = 51
+	jbig2Profiles                 = 52
+	jbig2Tables                   = 53
+	jbig2Extension                = 62
+)
+
+// the data length of segments whose length is unknown
+const jbig2UnknownLength = 0xffffffff
+
+// readJBIG2Segment reads the segment at the start of data,
+// returning the data after it
+func readJBIG2Segment(data []byte) (*jbig2Segment, []byte, error) {
+	truncated := errors.New("JBIG2 segment header is truncated")
+	if len(data) < 6 {
+		return nil, nil, truncated
+	}
+
+	s := &jbig2Segment{number: binary.BigEndian.Uint32(data)}
+	flags := data[4]
+	s.kind = int(flags & 0x3f)
+	data = data[5:]
+
+	// referred-to segments, 7.2.4
+	count := int(data[0] >> 5)
+	switch count {
+	case 5, 6:
+		return nil, nil, fmt.Errorf("invalid JBIG2 referred-to segment count: %d", count)
+	case 7:
+		if len(data) < 4 {
+			return nil, nil, truncated
+		}
+		long := binary.BigEndian.Uint32(data) & 0x1fffffff
+		retention := (uint64(long) + 8) / 8
+		if uint64(len(data)) < 4+retention {
+			return nil, nil, truncated
+		}
+		count = int(long)
+		data = data[4+retention:]
+	default:
+		data = data[1:]
+	}
+
+	// 7.2.5
+	size := 4
+	switch {
+	case s.number <= 256:
+		size = 1
+	case s.number <= 65536:
+		size = 2
+	}
+	if count > len(data)/size {
+		return nil, nil, truncated
+	}
+	s.referred = make([]uint32, count)
+	for i := range s.referred {
+		switch size {
+		case 1:
+			s.referred[i] = uint32(data[0])
+		case 2:
+			s.referred[i] = uint32(binary.BigEndian.Uint16(data))
+		default:
+			s.referred[i] = binary.BigEndian.Uint32(data)
+		}
+		data = data[size:]
+	}
+
+	// 7.2.6
+	if flags&0x40 != 0 {
+		if len(data) < 4 {
+			return nil, nil, truncated
+		}
+		s.page = binary.BigEndian.Uint32(data)
+		data = data[4:]
+	} else {
+		if len(data) < 1 {
+			return nil, nil, truncated
+		}
+		s.page = uint32(data[0])
+		data = data[1:]
+	}
+
+	// 7.2.7
+	if len(data) < 4 {
+		return nil, nil, truncated
+	}
+	length := binary.BigEndian.Uint32(data)
+	data = data[4:]
+
+	if length == jbig2UnknownLength {
+		end, err := jbig2GenericRegionEnd(s.kind, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.data = data[:end]
+		s.unknownLength = true
+		return s, data[end:], nil
+	}
+
+	if uint64(length) > uint64(len(data)) {
+		return nil, nil, errors.New("JBIG2 segment data is truncated")
+	}
+	s.data = data[:length]
+	return s, data[length:], nil
+}
+
+// jbig2GenericRegionEnd returns the length of the data of an
+// immediate generic region segment whose length is unknown, which
+// ends with a marker followed by the number of rows in the region
+// - 7.2.7
+func jbig2GenericRegionEnd(kind int, data []byte) (int, error) {
+	if kind != jbig2ImmediateGenericRegion {
+		return 0, fmt.Errorf("JBIG2 segment of type %d has an unknown length", kind)
+	}
+
+	region, rest, err := parseJBIG2Region(data)
+	if err != nil || len(rest) < 1 {
+		return 0, errors.New("JBIG2 generic region is truncated")
+	}
+
+	marker := []byte{0xff, 0xac}
+	if rest[0]&1 != 0 {
+		marker = []byte{0x00, 0x00}
+	}
+	for i := len(data) - len(rest); i+6 <= len(data); i++ {
+		if bytes.Equal(data[i:i+2], marker) && int64(binary.BigEndian.Uint32(data[i+2:])) <= region.height {
+			return i + 6, nil
+		}
+	}
+	return 0, errors.New("JBIG2 generic region without an end")
+}
+
+// jbig2Region is a region segment information field, 7.4.1
+type jbig2Region struct {
+	width, height int64
+	x, y          int64
+	op            int
+}
+
+func parseJBIG2Region(data []byte) (jbig2Region, []byte, error) {
+	if len(data) < 17 {
+		return jbig2Region{}, nil, errors.New("JBIG2 region segment information is truncated")
+	}
+
+	region := jbig2Region{
+		width:  int64(binary.BigEndian.Uint32(data)),
+		height: int64(binary.BigEndian.Uint32(data[4:])),
+		x:      int64(int32(binary.BigEndian.Uint32(data[8:]))),
+		y:      int64(int32(binary.BigEndian.Uint32(data[12:]))),
+		op:     int(data[16] & 7),
+	}
+	if region.op > jbig2Replace {
+		return jbig2Region{}, nil, fmt.Errorf("invalid JBIG2 combination operator: %d", region.op)
+	}
+
+	return region, data[17:], nil
+}
+
+// jbig2Decoder decodes the segments of the first page
+type jbig2Decoder struct {
+	page        *jbig2Bitmap
+	pageNumber  uint32
+	pageDefault byte
+	striped     bool // the height of the page is unknown
+	ended       bool
+
+	// exported by the symbol dictionary segments
+	symbols map[uint32][]*jbig2Bitmap
+
+	// the pixels in the bitmaps, and the most allowed
+	pixels    int64
+	maxPixels int64
+	file      *File // for MaxStreamSize, may be nil
+}
+
+func newJBIG2Decoder(file *File) *jbig2Decoder {
+	d := &jbig2Decoder{
+		symbols:   map[uint32][]*jbig2Bitmap{},
+		maxPixels: math.MaxInt64,
+		file:      file,
+	}
+	if file != nil && file.Limits.MaxStreamSize > 0 && file.Limits.MaxStreamSize <= math.MaxInt64/8 {
+		d.maxPixels = 8 * file.Limits.MaxStreamSize
+	}
+	return d
+}
+
+// allocate counts the pixels of a bitmap against the limit
+func (d *jbig2Decoder) allocate(width, height int64) error {
+	if width < 0 || height < 0 {
+		return fmt.Errorf("invalid JBIG2 bitmap size: %d by %d", width, height)
+	}
+	if width != 0 && height > (math.MaxInt64-d.pixels)/width || int64(int(width*height)) != width*height {
+		return fmt.Errorf("JBIG2 bitmap of %d by %d pixels is too large", width, height)
+	}
+	if d.pixels+width*height > d.maxPixels {
+		return &LimitError{Limit: "MaxStreamSize", Max: d.file.Limits.MaxStreamSize}
+	}
+	d.pixels += width * height
+	return nil
+}
+
+// newBitmap returns a bitmap of white pixels
+func (d *jbig2Decoder) newBitmap(width, height int64) (*jbig2Bitmap, error) {
+	err := d.allocate(width, height)
+	if err != nil {
+		return nil, err
+	}
+	return &jbig2Bitmap{
+		width:  int(width),
+		height: int(height),
+		pixels: make([]byte, width*height),
+	}, nil
+}
+
+// decode decodes the segments in data
+func (d *jbig2Decoder) decode(data []byte) error {
+	for len(data) > 0 && !d.ended {
+		s, rest, err := readJBIG2Segment(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+
+		// only the first page is decoded
+		if s.page != 0 && d.page != nil && s.page != d.pageNumber {
+			continue
+		}
+
+		err = d.segment(s)
+		if err != nil && !isLimit(err) {
+			return fmt.Errorf("JBIG2 segment %d: %v", s.number, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segment decodes a segment
+func (d *jbig2Decoder) segment(s *jbig2Segment) error {
+	switch s.kind {
+	case jbig2PageInformation:
+		if d.page != nil {
+			d.ended = true
+			return nil
+		}
+		return d.pageInformation(s)
+	case jbig2EndOfPage, jbig2EndOfFile:
+		d.ended = true
+		return nil
+	case jbig2EndOfStripe:
+		if len(s.data) < 4 || d.page == nil {
+			return errors.New("invalid end of stripe")
+		}
+		if d.striped {
+			return d.grow(int64(binary.BigEndian.Uint32(s.data)) + 1)
+		}
+		return nil
+	case jbig2Profiles, jbig2Tables, jbig2Extension:
+		return nil
+	case jbig2SymbolDictionary:
+		symbols, err := d.symbolDictionary(s)
+		if err != nil {
+			return err
+		}
+		d.symbols[s.number] = symbols
+		return nil
+	}
+
+	if d.page == nil {
+		return errors.New("region before page information")
+	}
+
+	var bitmap *jbig2Bitmap
+	var region jbig2Region
+	var err error
+	switch s.kind {
+	case jbig2ImmediateTextRegion, jbig2ImmediateLosslessText:
+		bitmap, region, err = d.textRegion(s)
+	case jbig2ImmediateGenericRegion, jbig2ImmediateLosslessGeneric:
+		bitmap, region, err = d.genericRegion(s)
+	default:
+		return fmt.Errorf("unsupported segment type %d", s.kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.striped {
+		err = d.grow(region.y + int64(bitmap.height))
+		if err != nil {
+			return err
+		}
+	}
+	d.page.compose(bitmap, int(region.x), int(region.y), region.op)
+	return nil
+}
+
+// pageInformation starts the page, 7.4.8
+func (d *jbig2Decoder) pageInformation(s *jbig2Segment) error {
+	if len(s.data) < 19 {
+		return errors.New("page information is truncated")
+	}
+
+	width := int64(binary.BigEndian.Uint32(s.data))
+	height := int64(binary.BigEndian.Uint32(s.data[4:]))
+	flags := s.data[16]
+	if flags&4 != 0 {
+		d.pageDefault = 1
+	}
+
+	if uint32(height) == jbig2UnknownLength {
+		d.striped = true
+		height = 0
+	}
+
+	page, err := d.newBitmap(width, height)
+	if err != nil {
+		return err
+	}
+	if d.pageDefault != 0 {
+		for i := range page.pixels {
+			page.pixels[i] = 1
+		}
+	}
+
+	d.page = page
+	d.pageNumber = s.page
+	return nil
+}
+
+// grow extends a striped page to height rows
+func (d *jbig2Decoder) grow(height int64) error {
+	if height <= int64(d.page.height) {
+		return nil
+	}
+
+	err := d.allocate(int64(d.page.width), height-int64(d.page.height))
+	if err != nil {
+		return err
+	}
+
+	extra := make([]byte, d.page.width*(int(height)-d.page.height))
+	if d.pageDefault != 0 {
+		for i := range extra {
+			extra[i] = 1
+		}
+	}
+	d.page.pixels = append(d.page.pixels, extra...)
+	d.page.height = int(height)
+	return nil
+}
+
+// jbig2Point is the position of an adaptive template pixel
+// relative to the pixel being decoded
+type jbig2Point struct {
+	x, y int
+}
+
+// readJBIG2Points reads n adaptive template pixels,
+// returning the data after them
+func readJBIG2Points(data []byte, n int) ([]jbig2Point, []byte, error) {
+	if len(data) < 2*n {
+		return nil, nil, errors.New("adaptive template pixels are truncated")
+	}
+	points := make([]jbig2Point, n)
+	for i := range points {
+		points[i] = jbig2Point{x: int(int8(data[2*i])), y: int(int8(data[2*i+1]))}
+	}
+	return points, data[2*n:], nil
+}
+
+// the number of adaptive template pixels of the generic templates
+func jbig2TemplatePoints(template int) int {
+	if template == 0 {
+		return 4
+	}
+	return 1
+}
+
+// genericRegion decodes a generic region segment, 7.4.6
+func (d *jbig2Decoder) genericRegion(s *jbig2Segment) (*jbig2Bitmap, jbig2Region, error) {
+	data := s.data
+	var rows int64
+	if s.unknownLength {
+		// the last 4 bytes are the number of rows, 7.4.6.4
+		rows = int64(binary.BigEndian.Uint32(data[len(data)-4:]))
+		data = data[:len(data)-4]
+	}
+
+	region, data, err := parseJBIG2Region(data)
+	if err != nil {
+		return nil, region, err
+	}
+	if s.unknownLength {
+		region.height = rows
+	}
+
+	if len(data) < 1 {
+		return nil, region, errors.New("generic region is truncated")
+	}
+	flags := data[0]
+	data = data[1:]
+	mmr := flags&1 != 0
+	template := int(flags>>1) & 3
+	tpgdon := flags&8 != 0
+	if flags&0x10 != 0 {
+		return nil, region, errors.New("unsupported extended template")
+	}
+
+	bitmap, err := d.newBitmap(region.width, region.height)
+	if err != nil {
+		return nil, region, err
+	}
+
+	if mmr {
+		err = decodeJBIG2MMR(data, bitmap)
+		return bitmap, region, err
+	}
+
+	at, data, err := readJBIG2Points(data, jbig2TemplatePoints(template))
+	if err != nil {
+		return nil, region, err
+	}
+
+	decodeJBIG2Generic(newMQDecoder(data), newJBIG2GenericContexts(template), bitmap, template, tpgdon, at)
+	return bitmap, region, nil
+}
+
+// decodeJBIG2MMR decodes a generic region with MMR coding, 6.2.6
+func decodeJBIG2MMR(data []byte, bitmap *jbig2Bitmap) error {
+	if bitmap.width == 0 || bitmap.height == 0 {
+		return nil
+	}
+
+	stride := (bitmap.width + 7) / 8
+	rows := make([]byte, stride*bitmap.height)
+	r, err := newCCITTReader(bytes.NewReader(data), ccittParameters{
+		k:        -1,
+		columns:  bitmap.width,
+		rows:     bitmap.height,
+		blackIs1: true,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(r, rows)
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < bitmap.height; y++ {
+		for x := 0; x < bitmap.width; x++ {
+			bitmap.pixels[y*bitmap.width+x] = rows[y*stride+x/8] >> uint(7-x%8) & 1
+		}
+	}
+	return nil
+}
+
+// the pixels of the generic templates of 6.2.5.3 in the order of
+// the bits of their contexts, the adaptive template pixels are nil
+var jbig2GenericTemplates = [4][]*jbig2Point{
+	{
+		{-1, 0}, {-2, 0}, {-3, 0}, {-4, 0}, nil,
+		{2, -1}, {1, -1}, {0, -1}, {-1, -1}, {-2, -1}, nil, nil,
+		{1, -2}, {0, -2}, {-1, -2}, nil,
+	},
+	{
+		{-1, 0}, {-2, 0}, {-3, 0}, nil,
+		{2, -1}, {1, -1}, {0, -1}, {-1, -1}, {-2, -1},
+		{2, -2}, {1, -2}, {0, -2}, {-1, -2},
+	},
+	{
+		{-1, 0}, {-2, 0}, nil,
+		{1, -1}, {0, -1}, {-1, -1}, {-2, -1},
+		{1, -2}, {0, -2}, {-1, -2},
+	},
+	{
+		{-1, 0}, {-2, 0}, {-3, 0}, {-4, 0}, nil,
+		{1, -1}, {0, -1}, {-1, -1}, {-2, -1}, {-3, -1},
+	},
+}
+
+// the contexts used to decode SLTP, 6.2.5.7
+var jbig2TypicalContexts = [4]int{0x9b25, 0x0795, 0x00e5, 0x0195}
+
+func newJBIG2GenericContexts(template int) mqContexts {
+	return make(mqContexts, 1<<uint(len(jbig2GenericTemplates[template])))
+}
+
+// decodeJBIG2Generic decodes the bitmap with the generic region
+// decoding procedure using arithmetic coding, 6.2.5
+func decodeJBIG2Generic(d *mqDecoder, contexts mqContexts, bitmap *jbig2Bitmap, template int, tpgdon bool, at []jbig2Point) {
+	// the template with the adaptive template pixels
+	points := make([]jbig2Point, len(jbig2GenericTemplates[template]))
+	next := 0
+	for i, point := range jbig2GenericTemplates[template] {
+		if point == nil {
+			points[i] = at[next]
+			next++
+		} else {
+			points[i] = *point
+		}
+	}
+
+	typical := 0
+	for y := 0; y < bitmap.height; y++ {
+		if tpgdon {
+			typical ^= d.decode(contexts, jbig2TypicalContexts[template])
+			if typical == 1 {
+				if y > 0 {
+					copy(bitmap.pixels[y*bitmap.width:(y+1)*bitmap.width], bitmap.pixels[(y-1)*bitmap.width:])
+				}
+				continue
+			}
+		}
+
+		for x := 0; x < bitmap.width; x++ {
+			cx := 0
+			for i, point := range points {
+				cx |= int(bitmap.at(x+point.x, y+point.y)) << uint(i)
+			}
+			bitmap.pixels[y*bitmap.width+x] = byte(d.decode(contexts, cx))
+		}
+	}
+}
+
+// inputSymbols returns the symbols exported by the symbol
+// dictionaries that the segment refers to
+func (d *jbig2Decoder) inputSymbols(s *jbig2Segment) []*jbig2Bitmap {
+	symbols := []*jbig2Bitmap{}
+	for _, referred := range s.referred {
+		symbols = append(symbols, d.symbols[referred]...)
+	}
+	return symbols
+}
+
+var errJBIG2Ended = errors.New("arithmetic coded data ended early")
+
+// symbolDictionary decodes a symbol dictionary segment,
+// returning its exported symbols, 6.5 and 7.4.2
+func (d *jbig2Decoder) symbolDictionary(s *jbig2Segment) ([]*jbig2Bitmap, error) {
+	data := s.data
+	if len(data) < 2 {
+		return nil, errors.New("symbol dictionary is truncated")
+	}
+	flags := binary.BigEndian.Uint16(data)
+	data = data[2:]
+	switch {
+	case flags&1 != 0:
+		return nil, errors.New("unsupported Huffman coded symbol dictionary")
+	case flags&2 != 0:
+		return nil, errors.New("unsupported refinement and aggregate coded symbol dictionary")
+	case flags&0x100 != 0:
+		return nil, errors.New("unsupported use of retained bitmap coding contexts")
+	}
+	template := int(flags>>10) & 3
+
+	at, data, err := readJBIG2Points(data, jbig2TemplatePoints(template))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errors.New("symbol dictionary is truncated")
+	}
+	newSymbols := int64(binary.BigEndian.Uint32(data[4:]))
+	data = data[8:]
+
+	input := d.inputSymbols(s)
+	mq := newMQDecoder(data)
+	iadh, iadw, iaex := newMQInteger(), newMQInteger(), newMQInteger()
+	contexts := newJBIG2GenericContexts(template)
+
+	// 6.5.5
+	symbols := []*jbig2Bitmap{}
+	height := int64(0)
+	for int64(len(symbols)) < newSymbols {
+		if mq.ended() {
+			return nil, errJBIG2Ended
+		}
+
+		dh, ok := iadh.decode(mq)
+		height += dh
+		if !ok || height < 0 {
+			return nil, errors.New("invalid symbol height")
+		}
+
+		width := int64(0)
+		for {
+			dw, ok := iadw.decode(mq)
+			if !ok {
+				break
+			}
+			width += dw
+			if width < 0 || int64(len(symbols)) == newSymbols {
+				return nil, errors.New("invalid symbol width")
+			}
+			if mq.ended() {
+				return nil, errJBIG2Ended
+			}
+
+			symbol, err := d.newBitmap(width, height)
+			if err != nil {
+				return nil, err
+			}
+			decodeJBIG2Generic(mq, contexts, symbol, template, false, at)
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	// the exported symbols, 6.5.10
+	all := append(input[:len(input):len(input)], symbols...)
+	exported := []*jbig2Bitmap{}
+	export := false
+	for i := 0; i < len(all); {
+		if mq.ended() {
+			return nil, errJBIG2Ended
+		}
+
+		run, ok := iaex.decode(mq)
+		if !ok || run < 0 || run > int64(len(all)-i) {
+			return nil, errors.New("invalid exported symbols")
+		}
+		if export {
+			exported = append(exported, all[i:i+int(run)]...)
+		}
+		i += int(run)
+		export = !export
+	}
+
+	return exported, nil
+}
+
+// textRegion decodes a text region segment, 6.4 and 7.4.3
+func (d *jbig2Decoder) textRegion(s *jbig2Segment) (*jbig2Bitmap, jbig2Region, error) {
+	region, data, err := parseJBIG2Region(s.data)
+	if err != nil {
+		return nil, region, err
+	}
+	if len(data) < 2 {
+		return nil, region, errors.New("text region is truncated")
+	}
+	flags := binary.BigEndian.Uint16(data)
+	data = data[2:]
+	switch {
+	case flags&1 != 0:
+		return nil, region, errors.New("unsupported Huffman coded text region")
+	case flags&2 != 0:
+		return nil, region, errors.New("unsupported refinement coded text region")
+	}
+	strips := int64(1) << (flags >> 2 & 3)
+	corner := int(flags>>4) & 3
+	transposed := flags&0x40 != 0
+	op := int(flags>>7) & 3
+	offset := int64(flags>>10) & 0x1f
+	if offset >= 16 {
+		offset -= 32
+	}
+
+	if len(data) < 4 {
+		return nil, region, errors.New("text region is truncated")
+	}
+	instances := int64(binary.BigEndian.Uint32(data))
+	data = data[4:]
+
+	symbols := d.inputSymbols(s)
+	length := uint(0)
+	for 1<<length < len(symbols) {
+		length++
+	}
+	if length > 24 {
+		return nil, region, errors.New("too many symbols")
+	}
+
+	bitmap, err := d.newBitmap(region.width, region.height)
+	if err != nil {
+		return nil, region, err
+	}
+	if flags&0x200 != 0 {
+		for i := range bitmap.pixels {
+			bitmap.pixels[i] = 1
+		}
+	}
+
+	mq := newMQDecoder(data)
+	iadt, iafs, iads, iait := newMQInteger(), newMQInteger(), newMQInteger(), newMQInteger()
+	iaid := newMQSymbolID(length)
+
+	// 6.4.5, the reference corners are
+	// 0 bottom left, 1 top left, 2 bottom right and 3 top right
+	right, top := corner&2 != 0, corner&1 != 0
+
+	stripT, ok := iadt.decode(mq)
+	if !ok {
+		return nil, region, errors.New("invalid strip")
+	}
+	stripT *= -strips
+	firstS := int64(0)
+	for placed := int64(0); placed < instances; {
+		dt, ok := iadt.decode(mq)
+		if !ok {
+			return nil, region, errors.New("invalid strip")
+		}
+		stripT += dt * strips
+
+		dfs, ok := iafs.decode(mq)
+		if !ok {
+			return nil, region, errors.New("invalid first symbol instance")
+		}
+		firstS += dfs
+
+		curS := firstS
+		for {
+			if mq.ended() {
+				return nil, region, errJBIG2Ended
+			}
+
+			curT := int64(0)
+			if strips > 1 {
+				curT, ok = iait.decode(mq)
+				if !ok {
+					return nil, region, errors.New("invalid symbol instance")
+				}
+			}
+			t := stripT + curT
+
+			id := iaid.decode(mq)
+			if id >= len(symbols) {
+				return nil, region, fmt.Errorf("invalid symbol ID: %d", id)
+			}
+			symbol := symbols[id]
+			width, height := int64(symbol.width), int64(symbol.height)
+
+			if !transposed && right {
+				curS += width - 1
+			} else if transposed && !top {
+				curS += height - 1
+			}
+
+			x, y := curS, t
+			if transposed {
+				x, y = t, curS
+			}
+			if right {
+				x -= width - 1
+			}
+			if !top {
+				y -= height - 1
+			}
+			if x > math.MaxInt32 || x < math.MinInt32 || y > math.MaxInt32 || y < math.MinInt32 {
+				return nil, region, errors.New("invalid symbol instance position")
+			}
+			bitmap.compose(symbol, int(x), int(y), op)
+
+			if !transposed && !right {
+				curS += width - 1
+			} else if transposed && top {
+				curS += height - 1
+			}
+
+			placed++
+			ds, ok := iads.decode(mq)
+			if !ok || placed == instances {
+				break
+			}
+			curS += ds + offset
+		}
+	}
+
+	return bitmap, region, nil
+}
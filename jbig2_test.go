@@ -0,0 +1,404 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// newJBIG2TestBitmap makes a bitmap from rows where X is black
+func newJBIG2TestBitmap(rows ...string) *jbig2Bitmap {
+	b := &jbig2Bitmap{width: len(rows[0]), height: len(rows)}
+	for _, row := range rows {
+		for _, c := range row {
+			if c == 'X' {
+				b.pixels = append(b.pixels, 1)
+			} else {
+				b.pixels = append(b.pixels, 0)
+			}
+		}
+	}
+	return b
+}
+
+// jbig2TestSegment encodes a segment with a one byte page association
+func jbig2TestSegment(number uint32, kind int, referred []byte, page byte, data []byte) []byte {
+	segment := make([]byte, 4)
+	binary.BigEndian.PutUint32(segment, number)
+	segment = append(segment, byte(kind), byte(len(referred))<<5)
+	segment = append(segment, referred...)
+	segment = append(segment, page, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(segment[len(segment)-4:], uint32(len(data)))
+	return append(segment, data...)
+}
+
+// jbig2TestPage encodes a page information segment
+func jbig2TestPage(width, height uint32, flags byte) []byte {
+	data := make([]byte, 19)
+	binary.BigEndian.PutUint32(data, width)
+	binary.BigEndian.PutUint32(data[4:], height)
+	data[16] = flags
+	return jbig2TestSegment(0, jbig2PageInformation, nil, 1, data)
+}
+
+// jbig2TestRegion encodes a region segment information field
+func jbig2TestRegion(width, height, x, y uint32, op byte) []byte {
+	data := make([]byte, 17)
+	binary.BigEndian.PutUint32(data, width)
+	binary.BigEndian.PutUint32(data[4:], height)
+	binary.BigEndian.PutUint32(data[8:], x)
+	binary.BigEndian.PutUint32(data[12:], y)
+	data[16] = op
+	return data
+}
+
+// encodeJBIG2Generic encodes the bitmap as decodeJBIG2Generic decodes it
+func encodeJBIG2Generic(e *mqEncoder, contexts mqContexts, bitmap *jbig2Bitmap, template int, tpgdon bool, at []jbig2Point) {
+	points := []jbig2Point{}
+	next := 0
+	for _, point := range jbig2GenericTemplates[template] {
+		if point == nil {
+			points = append(points, at[next])
+			next++
+		} else {
+			points = append(points, *point)
+		}
+	}
+
+	typical := 0
+	for y := 0; y < bitmap.height; y++ {
+		if tpgdon {
+			same := 1
+			for x := 0; x < bitmap.width; x++ {
+				if bitmap.at(x, y) != bitmap.at(x, y-1) {
+					same = 0
+				}
+			}
+			e.encode(contexts, jbig2TypicalContexts[template], same^typical)
+			typical = same
+			if same == 1 {
+				continue
+			}
+		}
+
+		for x := 0; x < bitmap.width; x++ {
+			cx := 0
+			for i, point := range points {
+				cx |= int(bitmap.at(x+point.x, y+point.y)) << uint(i)
+			}
+			e.encode(contexts, cx, int(bitmap.at(x, y)))
+		}
+	}
+}
+
+// jbig2TestGeneric encodes an immediate generic region segment
+func jbig2TestGeneric(bitmap *jbig2Bitmap, x, y uint32, template int, tpgdon bool) []byte {
+	at := []jbig2Point{{3, -1}, {-3, -1}, {2, -2}, {-2, -2}}
+	if template != 0 {
+		at = []jbig2Point{{2, -1}}
+	}
+	if template == 1 {
+		at = []jbig2Point{{3, -1}}
+	}
+
+	flags := byte(template << 1)
+	if tpgdon {
+		flags |= 8
+	}
+	data := append(jbig2TestRegion(uint32(bitmap.width), uint32(bitmap.height), x, y, jbig2Or), flags)
+	for _, point := range at {
+		data = append(data, byte(int8(point.x)), byte(int8(point.y)))
+	}
+
+	e := newMQEncoder()
+	encodeJBIG2Generic(e, newJBIG2GenericContexts(template), bitmap, template, tpgdon, at)
+	data = append(data, e.flush()...)
+
+	return jbig2TestSegment(1, jbig2ImmediateGenericRegion, nil, 1, data)
+}
+
+// packs the rows of a test image, where X is black
+func packJBIG2TestRows(rows ...string) []byte {
+	return newJBIG2TestBitmap(rows...).packed()
+}
+
+func decodeJBIG2(t *testing.T, file *File, data []byte, parameters Dictionary) ([]byte, error) {
+	stream, err := NewStream(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Stream = data
+	stream.Dictionary[Name("Filter")] = Name("JBIG2Decode")
+	if parameters != nil {
+		stream.Dictionary[Name("DecodeParms")] = parameters
+	}
+	return file.Decode(stream)
+}
+
+var jbig2TestRows = []string{
+	"....XXXXXXXX....XX..X",
+	"...X........X...XX..X",
+	"...X........X...XX..X",
+	"...X...XX...X.......X",
+	"...X...XX...X.......X",
+	"...X........X..XXXXXX",
+	"....XXXXXXXX........X",
+	"....XXXXXXXX........X",
+	"X...................X",
+}
+
+func init() {
+	// the last row is shorter to test row padding
+	jbig2TestRows[8] += "X"
+}
+
+// §7.4.7
+func TestJBIG2Generic(t *testing.T) {
+	bitmap := newJBIG2TestBitmap(jbig2TestRows...)
+	expected := bitmap.packed()
+
+	for template := 0; template < 4; template++ {
+		for _, tpgdon := range []bool{false, true} {
+			data := jbig2TestPage(uint32(bitmap.width), uint32(bitmap.height), 0)
+			data = append(data, jbig2TestGeneric(bitmap, 0, 0, template, tpgdon)...)
+			data = append(data, jbig2TestSegment(2, jbig2EndOfPage, nil, 1, nil)...)
+
+			decoded, err := decodeJBIG2(t, &File{}, data, nil)
+			if err != nil {
+				t.Errorf("template %d %v: %v", template, tpgdon, err)
+				continue
+			}
+			if !bytes.Equal(decoded, expected) {
+				t.Errorf("template %d %v: expected % x, got % x", template, tpgdon, expected, decoded)
+			}
+		}
+	}
+}
+
+func TestJBIG2Image(t *testing.T) {
+	data := jbig2TestPage(3, 2, 0)
+	data = append(data, jbig2TestGeneric(newJBIG2TestBitmap("X..", ".X."), 0, 0, 0, false)...)
+
+	stream := Stream{Dictionary: Dictionary{
+		Name("Width"):       Integer(3),
+		Name("Height"):      Integer(2),
+		Name("ImageMask"):   Boolean(false),
+		Name("ColorSpace"):  Name("DeviceGray"),
+		Name("Filter"):      Name("JBIG2Decode"),
+		Name("DecodeParms"): Dictionary{},
+	}, Stream: data}
+
+	img, err := (&File{}).Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &image.Gray{
+		Pix:    []byte{0, 255, 255, 255, 0, 255},
+		Stride: 3,
+		Rect:   image.Rect(0, 0, 3, 2),
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			if img.At(x, y).(color.Gray) != expected.At(x, y) {
+				t.Errorf("%d, %d: expected %v, got %v", x, y, expected.At(x, y), img.At(x, y))
+			}
+		}
+	}
+}
+
+// a symbol dictionary in JBIG2Globals used by a text region
+func TestJBIG2Text(t *testing.T) {
+	symbols := []*jbig2Bitmap{
+		newJBIG2TestBitmap(
+			".X.",
+			"X.X",
+			"XXX",
+			"X.X",
+		),
+		newJBIG2TestBitmap(
+			"XX.",
+			"XXX",
+			"X.X",
+			"XX.",
+		),
+		newJBIG2TestBitmap(
+			"X",
+			"X",
+		),
+	}
+
+	// symbol dictionary with template 1, the symbols are in height
+	// classes of 4 and 2 rows and all of them are exported
+	e := newMQEncoder()
+	iadh, iadw, iaex := newMQInteger(), newMQInteger(), newMQInteger()
+	contexts := newJBIG2GenericContexts(1)
+	at := []jbig2Point{{3, -1}}
+	value := func(v int64) *int64 { return &v }
+
+	iadh.encode(e, value(4))
+	iadw.encode(e, value(3))
+	encodeJBIG2Generic(e, contexts, symbols[0], 1, false, at)
+	iadw.encode(e, value(0))
+	encodeJBIG2Generic(e, contexts, symbols[1], 1, false, at)
+	iadw.encode(e, nil)
+	iadh.encode(e, value(-2))
+	iadw.encode(e, value(1))
+	encodeJBIG2Generic(e, contexts, symbols[2], 1, false, at)
+	iadw.encode(e, nil)
+	iaex.encode(e, value(0))
+	iaex.encode(e, value(3))
+
+	dictionary := []byte{0x04, 0x00, 3, 0xff}
+	dictionary = append(dictionary, 0, 0, 0, 3, 0, 0, 0, 3)
+	dictionary = append(dictionary, e.flush()...)
+	globals := jbig2TestSegment(0, jbig2SymbolDictionary, nil, 0, dictionary)
+
+	// text region of 12 by 6 pixels with the reference corner at the
+	// bottom left, a strip of the first two symbols and a strip
+	// of the last one
+	e = newMQEncoder()
+	iadt, iafs, iads, iaid := newMQInteger(), newMQInteger(), newMQInteger(), newMQSymbolID(2)
+	iadt.encode(e, value(0))
+
+	iadt.encode(e, value(3)) // bottom row of the first strip
+	iafs.encode(e, value(1))
+	iaid.encode(e, 0)
+	iads.encode(e, value(3)) // two pixels between the symbols
+	iaid.encode(e, 1)
+	iads.encode(e, nil)
+
+	iadt.encode(e, value(2))
+	iafs.encode(e, value(2))
+	iaid.encode(e, 2)
+	iads.encode(e, nil)
+
+	text := jbig2TestRegion(12, 6, 0, 0, jbig2Or)
+	text = append(text, 0x00, 0x00) // bottom left
+	text = append(text, 0, 0, 0, 3)
+	text = append(text, e.flush()...)
+
+	data := jbig2TestPage(12, 6, 0)
+	data = append(data, jbig2TestSegment(1, jbig2ImmediateTextRegion, []byte{0}, 1, text)...)
+
+	file := &File{objects: map[uint]interface{}{}}
+	ref, err := file.Add(Stream{Dictionary: Dictionary{}, Stream: globals})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeJBIG2(t, file, data, Dictionary{Name("JBIG2Globals"): ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := packJBIG2TestRows(
+		"..X...XX....",
+		".X.X..XXX...",
+		".XXX..X.X...",
+		".X.X..XX....",
+		"...X........",
+		"...X........",
+	)
+	if !bytes.Equal(decoded, expected) {
+		t.Errorf("expected\n%s\ngot\n%s", formatJBIG2TestRows(expected, 12), formatJBIG2TestRows(decoded, 12))
+	}
+}
+
+// formats packed rows for test output
+func formatJBIG2TestRows(packed []byte, width int) string {
+	stride := (width + 7) / 8
+	rows := []string{}
+	for y := 0; y*stride < len(packed); y++ {
+		row := ""
+		for x := 0; x < width; x++ {
+			if packed[y*stride+x/8]&(0x80>>uint(x%8)) == 0 {
+				row += "X"
+			} else {
+				row += "."
+			}
+		}
+		rows = append(rows, row)
+	}
+	return strings.Join(rows, "\n")
+}
+
+// a generic region using MMR coding on a black page, striped pages
+// and generic regions whose data length is unknown
+func TestJBIG2Pages(t *testing.T) {
+	// an MMR coded white region of 8 by 2 pixels, each row is V0
+	mmr := append(jbig2TestRegion(8, 2, 2, 1, jbig2Replace), 1)
+	mmr = append(mmr, 0xc0, 0x04, 0x00, 0x40)
+
+	data := jbig2TestPage(12, 4, 4)
+	data = append(data, jbig2TestSegment(1, jbig2ImmediateGenericRegion, nil, 1, mmr)...)
+	decoded, err := decodeJBIG2(t, &File{}, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := packJBIG2TestRows(
+		"XXXXXXXXXXXX",
+		"XX........XX",
+		"XX........XX",
+		"XXXXXXXXXXXX",
+	)
+	if !bytes.Equal(decoded, expected) {
+		t.Errorf("expected\n%s\ngot\n%s", formatJBIG2TestRows(expected, 12), formatJBIG2TestRows(decoded, 12))
+	}
+
+	// the region has 2 of its 3 rows, in a page of unknown height
+	// that ends after 4 rows
+	bitmap := newJBIG2TestBitmap("X.X", ".X.")
+	generic := jbig2TestGeneric(bitmap, 1, 1, 0, false)
+	header, region := generic[:11], generic[11:]
+	binary.BigEndian.PutUint32(header[7:], jbig2UnknownLength)
+	binary.BigEndian.PutUint32(region[4:], 3)
+	region = append(region, 0, 0, 0, 2)
+
+	data = jbig2TestPage(4, jbig2UnknownLength, 0)
+	data = append(data, header...)
+	data = append(data, region...)
+	data = append(data, jbig2TestSegment(2, jbig2EndOfStripe, nil, 1, []byte{0, 0, 0, 3})...)
+	data = append(data, jbig2TestSegment(3, jbig2EndOfPage, nil, 1, nil)...)
+	// ignored after the end of the page
+	data = append(data, jbig2TestPage(4, 4, 0)...)
+
+	decoded, err = decodeJBIG2(t, &File{}, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = packJBIG2TestRows(
+		"....",
+		".X.X",
+		"..X.",
+		"....",
+	)
+	if !bytes.Equal(decoded, expected) {
+		t.Errorf("expected\n%s\ngot\n%s", formatJBIG2TestRows(expected, 4), formatJBIG2TestRows(decoded, 4))
+	}
+}
+
+func TestJBIG2Errors(t *testing.T) {
+	tests := map[string][]byte{
+		"no page":      jbig2TestSegment(0, jbig2EndOfFile, nil, 0, nil),
+		"truncated":    jbig2TestPage(10, 10, 0)[:20],
+		"unsupported":  jbig2TestSegment(0, 16, nil, 0, []byte{0}),
+		"before page":  jbig2TestGeneric(newJBIG2TestBitmap("X"), 0, 0, 0, false),
+		"page too big": jbig2TestPage(1<<20, 1<<20, 0),
+	}
+
+	for name, data := range tests {
+		_, err := decodeJBIG2(t, &File{Limits: DefaultLimits}, data, nil)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	_, err := decodeJBIG2(t, &File{Limits: DefaultLimits}, tests["page too big"], nil)
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxStreamSize" {
+		t.Errorf("expected MaxStreamSize to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,1822 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// JPXDecode §7.4.9
+// The data is JPEG 2000 (ITU-T T.800), either a JP2 file or a
+// codestream. It is decoded to the interleaved samples of its
+// channels, at 8 bits per sample, or 16 when a channel has more than
+// 8 bits, scaled to those bits. The palette of a JP2 file is applied,
+// and components with fewer samples than the image are scaled to its
+// size. As the codestream describes the whole image, it is decoded
+// when the reader is created, checking the number of samples of its
+// components against the file's MaxStreamSize.
+//
+// A truncated codestream is decoded from the packets it has. Files
+// using the extensions of T.801 are decoded as JP2 files, from their
+// first codestream.
+func newJPXReader(r io.Reader, file *File) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeJPX(data, file)
+	if err != nil {
+		return nil, err
+	}
+	samples, _ := img.samples(len(img.channels), true)
+	return bytes.NewReader(samples), nil
+}
+
+// jpxImage is a decoded JPEG 2000 image
+type jpxImage struct {
+	// the image area of the reference grid
+	x0, y0        int
+	width, height int
+
+	components []*jpxComponent
+
+	// colors followed by opacity when alpha is true
+	channels []jpxChannel
+	alpha    bool
+
+	// the enumerated colour space of a JP2 file, 0 when unknown
+	colorSpace int
+}
+
+// jpxComponent has the samples of a component of the image
+type jpxComponent struct {
+	depth  int
+	signed bool
+
+	// a sample every dx columns and dy rows of the reference grid,
+	// starting with the sample at x0, y0 of the component
+	dx, dy        int
+	x0, y0        int
+	width, height int
+	samples       []int32
+}
+
+// jpxChannel is a component, or the values of a
+// palette column for the samples of a component
+type jpxChannel struct {
+	component int
+	palette   []int32
+	depth     int
+	signed    bool
+}
+
+// value returns the value of the channel at x, y of the image,
+// offset so that it is unsigned
+func (img *jpxImage) value(channel jpxChannel, x, y int) int64 {
+	c := img.components[channel.component]
+	cx := (img.x0+x)/c.dx - c.x0
+	cy := (img.y0+y)/c.dy - c.y0
+	if cx >= c.width {
+		cx = c.width - 1
+	}
+	if cy >= c.height {
+		cy = c.height - 1
+	}
+	v := int64(0)
+	if cx >= 0 && cy >= 0 {
+		v = int64(c.samples[cy*c.width+cx])
+	}
+
+	if channel.palette != nil {
+		if c.signed {
+			v += 1 << uint(c.depth-1)
+		}
+		if v < 0 {
+			v = 0
+		}
+		if v >= int64(len(channel.palette)) {
+			v = int64(len(channel.palette)) - 1
+		}
+		v = int64(channel.palette[v])
+	}
+	if channel.signed {
+		v += 1 << uint(channel.depth-1)
+	}
+	return v
+}
+
+// bitsPerSample returns the bits of the samples of the first n channels
+func (img *jpxImage) bitsPerSample(n int) int {
+	for _, channel := range img.channels[:n] {
+		if channel.depth > 8 {
+			return 16
+		}
+	}
+	return 8
+}
+
+// samples returns the rows of the first n channels interleaved,
+// at bitsPerSample bits. The values are scaled to those bits when
+// scale is true, and limited to them otherwise.
+func (img *jpxImage) samples(n int, scale bool) ([]byte, int) {
+	bpc := img.bitsPerSample(n)
+	high := int64(1)<<uint(bpc) - 1
+
+	samples := make([]byte, 0, img.width*img.height*n*bpc/8)
+	for y := 0; y < img.height; y++ {
+		for x := 0; x < img.width; x++ {
+			for _, channel := range img.channels[:n] {
+				v := img.value(channel, x, y)
+				if scale {
+					channelMax := int64(1)<<uint(channel.depth) - 1
+					v = (2*v*high + channelMax) / (2 * channelMax)
+				}
+				if v > high {
+					v = high
+				}
+				if bpc == 16 {
+					samples = append(samples, byte(v>>8))
+				}
+				samples = append(samples, byte(v))
+			}
+		}
+	}
+	return samples, bpc
+}
+
+// opacity returns the last channel, scaled to 8 bits
+func (img *jpxImage) opacity() *image.Gray {
+	channel := img.channels[len(img.channels)-1]
+	channelMax := int64(1)<<uint(channel.depth) - 1
+	gray := image.NewGray(image.Rect(0, 0, img.width, img.height))
+	for y := 0; y < img.height; y++ {
+		for x := 0; x < img.width; x++ {
+			v := (2*img.value(channel, x, y)*255 + channelMax) / (2 * channelMax)
+			if v > 255 {
+				v = 255
+			}
+			gray.Pix[y*gray.Stride+x] = uint8(v)
+		}
+	}
+	return gray
+}
+
+var jp2Signature = []byte{0, 0, 0, 12, 'j', 'P', ' ', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+// jp2Header has the boxes of the JP2 header box that are used, T.800 I.5.3
+type jp2Header struct {
+	colorSpace  int
+	palette     [][]int32 // the entries of each column
+	depths      []int     // of each palette column, with signed
+	signed      []bool
+	mapping     [][2]int // the component and palette column of each channel
+	definitions [][3]int // the channel, type and association of each channel
+}
+
+// decodeJPX decodes a JP2 file or a codestream
+func decodeJPX(data []byte, file *File) (*jpxImage, error) {
+	var header jp2Header
+	if bytes.HasPrefix(data, jp2Signature) {
+		var err error
+		data, header, err = readJP2(data[len(jp2Signature):])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := readJPXCodestream(data, file)
+	if err != nil {
+		return nil, err
+	}
+	img, err := c.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	img.colorSpace = header.colorSpace
+	err = header.channels(img)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// readJP2Box returns the type and contents of the box at the
+// start of data, and the data after it, T.800 I.4
+func readJP2Box(data []byte) (string, []byte, []byte, error) {
+	if len(data) < 8 {
+		return "", nil, nil, errors.New("invalid JP2 box")
+	}
+	length := uint64(binary.BigEndian.Uint32(data))
+	kind := string(data[4:8])
+	header := uint64(8)
+	switch length {
+	case 0:
+		// the last box
+		length = uint64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return "", nil, nil, errors.New("invalid JP2 box")
+		}
+		length = binary.BigEndian.Uint64(data[8:])
+		header = 16
+	}
+	if length < header || length > uint64(len(data)) {
+		return "", nil, nil, fmt.Errorf("invalid length of JP2 box %q: %d", kind, length)
+	}
+	return kind, data[header:length], data[length:], nil
+}
+
+// readJP2 returns the first codestream of the boxes of a JP2 file,
+// after the signature box, and its header
+func readJP2(data []byte) ([]byte, jp2Header, error) {
+	var header jp2Header
+	for len(data) > 0 {
+		kind, contents, rest, err := readJP2Box(data)
+		if err != nil {
+			return nil, header, err
+		}
+		data = rest
+
+		switch kind {
+		case "jp2h":
+			err = header.read(contents)
+			if err != nil {
+				return nil, header, err
+			}
+		case "jp2c":
+			return contents, header, nil
+		}
+	}
+	return nil, header, errors.New("no JPEG 2000 codestream")
+}
+
+// read reads the boxes of the JP2 header box
+func (h *jp2Header) read(data []byte) error {
+	for len(data) > 0 {
+		kind, contents, rest, err := readJP2Box(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+
+		switch kind {
+		case "colr":
+			// the first is used, when it is enumerated
+			if h.colorSpace == 0 && len(contents) >= 7 && contents[0] == 1 {
+				h.colorSpace = int(binary.BigEndian.Uint32(contents[3:]))
+			}
+		case "pclr":
+			err = h.readPalette(contents)
+		case "cmap":
+			for ; len(contents) >= 4; contents = contents[4:] {
+				column := -1
+				if contents[2] == 1 {
+					column = int(contents[3])
+				}
+				h.mapping = append(h.mapping, [2]int{int(binary.BigEndian.Uint16(contents)), column})
+			}
+		case "cdef":
+			if len(contents) < 2 {
+				return errors.New("invalid JP2 channel definition box")
+			}
+			n := int(binary.BigEndian.Uint16(contents))
+			if len(contents) < 2+6*n {
+				return errors.New("invalid JP2 channel definition box")
+			}
+			for i := 0; i < n; i++ {
+				entry := contents[2+6*i:]
+				h.definitions = append(h.definitions, [3]int{
+					int(binary.BigEndian.Uint16(entry)),
+					int(binary.BigEndian.Uint16(entry[2:])),
+					int(binary.BigEndian.Uint16(entry[4:])),
+				})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPalette reads a palette box, T.800 I.5.3.4
+func (h *jp2Header) readPalette(data []byte) error {
+	invalid := errors.New("invalid JP2 palette box")
+	if len(data) < 3 {
+		return invalid
+	}
+	entries, columns := int(binary.BigEndian.Uint16(data)), int(data[2])
+	data = data[3:]
+	if entries < 1 || columns < 1 || len(data) < columns {
+		return invalid
+	}
+
+	h.palette = make([][]int32, columns)
+	sizes := make([]int, columns)
+	for i := range h.palette {
+		depth := int(data[i]&0x7f) + 1
+		if depth > 16 {
+			return fmt.Errorf("unsupported JP2 palette of %d bits", depth)
+		}
+		h.depths = append(h.depths, depth)
+		h.signed = append(h.signed, data[i]&0x80 != 0)
+		h.palette[i] = make([]int32, entries)
+		sizes[i] = (depth + 7) / 8
+	}
+	data = data[columns:]
+
+	for entry := 0; entry < entries; entry++ {
+		for i, size := range sizes {
+			if len(data) < size {
+				return invalid
+			}
+			v := int32(0)
+			for _, b := range data[:size] {
+				v = v<<8 | int32(b)
+			}
+			if h.signed[i] && v >= 1<<uint(h.depths[i]-1) {
+				v -= 1 << uint(h.depths[i])
+			}
+			h.palette[i][entry] = v
+			data = data[size:]
+		}
+	}
+	return nil
+}
+
+// channels sets the channels of the image, from the components
+// mapped through the palette and ordered by the channel definitions
+func (h *jp2Header) channels(img *jpxImage) error {
+	if h.palette == nil || h.mapping == nil {
+		for i, c := range img.components {
+			img.channels = append(img.channels, jpxChannel{component: i, depth: c.depth, signed: c.signed})
+		}
+	} else {
+		for _, m := range h.mapping {
+			if m[0] >= len(img.components) || m[1] >= len(h.palette) {
+				return errors.New("invalid JP2 component mapping")
+			}
+			c := img.components[m[0]]
+			channel := jpxChannel{component: m[0], depth: c.depth, signed: c.signed}
+			if m[1] >= 0 {
+				channel.palette = h.palette[m[1]]
+				channel.depth, channel.signed = h.depths[m[1]], h.signed[m[1]]
+			}
+			img.channels = append(img.channels, channel)
+		}
+	}
+
+	if h.definitions == nil {
+		return nil
+	}
+
+	// colors in the order of their association, then opacity
+	type definition struct {
+		channel     jpxChannel
+		association int
+	}
+	var colors []definition
+	var alpha *jpxChannel
+	for _, d := range h.definitions {
+		if d[0] >= len(img.channels) {
+			return errors.New("invalid JP2 channel definition")
+		}
+		switch d[1] {
+		case 0:
+			colors = append(colors, definition{img.channels[d[0]], d[2]})
+		case 1, 2:
+			if alpha == nil {
+				alpha = &img.channels[d[0]]
+			}
+		}
+	}
+	// channels for the whole image or no color are after the others
+	order := func(association int) int {
+		if association == 0 || association == 0xffff {
+			return math.MaxInt32
+		}
+		return association
+	}
+	sort.SliceStable(colors, func(i, j int) bool {
+		return order(colors[i].association) < order(colors[j].association)
+	})
+
+	channels := []jpxChannel{}
+	for _, color := range colors {
+		channels = append(channels, color.channel)
+	}
+	if alpha != nil {
+		channels = append(channels, *alpha)
+	}
+	img.channels, img.alpha = channels, alpha != nil
+	return nil
+}
+
+// the markers of a codestream, T.800 A.2
+const (
+	jpxSOC = 0xff4f
+	jpxSIZ = 0xff51
+	jpxCOD = 0xff52
+	jpxCOC = 0xff53
+	jpxQCD = 0xff5c
+	jpxQCC = 0xff5d
+	jpxRGN = 0xff5e
+	jpxPOC = 0xff5f
+	jpxPPM = 0xff60
+	jpxPPT = 0xff61
+	jpxSOT = 0xff90
+	jpxSOP = 0xff91
+	jpxEPH = 0xff92
+	jpxSOD = 0xff93
+	jpxEOC = 0xffd9
+)
+
+// progression orders, T.800 Table A.16
+const (
+	jpxLRCP = iota
+	jpxRLCP
+	jpxRPCL
+	jpxPCRL
+	jpxCPRL
+)
+
+// the headers that set parameters, as those of tiles take precedence
+// over those of the main header, and those of components over those
+// for every component
+const (
+	jpxMainHeader = iota
+	jpxMainHeaderComponent
+	jpxTileHeader
+	jpxTileHeaderComponent
+)
+
+// jpxCodestream is a codestream with the packets of its tiles
+type jpxCodestream struct {
+	// the image area of the reference grid is x0, y0 to x1, y1
+	x0, y0, x1, y1 int
+
+	tileX0, tileY0        int
+	tileWidth, tileHeight int
+	tilesWide, tilesHigh  int
+	components            []jpxComponentSize
+	parameters            jpxParameters
+	tiles                 map[int]*jpxTile
+	ppm                   [][]byte // the packet headers of each tile-part
+}
+
+// jpxComponentSize is the size of a component's samples, from SIZ
+type jpxComponentSize struct {
+	depth  int
+	signed bool
+	dx, dy int
+}
+
+// jpxParameters are those of the main header or of a tile
+type jpxParameters struct {
+	order        int
+	layers       int
+	transform    bool // multiple component transformation
+	sop, eph     bool
+	coding       []jpxCoding
+	quantization []jpxQuantization
+	roiShift     []int
+	changes      []jpxProgressionChange
+}
+
+// jpxCoding is the coding style of a component, from COD or COC
+type jpxCoding struct {
+	source      int
+	levels      int
+	blockWidth  uint // exponents
+	blockHeight uint
+	blockStyle  byte
+	reversible  bool
+	precincts   [][2]uint // the exponents of each resolution
+}
+
+// jpxQuantization is the quantization of a component, from QCD or QCC
+type jpxQuantization struct {
+	source int
+	style  int
+	guard  int
+	steps  [][2]int // the exponent and mantissa of each subband
+}
+
+// jpxProgressionChange is a progression order for the packets in
+// ranges of layers, resolutions and components, from POC
+type jpxProgressionChange struct {
+	resolutionStart, resolutionEnd int
+	componentStart, componentEnd   int
+	layerEnd                       int
+	order                          int
+}
+
+// jpxTile has the parameters and packets of a tile
+type jpxTile struct {
+	x0, y0, x1, y1 int
+	parameters     jpxParameters
+	changed        bool // whether the tile has its own progression changes
+	data           []byte
+	headers        []byte // of the packets, from PPM or PPT
+	separate       bool   // whether the packet headers are separate
+}
+
+var errJPXTruncated = errors.New("truncated JPEG 2000 codestream")
+
+// readJPXMarker returns the marker at the start of data,
+// its segment and the data after it
+func readJPXMarker(data []byte) (int, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errJPXTruncated
+	}
+	marker := int(binary.BigEndian.Uint16(data))
+	if marker>>8 != 0xff {
+		return 0, nil, nil, fmt.Errorf("invalid JPEG 2000 marker: %#x", marker)
+	}
+	switch {
+	case marker == jpxSOC || marker == jpxSOD || marker == jpxEOC || marker == jpxEPH,
+		marker >= 0xff30 && marker <= 0xff3f:
+		return marker, nil, data[2:], nil
+	}
+	if len(data) < 4 {
+		return 0, nil, nil, errJPXTruncated
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < 2 {
+		return 0, nil, nil, fmt.Errorf("invalid length of JPEG 2000 marker %#x: %d", marker, length)
+	}
+	if 2+length > len(data) {
+		return 0, nil, nil, errJPXTruncated
+	}
+	return marker, data[4 : 2+length], data[2+length:], nil
+}
+
+// readJPXCodestream reads the main header and the tile-parts of a
+// codestream, T.800 Annex A
+func readJPXCodestream(data []byte, file *File) (*jpxCodestream, error) {
+	marker, _, data, err := readJPXMarker(data)
+	if err != nil || marker != jpxSOC {
+		return nil, errors.New("not a JPEG 2000 codestream")
+	}
+	c := &jpxCodestream{tiles: map[int]*jpxTile{}}
+
+	var ppm [][]byte
+	coded, quantized := false, false
+	for {
+		marker, segment, rest, err := readJPXMarker(data)
+		if err != nil {
+			return nil, err
+		}
+		if marker == jpxSOT {
+			break
+		}
+		data = rest
+
+		if marker != jpxSIZ && c.components == nil {
+			return nil, errors.New("JPEG 2000 codestream without SIZ")
+		}
+		switch marker {
+		case jpxSIZ:
+			err = c.readSIZ(segment, file)
+		case jpxCOD:
+			err = c.parameters.readCOD(segment, jpxMainHeader)
+			coded = true
+		case jpxQCD:
+			err = c.parameters.readQCD(segment, len(c.components), jpxMainHeader)
+			quantized = true
+		case jpxPPM:
+			if len(segment) < 1 {
+				return nil, errors.New("invalid PPM")
+			}
+			ppm = append(ppm, segment[1:])
+		default:
+			err = c.parameters.read(marker, segment, len(c.components), jpxMainHeaderComponent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !coded || !quantized {
+		return nil, errors.New("JPEG 2000 codestream without COD or QCD")
+	}
+
+	// the packet headers of each tile-part, A.7.4
+	if ppm != nil {
+		headers := bytes.Join(ppm, nil)
+		for len(headers) > 0 {
+			if len(headers) < 4 {
+				return nil, errors.New("invalid PPM")
+			}
+			n := binary.BigEndian.Uint32(headers)
+			if uint64(n) > uint64(len(headers)-4) {
+				return nil, errors.New("invalid PPM")
+			}
+			c.ppm = append(c.ppm, headers[4:4+n])
+			headers = headers[4+n:]
+		}
+	}
+
+	for parts := 0; len(data) > 0; parts++ {
+		data, err = c.readTilePart(data, parts)
+		if err == errJPXTruncated {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readSIZ reads the image and tile size, A.5.1
+func (c *jpxCodestream) readSIZ(data []byte, file *File) error {
+	if c.components != nil {
+		return errors.New("more than one SIZ")
+	}
+	if len(data) < 36 {
+		return errors.New("invalid SIZ")
+	}
+	values := make([]int, 8)
+	for i := range values {
+		v := binary.BigEndian.Uint32(data[2+4*i:])
+		if v > math.MaxInt32 {
+			return fmt.Errorf("JPEG 2000 image of %d by %d is too large", binary.BigEndian.Uint32(data[2:]), binary.BigEndian.Uint32(data[6:]))
+		}
+		values[i] = int(v)
+	}
+	c.x1, c.y1, c.x0, c.y0 = values[0], values[1], values[2], values[3]
+	c.tileWidth, c.tileHeight, c.tileX0, c.tileY0 = values[4], values[5], values[6], values[7]
+	if c.x0 >= c.x1 || c.y0 >= c.y1 || c.tileWidth < 1 || c.tileHeight < 1 ||
+		c.tileX0 > c.x0 || c.tileY0 > c.y0 || c.tileX0+c.tileWidth <= c.x0 || c.tileY0+c.tileHeight <= c.y0 {
+		return errors.New("invalid JPEG 2000 image or tile size")
+	}
+	c.tilesWide = (c.x1 - c.tileX0 + c.tileWidth - 1) / c.tileWidth
+	c.tilesHigh = (c.y1 - c.tileY0 + c.tileHeight - 1) / c.tileHeight
+	if c.tilesWide*c.tilesHigh > 65535 {
+		return fmt.Errorf("invalid number of JPEG 2000 tiles: %d", c.tilesWide*c.tilesHigh)
+	}
+
+	n := int(binary.BigEndian.Uint16(data[34:]))
+	if n < 1 || len(data) < 36+3*n {
+		return errors.New("invalid SIZ")
+	}
+	samples := int64(0)
+	for i := 0; i < n; i++ {
+		size := jpxComponentSize{
+			depth:  int(data[36+3*i]&0x7f) + 1,
+			signed: data[36+3*i]&0x80 != 0,
+			dx:     int(data[37+3*i]),
+			dy:     int(data[38+3*i]),
+		}
+		if size.dx < 1 || size.dy < 1 {
+			return errors.New("invalid component sub-sampling")
+		}
+		if size.depth > 16 {
+			return fmt.Errorf("unsupported JPEG 2000 component of %d bits", size.depth)
+		}
+		c.components = append(c.components, size)
+
+		width := int64(ceilDiv(c.x1, size.dx) - ceilDiv(c.x0, size.dx))
+		height := int64(ceilDiv(c.y1, size.dy) - ceilDiv(c.y0, size.dy))
+		samples += width * height
+	}
+
+	if samples > math.MaxInt32 {
+		return fmt.Errorf("JPEG 2000 image of %d by %d is too large", c.x1-c.x0, c.y1-c.y0)
+	}
+	if file != nil && file.Limits.MaxStreamSize > 0 && samples > file.Limits.MaxStreamSize {
+		return &LimitError{Limit: "MaxStreamSize", Max: file.Limits.MaxStreamSize}
+	}
+
+	c.parameters.coding = make([]jpxCoding, n)
+	c.parameters.quantization = make([]jpxQuantization, n)
+	c.parameters.roiShift = make([]int, n)
+	return nil
+}
+
+// readTilePart reads a tile-part, which is the part-th of the
+// codestream, returning the data after it, A.4.2
+func (c *jpxCodestream) readTilePart(data []byte, part int) ([]byte, error) {
+	start := data
+	marker, segment, data, err := readJPXMarker(data)
+	if err != nil {
+		return nil, err
+	}
+	if marker == jpxEOC {
+		return nil, nil
+	}
+	if marker != jpxSOT || len(segment) < 8 {
+		return nil, fmt.Errorf("expected a JPEG 2000 tile-part, got marker %#x", marker)
+	}
+
+	index := int(binary.BigEndian.Uint16(segment))
+	length := int64(binary.BigEndian.Uint32(segment[2:]))
+	if index >= c.tilesWide*c.tilesHigh {
+		return nil, fmt.Errorf("invalid JPEG 2000 tile: %d", index)
+	}
+
+	t := c.tiles[index]
+	if t == nil {
+		t = c.newTile(index)
+		c.tiles[index] = t
+	}
+
+	for {
+		marker, segment, data, err = readJPXMarker(data)
+		if err != nil {
+			return nil, err
+		}
+		if marker == jpxSOD {
+			break
+		}
+
+		switch marker {
+		case jpxCOD:
+			err = t.parameters.readCOD(segment, jpxTileHeader)
+		case jpxQCD:
+			err = t.parameters.readQCD(segment, len(c.components), jpxTileHeader)
+		case jpxPOC:
+			if !t.changed {
+				t.parameters.changes, t.changed = nil, true
+			}
+			err = t.parameters.read(marker, segment, len(c.components), jpxTileHeaderComponent)
+		case jpxPPT:
+			if len(segment) < 1 {
+				return nil, errors.New("invalid PPT")
+			}
+			t.headers = append(t.headers, segment[1:]...)
+			t.separate = true
+		default:
+			err = t.parameters.read(marker, segment, len(c.components), jpxTileHeaderComponent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the packets are up to the end of the tile-part, whose length
+	// is from its start, or up to the end of the codestream
+	end := len(start)
+	if length != 0 && length < int64(end) {
+		end = int(length)
+	}
+	if end < len(start)-len(data) {
+		return nil, errors.New("invalid length of JPEG 2000 tile-part")
+	}
+	packets := start[len(start)-len(data) : end]
+	if length == 0 && bytes.HasSuffix(packets, []byte{0xff, 0xd9}) {
+		packets = packets[:len(packets)-2]
+	}
+	t.data = append(t.data, packets...)
+
+	if c.ppm != nil {
+		if part >= len(c.ppm) {
+			return nil, errors.New("no PPM packet headers for a JPEG 2000 tile-part")
+		}
+		t.headers = append(t.headers, c.ppm[part]...)
+		t.separate = true
+	}
+	return start[end:], nil
+}
+
+// newTile returns the tile with index, B.3,
+// with the parameters of the main header
+func (c *jpxCodestream) newTile(index int) *jpxTile {
+	p, q := index%c.tilesWide, index/c.tilesWide
+	return &jpxTile{
+		x0:         max(c.tileX0+p*c.tileWidth, c.x0),
+		y0:         max(c.tileY0+q*c.tileHeight, c.y0),
+		x1:         min(c.tileX0+(p+1)*c.tileWidth, c.x1),
+		y1:         min(c.tileY0+(q+1)*c.tileHeight, c.y1),
+		parameters: c.parameters.copy(),
+	}
+}
+
+// copy returns parameters that can be changed by a tile
+func (p jpxParameters) copy() jpxParameters {
+	p.coding = append([]jpxCoding{}, p.coding...)
+	p.quantization = append([]jpxQuantization{}, p.quantization...)
+	p.roiShift = append([]int{}, p.roiShift...)
+	p.changes = append([]jpxProgressionChange{}, p.changes...)
+	return p
+}
+
+// read reads the markers for a component, or those for progression
+// changes, where source is the header they are in
+func (p *jpxParameters) read(marker int, data []byte, components int, source int) error {
+	// component indexes have two bytes when there are more than 256
+	component := func() (int, error) {
+		if components > 256 {
+			if len(data) < 2 {
+				return 0, errJPXTruncated
+			}
+			i := int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+			return i, nil
+		}
+		if len(data) < 1 {
+			return 0, errJPXTruncated
+		}
+		i := int(data[0])
+		data = data[1:]
+		return i, nil
+	}
+
+	switch marker {
+	case jpxCOC:
+		i, err := component()
+		if err != nil || i >= components || len(data) < 1 {
+			return errors.New("invalid COC")
+		}
+		coding, err := readJPXCoding(data[1:], data[0]&1 != 0)
+		if err != nil {
+			return err
+		}
+		if p.coding[i].source <= source {
+			coding.source = source
+			p.coding[i] = coding
+		}
+	case jpxQCC:
+		i, err := component()
+		if err != nil || i >= components {
+			return errors.New("invalid QCC")
+		}
+		quantization, err := readJPXQuantization(data)
+		if err != nil {
+			return err
+		}
+		if p.quantization[i].source <= source {
+			quantization.source = source
+			p.quantization[i] = quantization
+		}
+	case jpxRGN:
+		i, err := component()
+		if err != nil || i >= components || len(data) < 2 || data[0] != 0 {
+			return errors.New("invalid RGN")
+		}
+		p.roiShift[i] = int(data[1])
+	case jpxPOC:
+		for len(data) > 0 {
+			var change jpxProgressionChange
+			if len(data) < 1 {
+				return errors.New("invalid POC")
+			}
+			change.resolutionStart = int(data[0])
+			data = data[1:]
+			var err error
+			change.componentStart, err = component()
+			if err != nil || len(data) < 3 {
+				return errors.New("invalid POC")
+			}
+			change.layerEnd = int(binary.BigEndian.Uint16(data))
+			change.resolutionEnd = int(data[2])
+			data = data[3:]
+			change.componentEnd, err = component()
+			if err != nil || len(data) < 1 {
+				return errors.New("invalid POC")
+			}
+			if change.componentEnd == 0 {
+				change.componentEnd = 256
+			}
+			change.order = int(data[0])
+			data = data[1:]
+			p.changes = append(p.changes, change)
+		}
+	}
+	return nil
+}
+
+// readCOD reads the coding style of every component, A.6.1
+func (p *jpxParameters) readCOD(data []byte, source int) error {
+	if len(data) < 5 {
+		return errors.New("invalid COD")
+	}
+	p.order = int(data[1])
+	p.layers = int(binary.BigEndian.Uint16(data[2:]))
+	p.transform = data[4] == 1
+	p.sop, p.eph = data[0]&2 != 0, data[0]&4 != 0
+	if p.order > jpxCPRL || p.layers < 1 {
+		return errors.New("invalid COD")
+	}
+
+	coding, err := readJPXCoding(data[5:], data[0]&1 != 0)
+	if err != nil {
+		return err
+	}
+	coding.source = source
+	for i := range p.coding {
+		if p.coding[i].source <= source {
+			p.coding[i] = coding
+		}
+	}
+	return nil
+}
+
+// readJPXCoding reads the coding style parameters of COD and COC
+func readJPXCoding(data []byte, precincts bool) (jpxCoding, error) {
+	invalid := errors.New("invalid JPEG 2000 coding style")
+	if len(data) < 5 {
+		return jpxCoding{}, invalid
+	}
+	c := jpxCoding{
+		levels:      int(data[0]),
+		blockWidth:  uint(data[1]) + 2,
+		blockHeight: uint(data[2]) + 2,
+		blockStyle:  data[3],
+		reversible:  data[4] == 1,
+	}
+	if c.levels > 32 || c.blockWidth > 10 || c.blockHeight > 10 || c.blockWidth+c.blockHeight > 12 {
+		return jpxCoding{}, invalid
+	}
+
+	data = data[5:]
+	for r := 0; r <= c.levels; r++ {
+		size := [2]uint{15, 15}
+		if precincts {
+			if len(data) <= r {
+				return jpxCoding{}, invalid
+			}
+			size = [2]uint{uint(data[r] & 0xf), uint(data[r] >> 4)}
+			if r > 0 && (size[0] == 0 || size[1] == 0) {
+				return jpxCoding{}, invalid
+			}
+		}
+		c.precincts = append(c.precincts, size)
+	}
+	return c, nil
+}
+
+// readQCD reads the quantization of every component, A.6.4
+func (p *jpxParameters) readQCD(data []byte, components int, source int) error {
+	quantization, err := readJPXQuantization(data)
+	if err != nil {
+		return err
+	}
+	quantization.source = source
+	for i := range p.quantization {
+		if p.quantization[i].source <= source {
+			p.quantization[i] = quantization
+		}
+	}
+	return nil
+}
+
+// readJPXQuantization reads the parameters of QCD and QCC
+func readJPXQuantization(data []byte) (jpxQuantization, error) {
+	if len(data) < 1 {
+		return jpxQuantization{}, errors.New("invalid JPEG 2000 quantization")
+	}
+	q := jpxQuantization{style: int(data[0] & 0x1f), guard: int(data[0] >> 5)}
+	data = data[1:]
+	switch q.style {
+	case 0:
+		for _, b := range data {
+			q.steps = append(q.steps, [2]int{int(b >> 3), 0})
+		}
+	case 1, 2:
+		for ; len(data) >= 2; data = data[2:] {
+			v := int(binary.BigEndian.Uint16(data))
+			q.steps = append(q.steps, [2]int{v >> 11, v & 0x7ff})
+		}
+	default:
+		return jpxQuantization{}, fmt.Errorf("unsupported JPEG 2000 quantization style: %d", q.style)
+	}
+	if len(q.steps) == 0 {
+		return jpxQuantization{}, errors.New("invalid JPEG 2000 quantization")
+	}
+	return q, nil
+}
+
+// decode decodes the tiles into the components of an image
+func (c *jpxCodestream) decode() (*jpxImage, error) {
+	img := &jpxImage{x0: c.x0, y0: c.y0, width: c.x1 - c.x0, height: c.y1 - c.y0}
+	for _, size := range c.components {
+		x0, y0 := ceilDiv(c.x0, size.dx), ceilDiv(c.y0, size.dy)
+		component := &jpxComponent{
+			depth:  size.depth,
+			signed: size.signed,
+			dx:     size.dx,
+			dy:     size.dy,
+			x0:     x0,
+			y0:     y0,
+			width:  ceilDiv(c.x1, size.dx) - x0,
+			height: ceilDiv(c.y1, size.dy) - y0,
+		}
+		component.samples = make([]int32, component.width*component.height)
+		img.components = append(img.components, component)
+	}
+
+	indexes := make([]int, 0, len(c.tiles))
+	for index := range c.tiles {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		err := c.decodeTile(c.tiles[index], img)
+		if err != nil {
+			return nil, fmt.Errorf("JPEG 2000 tile %d: %v", index, err)
+		}
+	}
+	return img, nil
+}
+
+// subband orientations, where LL is only in the lowest resolution
+const (
+	jpxLL = iota
+	jpxHL
+	jpxLH
+	jpxHH
+)
+
+// jpxTileComponent is a component of a tile, B.3
+type jpxTileComponent struct {
+	x0, y0, x1, y1 int
+	dx, dy         int
+	coding         *jpxCoding
+	resolutions    []*jpxResolution
+}
+
+// jpxResolution is a resolution level of a tile-component, B.5
+type jpxResolution struct {
+	x0, y0, x1, y1 int
+	precinctWidth  uint // exponents
+	precinctHeight uint
+
+	// the precincts are those of a grid from the origin,
+	// starting with the one at precinctX0, precinctY0
+	precinctX0, precinctY0       int
+	precinctsWide, precinctsHigh int
+	bands                        []*jpxBand
+
+	// the layer of the next packet of each precinct
+	layers []int
+}
+
+// jpxBand is a subband of a resolution level, B.5
+type jpxBand struct {
+	orientation    int
+	x0, y0, x1, y1 int
+	precincts      []*jpxPrecinct
+
+	planes int     // Mb, the bit planes of the quantized coefficients
+	step   float64 // the quantization step size
+}
+
+// jpxPrecinct has the code-blocks of a precinct in a subband, B.6 and B.7
+type jpxPrecinct struct {
+	blocksWide int
+	blocks     []*jpxBlock
+	inclusion  *jpxTagTree
+	zeroPlanes *jpxTagTree
+}
+
+// jpxCeil returns a divided by 2^shift, rounded up
+func jpxCeil(a int, shift uint) int {
+	return -(-a >> shift)
+}
+
+// ceilDiv returns a divided by b, rounded up, for positive b
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// tileComponent returns the structure of component i of the tile
+func (c *jpxCodestream) tileComponent(t *jpxTile, i int) (*jpxTileComponent, error) {
+	size := c.components[i]
+	coding := &t.parameters.coding[i]
+	quantization := &t.parameters.quantization[i]
+	tc := &jpxTileComponent{
+		x0:     ceilDiv(t.x0, size.dx),
+		y0:     ceilDiv(t.y0, size.dy),
+		x1:     ceilDiv(t.x1, size.dx),
+		y1:     ceilDiv(t.y1, size.dy),
+		dx:     size.dx,
+		dy:     size.dy,
+		coding: coding,
+	}
+
+	levels := coding.levels
+	for r := 0; r <= levels; r++ {
+		shift := uint(levels - r)
+		res := &jpxResolution{
+			x0:             jpxCeil(tc.x0, shift),
+			y0:             jpxCeil(tc.y0, shift),
+			x1:             jpxCeil(tc.x1, shift),
+			y1:             jpxCeil(tc.y1, shift),
+			precinctWidth:  coding.precincts[r][0],
+			precinctHeight: coding.precincts[r][1],
+		}
+		res.precinctX0 = res.x0 >> res.precinctWidth
+		res.precinctY0 = res.y0 >> res.precinctHeight
+		if res.x1 > res.x0 && res.y1 > res.y0 {
+			res.precinctsWide = jpxCeil(res.x1, res.precinctWidth) - res.precinctX0
+			res.precinctsHigh = jpxCeil(res.y1, res.precinctHeight) - res.precinctY0
+		}
+		res.layers = make([]int, res.precinctsWide*res.precinctsHigh)
+
+		// the subbands of the decomposition level n, B.5
+		n := uint(levels - r + 1)
+		orientations := []int{jpxHL, jpxLH, jpxHH}
+		if r == 0 {
+			n = uint(levels)
+			orientations = []int{jpxLL}
+		}
+		for _, orientation := range orientations {
+			xo, yo := orientation&1, orientation>>1
+			band := &jpxBand{orientation: orientation}
+			if r == 0 {
+				band.x0, band.y0, band.x1, band.y1 = res.x0, res.y0, res.x1, res.y1
+			} else {
+				band.x0 = jpxCeil(tc.x0-xo<<(n-1), n)
+				band.y0 = jpxCeil(tc.y0-yo<<(n-1), n)
+				band.x1 = jpxCeil(tc.x1-xo<<(n-1), n)
+				band.y1 = jpxCeil(tc.y1-yo<<(n-1), n)
+			}
+
+			err := band.quantize(quantization, coding, size.depth, r, int(n), levels)
+			if err != nil {
+				return nil, err
+			}
+			band.partition(res, coding, r)
+			res.bands = append(res.bands, band)
+		}
+		tc.resolutions = append(tc.resolutions, res)
+	}
+	return tc, nil
+}
+
+// quantize sets the bit planes and step size of a subband of
+// resolution r at decomposition level n, E.1
+func (band *jpxBand) quantize(q *jpxQuantization, coding *jpxCoding, depth, r, n, levels int) error {
+	index := 0
+	if r > 0 {
+		index = 3*(r-1) + band.orientation
+	}
+
+	var exponent, mantissa int
+	switch {
+	case q.style == 1:
+		// derived from the values for LL
+		exponent, mantissa = q.steps[0][0]-levels+n, q.steps[0][1]
+	case index < len(q.steps):
+		exponent, mantissa = q.steps[index][0], q.steps[index][1]
+	default:
+		return errors.New("missing JPEG 2000 quantization step size")
+	}
+
+	band.planes = q.guard + exponent - 1
+	band.step = 1
+	if q.style != 0 {
+		// the gain of the subband, Table E.1
+		gain := []int{0, 1, 1, 2}[band.orientation]
+		band.step = math.Ldexp(1+float64(mantissa)/2048, depth+gain-exponent)
+	}
+	return nil
+}
+
+// partition divides the subband of resolution r
+// into precincts and code-blocks, B.6 and B.7
+func (band *jpxBand) partition(res *jpxResolution, coding *jpxCoding, r int) {
+	// the size of precincts and code-blocks in the subband
+	pw, ph := res.precinctWidth, res.precinctHeight
+	if r > 0 {
+		pw, ph = pw-1, ph-1
+	}
+	bw, bh := coding.blockWidth, coding.blockHeight
+	if bw > pw {
+		bw = pw
+	}
+	if bh > ph {
+		bh = ph
+	}
+
+	for k := 0; k < res.precinctsWide*res.precinctsHigh; k++ {
+		px0 := (res.precinctX0 + k%res.precinctsWide) << pw
+		py0 := (res.precinctY0 + k/res.precinctsWide) << ph
+		px1, py1 := max(px0+1<<pw, band.x0), max(py0+1<<ph, band.y0)
+		px0, py0 = max(px0, band.x0), max(py0, band.y0)
+		px1, py1 = min(px1, band.x1), min(py1, band.y1)
+
+		precinct := &jpxPrecinct{}
+		band.precincts = append(band.precincts, precinct)
+		if px0 >= px1 || py0 >= py1 {
+			continue
+		}
+
+		bx0, by0 := px0>>bw, py0>>bh
+		bx1, by1 := jpxCeil(px1, bw), jpxCeil(py1, bh)
+		precinct.blocksWide = bx1 - bx0
+		for by := by0; by < by1; by++ {
+			for bx := bx0; bx < bx1; bx++ {
+				precinct.blocks = append(precinct.blocks, &jpxBlock{
+					x0: max(bx<<bw, px0),
+					y0: max(by<<bh, py0),
+					x1: min((bx+1)<<bw, px1),
+					y1: min((by+1)<<bh, py1),
+				})
+			}
+		}
+		precinct.inclusion = newJPXTagTree(bx1-bx0, by1-by0)
+		precinct.zeroPlanes = newJPXTagTree(bx1-bx0, by1-by0)
+	}
+}
+
+// jpxTileDecoder reads the packets of a tile
+type jpxTileDecoder struct {
+	t          *jpxTile
+	components []*jpxTileComponent
+	data       []byte // the packet data, after the headers unless separate
+	headers    *jpxBits
+}
+
+// decodeTile decodes a tile into the components of the image
+func (c *jpxCodestream) decodeTile(t *jpxTile, img *jpxImage) error {
+	d := &jpxTileDecoder{t: t, data: t.data}
+	if t.separate {
+		d.headers = &jpxBits{data: t.headers}
+	}
+	for i := range c.components {
+		tc, err := c.tileComponent(t, i)
+		if err != nil {
+			return err
+		}
+		d.components = append(d.components, tc)
+	}
+
+	err := d.packets()
+	if err != nil && err != errJPXTruncated {
+		return err
+	}
+
+	samples := make([][]float32, len(d.components))
+	blocks := &jpxBlockDecoder{}
+	for i, tc := range d.components {
+		samples[i], err = tc.decode(blocks, t.parameters.roiShift[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// the multiple component transformation, G.2 and G.3
+	if t.parameters.transform && len(samples) >= 3 &&
+		len(samples[0]) == len(samples[1]) && len(samples[0]) == len(samples[2]) {
+		y0, y1, y2 := samples[0], samples[1], samples[2]
+		if d.components[0].coding.reversible {
+			for j := range y0 {
+				g := y0[j] - float32(math.Floor(float64(y1[j]+y2[j])/4))
+				y0[j], y1[j], y2[j] = y2[j]+g, g, y1[j]+g
+			}
+		} else {
+			for j := range y0 {
+				y, cb, cr := y0[j], y1[j], y2[j]
+				y0[j] = y + 1.402*cr
+				y1[j] = y - 0.34413*cb - 0.71414*cr
+				y2[j] = y + 1.772*cb
+			}
+		}
+	}
+
+	// the DC level shift, G.1, into the samples of the image
+	for i, tc := range d.components {
+		component := img.components[i]
+		shift, low, high := float32(0), float32(0), float32(int32(1)<<uint(component.depth)-1)
+		if component.signed {
+			low, high = -(high+1)/2, (high-1)/2
+		} else {
+			shift = (high + 1) / 2
+		}
+
+		width := tc.x1 - tc.x0
+		for y := tc.y0; y < tc.y1; y++ {
+			row := component.samples[(y-component.y0)*component.width+tc.x0-component.x0:]
+			for x, v := range samples[i][(y-tc.y0)*width : (y-tc.y0+1)*width] {
+				v = float32(math.Floor(float64(v+shift) + 0.5))
+				if v < low {
+					v = low
+				}
+				if v > high {
+					v = high
+				}
+				row[x] = int32(v)
+			}
+		}
+	}
+	return nil
+}
+
+// packets reads the packets of the tile in their progression order, B.12
+func (d *jpxTileDecoder) packets() error {
+	p := &d.t.parameters
+	resolutions := 0
+	for _, tc := range d.components {
+		resolutions = max(resolutions, len(tc.resolutions))
+	}
+
+	changes := p.changes
+	if len(changes) == 0 {
+		changes = []jpxProgressionChange{{
+			resolutionEnd: resolutions,
+			componentEnd:  len(d.components),
+			layerEnd:      p.layers,
+			order:         p.order,
+		}}
+	}
+
+	// the precincts of every resolution of every component, with the
+	// position on the reference grid where they start in the tile
+	type precinct struct {
+		component, resolution, index int
+		x, y                         int
+	}
+	var precincts []precinct
+	for i, tc := range d.components {
+		for r, res := range tc.resolutions {
+			shift := uint(len(tc.resolutions) - 1 - r)
+			for k := range res.layers {
+				x := (res.precinctX0 + k%res.precinctsWide) << res.precinctWidth << shift * tc.dx
+				y := (res.precinctY0 + k/res.precinctsWide) << res.precinctHeight << shift * tc.dy
+				precincts = append(precincts, precinct{i, r, k, max(x, d.t.x0), max(y, d.t.y0)})
+			}
+		}
+	}
+
+	for _, change := range changes {
+		layers := min(change.layerEnd, p.layers)
+		included := func(i, r int) bool {
+			return i >= change.componentStart && i < change.componentEnd &&
+				r >= change.resolutionStart && r < change.resolutionEnd &&
+				i < len(d.components) && r < len(d.components[i].resolutions)
+		}
+
+		switch change.order {
+		case jpxLRCP, jpxRLCP:
+			outer, inner := layers, resolutions
+			if change.order == jpxRLCP {
+				outer, inner = resolutions, layers
+			}
+			for a := 0; a < outer; a++ {
+				for b := 0; b < inner; b++ {
+					l, r := a, b
+					if change.order == jpxRLCP {
+						l, r = b, a
+					}
+					for i := range d.components {
+						if !included(i, r) {
+							continue
+						}
+						for k := range d.components[i].resolutions[r].layers {
+							err := d.packet(i, r, k, l)
+							if err != nil {
+								return err
+							}
+						}
+					}
+				}
+			}
+			continue
+		}
+
+		// the position progressions, visiting each precinct
+		// at its first position in the tile
+		order := make([]precinct, 0, len(precincts))
+		for _, pr := range precincts {
+			if included(pr.component, pr.resolution) {
+				order = append(order, pr)
+			}
+		}
+		key := func(pr precinct) [4]int {
+			switch change.order {
+			case jpxRPCL:
+				return [4]int{pr.resolution, pr.y, pr.x, pr.component}
+			case jpxPCRL:
+				return [4]int{pr.y, pr.x, pr.component, pr.resolution}
+			}
+			return [4]int{pr.component, pr.y, pr.x, pr.resolution}
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			ka, kb := key(order[a]), key(order[b])
+			for j := range ka {
+				if ka[j] != kb[j] {
+					return ka[j] < kb[j]
+				}
+			}
+			return false
+		})
+		for _, pr := range order {
+			for l := 0; l < layers; l++ {
+				err := d.packet(pr.component, pr.resolution, pr.index, l)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// the first pass after the segment of codeword segments with pass,
+// which are terminated according to the style of the code-block
+func jpxSegmentEnd(style byte, pass int) int {
+	switch {
+	case style&jpxTerminateAll != 0:
+		return pass + 1
+	case style&jpxBypass != 0:
+		// arithmetic coding for the first 10 passes, then raw for the
+		// significance propagation and magnitude refinement passes
+		if pass < 10 {
+			return 10
+		}
+		if (pass-10)%3 == 0 {
+			return pass + 2
+		}
+		return pass + 1
+	}
+	return math.MaxInt32
+}
+
+// packet reads the packet of layer l for precinct k of resolution r
+// of component i, B.9 and B.10, unless it was read
+func (d *jpxTileDecoder) packet(i, r, k, l int) error {
+	res := d.components[i].resolutions[r]
+	if res.layers[k] != l {
+		return nil
+	}
+	res.layers[k]++
+
+	if d.t.parameters.sop && len(d.data) >= 6 && d.data[0] == 0xff && d.data[1] == byte(jpxSOP&0xff) {
+		d.data = d.data[6:]
+	}
+	header := d.headers
+	if header == nil {
+		header = &jpxBits{data: d.data}
+	}
+	if header.pos >= len(header.data) {
+		return errJPXTruncated
+	}
+
+	// the passes and lengths of the data of each code-block
+	type contribution struct {
+		block   *jpxBlock
+		passes  []int
+		lengths []int
+	}
+	var contributions []contribution
+	style := d.components[i].coding.blockStyle
+	if header.bit() == 1 {
+		for _, band := range res.bands {
+			precinct := band.precincts[k]
+			for j, block := range precinct.blocks {
+				x, y := j%precinct.blocksWide, j/precinct.blocksWide
+				if !block.included {
+					if !precinct.inclusion.decode(header, x, y, l+1) {
+						continue
+					}
+					threshold := 1
+					for !precinct.zeroPlanes.decode(header, x, y, threshold) {
+						threshold++
+						if threshold > 64 || header.ended {
+							return errors.New("invalid number of zero bit planes")
+						}
+					}
+					block.zeroPlanes = precinct.zeroPlanes.value(x, y)
+					block.included = true
+					block.lengthBits = 3
+				} else if header.bit() == 0 {
+					continue
+				}
+
+				passes := jpxPasses(header)
+				for header.bit() == 1 && !header.ended {
+					block.lengthBits++
+				}
+
+				c := contribution{block: block}
+				for pass := block.passes; pass < block.passes+passes; {
+					n := min(jpxSegmentEnd(style, pass), block.passes+passes) - pass
+					length := header.read(block.lengthBits + bits.Len(uint(n)) - 1)
+					if length < 0 {
+						return errors.New("invalid length of JPEG 2000 code-block data")
+					}
+					c.passes = append(c.passes, n)
+					c.lengths = append(c.lengths, length)
+					pass += n
+				}
+				contributions = append(contributions, c)
+			}
+		}
+	}
+	header.align()
+	if header.ended {
+		return errJPXTruncated
+	}
+	if d.t.parameters.eph && header.pos+2 <= len(header.data) &&
+		header.data[header.pos] == 0xff && header.data[header.pos+1] == byte(jpxEPH&0xff) {
+		header.pos += 2
+	}
+	if d.headers == nil {
+		d.data = d.data[header.pos:]
+	}
+
+	for _, c := range contributions {
+		for j, n := range c.passes {
+			length := c.lengths[j]
+			truncated := length > len(d.data)
+			if truncated {
+				length = len(d.data)
+			}
+			c.block.add(style, n, d.data[:length])
+			d.data = d.data[length:]
+			if truncated {
+				return errJPXTruncated
+			}
+		}
+	}
+	return nil
+}
+
+// jpxPasses reads the number of coding passes of a
+// code-block in a packet, Table B.4
+func jpxPasses(bits *jpxBits) int {
+	switch {
+	case bits.bit() == 0:
+		return 1
+	case bits.bit() == 0:
+		return 2
+	}
+	if n := bits.read(2); n < 3 {
+		return 3 + n
+	}
+	if n := bits.read(5); n < 31 {
+		return 6 + n
+	}
+	return 37 + bits.read(7)
+}
+
+// jpxTagTree is a tag tree, B.10.2, with a level of
+// nodes for each halving of the width and height
+type jpxTagTree struct {
+	widths []int
+	values [][]int
+	lows   [][]int
+}
+
+func newJPXTagTree(width, height int) *jpxTagTree {
+	t := &jpxTagTree{}
+	for {
+		t.widths = append(t.widths, width)
+		values := make([]int, width*height)
+		for i := range values {
+			values[i] = math.MaxInt32
+		}
+		t.values = append(t.values, values)
+		t.lows = append(t.lows, make([]int, width*height))
+		if width <= 1 && height <= 1 {
+			return t
+		}
+		width, height = (width+1)/2, (height+1)/2
+	}
+}
+
+// decode reads the bits of the value of the leaf at x, y
+// while it could be less than threshold, reporting whether it is
+func (t *jpxTagTree) decode(bits *jpxBits, x, y, threshold int) bool {
+	low := 0
+	for level := len(t.values) - 1; level >= 0; level-- {
+		i := (y>>uint(level))*t.widths[level] + x>>uint(level)
+		if low < t.lows[level][i] {
+			low = t.lows[level][i]
+		}
+		for low < threshold && low < t.values[level][i] {
+			if bits.bit() == 1 {
+				t.values[level][i] = low
+			} else {
+				low++
+			}
+			if bits.ended {
+				return false
+			}
+		}
+		t.lows[level][i] = low
+	}
+	return t.values[0][y*t.widths[0]+x] < threshold
+}
+
+// value returns the value of the leaf at x, y once it is decoded
+func (t *jpxTagTree) value(x, y int) int {
+	return t.values[0][y*t.widths[0]+x]
+}
+
+// jpxBits reads bits, most significant first, where a byte after
+// 0xff has 7 bits as a 0 bit is stuffed before them, B.10.1 and D.6
+type jpxBits struct {
+	data  []byte
+	pos   int
+	c     byte // the byte being read
+	n     uint // the bits left in c
+	ended bool // whether bits were read after the end of the data
+}
+
+func (b *jpxBits) bit() int {
+	if b.n == 0 {
+		b.n = 8
+		if b.c == 0xff {
+			b.n = 7
+		}
+		b.c = 0
+		if b.pos < len(b.data) {
+			b.c = b.data[b.pos]
+		} else {
+			b.ended = true
+		}
+		b.pos++
+	}
+	b.n--
+	return int(b.c>>b.n) & 1
+}
+
+// read returns the next n bits, or -1 for more than 31
+func (b *jpxBits) read(n int) int {
+	if n > 31 {
+		return -1
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | b.bit()
+	}
+	return v
+}
+
+// align skips the rest of the byte, and the byte after it after 0xff,
+// as its stuffed bit is part of the packet header
+func (b *jpxBits) align() {
+	if b.c == 0xff {
+		b.pos++
+	}
+	b.c, b.n = 0, 0
+	if b.pos > len(b.data) {
+		b.ended = true
+	}
+}
+
+// decode decodes the code-blocks of the tile-component, returning its
+// samples, after the inverse discrete wavelet transformation
+func (tc *jpxTileComponent) decode(blocks *jpxBlockDecoder, roiShift int) ([]float32, error) {
+	reversible := tc.coding.reversible
+	var samples []float32
+	for r, res := range tc.resolutions {
+		bands := make([][]float32, len(res.bands))
+		for j, band := range res.bands {
+			width := band.x1 - band.x0
+			bands[j] = make([]float32, width*(band.y1-band.y0))
+			for _, precinct := range band.precincts {
+				for _, block := range precinct.blocks {
+					planes := band.planes + roiShift - block.zeroPlanes
+					if planes > 31 {
+						return nil, fmt.Errorf("unsupported number of bit planes: %d", planes)
+					}
+					if block.passes == 0 || planes <= 0 {
+						continue
+					}
+					blocks.decode(block, band.orientation, planes, tc.coding.blockStyle)
+
+					bw := block.x1 - block.x0
+					for y := block.y0; y < block.y1; y++ {
+						row := bands[j][(y-band.y0)*width+block.x0-band.x0:]
+						for x := 0; x < bw; x++ {
+							row[x] = blocks.value(x, y-block.y0, roiShift, reversible, band.step)
+						}
+					}
+				}
+			}
+		}
+
+		if r == 0 {
+			samples = bands[0]
+			continue
+		}
+		samples = res.inverseTransform(tc.resolutions[r-1], samples, bands, reversible)
+	}
+	return samples, nil
+}
+
+// inverseTransform returns the samples of the resolution from those of
+// the previous resolution and the subbands, F.3.2
+func (res *jpxResolution) inverseTransform(previous *jpxResolution, low []float32, bands [][]float32, reversible bool) []float32 {
+	width, height := res.x1-res.x0, res.y1-res.y0
+	samples := make([]float32, width*height)
+	if width == 0 || height == 0 {
+		return samples
+	}
+
+	// interleave the subbands, F.3.3, where the samples of low
+	// pass subbands are at even positions of the resolution
+	lowWidth := previous.x1 - previous.x0
+	for y := res.y0; y < res.y1; y++ {
+		for x := res.x0; x < res.x1; x++ {
+			var v float32
+			switch x%2 + 2*(y%2) {
+			case 0:
+				v = low[(y/2-previous.y0)*lowWidth+x/2-previous.x0]
+			default:
+				band := res.bands[x%2+2*(y%2)-1]
+				v = bands[x%2+2*(y%2)-1][(y/2-band.y0)*(band.x1-band.x0)+x/2-band.x0]
+			}
+			samples[(y-res.y0)*width+x-res.x0] = v
+		}
+	}
+
+	// the rows, then the columns
+	for y := 0; y < height; y++ {
+		jpxInverse1D(samples[y*width:(y+1)*width], res.x0, reversible)
+	}
+	column := make([]float32, height)
+	for x := 0; x < width; x++ {
+		for y := range column {
+			column[y] = samples[y*width+x]
+		}
+		jpxInverse1D(column, res.y0, reversible)
+		for y, v := range column {
+			samples[y*width+x] = v
+		}
+	}
+	return samples
+}
+
+// the lifting parameters of the 9-7 irreversible filter, Table F.4
+const (
+	jpxAlpha = -1.586134342059924
+	jpxBeta  = -0.052980118572961
+	jpxGamma = 0.882911075530934
+	jpxDelta = 0.443506852043971
+	jpxK     = 1.230174104914001
+)
+
+// jpxInverse1D is the one-dimensional inverse transformation of
+// samples starting at i0, with the periodic symmetric extension
+// of the signal at its ends, F.3.6 to F.3.8
+func jpxInverse1D(x []float32, i0 int, reversible bool) {
+	n := len(x)
+	if n == 1 {
+		if i0%2 == 1 {
+			x[0] /= 2
+		}
+		return
+	}
+
+	// the neighbors of j, reflected at the ends
+	at := func(j int) float32 {
+		if j < 0 {
+			j = -j
+		}
+		if j >= n {
+			j = 2*(n-1) - j
+		}
+		return x[j]
+	}
+	// the lifting step for the samples at even or odd positions
+	step := func(odd int, f func(v, neighbors float32) float32) {
+		for j := (i0 + odd) % 2; j < n; j += 2 {
+			x[j] = f(x[j], at(j-1)+at(j+1))
+		}
+	}
+
+	if reversible {
+		step(0, func(v, s float32) float32 { return v - float32(math.Floor(float64(s+2)/4)) })
+		step(1, func(v, s float32) float32 { return v + float32(math.Floor(float64(s)/2)) })
+		return
+	}
+	for j := range x {
+		if (i0+j)%2 == 0 {
+			x[j] *= jpxK
+		} else {
+			x[j] /= jpxK
+		}
+	}
+	step(0, func(v, s float32) float32 { return v - jpxDelta*s })
+	step(1, func(v, s float32) float32 { return v - jpxGamma*s })
+	step(0, func(v, s float32) float32 { return v - jpxBeta*s })
+	step(1, func(v, s float32) float32 { return v - jpxAlpha*s })
+}
@@ -0,0 +1,1644 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// jpxTestOptions are the coding parameters of the JPEG 2000 test encoder
+type jpxTestOptions struct {
+	width, height         int
+	x0, y0                int // of the image on the reference grid
+	tileWidth, tileHeight int // 0 for a single tile
+	tileX0, tileY0        int
+	components            []jpxComponentSize
+
+	levels                  int
+	blockWidth, blockHeight uint      // exponents, 0 for 4
+	precincts               [][2]uint // the exponents of each resolution, nil for none
+	layers                  int       // 0 for 1
+	order                   int
+	changes                 []jpxProgressionChange
+	sop, eph                bool
+	style                   byte
+	irreversible            bool
+	derived                 bool // derived quantization, for irreversible
+	transform               bool // multiple component transformation
+	roi                     bool // the left half of each subband is a region of interest
+
+	// components after the first have one level less and
+	// smaller code-blocks, from COC
+	componentCoding bool
+	// the coding parameters are in the tile headers,
+	// those of the main header are different
+	tileHeaders bool
+	// tiles have two tile-parts, those of the tiles being interleaved
+	tileParts bool
+	// the packet headers are in PPM or PPT
+	ppm, ppt bool
+	// the last tile-part has a length of 0
+	unknownLength bool
+}
+
+// jpxTestMarker returns a marker segment
+func jpxTestMarker(marker int, contents ...[]byte) []byte {
+	data := bytes.Join(contents, nil)
+	out := []byte{byte(marker >> 8), byte(marker), byte((len(data) + 2) >> 8), byte(len(data) + 2)}
+	return append(out, data...)
+}
+
+func be16(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func be32(v int) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// jpxTestBits writes bits, with a 0 bit stuffed after each 0xff byte
+type jpxTestBits struct {
+	out  []byte
+	free uint // bits left in the last byte
+}
+
+func (b *jpxTestBits) put(bit int) {
+	if b.free == 0 {
+		b.free = 8
+		if len(b.out) > 0 && b.out[len(b.out)-1] == 0xff {
+			b.free = 7
+		}
+		b.out = append(b.out, 0)
+	}
+	b.free--
+	b.out[len(b.out)-1] |= byte(bit) << b.free
+}
+
+func (b *jpxTestBits) write(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.put(v >> uint(i) & 1)
+	}
+}
+
+// flush ends a packet header, with a byte after a last 0xff
+// so that its stuffed bit is part of the header
+func (b *jpxTestBits) flush() []byte {
+	b.free = 0
+	if len(b.out) > 0 && b.out[len(b.out)-1] == 0xff {
+		b.out = append(b.out, 0)
+	}
+	return b.out
+}
+
+// jpxTestTagTree encodes the values of a tag tree, B.10.2
+type jpxTestTagTree struct {
+	widths []int
+	values [][]int
+	lows   [][]int
+	known  [][]bool
+}
+
+func newJPXTestTagTree(leaves []int, width, height int) *jpxTestTagTree {
+	t := &jpxTestTagTree{}
+	values := leaves
+	for {
+		t.widths = append(t.widths, width)
+		t.values = append(t.values, values)
+		t.lows = append(t.lows, make([]int, len(values)))
+		t.known = append(t.known, make([]bool, len(values)))
+		if width <= 1 && height <= 1 {
+			return t
+		}
+
+		parentWidth, parentHeight := (width+1)/2, (height+1)/2
+		parents := make([]int, parentWidth*parentHeight)
+		for i := range parents {
+			parents[i] = math.MaxInt32
+		}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				parent := &parents[y/2*parentWidth+x/2]
+				if values[y*width+x] < *parent {
+					*parent = values[y*width+x]
+				}
+			}
+		}
+		values, width, height = parents, parentWidth, parentHeight
+	}
+}
+
+// encode codes the value of the leaf at x, y while it could be
+// less than threshold
+func (t *jpxTestTagTree) encode(b *jpxTestBits, x, y, threshold int) {
+	low := 0
+	for level := len(t.values) - 1; level >= 0; level-- {
+		i := (y>>uint(level))*t.widths[level] + x>>uint(level)
+		if low < t.lows[level][i] {
+			low = t.lows[level][i]
+		}
+		for low < threshold {
+			if low >= t.values[level][i] {
+				if !t.known[level][i] {
+					b.put(1)
+					t.known[level][i] = true
+				}
+				break
+			}
+			b.put(0)
+			low++
+		}
+		t.lows[level][i] = low
+	}
+}
+
+// jpxBlockEncoder codes the coefficients of a code-block, with the
+// flags and context states of the decoder but contexts of its own
+type jpxBlockEncoder struct {
+	jpxBlockDecoder
+	style        byte
+	coefficients []int64 // with the border of the flags
+	plane        uint
+
+	coder    *mqEncoder
+	rawBits  *jpxTestBits
+	segments []jpxSegment
+}
+
+// encodeJPXBlock codes the coefficients of a block in a subband,
+// returning its codeword segments, coding passes and zero bit planes
+func encodeJPXBlock(t *testing.T, block *jpxBlock, coefficients []int64, orientation, planes int, style byte) ([]jpxSegment, int, int) {
+	e := &jpxBlockEncoder{style: style}
+	e.width, e.height = block.x1-block.x0, block.y1-block.y0
+	e.orientation = orientation
+	e.causal = style&jpxVerticallyCausal != 0
+	e.flags = make([]uint8, (e.width+2)*(e.height+2))
+	e.coefficients = make([]int64, len(e.flags))
+	e.reset()
+
+	maximum := uint64(0)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			v := coefficients[y*e.width+x]
+			e.coefficients[e.index(x, y)] = v
+			if v < 0 {
+				v = -v
+			}
+			if uint64(v) > maximum {
+				maximum = uint64(v)
+			}
+		}
+	}
+	used := bits.Len64(maximum)
+	if used > planes {
+		t.Fatalf("coefficient of %d bits with %d bit planes", used, planes)
+	}
+	if used == 0 {
+		return nil, 0, 0
+	}
+
+	passes := 3*used - 2
+	segmentEnd := 0
+	for pass := 0; pass < passes; pass++ {
+		if pass == segmentEnd {
+			segmentEnd = jpxSegmentEnd(style, pass)
+			e.segments = append(e.segments, jpxSegment{start: pass})
+			e.coder, e.rawBits = nil, nil
+			if style&jpxBypass != 0 && pass >= 10 && (pass+2)%3 != jpxCleanupPass {
+				e.rawBits = &jpxTestBits{}
+			} else {
+				e.coder = newMQEncoder()
+			}
+		}
+
+		e.plane = uint(used - 1 - (pass+2)/3)
+		switch (pass + 2) % 3 {
+		case jpxSignificancePass:
+			e.significancePass()
+		case jpxRefinementPass:
+			e.refinementPass()
+		case jpxCleanupPass:
+			e.cleanupPass()
+			if style&jpxSegmentationSymbols != 0 {
+				for _, bit := range []int{1, 0, 1, 0} {
+					e.code(bit, jpxUniformContext)
+				}
+			}
+		}
+		if style&jpxReset != 0 {
+			e.reset()
+		}
+
+		segment := &e.segments[len(e.segments)-1]
+		segment.passes++
+		if pass+1 == segmentEnd || pass+1 == passes {
+			if e.coder != nil {
+				// without the marker of the encoder of T.88,
+				// and the 0xff before it as in T.800 C.2.9
+				data := e.coder.flush()
+				segment.data = data[:len(data)-2]
+			} else {
+				segment.data = e.rawBits.out
+			}
+		}
+	}
+	return e.segments, passes, planes - used
+}
+
+// jpxTestZeroContexts are the rows of Table D.1 for the LL and LH
+// subbands, with the sums of the horizontal, vertical and diagonal
+// significant neighbors, -1 being any, and those for HH, with the
+// sums of the diagonal and of the horizontal and vertical ones
+var (
+	jpxTestZeroContexts = [][4]int{
+		{2, -1, -1, 8}, {1, 1, -1, 7}, {1, 2, -1, 7}, {1, 0, 1, 6}, {1, 0, 2, 6}, {1, 0, 3, 6}, {1, 0, 4, 6}, {1, 0, 0, 5},
+		{0, 2, -1, 4}, {0, 1, -1, 3}, {0, 0, 2, 2}, {0, 0, 3, 2}, {0, 0, 4, 2}, {0, 0, 1, 1}, {0, 0, 0, 0},
+	}
+	jpxTestDiagonalContexts = [][3]int{
+		{3, -1, 8}, {4, -1, 8}, {2, 1, 7}, {2, 2, 7}, {2, 3, 7}, {2, 4, 7}, {2, 0, 6},
+		{1, 2, 5}, {1, 3, 5}, {1, 4, 5}, {1, 1, 4}, {1, 0, 3}, {0, 2, 2}, {0, 3, 2}, {0, 4, 2}, {0, 1, 1}, {0, 0, 0},
+	}
+	// Table D.3, for the horizontal and vertical contributions
+	jpxTestSignContexts = map[[2]int][2]int{
+		{1, 1}: {13, 0}, {1, 0}: {12, 0}, {1, -1}: {11, 0},
+		{0, 1}: {10, 0}, {0, 0}: {9, 0}, {0, -1}: {10, 1},
+		{-1, 1}: {11, 1}, {-1, 0}: {12, 1}, {-1, -1}: {13, 1},
+	}
+)
+
+// neighbor returns the flags of the neighbor at dx, dy of x, y, where
+// those of the next stripe are not known with vertically causal contexts
+func (e *jpxBlockEncoder) neighbor(x, y, dx, dy int) uint8 {
+	x, y = x+dx, y+dy
+	if x < 0 || y < 0 || x >= e.width || y >= e.height || e.causal && dy == 1 && y%4 == 0 {
+		return 0
+	}
+	return e.flags[e.index(x, y)]
+}
+
+// sums returns the number of significant horizontal,
+// vertical and diagonal neighbors of x, y
+func (e *jpxBlockEncoder) sums(x, y int) (int, int, int) {
+	sums := [3]int{}
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 || e.neighbor(x, y, dx, dy)&jpxSignificant == 0 {
+				continue
+			}
+			switch {
+			case dy == 0:
+				sums[0]++
+			case dx == 0:
+				sums[1]++
+			default:
+				sums[2]++
+			}
+		}
+	}
+	return sums[0], sums[1], sums[2]
+}
+
+func (e *jpxBlockEncoder) zeroContext(x, y int) int {
+	h, v, d := e.sums(x, y)
+	if e.orientation == jpxHH {
+		for _, row := range jpxTestDiagonalContexts {
+			if row[0] == d && (row[1] == -1 || row[1] == h+v) {
+				return row[2]
+			}
+		}
+	}
+	if e.orientation == jpxHL {
+		h, v = v, h
+	}
+	for _, row := range jpxTestZeroContexts {
+		if row[0] == h && (row[1] == -1 || row[1] == v) && (row[2] == -1 || row[2] == d) {
+			return row[3]
+		}
+	}
+	panic("no context")
+}
+
+func (e *jpxBlockEncoder) signContext(x, y int) (int, int) {
+	// Table D.2
+	contribution := func(a, b uint8) int {
+		sign := func(flags uint8) int {
+			switch {
+			case flags&jpxSignificant == 0:
+				return 0
+			case flags&jpxNegative != 0:
+				return -1
+			}
+			return 1
+		}
+		sum := sign(a) + sign(b)
+		if sum > 1 {
+			return 1
+		}
+		if sum < -1 {
+			return -1
+		}
+		return sum
+	}
+	h := contribution(e.neighbor(x, y, -1, 0), e.neighbor(x, y, 1, 0))
+	v := contribution(e.neighbor(x, y, 0, -1), e.neighbor(x, y, 0, 1))
+	context := jpxTestSignContexts[[2]int{h, v}]
+	return context[0], context[1]
+}
+
+// reset sets the initial states of Table D.7
+func (e *jpxBlockEncoder) reset() {
+	e.contexts = make(mqContexts, jpxContexts)
+	e.contexts[0] = 4 << 1
+	e.contexts[17] = 3 << 1
+	e.contexts[18] = 46 << 1
+}
+
+func (e *jpxBlockEncoder) runLength(x, y int) bool {
+	for j := y; j < y+4; j++ {
+		if e.flags[e.index(x, j)] != 0 || e.zeroContext(x, j) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *jpxBlockEncoder) code(bit, cx int) {
+	if e.rawBits != nil {
+		e.rawBits.put(bit)
+		return
+	}
+	e.coder.encode(e.contexts, cx, bit)
+}
+
+// bit returns the bit of the coefficient at x, y in the bit plane
+func (e *jpxBlockEncoder) bitAt(x, y int) int {
+	v := e.coefficients[e.index(x, y)]
+	if v < 0 {
+		v = -v
+	}
+	return int(v>>e.plane) & 1
+}
+
+func (e *jpxBlockEncoder) encodeSign(x, y int) {
+	i := e.index(x, y)
+	negative := 0
+	if e.coefficients[i] < 0 {
+		negative = 1
+	}
+	if e.rawBits != nil {
+		e.rawBits.put(negative)
+	} else {
+		cx, xor := e.signContext(x, y)
+		e.coder.encode(e.contexts, cx, negative^xor)
+	}
+	e.flags[i] |= jpxSignificant
+	if negative == 1 {
+		e.flags[i] |= jpxNegative
+	}
+}
+
+func (e *jpxBlockEncoder) significancePass() {
+	for y0 := 0; y0 < e.height; y0 += 4 {
+		for x := 0; x < e.width; x++ {
+			for y := y0; y < y0+4 && y < e.height; y++ {
+				i := e.index(x, y)
+				cx := e.zeroContext(x, y)
+				if e.flags[i]&jpxSignificant != 0 || cx == 0 {
+					continue
+				}
+				e.flags[i] |= jpxVisited
+				bit := e.bitAt(x, y)
+				e.code(bit, cx)
+				if bit == 1 {
+					e.encodeSign(x, y)
+				}
+			}
+		}
+	}
+}
+
+func (e *jpxBlockEncoder) refinementPass() {
+	for y0 := 0; y0 < e.height; y0 += 4 {
+		for x := 0; x < e.width; x++ {
+			for y := y0; y < y0+4 && y < e.height; y++ {
+				i := e.index(x, y)
+				if e.flags[i]&(jpxSignificant|jpxVisited) != jpxSignificant {
+					continue
+				}
+				cx := jpxRefinementContext + 2
+				if e.flags[i]&jpxRefined == 0 {
+					cx = jpxRefinementContext
+					if h, v, diagonal := e.sums(x, y); h+v+diagonal > 0 {
+						cx++
+					}
+				}
+				e.code(e.bitAt(x, y), cx)
+				e.flags[i] |= jpxRefined
+			}
+		}
+	}
+}
+
+func (e *jpxBlockEncoder) cleanupPass() {
+	for y0 := 0; y0 < e.height; y0 += 4 {
+		for x := 0; x < e.width; x++ {
+			y := y0
+			if y0+4 <= e.height && e.runLength(x, y0) {
+				for y < y0+4 && e.bitAt(x, y) == 0 {
+					y++
+				}
+				if y == y0+4 {
+					e.code(0, jpxRunLengthContext)
+					continue
+				}
+				e.code(1, jpxRunLengthContext)
+				e.code((y-y0)>>1, jpxUniformContext)
+				e.code((y-y0)&1, jpxUniformContext)
+				e.encodeSign(x, y)
+				y++
+			}
+
+			for ; y < y0+4 && y < e.height; y++ {
+				if e.flags[e.index(x, y)]&(jpxSignificant|jpxVisited) == 0 {
+					bit := e.bitAt(x, y)
+					e.code(bit, e.zeroContext(x, y))
+					if bit == 1 {
+						e.encodeSign(x, y)
+					}
+				}
+			}
+		}
+	}
+	for i := range e.flags {
+		e.flags[i] &^= jpxVisited
+	}
+}
+
+// jpxForward1D is the one-dimensional forward transformation
+// of samples starting at i0, F.4.8
+func jpxForward1D(x []float64, i0 int, reversible bool) {
+	n := len(x)
+	if n == 1 {
+		if i0%2 == 1 {
+			x[0] *= 2
+		}
+		return
+	}
+
+	at := func(j int) float64 {
+		if j < 0 {
+			j = -j
+		}
+		if j >= n {
+			j = 2*(n-1) - j
+		}
+		return x[j]
+	}
+	step := func(odd int, f func(v, neighbors float64) float64) {
+		for j := (i0 + odd) % 2; j < n; j += 2 {
+			x[j] = f(x[j], at(j-1)+at(j+1))
+		}
+	}
+
+	if reversible {
+		step(1, func(v, s float64) float64 { return v - math.Floor(s/2) })
+		step(0, func(v, s float64) float64 { return v + math.Floor((s+2)/4) })
+		return
+	}
+	step(1, func(v, s float64) float64 { return v + jpxAlpha*s })
+	step(0, func(v, s float64) float64 { return v + jpxBeta*s })
+	step(1, func(v, s float64) float64 { return v + jpxGamma*s })
+	step(0, func(v, s float64) float64 { return v + jpxDelta*s })
+	for j := range x {
+		if (i0+j)%2 == 0 {
+			x[j] /= jpxK
+		} else {
+			x[j] *= jpxK
+		}
+	}
+}
+
+// jpxTestBlock is the coded data of a block and what was sent of it
+type jpxTestBlock struct {
+	segments   []jpxSegment
+	passes     int
+	zeroPlanes int
+	layerEnds  []int // the passes up to the end of each layer
+
+	sent       int // passes
+	sentBytes  int // of the segment being sent
+	lengthBits int
+}
+
+// jpxTestPrecinct has the tag trees of a precinct in a subband
+type jpxTestPrecinct struct {
+	inclusion  *jpxTestTagTree
+	zeroPlanes *jpxTestTagTree
+}
+
+// jpxTestTile codes the packets of a tile
+type jpxTestTile struct {
+	options    *jpxTestOptions
+	t          *jpxTile
+	components []*jpxTileComponent
+	blocks     map[*jpxBlock]*jpxTestBlock
+	precincts  map[*jpxPrecinct]*jpxTestPrecinct
+	layers     [][][]int // the next layer of each precinct
+
+	body, headers []byte
+	// the end of each packet in body and headers
+	bodyEnds, headerEnds []int
+}
+
+// jpxTestEncoder encodes images, making test data for the decoder
+type jpxTestEncoder struct {
+	options jpxTestOptions
+	c       *jpxCodestream
+
+	// the marker segments of the main header,
+	// and those of each tile header
+	main, tile []byte
+}
+
+func (e *jpxTestEncoder) componentIndex(i int) []byte {
+	if len(e.options.components) > 256 {
+		return be16(i)
+	}
+	return []byte{byte(i)}
+}
+
+// coding returns the COD and COC segments of the options, with those
+// for a main header whose parameters are overridden in the tile headers
+func (e *jpxTestEncoder) coding(overridden bool) []byte {
+	o := &e.options
+	if overridden {
+		return jpxTestMarker(jpxCOD, []byte{0, 0}, be16(1), []byte{0, 1, 2, 2, 0, 1})
+	}
+
+	scod := byte(0)
+	if o.precincts != nil {
+		scod |= 1
+	}
+	if o.sop {
+		scod |= 2
+	}
+	if o.eph {
+		scod |= 4
+	}
+	mct, transformation := byte(0), byte(1)
+	if o.transform {
+		mct = 1
+	}
+	if o.irreversible {
+		transformation = 0
+	}
+	spcod := func(levels int, bw, bh uint) []byte {
+		data := []byte{byte(levels), byte(bw - 2), byte(bh - 2), o.style, transformation}
+		if o.precincts != nil {
+			for _, size := range o.precincts[len(o.precincts)-levels-1:] {
+				data = append(data, byte(size[0]|size[1]<<4))
+			}
+		}
+		return data
+	}
+
+	out := jpxTestMarker(jpxCOD, []byte{scod, byte(o.order)}, be16(o.layers), []byte{mct},
+		spcod(o.levels, o.blockWidth, o.blockHeight))
+	if o.componentCoding {
+		for i := 1; i < len(o.components); i++ {
+			out = append(out, jpxTestMarker(jpxCOC, e.componentIndex(i), []byte{scod & 1},
+				spcod(o.levels-1, o.blockWidth-1, o.blockHeight))...)
+		}
+	}
+	return out
+}
+
+// steps returns the quantization style of component i
+// and the exponent and mantissa signalled for each subband
+func (o *jpxTestOptions) steps(i int) (style byte, steps [][2]int) {
+	depth, levels := o.components[i].depth, o.levels
+	if o.transform && i < 3 {
+		// the transformed components have an extra bit
+		depth++
+	}
+	if o.componentCoding && i > 0 {
+		levels--
+	}
+
+	if o.irreversible && o.derived {
+		return 1, [][2]int{{depth + 4, 0x123}}
+	}
+	for j := 0; j <= 3*levels; j++ {
+		gain := []int{0, 1, 1, 2}[(j+2)%3+1]
+		if j == 0 {
+			gain = 0
+		}
+		if o.irreversible {
+			steps = append(steps, [2]int{depth + gain + 4, j * 100})
+		} else {
+			steps = append(steps, [2]int{depth + gain, 0})
+		}
+	}
+	if o.irreversible {
+		return 2, steps
+	}
+	return 0, steps
+}
+
+// step returns the number of bit planes and the step size
+// of a subband of resolution r in component i, E.1
+func (o *jpxTestOptions) step(i, r, orientation int) (planes int, step float64) {
+	style, steps := o.steps(i)
+	var exponent, mantissa int
+	if style == 1 {
+		// each level of decomposition below that of
+		// the first subbands has one exponent less
+		exponent, mantissa = steps[0][0], steps[0][1]
+		if r > 1 {
+			exponent -= r - 1
+		}
+	} else {
+		j := 0
+		if r > 0 {
+			j = 3*(r-1) + orientation
+		}
+		exponent, mantissa = steps[j][0], steps[j][1]
+	}
+
+	// two guard bits
+	planes = 2 + exponent - 1
+	if style == 0 {
+		return planes, 1
+	}
+	gain := []int{0, 1, 1, 2}[orientation]
+	return planes, math.Ldexp(1+float64(mantissa)/2048, o.components[i].depth+gain-exponent)
+}
+
+// quantization returns the QCD segment, and QCC segments for the
+// components whose step sizes are different from the first
+func (e *jpxTestEncoder) quantization(overridden bool) []byte {
+	o := &e.options
+	if overridden {
+		return jpxTestMarker(jpxQCD, []byte{2 << 5, 8 << 3, 9 << 3, 9 << 3, 10 << 3})
+	}
+
+	values := func(i int) []byte {
+		style, steps := o.steps(i)
+		data := []byte{2<<5 | style}
+		for _, step := range steps {
+			if style == 0 {
+				data = append(data, byte(step[0])<<3)
+			} else {
+				data = append(data, be16(step[0]<<11|step[1])...)
+			}
+		}
+		return data
+	}
+
+	first := values(0)
+	out := jpxTestMarker(jpxQCD, first)
+	for i := 1; i < len(o.components); i++ {
+		if v := values(i); !bytes.Equal(v, first) {
+			out = append(out, jpxTestMarker(jpxQCC, e.componentIndex(i), v)...)
+		}
+	}
+	return out
+}
+
+// progression returns the POC segment of the progression changes
+func (e *jpxTestEncoder) progression() []byte {
+	if len(e.options.changes) == 0 {
+		return nil
+	}
+	data := []byte{}
+	for _, change := range e.options.changes {
+		data = append(data, byte(change.resolutionStart))
+		data = append(data, e.componentIndex(change.componentStart)...)
+		data = append(data, be16(change.layerEnd)...)
+		data = append(data, byte(change.resolutionEnd))
+		data = append(data, e.componentIndex(change.componentEnd)...)
+		data = append(data, byte(change.order))
+	}
+	return jpxTestMarker(jpxPOC, data)
+}
+
+// read reads the marker segments of a header into the parameters
+func (e *jpxTestEncoder) read(t *testing.T, p *jpxParameters, segments []byte, main bool) {
+	source, component := jpxMainHeader, jpxMainHeaderComponent
+	if !main {
+		source, component = jpxTileHeader, jpxTileHeaderComponent
+	}
+	for len(segments) > 0 {
+		marker, segment, rest, err := readJPXMarker(segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = rest
+		switch marker {
+		case jpxCOD:
+			err = p.readCOD(segment, source)
+		case jpxQCD:
+			err = p.readQCD(segment, len(e.options.components), source)
+		default:
+			err = p.read(marker, segment, len(e.options.components), component)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// encodeJPXTest returns the codestream of the samples of each component
+func encodeJPXTest(t *testing.T, options jpxTestOptions, samples [][]int32) []byte {
+	o := &options
+	if o.layers == 0 {
+		o.layers = 1
+	}
+	if o.blockWidth == 0 {
+		o.blockWidth, o.blockHeight = 4, 4
+	}
+	if o.tileWidth == 0 {
+		o.tileWidth, o.tileHeight = o.x0+o.width, o.y0+o.height
+	}
+	e := &jpxTestEncoder{options: options, c: &jpxCodestream{tiles: map[int]*jpxTile{}}}
+
+	siz := [][]byte{be16(0), be32(o.x0 + o.width), be32(o.y0 + o.height), be32(o.x0), be32(o.y0),
+		be32(o.tileWidth), be32(o.tileHeight), be32(o.tileX0), be32(o.tileY0), be16(len(o.components))}
+	for _, size := range o.components {
+		ssiz := byte(size.depth - 1)
+		if size.signed {
+			ssiz |= 0x80
+		}
+		siz = append(siz, []byte{ssiz, byte(size.dx), byte(size.dy)})
+	}
+	e.main = jpxTestMarker(jpxSIZ, siz...)
+	err := e.c.readSIZ(e.main[4:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameters := append(e.coding(false), e.quantization(false)...)
+	parameters = append(parameters, e.progression()...)
+	if o.tileHeaders {
+		e.tile = parameters
+		parameters = append(e.coding(true), e.quantization(true)...)
+	}
+	e.main = append(e.main, parameters...)
+	e.read(t, &e.c.parameters, parameters, true)
+
+	if o.roi {
+		// a shift of the most bit planes of any subband
+		rgn := []byte{}
+		tile := e.newTile(t, 0)
+		for i, tc := range tile.components {
+			shift := 0
+			for r, res := range tc.resolutions {
+				for _, band := range res.bands {
+					planes, _ := o.step(i, r, band.orientation)
+					shift = max(shift, planes)
+				}
+			}
+			rgn = append(rgn, jpxTestMarker(jpxRGN, e.componentIndex(i), []byte{0, byte(shift)})...)
+		}
+		e.main = append(e.main, rgn...)
+		e.read(t, &e.c.parameters, rgn, true)
+	}
+
+	var tiles []*jpxTestTile
+	for index := 0; index < e.c.tilesWide*e.c.tilesHigh; index++ {
+		tile := e.newTile(t, index)
+		tile.encode(t, samples, e.c)
+		tiles = append(tiles, tile)
+	}
+
+	// the tile-parts, with the packets of each
+	type part struct {
+		tile, index int
+		first, last int // packets
+	}
+	var parts []part
+	for i, tile := range tiles {
+		n := len(tile.bodyEnds)
+		if o.tileParts {
+			parts = append(parts, part{i, 0, 0, n / 2}, part{i, 1, n / 2, n})
+		} else {
+			parts = append(parts, part{i, 0, 0, n})
+		}
+	}
+	if o.tileParts {
+		// the first tile-parts of each tile, then the second
+		interleaved := []part{}
+		for index := 0; index < 2; index++ {
+			for _, p := range parts {
+				if p.index == index {
+					interleaved = append(interleaved, p)
+				}
+			}
+		}
+		parts = interleaved
+	}
+
+	// the range of the body and headers of packets
+	span := func(ends []int, first, last int) (int, int) {
+		start := 0
+		if first > 0 {
+			start = ends[first-1]
+		}
+		end := start
+		if last > 0 {
+			end = ends[last-1]
+		}
+		return start, end
+	}
+	chunks := func(marker int, data []byte, index int) []byte {
+		out := []byte{}
+		for z := 0; len(data) > 0 || z == 0; z++ {
+			n := min(len(data), 50)
+			out = append(out, jpxTestMarker(marker, []byte{byte(index + z)}, data[:n])...)
+			data = data[n:]
+		}
+		return out
+	}
+
+	ppm := []byte{}
+	codestream := []byte{}
+	for j, p := range parts {
+		tile := tiles[p.tile]
+		start, end := span(tile.bodyEnds, p.first, p.last)
+		headerStart, headerEnd := span(tile.headerEnds, p.first, p.last)
+		headers := tile.headers[headerStart:headerEnd]
+
+		header := []byte{}
+		if p.index == 0 {
+			header = append(header, e.tile...)
+		}
+		if o.ppt {
+			header = append(header, chunks(jpxPPT, headers, 0)...)
+		}
+		if o.ppm {
+			ppm = append(ppm, be32(len(headers))...)
+			ppm = append(ppm, headers...)
+		}
+
+		length := 12 + len(header) + 2 + end - start
+		if o.unknownLength && j == len(parts)-1 {
+			length = 0
+		}
+		tileParts := 1
+		if o.tileParts {
+			tileParts = 2
+		}
+		codestream = append(codestream, jpxTestMarker(jpxSOT, be16(p.tile), be32(length), []byte{byte(p.index), byte(tileParts)})...)
+		codestream = append(codestream, header...)
+		codestream = append(codestream, 0xff, 0x93)
+		codestream = append(codestream, tile.body[start:end]...)
+	}
+
+	out := append([]byte{0xff, 0x4f}, e.main...)
+	if o.ppm {
+		out = append(out, chunks(jpxPPM, ppm, 0)...)
+	}
+	out = append(out, codestream...)
+	return append(out, 0xff, 0xd9)
+}
+
+// newTile returns a tile with the structure of its components
+func (e *jpxTestEncoder) newTile(t *testing.T, index int) *jpxTestTile {
+	tile := &jpxTestTile{
+		options:   &e.options,
+		t:         e.c.newTile(index),
+		blocks:    map[*jpxBlock]*jpxTestBlock{},
+		precincts: map[*jpxPrecinct]*jpxTestPrecinct{},
+	}
+	if e.tile != nil {
+		tile.t.parameters.changes = nil
+		e.read(t, &tile.t.parameters, e.tile, false)
+	}
+	for i := range e.options.components {
+		tc, err := e.c.tileComponent(tile.t, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile.components = append(tile.components, tc)
+
+		layers := [][]int{}
+		for _, res := range tc.resolutions {
+			layers = append(layers, make([]int, len(res.layers)))
+		}
+		tile.layers = append(tile.layers, layers)
+	}
+	return tile
+}
+
+// encode codes the samples of the tile and its packets
+func (tile *jpxTestTile) encode(t *testing.T, samples [][]int32, c *jpxCodestream) {
+	o := tile.options
+	p := &tile.t.parameters
+
+	// the samples of the tile-components, after the DC level shift
+	values := make([][]float64, len(tile.components))
+	for i, tc := range tile.components {
+		size := c.components[i]
+		x0, y0 := ceilDiv(c.x0, size.dx), ceilDiv(c.y0, size.dy)
+		width := ceilDiv(c.x1, size.dx) - x0
+		shift := 0.0
+		if !size.signed {
+			shift = float64(int(1) << uint(size.depth-1))
+		}
+		for y := tc.y0; y < tc.y1; y++ {
+			for x := tc.x0; x < tc.x1; x++ {
+				values[i] = append(values[i], float64(samples[i][(y-y0)*width+x-x0])-shift)
+			}
+		}
+	}
+
+	// the forward multiple component transformation, G.2 and G.3
+	if p.transform {
+		r, g, b := values[0], values[1], values[2]
+		for j := range r {
+			if o.irreversible {
+				r[j], g[j], b[j] = 0.299*r[j]+0.587*g[j]+0.114*b[j],
+					-0.16875*r[j]-0.33126*g[j]+0.5*b[j],
+					0.5*r[j]-0.41869*g[j]-0.08131*b[j]
+			} else {
+				r[j], g[j], b[j] = math.Floor((r[j]+2*g[j]+b[j])/4), b[j]-g[j], r[j]-g[j]
+			}
+		}
+	}
+
+	for i, tc := range tile.components {
+		reversible := tc.coding.reversible
+
+		// the forward discrete wavelet transformation, F.4,
+		// into the coefficients of each subband
+		bands := make([][][]float64, len(tc.resolutions))
+		current := values[i]
+		for r := len(tc.resolutions) - 1; r >= 0; r-- {
+			res := tc.resolutions[r]
+			bands[r] = make([][]float64, len(res.bands))
+			for j, band := range res.bands {
+				bands[r][j] = make([]float64, (band.x1-band.x0)*(band.y1-band.y0))
+			}
+			if r == 0 {
+				copy(bands[0][0], current)
+				break
+			}
+
+			width, height := res.x1-res.x0, res.y1-res.y0
+			if width == 0 || height == 0 {
+				current = nil
+				continue
+			}
+			column := make([]float64, height)
+			for x := 0; x < width; x++ {
+				for y := range column {
+					column[y] = current[y*width+x]
+				}
+				jpxForward1D(column, res.y0, reversible)
+				for y, v := range column {
+					current[y*width+x] = v
+				}
+			}
+			for y := 0; y < height; y++ {
+				jpxForward1D(current[y*width:(y+1)*width], res.x0, reversible)
+			}
+
+			low := tc.resolutions[r-1]
+			next := make([]float64, (low.x1-low.x0)*(low.y1-low.y0))
+			for y := res.y0; y < res.y1; y++ {
+				for x := res.x0; x < res.x1; x++ {
+					v := current[(y-res.y0)*width+x-res.x0]
+					if o := x%2 + 2*(y%2); o > 0 {
+						band := res.bands[o-1]
+						bands[r][o-1][(y/2-band.y0)*(band.x1-band.x0)+x/2-band.x0] = v
+					} else {
+						next[(y/2-low.y0)*(low.x1-low.x0)+x/2-low.x0] = v
+					}
+				}
+			}
+			current = next
+		}
+
+		// the quantized coefficients of each block, with those of
+		// the left half of the subbands shifted for the region of interest
+		roiShift := p.roiShift[i]
+		for r, res := range tc.resolutions {
+			for j, band := range res.bands {
+				planes, step := o.step(i, r, band.orientation)
+				width := band.x1 - band.x0
+				for _, precinct := range band.precincts {
+					for _, block := range precinct.blocks {
+						coefficients := []int64{}
+						for y := block.y0; y < block.y1; y++ {
+							for x := block.x0; x < block.x1; x++ {
+								v := bands[r][j][(y-band.y0)*width+x-band.x0]
+								q := int64(math.Abs(v) / step)
+								if roiShift > 0 && x-band.x0 < width/2 {
+									q <<= uint(roiShift)
+								}
+								if v < 0 {
+									q = -q
+								}
+								coefficients = append(coefficients, q)
+							}
+						}
+
+						b := &jpxTestBlock{lengthBits: 3}
+						b.segments, b.passes, b.zeroPlanes = encodeJPXBlock(t, block, coefficients, band.orientation, planes+roiShift, tc.coding.blockStyle)
+						for l := 0; l < p.layers; l++ {
+							b.layerEnds = append(b.layerEnds, b.passes*(l+1)/p.layers)
+						}
+						tile.blocks[block] = b
+					}
+
+					// the first layer including each block
+					if len(precinct.blocks) == 0 {
+						continue
+					}
+					height := len(precinct.blocks) / precinct.blocksWide
+					inclusion, zeroPlanes := []int{}, []int{}
+					for _, block := range precinct.blocks {
+						b := tile.blocks[block]
+						layer := 0
+						for layer < p.layers && b.layerEnds[layer] == 0 {
+							layer++
+						}
+						inclusion = append(inclusion, layer)
+						zeroPlanes = append(zeroPlanes, b.zeroPlanes)
+					}
+					tile.precincts[precinct] = &jpxTestPrecinct{
+						inclusion:  newJPXTestTagTree(inclusion, precinct.blocksWide, height),
+						zeroPlanes: newJPXTestTagTree(zeroPlanes, precinct.blocksWide, height),
+					}
+				}
+			}
+		}
+	}
+
+	tile.progression()
+}
+
+// progression codes the packets in the progression order,
+// with the loops of B.12.1 rather than those of the decoder
+func (tile *jpxTestTile) progression() {
+	p := &tile.t.parameters
+	changes := p.changes
+	if len(changes) == 0 {
+		changes = []jpxProgressionChange{{resolutionEnd: 33, componentEnd: len(tile.components), layerEnd: p.layers, order: p.order}}
+	}
+
+	// the precinct of resolution r of component i at x, y, when it
+	// is the first position of the precinct in the tile, B.12.1.3
+	precinct := func(i, r, x, y int) (int, bool) {
+		tc := tile.components[i]
+		if r >= len(tc.resolutions) {
+			return 0, false
+		}
+		res := tc.resolutions[r]
+		if len(res.layers) == 0 {
+			return 0, false
+		}
+		shift := uint(len(tc.resolutions) - 1 - r)
+		first := func(v, t0, d, r0 int, size uint) bool {
+			return v%(d<<(size+shift)) == 0 || v == t0 && (r0<<shift)%(1<<(size+shift)) != 0
+		}
+		if !first(y, tile.t.y0, tc.dy, res.y0, res.precinctHeight) || !first(x, tile.t.x0, tc.dx, res.x0, res.precinctWidth) {
+			return 0, false
+		}
+		px := ceilDiv(x, tc.dx<<shift)>>res.precinctWidth - res.x0>>res.precinctWidth
+		py := ceilDiv(y, tc.dy<<shift)>>res.precinctHeight - res.y0>>res.precinctHeight
+		return py*res.precinctsWide + px, true
+	}
+
+	for _, change := range changes {
+		layers := min(change.layerEnd, p.layers)
+		components := min(change.componentEnd, len(tile.components))
+		packets := func(i, r, k int, l int) {
+			if r < len(tile.components[i].resolutions) && tile.layers[i][r][k] == l && l < layers {
+				tile.layers[i][r][k]++
+				tile.packet(i, r, k, l)
+			}
+		}
+		all := func(i, r, l int) {
+			if r < len(tile.components[i].resolutions) {
+				for k := range tile.components[i].resolutions[r].layers {
+					packets(i, r, k, l)
+				}
+			}
+		}
+		positions := func(i, r, x, y int) {
+			if k, ok := precinct(i, r, x, y); ok {
+				for l := 0; l < layers; l++ {
+					packets(i, r, k, l)
+				}
+			}
+		}
+
+		t := tile.t
+		switch change.order {
+		case jpxLRCP:
+			for l := 0; l < layers; l++ {
+				for r := change.resolutionStart; r < change.resolutionEnd; r++ {
+					for i := change.componentStart; i < components; i++ {
+						all(i, r, l)
+					}
+				}
+			}
+		case jpxRLCP:
+			for r := change.resolutionStart; r < change.resolutionEnd; r++ {
+				for l := 0; l < layers; l++ {
+					for i := change.componentStart; i < components; i++ {
+						all(i, r, l)
+					}
+				}
+			}
+		case jpxRPCL:
+			for r := change.resolutionStart; r < change.resolutionEnd; r++ {
+				for y := t.y0; y < t.y1; y++ {
+					for x := t.x0; x < t.x1; x++ {
+						for i := change.componentStart; i < components; i++ {
+							positions(i, r, x, y)
+						}
+					}
+				}
+			}
+		case jpxPCRL:
+			for y := t.y0; y < t.y1; y++ {
+				for x := t.x0; x < t.x1; x++ {
+					for i := change.componentStart; i < components; i++ {
+						for r := change.resolutionStart; r < change.resolutionEnd; r++ {
+							positions(i, r, x, y)
+						}
+					}
+				}
+			}
+		case jpxCPRL:
+			for i := change.componentStart; i < components; i++ {
+				for y := t.y0; y < t.y1; y++ {
+					for x := t.x0; x < t.x1; x++ {
+						for r := change.resolutionStart; r < change.resolutionEnd; r++ {
+							positions(i, r, x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// packet codes the packet of layer l for precinct k of resolution r
+// of component i, B.9 and B.10
+func (tile *jpxTestTile) packet(i, r, k, l int) {
+	o := tile.options
+	res := tile.components[i].resolutions[r]
+
+	header := &jpxTestBits{}
+	body := []byte{}
+	if o.sop {
+		body = append(body, jpxTestMarker(jpxSOP, be16(len(tile.bodyEnds)))...)
+	}
+
+	empty := true
+	for _, band := range res.bands {
+		for _, block := range band.precincts[k].blocks {
+			b := tile.blocks[block]
+			if b.layerEnds[l] > b.sent {
+				empty = false
+			}
+		}
+	}
+
+	if empty {
+		header.put(0)
+	} else {
+		header.put(1)
+		for _, band := range res.bands {
+			precinct := band.precincts[k]
+			trees := tile.precincts[precinct]
+			for j, block := range precinct.blocks {
+				x, y := j%precinct.blocksWide, j/precinct.blocksWide
+				b := tile.blocks[block]
+				passes := b.layerEnds[l] - b.sent
+				if b.sent == 0 {
+					trees.inclusion.encode(header, x, y, l+1)
+					if passes == 0 {
+						continue
+					}
+					trees.zeroPlanes.encode(header, x, y, b.zeroPlanes+1)
+				} else {
+					header.put(min(passes, 1))
+					if passes == 0 {
+						continue
+					}
+				}
+
+				// Table B.4
+				switch {
+				case passes == 1:
+					header.write(0, 1)
+				case passes == 2:
+					header.write(2, 2)
+				case passes <= 5:
+					header.write(0xc|(passes-3), 4)
+				case passes <= 36:
+					header.write(0x1e0|(passes-6), 9)
+				default:
+					header.write(0xff80|(passes-37), 16)
+				}
+
+				// the pieces of the segments with the passes,
+				// with the data split in proportion to the passes
+				type piece struct {
+					passes int
+					data   []byte
+				}
+				pieces := []piece{}
+				for pass := b.sent; pass < b.layerEnds[l]; {
+					s := 0
+					for s+1 < len(b.segments) && b.segments[s+1].start <= pass {
+						s++
+					}
+					segment := b.segments[s]
+					end := min(segment.start+segment.passes, b.layerEnds[l])
+					length := len(segment.data) * (end - segment.start) / segment.passes
+					pieces = append(pieces, piece{end - pass, segment.data[b.sentBytes:length]})
+					b.sentBytes = length
+					if end == segment.start+segment.passes {
+						b.sentBytes = 0
+					}
+					pass = end
+				}
+				b.sent = b.layerEnds[l]
+
+				increase := 0
+				for _, p := range pieces {
+					for b.lengthBits+increase+bits.Len(uint(p.passes))-1 < bits.Len(uint(len(p.data))) {
+						increase++
+					}
+				}
+				for ; increase > 0; increase-- {
+					header.put(1)
+					b.lengthBits++
+				}
+				header.put(0)
+				for _, p := range pieces {
+					header.write(len(p.data), b.lengthBits+bits.Len(uint(p.passes))-1)
+					body = append(body, p.data...)
+				}
+			}
+		}
+	}
+
+	headerBytes := header.flush()
+	if o.eph {
+		headerBytes = append(headerBytes, 0xff, 0x92)
+	}
+	if o.ppm || o.ppt {
+		tile.headers = append(tile.headers, headerBytes...)
+		tile.body = append(tile.body, body...)
+	} else {
+		if o.sop {
+			tile.body = append(tile.body, body[:6]...)
+			body = body[6:]
+		}
+		tile.body = append(tile.body, headerBytes...)
+		tile.body = append(tile.body, body...)
+	}
+	tile.bodyEnds = append(tile.bodyEnds, len(tile.body))
+	tile.headerEnds = append(tile.headerEnds, len(tile.headers))
+}
+
+// jpxTestSamples returns the samples of the components, a gradient
+// with some noise, the components being different
+func jpxTestSamples(o jpxTestOptions) [][]int32 {
+	samples := [][]int32{}
+	for i, size := range o.components {
+		x0, y0 := ceilDiv(o.x0, size.dx), ceilDiv(o.y0, size.dy)
+		x1, y1 := ceilDiv(o.x0+o.width, size.dx), ceilDiv(o.y0+o.height, size.dy)
+		levels := int64(1) << uint(size.depth)
+		component := []int32{}
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				v := (int64(x)*levels/37 + int64(y)*levels/53 + int64(i)*levels/5) % levels
+				v = (v + int64(x*y*7+i)%11*levels/64) % levels
+				if size.signed {
+					v -= levels / 2
+				}
+				component = append(component, int32(v))
+			}
+		}
+		samples = append(samples, component)
+	}
+	return samples
+}
+
+func jpxTestComponents(n int, size jpxComponentSize) []jpxComponentSize {
+	components := []jpxComponentSize{}
+	for i := 0; i < n; i++ {
+		components = append(components, size)
+	}
+	return components
+}
+
+var (
+	jpxGray8 = []jpxComponentSize{{depth: 8, dx: 1, dy: 1}}
+	jpxRGB8  = jpxTestComponents(3, jpxComponentSize{depth: 8, dx: 1, dy: 1})
+	// components of various sub-samplings
+	jpxSubsampled = []jpxComponentSize{{depth: 8, dx: 1, dy: 1}, {depth: 8, dx: 2, dy: 2}, {depth: 7, dx: 1, dy: 3}}
+)
+
+func TestJPX(t *testing.T) {
+	precincts := [][2]uint{{2, 3}, {3, 3}, {4, 3}, {4, 5}}
+	tests := map[string]struct {
+		options   jpxTestOptions
+		tolerance int32
+	}{
+		"lossless":    {options: jpxTestOptions{width: 33, height: 27, components: jpxGray8, levels: 3}},
+		"no levels":   {options: jpxTestOptions{width: 20, height: 9, components: jpxGray8}},
+		"one sample":  {options: jpxTestOptions{width: 1, height: 1, components: jpxGray8, levels: 2}},
+		"odd offsets": {options: jpxTestOptions{width: 1, height: 3, x0: 1, y0: 3, components: jpxGray8, levels: 2}},
+		"tiles": {options: jpxTestOptions{
+			width: 37, height: 29, x0: 5, y0: 3, tileWidth: 16, tileHeight: 12, tileX0: 3, tileY0: 1,
+			components: jpxRGB8, levels: 2, transform: true,
+		}},
+		"signed": {options: jpxTestOptions{
+			width: 19, height: 23, levels: 2,
+			components: []jpxComponentSize{{depth: 12, signed: true, dx: 1, dy: 1}, {depth: 16, dx: 1, dy: 1}, {depth: 1, dx: 1, dy: 1}},
+		}},
+		"component coding": {options: jpxTestOptions{
+			width: 30, height: 30, components: jpxRGB8, levels: 3, layers: 2, order: jpxRPCL,
+			precincts: precincts, componentCoding: true,
+		}},
+		"code-block styles": {options: jpxTestOptions{
+			width: 40, height: 35, components: jpxGray8, levels: 2, layers: 3, blockWidth: 3, blockHeight: 5,
+			style: jpxBypass | jpxReset | jpxVerticallyCausal | jpxSegmentationSymbols | jpxPredictable,
+		}},
+		"terminate all": {options: jpxTestOptions{
+			width: 40, height: 35, components: []jpxComponentSize{{depth: 12, dx: 1, dy: 1}}, levels: 2, layers: 4,
+			style: jpxTerminateAll | jpxBypass,
+		}},
+		"bypass": {options: jpxTestOptions{
+			width: 40, height: 35, components: []jpxComponentSize{{depth: 12, dx: 1, dy: 1}}, levels: 1, layers: 5,
+			style: jpxBypass,
+		}},
+		"region of interest": {options: jpxTestOptions{
+			width: 30, height: 25, components: jpxRGB8, levels: 2, layers: 2, roi: true, transform: true,
+		}},
+		"markers": {options: jpxTestOptions{
+			width: 50, height: 40, tileWidth: 32, tileHeight: 32, components: jpxRGB8, levels: 2, layers: 3,
+			precincts: precincts[1:], sop: true, eph: true, tileParts: true, unknownLength: true,
+		}},
+		"ppm": {options: jpxTestOptions{
+			width: 50, height: 40, tileWidth: 32, tileHeight: 32, components: jpxRGB8, levels: 2, layers: 3,
+			precincts: precincts[1:], sop: true, eph: true, tileParts: true, ppm: true,
+		}},
+		"ppt": {options: jpxTestOptions{
+			width: 50, height: 40, tileWidth: 32, tileHeight: 32, components: jpxRGB8, levels: 2, layers: 3,
+			precincts: precincts[1:], eph: true, tileParts: true, ppt: true,
+		}},
+		"tile headers": {options: jpxTestOptions{
+			width: 50, height: 40, tileWidth: 20, tileHeight: 32, components: jpxSubsampled, levels: 2, layers: 2,
+			order: jpxCPRL, precincts: precincts[1:], componentCoding: true, tileHeaders: true, tileParts: true,
+			changes: []jpxProgressionChange{
+				{resolutionEnd: 1, componentEnd: 3, layerEnd: 2, order: jpxLRCP},
+				{resolutionEnd: 3, componentEnd: 3, layerEnd: 2, order: jpxPCRL},
+			},
+		}},
+		"progression changes": {options: jpxTestOptions{
+			width: 40, height: 40, components: jpxSubsampled, levels: 3, layers: 3, precincts: precincts,
+			changes: []jpxProgressionChange{
+				{resolutionEnd: 2, componentEnd: 2, layerEnd: 2, order: jpxRLCP},
+				{resolutionStart: 1, resolutionEnd: 4, componentEnd: 3, layerEnd: 3, order: jpxCPRL},
+				{resolutionEnd: 4, componentEnd: 3, layerEnd: 3, order: jpxRPCL},
+			},
+		}},
+		"many components": {options: jpxTestOptions{
+			width: 3, height: 2, levels: 1,
+			components: append(jpxTestComponents(256, jpxComponentSize{depth: 4, dx: 1, dy: 1}), jpxComponentSize{depth: 6, dx: 1, dy: 1}),
+			changes:    []jpxProgressionChange{{resolutionEnd: 2, componentEnd: 257, layerEnd: 1, order: jpxCPRL}},
+		}},
+		"irreversible": {options: jpxTestOptions{
+			width: 33, height: 27, components: jpxRGB8, levels: 3, irreversible: true, transform: true,
+		}, tolerance: 2},
+		"derived quantization": {options: jpxTestOptions{
+			width: 33, height: 27, components: jpxGray8, levels: 2, layers: 2, irreversible: true, derived: true,
+		}, tolerance: 2},
+	}
+	for _, order := range []int{jpxLRCP, jpxRLCP, jpxRPCL, jpxPCRL, jpxCPRL} {
+		tests[fmt.Sprintf("order %d", order)] = struct {
+			options   jpxTestOptions
+			tolerance int32
+		}{options: jpxTestOptions{
+			width: 45, height: 38, x0: 3, y0: 5, tileWidth: 25, tileHeight: 30, components: jpxSubsampled,
+			levels: 3, layers: 3, order: order, precincts: precincts,
+		}}
+	}
+
+	for name, test := range tests {
+		samples := jpxTestSamples(test.options)
+		data := encodeJPXTest(t, test.options, samples)
+
+		img, err := decodeJPX(data, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if img.width != test.options.width || img.height != test.options.height {
+			t.Errorf("%s: expected an image of %d by %d, got %d by %d", name, test.options.width, test.options.height, img.width, img.height)
+			continue
+		}
+		for i, component := range img.components {
+			difference := int32(0)
+			for j, v := range component.samples {
+				d := v - samples[i][j]
+				if d < 0 {
+					d = -d
+				}
+				if d > difference {
+					difference = d
+				}
+			}
+			if difference > test.tolerance {
+				t.Errorf("%s: samples of component %d differ by up to %d", name, i, difference)
+				break
+			}
+		}
+	}
+}
+
+// the packets that were read are decoded
+func TestJPXTruncated(t *testing.T) {
+	options := jpxTestOptions{width: 30, height: 30, components: jpxRGB8, levels: 2, layers: 3, transform: true}
+	samples := jpxTestSamples(options)
+	data := encodeJPXTest(t, options, samples)
+
+	previous := int64(math.MaxInt64)
+	for _, length := range []int{len(data) / 4, len(data) / 2, len(data) * 3 / 4, len(data) - 2} {
+		img, err := decodeJPX(data[:length], nil)
+		if err != nil {
+			t.Errorf("%d bytes: %v", length, err)
+			continue
+		}
+		difference := int64(0)
+		for i, component := range img.components {
+			for j, v := range component.samples {
+				d := int64(v - samples[i][j])
+				difference += d * d
+			}
+		}
+		if difference > previous {
+			t.Errorf("%d bytes: expected the image to be closer with more data", length)
+		}
+		previous = difference
+	}
+	if previous != 0 {
+		t.Error("expected the image without its end of codestream to be the same")
+	}
+
+	for _, length := range []int{0, 2, 20} {
+		_, err := decodeJPX(data[:length], nil)
+		if err == nil {
+			t.Errorf("%d bytes: expected an error", length)
+		}
+	}
+}
+
+func TestJPXLimits(t *testing.T) {
+	options := jpxTestOptions{width: 20, height: 10, components: jpxRGB8, levels: 1}
+	data := encodeJPXTest(t, options, jpxTestSamples(options))
+
+	file := &File{Limits: Limits{MaxStreamSize: 599}}
+	_, err := decodeJPX(data, file)
+	if _, ok := err.(*LimitError); !ok {
+		t.Errorf("expected a LimitError, got %v", err)
+	}
+
+	file.Limits.MaxStreamSize = 600
+	_, err = decodeJPX(data, file)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func jp2TestBox(kind string, contents ...[]byte) []byte {
+	data := bytes.Join(contents, nil)
+	return append(append(be32(8+len(data)), kind...), data...)
+}
+
+// jp2TestFile returns a JP2 file with the codestream
+// and the boxes of the header
+func jp2TestFile(codestream []byte, header ...[]byte) []byte {
+	out := append([]byte{}, jp2Signature...)
+	out = append(out, jp2TestBox("ftyp", []byte("jp2 "), be32(0), []byte("jp2 "))...)
+	out = append(out, jp2TestBox("jp2h", append([][]byte{jp2TestBox("ihdr", make([]byte, 14))}, header...)...)...)
+	return append(out, jp2TestBox("jp2c", codestream)...)
+}
+
+func TestJPXImage(t *testing.T) {
+	// RGBA with the channels in another order
+	options := jpxTestOptions{width: 6, height: 5, levels: 1, components: jpxTestComponents(4, jpxComponentSize{depth: 8, dx: 1, dy: 1})}
+	samples := jpxTestSamples(options)
+	rgba := jp2TestFile(encodeJPXTest(t, options, samples),
+		jp2TestBox("colr", []byte{1, 0, 0}, be32(16)),
+		jp2TestBox("cdef", be16(4),
+			be16(0), be16(0), be16(3),
+			be16(1), be16(1), be16(0),
+			be16(2), be16(0), be16(1),
+			be16(3), be16(0), be16(2)))
+
+	// a palette of 4 bit indexes, of 3 columns of 8 bits
+	palette := []byte{}
+	for i := 0; i < 16; i++ {
+		palette = append(palette, byte(i*16), byte(255-i*16), byte(i*i))
+	}
+	options = jpxTestOptions{width: 7, height: 3, levels: 1, components: []jpxComponentSize{{depth: 4, dx: 1, dy: 1}}}
+	indexes := jpxTestSamples(options)
+	indexed := jp2TestFile(encodeJPXTest(t, options, indexes),
+		jp2TestBox("pclr", be16(16), []byte{3, 7, 7, 7}, palette),
+		jp2TestBox("cmap", []byte{0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 2}))
+
+	// 12 bits, with the color space of the image
+	options = jpxTestOptions{width: 5, height: 4, components: []jpxComponentSize{{depth: 12, dx: 1, dy: 1}}}
+	gray := jpxTestSamples(options)
+	gray12 := encodeJPXTest(t, options, gray)
+
+	file := &File{}
+	stream := newTestImage(t, rgba, Dictionary{
+		Name("Width"):       Integer(6),
+		Name("Height"):      Integer(5),
+		Name("Filter"):      Name("JPXDecode"),
+		Name("SMaskInData"): Integer(1),
+	})
+	img, err := file.Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &image.NRGBA{Stride: 6 * 4, Rect: image.Rect(0, 0, 6, 5)}
+	for j := range samples[0] {
+		expected.Pix = append(expected.Pix, byte(samples[2][j]), byte(samples[3][j]), byte(samples[0][j]), byte(samples[1][j]))
+	}
+	err = compare(img, expected)
+	if err != nil {
+		t.Errorf("RGBA: %v", err)
+	}
+
+	// the samples of the filter are those of the channels
+	decoded, err := file.Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, expected.Pix) {
+		t.Errorf("expected the samples of the channels, got %v", decoded)
+	}
+
+	stream = newTestImage(t, indexed, Dictionary{
+		Name("Width"):  Integer(7),
+		Name("Height"): Integer(3),
+		Name("Filter"): Name("JPXDecode"),
+		// ignored
+		Name("BitsPerComponent"): Integer(1),
+	})
+	img, err = file.Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRGB := &image.RGBA{Stride: 7 * 4, Rect: image.Rect(0, 0, 7, 3)}
+	for _, index := range indexes[0] {
+		expectedRGB.Pix = append(expectedRGB.Pix, palette[3*index:3*index+3]...)
+		expectedRGB.Pix = append(expectedRGB.Pix, 255)
+	}
+	err = compare(img, expectedRGB)
+	if err != nil {
+		t.Errorf("palette: %v", err)
+	}
+
+	stream = newTestImage(t, gray12, Dictionary{
+		Name("Width"):      Integer(5),
+		Name("Height"):     Integer(4),
+		Name("Filter"):     Name("JPXDecode"),
+		Name("ColorSpace"): Name("DeviceGray"),
+		Name("Decode"):     Array{Integer(1), Integer(0)},
+	})
+	img, err = file.Image(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedGray := &image.Gray{Stride: 5, Rect: image.Rect(0, 0, 5, 4)}
+	for _, v := range gray[0] {
+		// scaled to 16 bits, then inverted to 8 bits
+		v16 := (2*int64(v)*65535 + 4095) / (2 * 4095)
+		expectedGray.Pix = append(expectedGray.Pix, uint8(math.Round(float64(65535-v16)*255/65535)))
+	}
+	err = compare(img, expectedGray)
+	if err != nil {
+		t.Errorf("12 bits: %v", err)
+	}
+
+	stream.Dictionary[Name("Width")] = Integer(4)
+	_, err = file.Image(stream)
+	if err == nil {
+		t.Error("expected an error for an image of another size")
+	}
+}
@@ -0,0 +1,421 @@
+package pdf
+
+import "math"
+
+// The coefficient bit modeling of JPEG 2000 code-blocks (ITU-T T.800 Annex D)
+
+// code-block styles, Table A.19
+const (
+	jpxBypass              = 1 << iota // selective arithmetic coding bypass
+	jpxReset                           // reset of the contexts after each pass
+	jpxTerminateAll                    // termination of each pass
+	jpxVerticallyCausal                // vertically causal contexts
+	jpxPredictable                     // predictable termination
+	jpxSegmentationSymbols             // segmentation symbols after each cleanup pass
+)
+
+// the contexts of the bits of code-blocks, Tables D.1 to D.4
+const (
+	jpxSignContext       = 9
+	jpxRefinementContext = 14
+	jpxRunLengthContext  = 17
+	jpxUniformContext    = 18
+	jpxContexts          = 19
+)
+
+// coding passes, in their order for a bit plane after the first
+const (
+	jpxSignificancePass = iota
+	jpxRefinementPass
+	jpxCleanupPass
+)
+
+// the flags of each coefficient
+const (
+	jpxSignificant = 1 << iota
+	jpxNegative
+	jpxVisited // coded in the significance propagation pass of the bit plane
+	jpxRefined
+)
+
+// jpxBlock is a code-block of a subband, with the codeword segments
+// of the coding passes included in the packets that were read
+type jpxBlock struct {
+	x0, y0, x1, y1 int
+
+	included   bool
+	zeroPlanes int
+	lengthBits int // Lblock, B.10.7.1
+
+	passes   int
+	segments []jpxSegment
+}
+
+// jpxSegment is the data of the coding passes from start,
+// which are terminated together
+type jpxSegment struct {
+	start, passes int
+	data          []byte
+}
+
+// add adds the data of the next coding passes of the block, which
+// either continue its last codeword segment or start one
+func (b *jpxBlock) add(style byte, passes int, data []byte) {
+	last := len(b.segments) - 1
+	if last < 0 || b.passes >= jpxSegmentEnd(style, b.segments[last].start) {
+		b.segments = append(b.segments, jpxSegment{start: b.passes})
+		last++
+	}
+	b.segments[last].passes += passes
+	b.segments[last].data = append(b.segments[last].data, data...)
+	b.passes += passes
+}
+
+// jpxBlockDecoder decodes the coefficients of code-blocks,
+// reusing its buffers from one block to the next
+type jpxBlockDecoder struct {
+	width, height int
+	orientation   int
+	causal        bool
+
+	// with a border of one coefficient on each side
+	flags      []uint8
+	magnitudes []uint32
+	lowest     []uint8 // the lowest bit plane that was coded
+
+	contexts mqContexts
+	mq       *mqDecoder
+	raw      *jpxBits // the data of passes that bypass the arithmetic coder
+}
+
+// decode decodes the coefficients of a block of a subband,
+// where planes is the number of bit planes that can be coded
+func (d *jpxBlockDecoder) decode(block *jpxBlock, orientation, planes int, style byte) {
+	d.width, d.height = block.x1-block.x0, block.y1-block.y0
+	d.orientation = orientation
+	d.causal = style&jpxVerticallyCausal != 0
+	size := (d.width + 2) * (d.height + 2)
+	if cap(d.flags) < size {
+		d.flags = make([]uint8, size)
+		d.magnitudes = make([]uint32, size)
+		d.lowest = make([]uint8, size)
+	}
+	d.flags, d.magnitudes, d.lowest = d.flags[:size], d.magnitudes[:size], d.lowest[:size]
+	for i := range d.flags {
+		d.flags[i], d.magnitudes[i], d.lowest[i] = 0, 0, 0
+	}
+	d.reset()
+
+	passes := block.passes
+	if passes > 3*planes-2 {
+		passes = 3*planes - 2
+	}
+	pass := 0
+	for _, segment := range block.segments {
+		// the first pass of a raw segment is a significance
+		// propagation pass, D.6
+		d.mq, d.raw = nil, nil
+		if style&jpxBypass != 0 && pass >= 10 && (pass+2)%3 != jpxCleanupPass {
+			d.raw = &jpxBits{data: segment.data}
+		} else {
+			d.mq = newMQDecoder(segment.data)
+		}
+
+		for end := pass + segment.passes; pass < end && pass < passes; pass++ {
+			plane := uint(planes - 1 - (pass+2)/3)
+			switch (pass + 2) % 3 {
+			case jpxSignificancePass:
+				d.significancePass(plane)
+			case jpxRefinementPass:
+				d.refinementPass(plane)
+			case jpxCleanupPass:
+				d.cleanupPass(plane)
+				if style&jpxSegmentationSymbols != 0 {
+					for i := 0; i < 4; i++ {
+						d.bit(jpxUniformContext)
+					}
+				}
+			}
+			if style&jpxReset != 0 {
+				d.reset()
+			}
+		}
+	}
+}
+
+// reset sets the contexts to their initial states, Table D.7
+func (d *jpxBlockDecoder) reset() {
+	if d.contexts == nil {
+		d.contexts = make(mqContexts, jpxContexts)
+	}
+	for i := range d.contexts {
+		d.contexts[i] = 0
+	}
+	d.contexts[0] = 4 << 1
+	d.contexts[jpxRunLengthContext] = 3 << 1
+	d.contexts[jpxUniformContext] = 46 << 1
+}
+
+// bit returns the next bit, with the context cx unless it is raw
+func (d *jpxBlockDecoder) bit(cx int) int {
+	if d.raw != nil {
+		return d.raw.bit()
+	}
+	return d.mq.decode(d.contexts, cx)
+}
+
+// index returns the index of the coefficient at x, y
+func (d *jpxBlockDecoder) index(x, y int) int {
+	return (y+1)*(d.width+2) + x + 1
+}
+
+// neighbors returns the number of significant horizontal, vertical
+// and diagonal neighbors of the coefficient at x, y, where those of
+// the next stripe are ignored with vertically causal contexts
+func (d *jpxBlockDecoder) neighbors(x, y int) (h, v, diagonal int) {
+	stride := d.width + 2
+	i := d.index(x, y)
+	significant := func(j int) int {
+		return int(d.flags[j] & jpxSignificant)
+	}
+
+	h = significant(i-1) + significant(i+1)
+	v = significant(i - stride)
+	diagonal = significant(i-stride-1) + significant(i-stride+1)
+	if !d.causal || y%4 != 3 {
+		v += significant(i + stride)
+		diagonal += significant(i+stride-1) + significant(i+stride+1)
+	}
+	return h, v, diagonal
+}
+
+// zeroContext returns the context of the significance of the
+// coefficient at x, y, Table D.1
+func (d *jpxBlockDecoder) zeroContext(x, y int) int {
+	h, v, diagonal := d.neighbors(x, y)
+	switch d.orientation {
+	case jpxHL:
+		h, v = v, h
+	case jpxHH:
+		hv := h + v
+		switch {
+		case diagonal >= 3:
+			return 8
+		case diagonal == 2:
+			if hv >= 1 {
+				return 7
+			}
+			return 6
+		case diagonal == 1:
+			if hv >= 2 {
+				return 5
+			}
+			return 3 + hv
+		}
+		if hv >= 2 {
+			return 2
+		}
+		return hv
+	}
+
+	switch {
+	case h == 2:
+		return 8
+	case h == 1 && v >= 1:
+		return 7
+	case h == 1 && diagonal >= 1:
+		return 6
+	case h == 1:
+		return 5
+	case v == 2:
+		return 4
+	case v == 1:
+		return 3
+	case diagonal >= 2:
+		return 2
+	}
+	return diagonal
+}
+
+// signContext returns the context of the sign of the coefficient
+// at x, y, and the bit it is xored with, Tables D.2 and D.3
+func (d *jpxBlockDecoder) signContext(x, y int) (int, int) {
+	stride := d.width + 2
+	i := d.index(x, y)
+	contribution := func(j int) int {
+		switch d.flags[j] & (jpxSignificant | jpxNegative) {
+		case jpxSignificant:
+			return 1
+		case jpxSignificant | jpxNegative:
+			return -1
+		}
+		return 0
+	}
+	clamp := func(v int) int {
+		if v < -1 {
+			return -1
+		}
+		if v > 1 {
+			return 1
+		}
+		return v
+	}
+
+	h := clamp(contribution(i-1) + contribution(i+1))
+	below := 0
+	if !d.causal || y%4 != 3 {
+		below = contribution(i + stride)
+	}
+	v := clamp(contribution(i-stride) + below)
+
+	xor := 0
+	if h < 0 || h == 0 && v < 0 {
+		h, v, xor = -h, -v, 1
+	}
+	cx := jpxSignContext + v
+	if h == 1 {
+		cx += 3
+	}
+	return cx, xor
+}
+
+// decodeSign decodes the sign of the coefficient at x, y,
+// which is now significant in the bit plane
+func (d *jpxBlockDecoder) decodeSign(x, y int, plane uint) {
+	i := d.index(x, y)
+	if d.raw != nil {
+		if d.raw.bit() == 1 {
+			d.flags[i] |= jpxNegative
+		}
+	} else if cx, xor := d.signContext(x, y); d.mq.decode(d.contexts, cx)^xor == 1 {
+		d.flags[i] |= jpxNegative
+	}
+
+	d.flags[i] |= jpxSignificant
+	d.magnitudes[i] |= 1 << plane
+}
+
+// significancePass is the significance propagation pass, D.3.1
+func (d *jpxBlockDecoder) significancePass(plane uint) {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		for x := 0; x < d.width; x++ {
+			for y := y0; y < y0+4 && y < d.height; y++ {
+				i := d.index(x, y)
+				if d.flags[i]&jpxSignificant != 0 {
+					continue
+				}
+				cx := d.zeroContext(x, y)
+				if cx == 0 {
+					continue
+				}
+				d.flags[i] |= jpxVisited
+				d.lowest[i] = uint8(plane)
+				if d.bit(cx) == 1 {
+					d.decodeSign(x, y, plane)
+				}
+			}
+		}
+	}
+}
+
+// refinementPass is the magnitude refinement pass, D.3.3
+func (d *jpxBlockDecoder) refinementPass(plane uint) {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		for x := 0; x < d.width; x++ {
+			for y := y0; y < y0+4 && y < d.height; y++ {
+				i := d.index(x, y)
+				if d.flags[i]&(jpxSignificant|jpxVisited) != jpxSignificant {
+					continue
+				}
+
+				cx := jpxRefinementContext + 2
+				if d.flags[i]&jpxRefined == 0 {
+					cx = jpxRefinementContext
+					if h, v, diagonal := d.neighbors(x, y); h+v+diagonal > 0 {
+						cx++
+					}
+				}
+				d.magnitudes[i] |= uint32(d.bit(cx)) << plane
+				d.flags[i] |= jpxRefined
+				d.lowest[i] = uint8(plane)
+			}
+		}
+	}
+}
+
+// cleanupPass is the cleanup pass, D.3.4, which codes the coefficients
+// that were not coded in the bit plane, with the run-length coding
+// of columns of a stripe without significant coefficients
+func (d *jpxBlockDecoder) cleanupPass(plane uint) {
+	for y0 := 0; y0 < d.height; y0 += 4 {
+		for x := 0; x < d.width; x++ {
+			y := y0
+			if y0+4 <= d.height && d.runLength(x, y0) {
+				if d.bit(jpxRunLengthContext) == 0 {
+					for ; y < y0+4; y++ {
+						d.lowest[d.index(x, y)] = uint8(plane)
+					}
+					continue
+				}
+				y += d.bit(jpxUniformContext) << 1
+				y += d.bit(jpxUniformContext)
+				d.decodeSign(x, y, plane)
+				d.lowest[d.index(x, y)] = uint8(plane)
+				y++
+			}
+
+			for ; y < y0+4 && y < d.height; y++ {
+				i := d.index(x, y)
+				if d.flags[i]&(jpxSignificant|jpxVisited) == 0 {
+					d.lowest[i] = uint8(plane)
+					if d.bit(d.zeroContext(x, y)) == 1 {
+						d.decodeSign(x, y, plane)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range d.flags {
+		d.flags[i] &^= jpxVisited
+	}
+}
+
+// runLength reports whether the column of the stripe starting at
+// x, y is coded in run-length mode
+func (d *jpxBlockDecoder) runLength(x, y int) bool {
+	for j := y; j < y+4; j++ {
+		if d.flags[d.index(x, j)] != 0 || d.zeroContext(x, j) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns the reconstructed coefficient at x, y of the block, E.1.1,
+// from the bit planes that were decoded, with the ROI coefficients
+// scaled down by roiShift, E.2, and then multiplied by the step size
+func (d *jpxBlockDecoder) value(x, y, roiShift int, reversible bool, step float64) float32 {
+	i := d.index(x, y)
+	magnitude := d.magnitudes[i]
+	if magnitude == 0 {
+		return 0
+	}
+
+	// half of the smallest bit plane that was not decoded
+	v := float64(magnitude)
+	if lowest := d.lowest[i]; lowest > 0 {
+		v += math.Ldexp(1, int(lowest)-1)
+	} else if !reversible {
+		v += 0.5
+	}
+	if roiShift > 0 && roiShift < 32 && magnitude >= 1<<uint(roiShift) {
+		v = math.Ldexp(v, -roiShift)
+	}
+
+	v *= step
+	if d.flags[i]&jpxNegative != 0 {
+		v = -v
+	}
+	return float32(v)
+}
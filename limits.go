@@ -0,0 +1,163 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// maximum nesting of arrays and dictionaries
+// when parsing without a File's Limits
+const defaultMaxDepth = 256
+
+var errNestingTooDeep = errors.New("arrays and dictionaries are nested deeper than MaxDepth")
+
+// Limits restrict the resources used to read a File, protecting
+// against damaged or malicious files, such as a small stream
+// that decodes to gigabytes. Limits that are zero or less are
+// not enforced.
+type Limits struct {
+	// MaxStreamSize is the maximum size in bytes of a decoded stream.
+	MaxStreamSize int64
+
+	// MaxDepth is the maximum nesting of arrays and dictionaries
+	// in an object.
+	MaxDepth int
+
+	// MaxObjects is the maximum number of objects
+	// in the cross-reference sections of a file.
+	MaxObjects int
+
+	// MaxXrefSections is the maximum number of cross-reference
+	// sections followed through Prev and XRefStm entries.
+	MaxXrefSections int
+
+	// MaxMemory is the maximum number of bytes decoded from all of
+	// the streams of a File, including its cross-reference streams.
+	// It is a budget shared by every call that decodes a stream,
+	// such as Decode, Reader or a Get of an object in an object
+	// stream. ResetMemory starts the budget over, and WithBudget
+	// gives the calls made with a context a budget of their own.
+	MaxMemory int64
+}
+
+// DefaultLimits are used by Open and Create. They allow
+// streams that decode to 256 MiB, and are meant for untrusted
+// files. MaxMemory is not set, as it would limit everything
+// decoded over the life of a File; WithBudget limits what is
+// decoded by each operation instead.
+var DefaultLimits = Limits{
+	MaxStreamSize: 256 << 20,
+	MaxDepth:      defaultMaxDepth,
+}
+
+// A LimitError is returned when reading a File would exceed one of
+// its Limits.
+type LimitError struct {
+	// Limit is the name of the exceeded field in Limits.
+	Limit string
+
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("exceeded %s of %d", err.Limit, err.Max)
+}
+
+// isLimit reports whether err should stop processing
+// even when the File is Lenient
+func isLimit(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded || err == errNestingTooDeep {
+		return true
+	}
+	_, ok := err.(*LimitError)
+	return ok
+}
+
+// maxDepth returns the depth to use when parsing objects
+func (f *File) maxDepth() int {
+	if f.Limits.MaxDepth <= 0 {
+		return math.MaxInt32
+	}
+	return f.Limits.MaxDepth
+}
+
+// a number of decoded bytes and the most that are allowed
+type budget struct {
+	used int64 // first for 64-bit alignment of atomic operations
+	file *File
+	max  int64
+}
+
+// the key of a budget in a context
+type budgetKey struct{}
+
+// WithBudget returns a context in which the streams of the file
+// decoded by calls given the context, such as DecodeContext and
+// ReaderContext, count against a budget of max bytes instead of
+// MaxMemory. It allows a file to be read in several operations
+// that are each limited, such as the requests of a server.
+// A max of zero or less is not enforced.
+func (f *File) WithBudget(ctx context.Context, max int64) context.Context {
+	return context.WithValue(ctx, budgetKey{}, &budget{file: f, max: max})
+}
+
+// ResetMemory sets the number of bytes counted against
+// MaxMemory to zero, allowing the file to be read further.
+func (f *File) ResetMemory() {
+	atomic.StoreInt64(&f.decoded, 0)
+}
+
+// budget returns the number of bytes decoded by the calls given
+// ctx and the most that are allowed, which are those of the File
+// unless ctx is from WithBudget for the File
+func (f *File) budget(ctx context.Context) (*int64, int64) {
+	if b, ok := ctx.Value(budgetKey{}).(*budget); ok && b.file == f {
+		return &b.used, b.max
+	}
+	return &f.decoded, f.Limits.MaxMemory
+}
+
+// limitReader enforces the File's stream size and memory limits
+// and stops when ctx is done
+type limitReader struct {
+	ctx     context.Context
+	file    *File
+	r       io.Reader
+	read    int64
+	decoded *int64 // counted against the budget
+	max     int64  // of the budget
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	err := lr.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	limits := lr.file.Limits
+
+	// read at most one byte more than allowed to detect
+	// when the limit would be exceeded
+	if max := limits.MaxStreamSize; max > 0 && int64(len(p)) > max-lr.read+1 {
+		p = p[:max-lr.read+1]
+	}
+
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+
+	if max := limits.MaxStreamSize; max > 0 && lr.read > max {
+		return n - int(lr.read-max), &LimitError{Limit: "MaxStreamSize", Max: max}
+	}
+
+	decoded := atomic.AddInt64(lr.decoded, int64(n))
+	if max := lr.max; max > 0 && decoded > max {
+		return n, &LimitError{Limit: "MaxMemory", Max: max}
+	}
+
+	return n, err
+}
@@ -0,0 +1,197 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaxDepth(t *testing.T) {
+	nested := strings.Repeat("[", defaultMaxDepth+1) + strings.Repeat("]", defaultMaxDepth+1)
+	_, _, err := parseObject([]byte(nested))
+	if err != errNestingTooDeep {
+		t.Errorf("expected %v, got %v", errNestingTooDeep, err)
+	}
+
+	nested = strings.Repeat("<< /A ", defaultMaxDepth) + "1" + strings.Repeat(" >>", defaultMaxDepth)
+	_, _, err = parseObject([]byte(nested))
+	if err != nil {
+		t.Errorf("expected nesting of %d to be parsed, got %v", defaultMaxDepth, err)
+	}
+
+	_, _, err = parseNestedObject([]byte("[[[1]]]"), 2)
+	if err != errNestingTooDeep {
+		t.Errorf("expected %v, got %v", errNestingTooDeep, err)
+	}
+}
+
+func TestMaxStreamSize(t *testing.T) {
+	// a small stream that decodes to a lot of data
+	bomb, err := NewStream(make([]byte, 10<<20), Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{Lenient: true, Limits: Limits{MaxStreamSize: 1 << 20}}
+	decoded, err := file.Decode(bomb)
+	if _, ok := err.(*LimitError); !ok {
+		t.Errorf("expected a *LimitError, got %v", err)
+	}
+	if decoded != nil {
+		t.Errorf("expected no data, got %d bytes", len(decoded))
+	}
+
+	// the limit is the largest allowed size
+	file.Limits.MaxStreamSize = 10 << 20
+	decoded, err = file.Decode(bomb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 10<<20 {
+		t.Errorf("expected %d bytes, got %d", 10<<20, len(decoded))
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	stream, err := NewStream(make([]byte, 1000), Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the streams decoded by separate calls are counted together
+	file := &File{Limits: Limits{MaxMemory: 2500}}
+	for i := 0; i < 2; i++ {
+		_, err = file.Decode(stream)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	_, err = file.Decode(stream)
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxMemory" {
+		t.Errorf("expected MaxMemory to be exceeded, got %v", err)
+	}
+
+	// until the budget is started over
+	file.ResetMemory()
+	_, err = file.Decode(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a context can have a budget of its own
+	ctx := file.WithBudget(context.Background(), 1500)
+	_, err = file.DecodeContext(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.DecodeContext(ctx, stream)
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxMemory" || err.Max != 1500 {
+		t.Errorf("expected the budget of 1500 to be exceeded, got %v", err)
+	}
+
+	// which is not used by other files
+	other := &File{}
+	_, err = other.DecodeContext(ctx, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.ResetMemory()
+	file.Limits.MaxMemory = 500
+	_, err = file.Decode(stream)
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxMemory" {
+		t.Errorf("expected MaxMemory to be exceeded by one stream, got %v", err)
+	}
+}
+
+func TestDecodeContext(t *testing.T) {
+	stream, err := NewStream([]byte("data"), Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = (&File{Lenient: true}).DecodeContext(ctx, stream)
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+// writes a file with a cross-reference table for each of prevs,
+// which are the index of the section in its Prev entry or -1 for none.
+// The last section is used by startxref.
+func writeSectionsFile(t *testing.T, prevs []int) (string, func()) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "sections.pdf")
+
+	pdf := &bytes.Buffer{}
+	pdf.WriteString("%PDF-1.7\n")
+	catalog := pdf.Len()
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+
+	// sections are the same length, so their offsets are known in advance
+	section := func(prev int) string {
+		entry := fmt.Sprintf("%20s", "")
+		if prev != -1 {
+			entry = fmt.Sprintf("/Prev %014d", prev)
+		}
+		return fmt.Sprintf("xref\n0 2\n0000000000 65535 f \n%010d 00000 n \ntrailer\n<< /Size 2 /Root 1 0 R %s >>\n", catalog, entry)
+	}
+	start := pdf.Len()
+	length := len(section(-1))
+
+	for _, prev := range prevs {
+		if prev == -1 {
+			pdf.WriteString(section(-1))
+		} else {
+			pdf.WriteString(section(start + prev*length))
+		}
+	}
+	fmt.Fprintf(pdf, "startxref\n%d\n%%%%EOF\n", start+(len(prevs)-1)*length)
+
+	err = ioutil.WriteFile(filename, pdf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestXrefSectionLimits(t *testing.T) {
+	// the last section (2) refers to 1, which refers to 0
+	filename, cleanup := writeSectionsFile(t, []int{-1, 0, 1})
+	defer cleanup()
+
+	file, err := OpenContext(context.Background(), filename, Limits{MaxXrefSections: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	_, err = OpenContext(context.Background(), filename, Limits{MaxXrefSections: 2})
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxXrefSections" {
+		t.Errorf("expected MaxXrefSections to be exceeded, got %v", err)
+	}
+
+	_, err = OpenContext(context.Background(), filename, Limits{MaxObjects: 1})
+	if err, ok := err.(*LimitError); !ok || err.Limit != "MaxObjects" {
+		t.Errorf("expected MaxObjects to be exceeded, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = OpenContext(ctx, filename, DefaultLimits)
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,257 @@
+package pdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// LZW as used by the LZWDecode filter (§7.4.4).
+// Differs from compress/lzw by using an EarlyChange parameter,
+// a maximum code width of 12 bits and a clear-table code
+// that can appear anywhere in the data.
+
+const (
+	lzwClear    = 256
+	lzwEOD      = 257
+	lzwFirst    = 258
+	lzwMaxWidth = 12
+	lzwMaxCodes = 1 << lzwMaxWidth
+)
+
+// earlyChange returns the EarlyChange parameter, which defaults to 1
+func earlyChange(parameters Dictionary) (int, error) {
+	early, ok := parameters[Name("EarlyChange")]
+	if !ok {
+		return 1, nil
+	}
+
+	integer, ok := early.(Integer)
+	if !ok || (integer != 0 && integer != 1) {
+		return 0, fmt.Errorf("invalid EarlyChange: %v", early)
+	}
+
+	return int(integer), nil
+}
+
+// reads codes of varying widths, most significant bit first
+type bitReader struct {
+	r      io.ByteReader
+	bits   uint32 // unread bits, in the low nbits bits
+	nbits  uint
+	offset int // in bits
+}
+
+func (br *bitReader) read(width int) (int, error) {
+	for br.nbits < uint(width) {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		br.bits = br.bits<<8 | uint32(b)
+		br.nbits += 8
+	}
+
+	br.nbits -= uint(width)
+	code := int(br.bits>>br.nbits) & (1<<uint(width) - 1)
+	br.offset += width
+	return code, nil
+}
+
+// writes codes of varying widths, most significant bit first
+type bitWriter struct {
+	data  []byte // complete bytes, not yet written
+	bits  uint32 // pending bits, in the low nbits bits
+	nbits uint
+}
+
+func (bw *bitWriter) write(code int, width int) {
+	bw.bits = bw.bits<<uint(width) | uint32(code)
+	bw.nbits += uint(width)
+	for bw.nbits >= 8 {
+		bw.nbits -= 8
+		bw.data = append(bw.data, byte(bw.bits>>bw.nbits))
+	}
+}
+
+// pad writes the pending bits, padded with zeros to a complete byte
+func (bw *bitWriter) pad() {
+	if bw.nbits > 0 {
+		bw.write(0, int(8-bw.nbits))
+	}
+}
+
+type lzwReader struct {
+	r           *bitReader
+	earlyChange int
+
+	table    [][]byte
+	width    int
+	previous []byte
+
+	pending []byte // decoded, but not yet read
+	err     error
+}
+
+func newLZWReader(r io.Reader, earlyChange int) io.Reader {
+	lr := &lzwReader{
+		r:           &bitReader{r: bufio.NewReader(r)},
+		earlyChange: earlyChange,
+		table:       make([][]byte, lzwFirst, lzwMaxCodes),
+		width:       9,
+	}
+	for i := 0; i < 256; i++ {
+		lr.table[i] = []byte{byte(i)}
+	}
+	return lr
+}
+
+func (lr *lzwReader) Read(p []byte) (int, error) {
+	for len(lr.pending) == 0 {
+		if lr.err != nil {
+			return 0, lr.err
+		}
+		lr.err = lr.decode()
+	}
+
+	n := copy(p, lr.pending)
+	lr.pending = lr.pending[n:]
+	return n, nil
+}
+
+// decode decodes the next code into pending
+func (lr *lzwReader) decode() error {
+	code, err := lr.r.read(lr.width)
+	if err != nil {
+		// io.EOF tolerates missing end of data markers
+		return err
+	}
+
+	switch code {
+	case lzwClear:
+		lr.table = lr.table[:lzwFirst]
+		lr.width = 9
+		lr.previous = nil
+		return nil
+	case lzwEOD:
+		return io.EOF
+	}
+
+	var entry []byte
+	switch {
+	case code < len(lr.table):
+		entry = lr.table[code]
+	case code == len(lr.table) && lr.previous != nil:
+		entry = make([]byte, len(lr.previous)+1)
+		copy(entry, lr.previous)
+		entry[len(lr.previous)] = lr.previous[0]
+	default:
+		return fmt.Errorf("invalid code %d at bit %d", code, lr.r.offset-lr.width)
+	}
+	lr.pending = entry
+
+	if lr.previous != nil && len(lr.table) < lzwMaxCodes {
+		added := make([]byte, len(lr.previous)+1)
+		copy(added, lr.previous)
+		added[len(lr.previous)] = entry[0]
+		lr.table = append(lr.table, added)
+	}
+	lr.previous = entry
+
+	if len(lr.table)+lr.earlyChange >= 1<<uint(lr.width) && lr.width < lzwMaxWidth {
+		lr.width++
+	}
+
+	return nil
+}
+
+type lzwWriter struct {
+	w           io.Writer
+	earlyChange int
+	bits        *bitWriter
+
+	// the table maps prefix code << 8 | next byte to a code
+	table  map[int]int
+	next   int
+	width  int
+	prefix int // -1 before the first byte
+}
+
+func newLZWWriter(w io.Writer, earlyChange int) io.WriteCloser {
+	lw := &lzwWriter{
+		w:           w,
+		earlyChange: earlyChange,
+		bits:        &bitWriter{},
+		table:       map[int]int{},
+		next:        lzwFirst,
+		width:       9,
+		prefix:      -1,
+	}
+	lw.bits.write(lzwClear, lw.width)
+	return lw
+}
+
+func (lw *lzwWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if lw.prefix == -1 {
+			lw.prefix = int(c)
+			continue
+		}
+
+		key := lw.prefix<<8 | int(c)
+		if code, ok := lw.table[key]; ok {
+			lw.prefix = code
+			continue
+		}
+
+		lw.bits.write(lw.prefix, lw.width)
+		lw.table[key] = lw.next
+		lw.next++
+
+		// the decoder adds its entries one code later than the encoder
+		if lw.next-1+lw.earlyChange >= 1<<uint(lw.width) && lw.width < lzwMaxWidth {
+			lw.width++
+		}
+
+		if lw.next == lzwMaxCodes {
+			lw.bits.write(lzwClear, lw.width)
+			lw.table = map[int]int{}
+			lw.next = lzwFirst
+			lw.width = 9
+		}
+
+		lw.prefix = int(c)
+	}
+
+	err := lw.flush()
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (lw *lzwWriter) Close() error {
+	if lw.prefix != -1 {
+		lw.bits.write(lw.prefix, lw.width)
+
+		// the decoder may change width after reading the last code
+		if lw.next+lw.earlyChange >= 1<<uint(lw.width) && lw.width < lzwMaxWidth {
+			lw.width++
+		}
+	}
+	lw.bits.write(lzwEOD, lw.width)
+	lw.bits.pad()
+
+	return lw.flush()
+}
+
+// flush writes the complete bytes of encoded data
+func (lw *lzwWriter) flush() error {
+	if len(lw.bits.data) == 0 {
+		return nil
+	}
+
+	_, err := lw.w.Write(lw.bits.data)
+	lw.bits.data = lw.bits.data[:0]
+	return err
+}
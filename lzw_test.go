@@ -0,0 +1,57 @@
+package pdf
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestLZWRoundTrip(t *testing.T) {
+	// enough data to fill the table and clear it several times
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := [][]byte{
+		[]byte{},
+		[]byte("a"),
+		[]byte("-----A---B"),
+		bytes.Repeat([]byte("TOBEORNOTTOBEORTOBEORNOT"), 1000),
+		random,
+	}
+
+	for _, early := range []int{0, 1} {
+		for n, input := range inputs {
+			parameters := Dictionary{Name("EarlyChange"): Integer(early)}
+			encoded, err := encodeFilter(input, Filter{Name: "LZWDecode", DecodeParms: parameters})
+			if err != nil {
+				t.Errorf("EarlyChange %d test %d: %v", early, n, err)
+				continue
+			}
+
+			decoded, err := decodeFilter("LZWDecode", encoded, parameters)
+			if err != nil {
+				t.Errorf("EarlyChange %d test %d: %v", early, n, err)
+				continue
+			}
+
+			if !bytes.Equal(decoded, input) {
+				t.Errorf("EarlyChange %d test %d: round trip failed, got %d bytes, expected %d", early, n, len(decoded), len(input))
+			}
+		}
+	}
+}
+
+// §7.4.4.2 Example: 45 45 45 45 45 65 45 45 45 66
+func TestLZWEncodeExample(t *testing.T) {
+	input := []byte{45, 45, 45, 45, 45, 65, 45, 45, 45, 66}
+	// codes: 256 45 258 258 65 259 66 257
+	expected := []byte{0x80, 0x0B, 0x60, 0x50, 0x22, 0x0C, 0x0C, 0x85, 0x01}
+
+	encoded, err := encodeFilter(input, Filter{Name: "LZWDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("expected %#v, got %#v", expected, encoded)
+	}
+}
@@ -0,0 +1,262 @@
+package pdf
+
+// The MQ arithmetic decoder used by JBIG2 (ITU-T T.88 Annex E)
+// and the integer decoders built on it (Annex A.2 and A.3).
+
+// a row of the probability estimation table, Table E.1
+type mqState struct {
+	qe         uint32
+	nmps, nlps uint8
+	switchMPS  bool
+}
+
+var mqStates = [...]mqState{
+	{0x5601, 1, 1, true},
+	{0x3401, 2, 6, false},
+	{0x1801, 3, 9, false},
+	{0x0ac1, 4, 12, false},
+	{0x0521, 5, 29, false},
+	{0x0221, 38, 33, false},
+	{0x5601, 7, 6, true},
+	{0x5401, 8, 14, false},
+	{0x4801, 9, 14, false},
+	{0x3801, 10, 14, false},
+	{0x3001, 11, 17, false},
+	{0x2401, 12, 18, false},
+	{0x1c01, 13, 20, false},
+	{0x1601, 29, 21, false},
+	{0x5601, 15, 14, true},
+	{0x5401, 16, 14, false},
+	{0x5101, 17, 15, false},
+	{0x4801, 18, 16, false},
+	{0x3801, 19, 17, false},
+	{0x3401, 20, 18, false},
+	{0x3001, 21, 19, false},
+	{0x2801, 22, 19, false},
+	{0x2401, 23, 20, false},
+	{0x2201, 24, 21, false},
+	{0x1c01, 25, 22, false},
+	{0x1801, 26, 23, false},
+	{0x1601, 27, 24, false},
+	{0x1401, 28, 25, false},
+	{0x1201, 29, 26, false},
+	{0x1101, 30, 27, false},
+	{0x0ac1, 31, 28, false},
+	{0x09c1, 32, 29, false},
+	{0x08a1, 33, 30, false},
+	{0x0521, 34, 31, false},
+	{0x0441, 35, 32, false},
+	{0x02a1, 36, 33, false},
+	{0x0221, 37, 34, false},
+	{0x0141, 38, 35, false},
+	{0x0111, 39, 36, false},
+	{0x0085, 40, 37, false},
+	{0x0049, 41, 38, false},
+	{0x0025, 42, 39, false},
+	{0x0015, 43, 40, false},
+	{0x0009, 44, 41, false},
+	{0x0005, 45, 42, false},
+	{0x0001, 45, 43, false},
+	{0x5601, 46, 46, false},
+}
+
+// mqContexts are the states of the contexts used to decode bits,
+// each is the index in mqStates shifted left by one with the
+// more probable symbol in the lowest bit
+type mqContexts []uint8
+
+// mqDecoder is the decoding procedure of Annex E.3,
+// with the C register split into its high and low halves
+type mqDecoder struct {
+	data  []byte
+	bp    int
+	chigh uint32
+	clow  uint32
+	a     uint32
+	ct    int
+
+	// bytes supplied after the end of the data
+	overrun int
+}
+
+// INITDEC
+func newMQDecoder(data []byte) *mqDecoder {
+	d := &mqDecoder{data: data}
+	d.chigh = uint32(d.byteAt(0))
+	d.byteIn()
+	d.chigh = d.chigh<<7&0xffff | d.clow>>9&0x7f
+	d.clow = d.clow << 7 & 0xffff
+	d.ct -= 7
+	d.a = 0x8000
+	return d
+}
+
+// byteAt returns the byte at i, 0xff past the end of the data
+func (d *mqDecoder) byteAt(i int) byte {
+	if i >= len(d.data) {
+		return 0xff
+	}
+	return d.data[i]
+}
+
+// BYTEIN
+func (d *mqDecoder) byteIn() {
+	if d.bp+1 >= len(d.data) {
+		d.overrun++
+	}
+
+	switch {
+	case d.byteAt(d.bp) == 0xff && d.byteAt(d.bp+1) > 0x8f:
+		// a marker, supply 1 bits
+		d.clow += 0xff00
+		d.ct = 8
+	case d.byteAt(d.bp) == 0xff:
+		d.bp++
+		d.clow += uint32(d.byteAt(d.bp)) << 9
+		d.ct = 7
+	default:
+		d.bp++
+		d.clow += uint32(d.byteAt(d.bp)) << 8
+		d.ct = 8
+	}
+
+	if d.clow > 0xffff {
+		d.chigh += d.clow >> 16
+		d.clow &= 0xffff
+	}
+}
+
+// decode returns the next bit using the context cx, DECODE
+func (d *mqDecoder) decode(contexts mqContexts, cx int) int {
+	state := &mqStates[contexts[cx]>>1]
+	mps := int(contexts[cx] & 1)
+
+	a := d.a - state.qe
+	var bit int
+	if d.chigh < state.qe {
+		// LPS_EXCHANGE
+		if a < state.qe {
+			bit = mps
+			contexts[cx] = state.nmps<<1 | uint8(mps)
+		} else {
+			bit = 1 - mps
+			d.lps(contexts, cx, state, mps)
+		}
+		a = state.qe
+	} else {
+		d.chigh -= state.qe
+		if a&0x8000 != 0 {
+			d.a = a
+			return mps
+		}
+
+		// MPS_EXCHANGE
+		if a < state.qe {
+			bit = 1 - mps
+			d.lps(contexts, cx, state, mps)
+		} else {
+			bit = mps
+			contexts[cx] = state.nmps<<1 | uint8(mps)
+		}
+	}
+
+	// RENORMD
+	for {
+		if d.ct == 0 {
+			d.byteIn()
+		}
+		a <<= 1
+		d.chigh = d.chigh<<1&0xffff | d.clow>>15&1
+		d.clow = d.clow << 1 & 0xffff
+		d.ct--
+		if a&0x8000 != 0 {
+			break
+		}
+	}
+	d.a = a
+
+	return bit
+}
+
+// lps updates the context after decoding its less probable symbol
+func (d *mqDecoder) lps(contexts mqContexts, cx int, state *mqState, mps int) {
+	if state.switchMPS {
+		mps = 1 - mps
+	}
+	contexts[cx] = state.nlps<<1 | uint8(mps)
+}
+
+// ended reports whether the decoder has read well past the end of
+// its data, which only happens when the data is truncated or damaged
+func (d *mqDecoder) ended() bool {
+	return d.overrun > 16
+}
+
+// mqInteger decodes integers with the procedure of Annex A.2,
+// each of the IAx procedures has its own mqInteger
+type mqInteger mqContexts
+
+func newMQInteger() mqInteger {
+	return make(mqInteger, 512)
+}
+
+// decode returns the next integer, ok is false for OOB
+func (contexts mqInteger) decode(d *mqDecoder) (value int64, ok bool) {
+	prev := 1
+	bits := func(n int) int64 {
+		v := int64(0)
+		for i := 0; i < n; i++ {
+			bit := d.decode(mqContexts(contexts), prev)
+			if prev < 256 {
+				prev = prev<<1 | bit
+			} else {
+				prev = (prev<<1|bit)&511 | 256
+			}
+			v = v<<1 | int64(bit)
+		}
+		return v
+	}
+
+	sign := bits(1)
+	switch {
+	case bits(1) == 0:
+		value = bits(2)
+	case bits(1) == 0:
+		value = bits(4) + 4
+	case bits(1) == 0:
+		value = bits(6) + 20
+	case bits(1) == 0:
+		value = bits(8) + 84
+	case bits(1) == 0:
+		value = bits(12) + 340
+	default:
+		value = bits(32) + 4436
+	}
+
+	if sign == 1 {
+		if value == 0 {
+			return 0, false
+		}
+		value = -value
+	}
+	return value, true
+}
+
+// mqSymbolID decodes symbol IDs of length bits with the
+// procedure of Annex A.3, IAID
+type mqSymbolID struct {
+	contexts mqContexts
+	length   uint
+}
+
+func newMQSymbolID(length uint) mqSymbolID {
+	return mqSymbolID{contexts: make(mqContexts, 1<<(length+1)), length: length}
+}
+
+func (id mqSymbolID) decode(d *mqDecoder) int {
+	prev := 1
+	for i := uint(0); i < id.length; i++ {
+		prev = prev<<1 | d.decode(id.contexts, prev)
+	}
+	return prev - 1<<id.length
+}
@@ -0,0 +1,248 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+// mqEncoder is the encoding procedure of Annex E.2,
+// used to make test data for the decoder
+type mqEncoder struct {
+	out []byte // the first byte is before the coded data
+	c   uint32
+	a   uint32
+	ct  int
+}
+
+// INITENC
+func newMQEncoder() *mqEncoder {
+	return &mqEncoder{out: []byte{0}, a: 0x8000, ct: 12}
+}
+
+// ENCODE
+func (e *mqEncoder) encode(contexts mqContexts, cx int, bit int) {
+	state := &mqStates[contexts[cx]>>1]
+	mps := int(contexts[cx] & 1)
+
+	e.a -= state.qe
+	if bit == mps {
+		// CODEMPS
+		if e.a&0x8000 != 0 {
+			e.c += state.qe
+			return
+		}
+		if e.a < state.qe {
+			e.a = state.qe
+		} else {
+			e.c += state.qe
+		}
+		contexts[cx] = state.nmps<<1 | uint8(mps)
+	} else {
+		// CODELPS
+		if e.a < state.qe {
+			e.c += state.qe
+		} else {
+			e.a = state.qe
+		}
+		if state.switchMPS {
+			mps = 1 - mps
+		}
+		contexts[cx] = state.nlps<<1 | uint8(mps)
+	}
+
+	// RENORME
+	for {
+		e.a <<= 1
+		e.c <<= 1
+		e.ct--
+		if e.ct == 0 {
+			e.byteOut()
+		}
+		if e.a&0x8000 != 0 {
+			break
+		}
+	}
+}
+
+// BYTEOUT
+func (e *mqEncoder) byteOut() {
+	b := &e.out[len(e.out)-1]
+	if *b != 0xff && e.c >= 0x8000000 {
+		// carry into the last byte
+		*b++
+		e.c &= 0x7ffffff
+	}
+
+	if *b == 0xff {
+		e.out = append(e.out, byte(e.c>>20))
+		e.c &= 0xfffff
+		e.ct = 7
+		return
+	}
+	e.out = append(e.out, byte(e.c>>19))
+	e.c &= 0x7ffff
+	e.ct = 8
+}
+
+// FLUSH, returns the coded data followed by a marker
+func (e *mqEncoder) flush() []byte {
+	// SETBITS
+	temp := e.c + e.a
+	e.c |= 0xffff
+	if e.c >= temp {
+		e.c -= 0x8000
+	}
+
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	e.c <<= uint(e.ct)
+	e.byteOut()
+
+	if e.out[len(e.out)-1] != 0xff {
+		e.out = append(e.out, 0xff)
+	}
+	return append(e.out[1:], 0xac)
+}
+
+// encodes integers with the procedure of Annex A.2, nil is OOB
+func (contexts mqInteger) encode(e *mqEncoder, value *int64) {
+	prev := 1
+	bits := func(v int64, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bit := int(v>>uint(i)) & 1
+			e.encode(mqContexts(contexts), prev, bit)
+			if prev < 256 {
+				prev = prev<<1 | bit
+			} else {
+				prev = (prev<<1|bit)&511 | 256
+			}
+		}
+	}
+
+	if value == nil {
+		bits(1, 1)
+		bits(0, 1)
+		bits(0, 2)
+		return
+	}
+
+	v := *value
+	if v < 0 {
+		bits(1, 1)
+		v = -v
+	} else {
+		bits(0, 1)
+	}
+
+	switch {
+	case v < 4:
+		bits(0, 1)
+		bits(v, 2)
+	case v < 20:
+		bits(2, 2)
+		bits(v-4, 4)
+	case v < 84:
+		bits(6, 3)
+		bits(v-20, 6)
+	case v < 340:
+		bits(14, 4)
+		bits(v-84, 8)
+	case v < 4436:
+		bits(30, 5)
+		bits(v-340, 12)
+	default:
+		bits(31, 5)
+		bits(v-4436, 32)
+	}
+}
+
+// encodes symbol IDs with the procedure of Annex A.3
+func (id mqSymbolID) encode(e *mqEncoder, symbol int) {
+	prev := 1
+	for i := int(id.length) - 1; i >= 0; i-- {
+		bit := symbol >> uint(i) & 1
+		e.encode(id.contexts, prev, bit)
+		prev = prev<<1 | bit
+	}
+}
+
+// the test sequence for the arithmetic coder of T.88 Annex H.2
+var (
+	mqTestData = []byte{
+		0x00, 0x02, 0x00, 0x51, 0x00, 0x00, 0x00, 0xc0,
+		0x03, 0x52, 0x87, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa,
+		0x82, 0xc0, 0x20, 0x00, 0xfc, 0xd7, 0x9e, 0xf6,
+		0xbf, 0x7f, 0xed, 0x90, 0x4f, 0x46, 0xa3, 0xbf,
+	}
+	mqTestCoded = []byte{
+		0x84, 0xc7, 0x3b, 0xfc, 0xe1, 0xa1, 0x43, 0x04,
+		0x02, 0x20, 0x00, 0x00, 0x41, 0x0d, 0xbb, 0x86,
+		0xf4, 0x31, 0x7f, 0xff, 0x88, 0xff, 0x37, 0x47,
+		0x1a, 0xdb, 0x6a, 0xdf, 0xff, 0xac,
+	}
+)
+
+func TestMQDecoder(t *testing.T) {
+	contexts := make(mqContexts, 1)
+	d := newMQDecoder(mqTestCoded)
+
+	decoded := make([]byte, len(mqTestData))
+	for i := range decoded {
+		for bit := 7; bit >= 0; bit-- {
+			decoded[i] |= byte(d.decode(contexts, 0) << uint(bit))
+		}
+	}
+
+	if !bytes.Equal(decoded, mqTestData) {
+		t.Errorf("expected % x, got % x", mqTestData, decoded)
+	}
+}
+
+func TestMQEncoder(t *testing.T) {
+	contexts := make(mqContexts, 1)
+	e := newMQEncoder()
+	for _, b := range mqTestData {
+		for bit := 7; bit >= 0; bit-- {
+			e.encode(contexts, 0, int(b>>uint(bit))&1)
+		}
+	}
+
+	coded := e.flush()
+	if !bytes.Equal(coded, mqTestCoded) {
+		t.Errorf("expected % x, got % x", mqTestCoded, coded)
+	}
+}
+
+func TestMQInteger(t *testing.T) {
+	values := []int64{0, 1, -1, 3, 4, -19, 20, 83, 84, -339, 340, 4435, 4436, 1 << 31}
+
+	e := newMQEncoder()
+	integers, ids := newMQInteger(), newMQSymbolID(5)
+	for i, v := range values {
+		v := v
+		integers.encode(e, &v)
+		ids.encode(e, i)
+	}
+	integers.encode(e, nil)
+	coded := e.flush()
+
+	d := newMQDecoder(coded)
+	integers, ids = newMQInteger(), newMQSymbolID(5)
+	for i, expected := range values {
+		v, ok := integers.decode(d)
+		if !ok || v != expected {
+			t.Errorf("expected %d, got %d (%v)", expected, v, ok)
+		}
+		id := ids.decode(d)
+		if id != i {
+			t.Errorf("expected symbol %d, got %d", i, id)
+		}
+	}
+	_, ok := integers.decode(d)
+	if ok {
+		t.Error("expected OOB")
+	}
+	if d.ended() {
+		t.Error("read past the end of the data")
+	}
+}
@@ -25,11 +25,13 @@ type Boolean bool
 
 // Integer objects represent mathematical integers.
 // - §7.3.3
-type Integer int32
+type Integer int64
 
 // Real objects represent mathematical real numbers.
+// They are stored with 64-bit precision and written in
+// fixed-point form.
 // - §7.3.3
-type Real float32
+type Real float64
 
 // A String object consists of zero or more bytes.
 // - §7.3.4
@@ -2,7 +2,9 @@ package pdf
 
 import (
 	// "errors"
+	"bytes"
 	"fmt"
+	"math"
 	"path"
 	"reflect"
 	"runtime"
@@ -103,6 +105,10 @@ func TestLiteralStringExample1(t *testing.T) {
 			literal: []byte("(It has zero (0) length.)"),
 			object:  String("It has zero (0) length."),
 		},
+		test{
+			literal: []byte("(An unbalanced \\) and \\\\)"),
+			object:  String("An unbalanced ) and \\"),
+		},
 	})
 }
 
@@ -124,13 +130,6 @@ func TestLiteralStringExample2(t *testing.T) {
 }
 
 // §7.3.4.2 Examples 3, 4, 5
-// These examples deal with how pdf strings should
-// be interpreted. There is nothing in the spec that
-// says that an string with escaped characters which
-// is then interpreted is equivalent to one without
-// escaping those characters (with the exeption in
-// Example 2). These examples are included for
-// completeness.
 func TestLiteralStringExamples345(t *testing.T) {
 	runTests(t, []test{
 		// Example 3
@@ -139,30 +138,91 @@ func TestLiteralStringExamples345(t *testing.T) {
 			object:  String("This string has an end-of-line at the end of it.\n"),
 		},
 		test{
-			literal: []byte("(So does this one.\n)"),
+			literal: []byte("(So does this one.\\n)"),
 			object:  String("So does this one.\n"),
 		},
 		// Example 4
 		test{
 			literal: []byte("(This string contains \\245two octal characters\\307.)"),
-			object:  String("This string contains \\245two octal characters\\307."),
+			object:  String("This string contains \245two octal characters\307."),
 		},
 		// Example 5
 		test{
 			literal: []byte("(\\0053)"),
-			object:  String("\\0053"),
+			object:  String("\0053"),
 		},
 		test{
 			literal: []byte("(\\053)"),
-			object:  String("\\053"),
+			object:  String("+"),
 		},
 		test{
 			literal: []byte("(\\53)"),
-			object:  String("\\53"),
+			object:  String("+"),
 		},
 	})
 }
 
+// §7.3.4.2 Table 3
+func TestLiteralStringEscapes(t *testing.T) {
+	runTests(t, []test{
+		test{
+			literal: []byte("(\\n\\r\\t\\b\\f\\(\\)\\\\)"),
+			object:  String("\n\r\t\b\f()\\"),
+		},
+		// line continuations with each end of line marker
+		test{
+			literal: []byte("(a\\\nb\\\rc\\\r\nd)"),
+			object:  String("abcd"),
+		},
+		// the backslash is ignored before other characters
+		test{
+			literal: []byte("(\\q\\8)"),
+			object:  String("q8"),
+		},
+		// high-order overflow is ignored
+		test{
+			literal: []byte("(\\777)"),
+			object:  String("\xff"),
+		},
+	})
+}
+
+// Strings are escaped when written and read back unchanged
+func TestStringWriteTo(t *testing.T) {
+	tests := []struct {
+		str     String
+		literal string
+	}{
+		{String("a (b) c"), "(a \\(b\\) c)"},
+		{String("\x00\\"), "(\\000\\\\)"},
+		{String("\n\r\t\b\f\x1b\x7f"), "(\\n\\r\\t\\b\\f\\033\\177)"},
+		{String(")\xff("), "(\\)\xff\\()"},
+	}
+
+	for n, test := range tests {
+		buf := &bytes.Buffer{}
+		_, err := test.str.writeTo(buf)
+		if err != nil {
+			t.Errorf("test %v: %v", n, err)
+			continue
+		}
+
+		if buf.String() != test.literal {
+			t.Errorf("test %v: expected %q, got %q", n, test.literal, buf.String())
+		}
+
+		object, _, err := parseObject(buf.Bytes())
+		if err != nil {
+			t.Errorf("test %v: %v", n, err)
+		}
+
+		err = compare(object, test.str)
+		if err != nil {
+			t.Errorf("test %v: %v", n, err)
+		}
+	}
+}
+
 //§7.3.7 Example
 func TestDictionaryExample(t *testing.T) {
 	runTests(t, []test{
@@ -303,6 +363,14 @@ func TestNumericObjects(t *testing.T) {
 			literal: []byte("0"),
 			object:  Integer(0),
 		},
+		test{
+			literal: []byte("5000000000"),
+			object:  Integer(5000000000),
+		},
+		test{
+			literal: []byte("-9223372036854775808"),
+			object:  Integer(-9223372036854775808),
+		},
 		test{
 			literal: []byte("34.5"),
 			object:  Real(34.5),
@@ -327,7 +395,83 @@ func TestNumericObjects(t *testing.T) {
 			literal: []byte("0.0"),
 			object:  Real(0.0),
 		},
+		test{
+			literal: []byte(".5"),
+			object:  Real(.5),
+		},
+	})
+}
+
+// Reals are written in fixed-point form and read back unchanged
+func TestRealWriteTo(t *testing.T) {
+	tests := []struct {
+		real    Real
+		literal string
+	}{
+		{Real(4), "4.0"},
+		{Real(-3.62), "-3.62"},
+		{Real(0.00001), "0.00001"},
+		{Real(123456789.123456), "123456789.123456"},
+		{Real(612.0000000000001), "612.0000000000001"},
+		{Real(math.Copysign(0, -1)), "0.0"},
+		{Real(-0.5), "-0.5"},
+	}
+
+	for n, test := range tests {
+		buf := &bytes.Buffer{}
+		_, err := test.real.writeTo(buf)
+		if err != nil {
+			t.Errorf("test %v: %v", n, err)
+			continue
+		}
+
+		if buf.String() != test.literal {
+			t.Errorf("test %v: expected %q, got %q", n, test.literal, buf.String())
+		}
+
+		object, _, err := parseObject(buf.Bytes())
+		if err != nil {
+			t.Errorf("test %v: %v", n, err)
+		}
+
+		if object != test.real {
+			t.Errorf("test %v: expected %#v after reading back, got %#v", n, test.real, object)
+		}
+	}
+
+	for _, r := range []Real{Real(math.NaN()), Real(math.Inf(1)), Real(math.Inf(-1))} {
+		buf := &bytes.Buffer{}
+		_, err := r.writeTo(buf)
+		if err == nil {
+			t.Errorf("%v: expected an error", r)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%v: expected nothing to be written, got %q", r, buf.String())
+		}
+	}
+}
+
+func TestRoundReals(t *testing.T) {
+	object := roundReals(Array{
+		Real(1.23456789),
+		Integer(7),
+		Dictionary{Name("Real"): Real(-0.987654)},
+		Real(-0.0001),
+	}, 3)
+
+	err := compare(object, Array{
+		Real(1.235),
+		Integer(7),
+		Dictionary{Name("Real"): Real(-0.988)},
+		Real(0),
 	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s := formatReal(-0.0001, 3); s != "0.0" {
+		t.Errorf("expected %q, got %q", "0.0", s)
+	}
 }
 
 //§7.3.4.3 Examples 1, 2
@@ -347,6 +491,11 @@ func TestHexadecimalStringExamples12(t *testing.T) {
 			literal: []byte("<901FA>"),
 			object:  String{0x90, 0x1F, 0xA0},
 		},
+		// white space is ignored
+		test{
+			literal: []byte("<90 1F\nA >"),
+			object:  String{0x90, 0x1F, 0xA0},
+		},
 	})
 }
 
@@ -367,6 +516,34 @@ func TestArrayExample(t *testing.T) {
 }
 
 //§7.3.9
+// damaged objects are errors rather than panics
+func TestParseDamaged(t *testing.T) {
+	for _, damaged := range []string{
+		"<",
+		"<<",
+		"<</A 1 >",
+		"/A#4",
+		"@",
+		"[1 2",
+		"<< /Length 5 >> stream",
+		"<< /Length 5 >> stream\r",
+		"<< /Length 50 >> stream\nshort\nendstream",
+		"<< /Length -1 >> stream\nshort\nendstream",
+	} {
+		_, _, err := parseObject([]byte(damaged))
+		if err == nil {
+			t.Errorf("%q: expected an error", damaged)
+		}
+	}
+
+	for _, damaged := range []string{"1 0 obj", "1 0 obj <<", "1 0 obj << /Length 9 >> stream\n"} {
+		_, _, err := parseIndirectObject([]byte(damaged))
+		if err == nil {
+			t.Errorf("%q: expected an error", damaged)
+		}
+	}
+}
+
 func TestNull(t *testing.T) {
 	runTests(t, []test{
 		test{
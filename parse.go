@@ -2,6 +2,7 @@ package pdf
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 )
 
@@ -13,6 +14,12 @@ import (
 type parseFn func(slice []byte) (Object, int, error)
 
 func parseObject(slice []byte) (Object, int, error) {
+	return parseNestedObject(slice, defaultMaxDepth)
+}
+
+// parseNestedObject parses an object with at most depth levels
+// of nested arrays and dictionaries
+func parseNestedObject(slice []byte, depth int) (Object, int, error) {
 	start, ok := nextNonWhitespace(slice)
 	if !ok {
 		return nil, 0, errors.New("expected a non-whitespace char")
@@ -29,7 +36,7 @@ func parseObject(slice []byte) (Object, int, error) {
 	case 't', 'f':
 		// Boolean §7.3.2
 		parser = parseBoolean
-	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '+', '-':
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '+', '-', '.':
 		// Integer §7.3.3
 		// Real §7.3.3
 		// could also be the start of an object reference
@@ -44,12 +51,22 @@ func parseObject(slice []byte) (Object, int, error) {
 		parser = parseName
 	case '[':
 		// Array §7.3.6
-		parser = parseArray
+		if depth <= 0 {
+			return nil, start, errNestingTooDeep
+		}
+		parser = func(slice []byte) (Object, int, error) {
+			return parseNestedArray(slice, depth-1)
+		}
 	case '<':
-		if slice[start+1] == '<' {
+		if start+1 < len(slice) && slice[start+1] == '<' {
 			// Dictionary §7.3.7
 			// println("Dictionary")
-			parser = parseDictionary
+			if depth <= 0 {
+				return nil, start, errNestingTooDeep
+			}
+			parser = func(slice []byte) (Object, int, error) {
+				return parseNestedDictionary(slice, depth-1)
+			}
 			maybeStream = true
 		} else {
 			// String §7.3.4
@@ -59,7 +76,7 @@ func parseObject(slice []byte) (Object, int, error) {
 		// Null §7.3.9
 		parser = parseNull
 	default:
-		panic(string(slice[start]))
+		return nil, start, fmt.Errorf("unexpected %q", slice[start])
 	}
 
 	object, n, err := parser(slice[start:])
@@ -79,10 +96,13 @@ func parseObject(slice []byte) (Object, int, error) {
 			n += n2
 
 			// consume end of line (§7.3.8.1 paragraph after example)
+			if start+n >= len(slice) {
+				return object, start + n, errors.New("expected end of line marker")
+			}
 			switch slice[start+n] {
 			case 13: // carriage return
 				n++
-				if slice[start+n] != '\n' {
+				if start+n >= len(slice) || slice[start+n] != '\n' {
 					return object, start + n + 1, errors.New("end of line marker cannot have only a carriage return")
 				}
 			case '\n': // new line
@@ -104,6 +124,9 @@ func parseObject(slice []byte) (Object, int, error) {
 					Stream:     slice[start+n:],
 				}
 			} else {
+				if streamLengthInteger < 0 || int64(streamLengthInteger) > int64(len(slice)-start-n) {
+					return object, start + n, fmt.Errorf("stream Length %d is outside of the data", streamLengthInteger)
+				}
 				streamLength := int(streamLengthInteger)
 				object = Stream{
 					Dictionary: dict,
@@ -211,13 +234,14 @@ func index(slice []byte, toFind byte) (int, bool) {
 }
 
 func parseLiteralString(slice []byte) (Object, int, error) {
-	decoded := make([]byte, len(slice))
-	decodedIndex := 0
-
-	if slice[0] != '(' {
-		return String(decoded[:decodedIndex]), 0, errors.New("not a literal string")
+	if len(slice) == 0 || slice[0] != '(' {
+		return String(""), 0, errors.New("not a literal string")
 	}
 
+	// grown as the string is decoded, as allocating
+	// for the rest of slice makes parsing quadratic
+	decoded := []byte{}
+
 	parens := 0
 	i := 0
 	for i < len(slice) {
@@ -231,31 +255,70 @@ func parseLiteralString(slice []byte) (Object, int, error) {
 		case ')':
 			parens--
 			if parens == 0 {
-				return String(decoded[:decodedIndex]), i + 1, nil
+				return String(decoded), i + 1, nil
 			}
 			include = true
-		case '\n':
-			if slice[i-1] == '\\' {
-				decodedIndex--
-				include = false
+		case '\\':
+			// escape sequences from §7.3.4.2 Table 3, escaped
+			// parentheses are not counted
+			if i+1 >= len(slice) {
+				break
 			}
+			i++
+			switch c := slice[i]; c {
+			case 'n':
+				decoded = append(decoded, '\n')
+			case 'r':
+				decoded = append(decoded, '\r')
+			case 't':
+				decoded = append(decoded, '\t')
+			case 'b':
+				decoded = append(decoded, '\b')
+			case 'f':
+				decoded = append(decoded, '\f')
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				// up to three octal digits, high-order overflow is ignored
+				value := c - '0'
+				for n := 1; n < 3 && i+1 < len(slice) && slice[i+1] >= '0' && slice[i+1] <= '7'; n++ {
+					i++
+					value = value<<3 | (slice[i] - '0')
+				}
+				decoded = append(decoded, value)
+			case '\r', '\n':
+				// line continuation, the end of line is not part of the string
+				if c == '\r' && i+1 < len(slice) && slice[i+1] == '\n' {
+					i++
+				}
+				i++
+				continue
+			default:
+				// includes '(', ')' and '\\', the backslash is
+				// ignored for any other character
+				decoded = append(decoded, c)
+			}
+			i++
+			continue
 		}
 
 		if include {
-			decoded[decodedIndex] = slice[i]
-			decodedIndex++
+			decoded = append(decoded, slice[i])
 		}
 		i++
 	}
 
-	return String(decoded[:decodedIndex]), i, errors.New("couldn't find end of string")
+	return String(decoded), i, errors.New("couldn't find end of string")
 }
 
 // returned int is the length of slice consumed
 func parseDictionary(slice []byte) (Object, int, error) {
+	return parseNestedDictionary(slice, defaultMaxDepth)
+}
+
+// parses a dictionary whose values can have depth levels of nesting
+func parseNestedDictionary(slice []byte, depth int) (Object, int, error) {
 	dict := make(Dictionary)
 
-	if slice[0] != '<' && slice[1] != '<' {
+	if len(slice) < 2 || slice[0] != '<' || slice[1] != '<' {
 		return dict, 0, errors.New("not a dictionary")
 	}
 
@@ -269,9 +332,8 @@ func parseDictionary(slice []byte) (Object, int, error) {
 		i += n
 
 		// check to see if end
-		if slice[i] == '>' && slice[i+1] == '>' {
-			i += 2
-			break
+		if slice[i] == '>' && i+1 < len(slice) && slice[i+1] == '>' {
+			return dict, i + 2, nil
 		}
 
 		// get the key
@@ -291,7 +353,7 @@ func parseDictionary(slice []byte) (Object, int, error) {
 
 		// get the value
 		var value Object
-		value, n, err = parseObject(slice[i:])
+		value, n, err = parseNestedObject(slice[i:], depth)
 		if err != nil {
 			return dict, i, err
 		}
@@ -301,15 +363,20 @@ func parseDictionary(slice []byte) (Object, int, error) {
 		dict[key] = value
 	}
 
-	return dict, i, nil
+	return dict, i, errors.New("end of dictionary not found")
 }
 
 func parseName(slice []byte) (Object, int, error) {
-	name := make([]byte, 0, len(slice))
+	if len(slice) == 0 || slice[0] != '/' {
+		return Name(""), 0, errors.New("not a name")
+	}
 
-	if slice[0] != '/' {
-		return Name(name), 0, errors.New("not a name")
+	// allocated for the name, not for the rest of slice
+	end := 1
+	for end < len(slice) && !isDelimiter(slice[end]) && !isWhitespace(slice[end]) {
+		end++
 	}
+	name := make([]byte, 0, end-1)
 
 	i := 1
 	for i < len(slice) {
@@ -319,6 +386,9 @@ func parseName(slice []byte) (Object, int, error) {
 
 		switch slice[i] {
 		case '#':
+			if i+3 > len(slice) {
+				return Name(name), i, errors.New("expected two hexadecimal digits after #")
+			}
 			char, err := strconv.ParseUint(string(slice[i+1:i+3]), 16, 8)
 			if err != nil {
 				return Name(name), i, err
@@ -361,7 +431,7 @@ func parseNumeric(slice []byte) (Object, int, error) {
 	}
 
 	if isInteger {
-		integer, err := strconv.ParseInt(string(token), 10, 0)
+		integer, err := strconv.ParseInt(string(token), 10, 64)
 		if err != nil {
 			return Integer(integer), n, err
 		}
@@ -378,47 +448,61 @@ func parseNumeric(slice []byte) (Object, int, error) {
 }
 
 func parseHexadecimalString(slice []byte) (Object, int, error) {
-	hex := make(String, 0, int(len(slice)/2))
+	// allocated for the digits up to the end of the string,
+	// not for the rest of slice
+	length := len(slice)
+	if end, ok := index(slice, '>'); ok {
+		length = end
+	}
+	hex := make(String, 0, length/2)
 
-	if slice[0] != '<' {
+	if len(slice) == 0 || slice[0] != '<' {
 		return hex, 0, errors.New("not a hexadecimal string")
 	}
 
+	// digits are paired ignoring white space, and a final
+	// unpaired digit is followed by 0 (§7.3.4.3)
+	digits := make([]byte, 0, 2)
 	i := 1
-	for i < len(slice) {
+	for ; i < len(slice); i++ {
 		if slice[i] == '>' {
-			i++
-			break
+			if len(digits) == 1 {
+				b, _ := strconv.ParseUint(string(digits)+"0", 16, 8)
+				hex = append(hex, byte(b))
+			}
+			return hex, i + 1, nil
 		}
 
-		if isHexDigit(slice[i]) && isHexDigit(slice[i+1]) {
-			b, err := strconv.ParseUint(string(slice[i:i+2]), 16, 8)
-			if err != nil {
-				return hex, i, err
-			}
-			hex = append(hex, byte(b))
-			i += 2
+		if isWhitespace(slice[i]) {
 			continue
 		}
+		if !isHexDigit(slice[i]) {
+			return hex, i, errors.New("expected a hexadecimal digit")
+		}
 
-		if isHexDigit(slice[i]) && slice[i+1] == '>' {
-			b, err := strconv.ParseUint(string(slice[i])+"0", 16, 8)
+		digits = append(digits, slice[i])
+		if len(digits) == 2 {
+			b, err := strconv.ParseUint(string(digits), 16, 8)
 			if err != nil {
 				return hex, i, err
 			}
 			hex = append(hex, byte(b))
-			i += 2
-			break
+			digits = digits[:0]
 		}
 	}
 
-	return hex, i, nil
+	return hex, i, errors.New("end of hexadecimal string not found")
 }
 
 func parseArray(slice []byte) (Object, int, error) {
+	return parseNestedArray(slice, defaultMaxDepth)
+}
+
+// parses an array whose elements can have depth levels of nesting
+func parseNestedArray(slice []byte, depth int) (Object, int, error) {
 	array := make(Array, 0)
 
-	if slice[0] != '[' {
+	if len(slice) == 0 || slice[0] != '[' {
 		return array, 0, errors.New("not an array")
 	}
 
@@ -433,7 +517,7 @@ func parseArray(slice []byte) (Object, int, error) {
 			return array, i + 1, nil
 		}
 
-		object, n, err := parseObject(slice[i:])
+		object, n, err := parseNestedObject(slice[i:], depth)
 		if err != nil {
 			return array, i, err
 		}
@@ -491,6 +575,12 @@ func parseObjectReference(slice []byte) (Object, int, error) {
 }
 
 func parseIndirectObject(slice []byte) (Object, int, error) {
+	return parseNestedIndirectObject(slice, defaultMaxDepth)
+}
+
+// parses an indirect object whose object can have
+// depth levels of nested arrays and dictionaries
+func parseNestedIndirectObject(slice []byte, depth int) (Object, int, error) {
 	i := 0
 
 	// Object Number
@@ -523,7 +613,7 @@ func parseIndirectObject(slice []byte) (Object, int, error) {
 
 	// the object
 	var object Object
-	object, n, err = parseObject(slice[i:])
+	object, n, err = parseNestedObject(slice[i:], depth)
 	i += n
 	io.Object = object
 	if err != nil {
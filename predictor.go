@@ -0,0 +1,421 @@
+package pdf
+
+import (
+	"fmt"
+	"io"
+)
+
+// Predictors for FlateDecode and LZWDecode (§7.4.4.4)
+//
+// 	1 no prediction
+// 	2 TIFF Predictor 2
+// 	10-14 PNG predictors (None, Sub, Up, Average, Paeth) on all rows
+// 	15 PNG predictor chosen per row
+//
+// When decoding, the PNG predictor for each row is read from the
+// first byte of the row, so all PNG predictors decode the same way.
+
+// the limits on the parameters, which keep the
+// rows that are allocated to a reasonable size
+const (
+	maxPredictorColors    = 32
+	maxPredictorRowLength = 1 << 24
+)
+
+type predictorParameters struct {
+	predictor        int
+	colors           int
+	bitsPerComponent int
+	columns          int
+}
+
+func newPredictorParameters(dict Dictionary) (predictorParameters, error) {
+	p := predictorParameters{
+		predictor:        1,
+		colors:           1,
+		bitsPerComponent: 8,
+		columns:          1,
+	}
+
+	integers := []struct {
+		name  Name
+		value *int
+	}{
+		{Name("Predictor"), &p.predictor},
+		{Name("Colors"), &p.colors},
+		{Name("BitsPerComponent"), &p.bitsPerComponent},
+		{Name("Columns"), &p.columns},
+	}
+	for _, integer := range integers {
+		obj, ok := dict[integer.name]
+		if !ok {
+			continue
+		}
+
+		value, ok := obj.(Integer)
+		if !ok || value < 1 {
+			return p, fmt.Errorf("invalid %s: %v", integer.name, obj)
+		}
+		*integer.value = int(value)
+	}
+
+	switch p.bitsPerComponent {
+	case 1, 2, 4, 8, 16:
+	default:
+		return p, fmt.Errorf("invalid BitsPerComponent: %d", p.bitsPerComponent)
+	}
+
+	if p.colors > maxPredictorColors {
+		return p, fmt.Errorf("invalid Colors: %d", p.colors)
+	}
+
+	// checked by division, as the row length might overflow
+	if p.columns > maxPredictorRowLength*8/(p.colors*p.bitsPerComponent) {
+		return p, fmt.Errorf("invalid Columns: %d", p.columns)
+	}
+
+	switch {
+	case p.predictor == 1, p.predictor == 2:
+	case p.predictor >= 10 && p.predictor <= 15:
+	default:
+		return p, fmt.Errorf("unknown Predictor: %d", p.predictor)
+	}
+
+	return p, nil
+}
+
+// number of bytes in a row of samples, without the PNG predictor byte
+func (p predictorParameters) rowLength() int {
+	return (p.colors*p.bitsPerComponent*p.columns + 7) / 8
+}
+
+// number of bytes per complete pixel, at least 1
+func (p predictorParameters) bytesPerPixel() int {
+	return (p.colors*p.bitsPerComponent + 7) / 8
+}
+
+// newPredictorReader reverses the predictor specified in parameters
+// on the data read from r
+func newPredictorReader(r io.Reader, parameters Dictionary) (io.Reader, error) {
+	p, err := newPredictorParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.predictor == 1 {
+		return r, nil
+	}
+
+	return &predictorReader{
+		p:        p,
+		r:        r,
+		previous: make([]byte, p.rowLength()),
+	}, nil
+}
+
+type predictorReader struct {
+	p        predictorParameters
+	r        io.Reader
+	previous []byte // the previous decoded row
+	rows     int    // number of rows read
+
+	pending []byte // decoded, but not yet read
+	err     error
+}
+
+func (pr *predictorReader) Read(p []byte) (int, error) {
+	for len(pr.pending) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		pr.err = pr.nextRow()
+	}
+
+	n := copy(p, pr.pending)
+	pr.pending = pr.pending[n:]
+	return n, nil
+}
+
+// nextRow decodes the next row into pending
+func (pr *predictorReader) nextRow() error {
+	rowLength := pr.p.rowLength()
+	if pr.p.predictor >= 10 {
+		rowLength++ // for the PNG predictor byte
+	}
+
+	row := make([]byte, rowLength)
+	n, err := io.ReadFull(pr.r, row)
+	if err == io.ErrUnexpectedEOF {
+		if pr.p.predictor == 2 {
+			// incomplete rows are left as is
+			pr.pending = row[:n]
+		}
+		// incomplete PNG rows are ignored
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+
+	if pr.p.predictor == 2 {
+		pr.p.tiff(row, false)
+		pr.pending = row
+		return nil
+	}
+
+	err = pr.p.unpredictPNG(row[0], row[1:], pr.previous)
+	if err != nil {
+		return fmt.Errorf("%v in row %d", err, pr.rows)
+	}
+	pr.pending = row[1:]
+	pr.previous = row[1:]
+	pr.rows++
+	return nil
+}
+
+// newPredictorWriter applies the predictor specified
+// in parameters to the data written to w
+func newPredictorWriter(w io.Writer, parameters Dictionary) (io.WriteCloser, error) {
+	p, err := newPredictorParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &predictorWriter{
+		p:        p,
+		w:        w,
+		previous: make([]byte, p.rowLength()),
+	}, nil
+}
+
+type predictorWriter struct {
+	p        predictorParameters
+	w        io.Writer
+	previous []byte // the previous unencoded row
+	row      []byte // the incomplete current row
+}
+
+func (pw *predictorWriter) Write(p []byte) (int, error) {
+	if pw.p.predictor == 1 {
+		return pw.w.Write(p)
+	}
+
+	rowLength := pw.p.rowLength()
+	written := len(p)
+	for len(p) > 0 {
+		n := rowLength - len(pw.row)
+		if n > len(p) {
+			n = len(p)
+		}
+		pw.row = append(pw.row, p[:n]...)
+		p = p[n:]
+
+		if len(pw.row) == rowLength {
+			err := pw.writeRow()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (pw *predictorWriter) Close() error {
+	if len(pw.row) == 0 {
+		return nil
+	}
+
+	if pw.p.predictor == 2 {
+		// incomplete rows are left as is
+		_, err := pw.w.Write(pw.row)
+		return err
+	}
+
+	// pad the last row
+	pw.row = append(pw.row, make([]byte, pw.p.rowLength()-len(pw.row))...)
+	return pw.writeRow()
+}
+
+func (pw *predictorWriter) writeRow() error {
+	var encoded []byte
+	if pw.p.predictor == 2 {
+		encoded = append([]byte{}, pw.row...)
+		pw.p.tiff(encoded, true)
+	} else {
+		encoded = pw.p.predictPNG(pw.row, pw.previous)
+	}
+
+	_, err := pw.w.Write(encoded)
+	pw.previous, pw.row = pw.row, pw.previous[:0]
+	return err
+}
+
+// tiff applies (encode) or reverses TIFF Predictor 2 on a row,
+// where each sample is stored as the difference from the same
+// component of the previous pixel in the row
+func (p predictorParameters) tiff(row []byte, encode bool) {
+	bpc := uint(p.bitsPerComponent)
+	mask := 1<<bpc - 1
+
+	// process right to left when encoding
+	// so that the original values are used as the predictions
+	samples := p.columns * p.colors
+	for i := 0; i < samples-p.colors; i++ {
+		sample := i + p.colors
+		if encode {
+			sample = samples - 1 - i
+		}
+
+		left := getSample(row, sample-p.colors, bpc)
+		value := getSample(row, sample, bpc)
+		if encode {
+			value = (value - left) & mask
+		} else {
+			value = (value + left) & mask
+		}
+		setSample(row, sample, bpc, value)
+	}
+}
+
+func getSample(row []byte, n int, bpc uint) int {
+	switch bpc {
+	case 8:
+		return int(row[n])
+	case 16:
+		return int(row[2*n])<<8 | int(row[2*n+1])
+	}
+
+	bit := uint(n) * bpc
+	shift := 8 - bpc - bit%8
+	return int(row[bit/8]>>shift) & (1<<bpc - 1)
+}
+
+func setSample(row []byte, n int, bpc uint, value int) {
+	switch bpc {
+	case 8:
+		row[n] = byte(value)
+		return
+	case 16:
+		row[2*n] = byte(value >> 8)
+		row[2*n+1] = byte(value)
+		return
+	}
+
+	bit := uint(n) * bpc
+	shift := 8 - bpc - bit%8
+	mask := byte(1<<bpc-1) << shift
+	row[bit/8] = row[bit/8]&^mask | byte(value)<<shift&mask
+}
+
+// PNG filter types (the first byte of each row)
+const (
+	pngNone = iota
+	pngSub
+	pngUp
+	pngAverage
+	pngPaeth
+)
+
+// unpredictPNG reverses the PNG filter on row in place
+func (p predictorParameters) unpredictPNG(filter byte, row, previous []byte) error {
+	bpp := p.bytesPerPixel()
+
+	for i := range row {
+		var left, upLeft byte
+		if i >= bpp {
+			left = row[i-bpp]
+			upLeft = previous[i-bpp]
+		}
+		up := previous[i]
+
+		switch filter {
+		case pngNone:
+		case pngSub:
+			row[i] += left
+		case pngUp:
+			row[i] += up
+		case pngAverage:
+			row[i] += byte((int(left) + int(up)) / 2)
+		case pngPaeth:
+			row[i] += paeth(left, up, upLeft)
+		default:
+			return fmt.Errorf("unknown PNG predictor %d", filter)
+		}
+	}
+
+	return nil
+}
+
+// predictPNG returns the row encoded with the predictor's PNG filter,
+// preceded by the filter type
+func (p predictorParameters) predictPNG(row, previous []byte) []byte {
+	bpp := p.bytesPerPixel()
+
+	filters := []byte{byte(p.predictor - 10)}
+	if p.predictor == 15 {
+		filters = []byte{pngNone, pngSub, pngUp, pngAverage, pngPaeth}
+	}
+
+	// use the filter with the smallest sum of absolute differences
+	encoded := make([]byte, len(row))
+	var best []byte
+	bestSum := -1
+	for _, filter := range filters {
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = previous[i-bpp]
+			}
+			up := previous[i]
+
+			switch filter {
+			case pngNone:
+				encoded[i] = row[i]
+			case pngSub:
+				encoded[i] = row[i] - left
+			case pngUp:
+				encoded[i] = row[i] - up
+			case pngAverage:
+				encoded[i] = row[i] - byte((int(left)+int(up))/2)
+			case pngPaeth:
+				encoded[i] = row[i] - paeth(left, up, upLeft)
+			}
+		}
+
+		sum := 0
+		for _, b := range encoded {
+			sum += abs(int(int8(b)))
+		}
+		if bestSum == -1 || sum < bestSum {
+			bestSum = sum
+			best = append(append(best[:0], filter), encoded...)
+		}
+	}
+
+	return best
+}
+
+// paeth predictor from the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa := abs(p - int(a))
+	pb := abs(p - int(b))
+	pc := abs(p - int(c))
+
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
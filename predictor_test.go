@@ -0,0 +1,194 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func predict(data []byte, parameters Dictionary) ([]byte, error) {
+	predicted := &bytes.Buffer{}
+	w, err := newPredictorWriter(predicted, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return predicted.Bytes(), err
+}
+
+func unpredict(data []byte, parameters Dictionary) ([]byte, error) {
+	r, err := newPredictorReader(bytes.NewReader(data), parameters)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestPredictorRoundTrip(t *testing.T) {
+	data := make([]byte, 3*7*2*5)
+	rand.New(rand.NewSource(1)).Read(data)
+	// smooth data exercises the differences more than random data
+	for i := 0; i < len(data)/2; i++ {
+		data[i] = byte(i / 3)
+	}
+
+	for _, predictor := range []int{1, 2, 10, 11, 12, 13, 14, 15} {
+		for _, bpc := range []int{1, 2, 4, 8, 16} {
+			for _, colors := range []int{1, 3} {
+				parameters := Dictionary{
+					Name("Predictor"):        Integer(predictor),
+					Name("Colors"):           Integer(colors),
+					Name("BitsPerComponent"): Integer(bpc),
+					Name("Columns"):          Integer(7),
+				}
+				name := fmt.Sprintf("Predictor %d BitsPerComponent %d Colors %d", predictor, bpc, colors)
+
+				// use complete rows
+				p, _ := newPredictorParameters(parameters)
+				input := data[:len(data)/p.rowLength()*p.rowLength()]
+
+				predicted, err := predict(input, parameters)
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+
+				unpredicted, err := unpredict(predicted, parameters)
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+
+				if !bytes.Equal(unpredicted, input) {
+					t.Errorf("%s: round trip failed\n\t%v\n\t%v", name, input, unpredicted)
+				}
+			}
+		}
+	}
+}
+
+func TestPredictorParametersErrors(t *testing.T) {
+	for _, test := range []struct {
+		parameters Dictionary
+		err        string
+	}{
+		{Dictionary{Name("Predictor"): Integer(7)}, "unknown Predictor: 7"},
+		{Dictionary{Name("Colors"): Integer(0)}, "invalid Colors: 0"},
+		{Dictionary{Name("Colors"): Integer(33)}, "invalid Colors: 33"},
+		{Dictionary{Name("BitsPerComponent"): Integer(3)}, "invalid BitsPerComponent: 3"},
+		{Dictionary{Name("Columns"): Integer(1 << 40)}, "invalid Columns: 1099511627776"},
+		{Dictionary{Name("Colors"): Integer(32), Name("BitsPerComponent"): Integer(16), Name("Columns"): Integer(1 << 20)}, "invalid Columns: 1048576"},
+	} {
+		_, err := newPredictorReader(nil, test.parameters)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v: expected %q, got %v", test.parameters, test.err, err)
+		}
+	}
+}
+
+// §7.4.4.4 TIFF Predictor 2 with 8 bit RGB samples
+func TestTIFFPredictor(t *testing.T) {
+	parameters := Dictionary{
+		Name("Predictor"): Integer(2),
+		Name("Colors"):    Integer(3),
+		Name("Columns"):   Integer(2),
+	}
+
+	decoded, err := unpredict([]byte{10, 20, 30, 1, 2, 3}, parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = compare(decoded, []byte{10, 20, 30, 11, 22, 33})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// Cross-reference streams are usually written with /Predictor 12
+// (PNG Up) over rows of /W [1 2 1] and compressed with FlateDecode.
+func TestCrossReferenceStreamWithPredictor(t *testing.T) {
+	pdf := &bytes.Buffer{}
+	pdf.WriteString("%PDF-1.5\n")
+
+	offsets := []int{}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	}
+	for i, object := range objects {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xrefOffset := pdf.Len()
+
+	rows := [][]byte{
+		{0, 0, 0, 255},
+		{1, byte(offsets[0] >> 8), byte(offsets[0]), 0},
+		{1, byte(offsets[1] >> 8), byte(offsets[1]), 0},
+		{1, byte(xrefOffset >> 8), byte(xrefOffset), 0},
+	}
+	predicted := []byte{}
+	previous := make([]byte, 4)
+	for _, row := range rows {
+		predicted = append(predicted, pngUp)
+		for i := range row {
+			predicted = append(predicted, row[i]-previous[i])
+		}
+		previous = row
+	}
+
+	compressed := &bytes.Buffer{}
+	w := zlib.NewWriter(compressed)
+	w.Write(predicted)
+	w.Close()
+
+	fmt.Fprintf(pdf, "3 0 obj\n<< /Type /XRef /Size 4 /W [1 2 1] /Root 1 0 R /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 4 >> /Length %d >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	fmt.Fprintf(pdf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "xrefstream.pdf")
+
+	err = ioutil.WriteFile(filename, pdf.Bytes(), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = compare(file.Get(file.Root), Dictionary{
+		Name("Type"):  Name("Catalog"),
+		Name("Pages"): ObjectReference{ObjectNumber: 2},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = compare(file.Get(ObjectReference{ObjectNumber: 2}), Dictionary{
+		Name("Type"):  Name("Pages"),
+		Name("Kids"):  Array{},
+		Name("Count"): Integer(0),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
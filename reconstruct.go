@@ -0,0 +1,195 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// reconstructReferences rebuilds the cross-references and trailer of
+// a file whose cross-reference sections cannot be loaded by scanning
+// the file for indirect objects. Later definitions of an object mask
+// earlier ones, as with incremental updates (§7.5.6).
+func (file *File) reconstructReferences(ctx context.Context) (map[uint]interface{}, Dictionary, error) {
+	refs := map[uint]interface{}{}
+	data := file.mmap
+
+	for i := 0; i < len(data); {
+		j := bytes.Index(data[i:], []byte("obj"))
+		if j == -1 {
+			break
+		}
+		j += i
+		i = j + 3
+
+		start, number, generation, ok := objectHeader(data, j)
+		if !ok {
+			continue
+		}
+
+		err := ctx.Err()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		refs[number] = crossReference{1, uint64(start), generation}
+		if max := file.Limits.MaxObjects; max > 0 && len(refs) > max {
+			return nil, nil, &LimitError{Limit: "MaxObjects", Max: int64(max)}
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, nil, errors.New("no objects found")
+	}
+
+	// use the last trailer that has a Root,
+	// filling in from the others
+	trailer := Dictionary{}
+	end := len(data)
+	for {
+		i := bytes.LastIndex(data[:end], []byte("trailer"))
+		if i == -1 {
+			break
+		}
+		end = i
+
+		object, _, err := parseNestedObject(data[i+len("trailer"):], file.maxDepth())
+		dict, ok := object.(Dictionary)
+		if err != nil || !ok {
+			continue
+		}
+		if _, hasRoot := trailer[Name("Root")]; !hasRoot {
+			if root, ok := dict[Name("Root")]; ok {
+				trailer[Name("Root")] = root
+			}
+		}
+		for _, name := range []Name{"Info", "ID", "Encrypt"} {
+			if _, ok := trailer[name]; !ok && dict[name] != nil {
+				trailer[name] = dict[name]
+			}
+		}
+	}
+
+	// find the objects in object streams and, for files with
+	// cross-reference streams instead of trailers, the Root
+	file.objects = refs
+	var catalog uint
+	var size uint
+	numbers := []uint{}
+	for number := range refs {
+		numbers = append(numbers, number)
+	}
+	for _, number := range numbers {
+		if number >= size {
+			size = number + 1
+		}
+
+		err := ctx.Err()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch typed := file.Get(ObjectReference{ObjectNumber: number}).(type) {
+		case Stream:
+			switch typed.Dictionary[Name("Type")] {
+			case Name("XRef"):
+				if _, ok := trailer[Name("Root")]; !ok && typed.Dictionary[Name("Root")] != nil {
+					trailer[Name("Root")] = typed.Dictionary[Name("Root")]
+					trailer[Name("Info")] = typed.Dictionary[Name("Info")]
+					trailer[Name("ID")] = typed.Dictionary[Name("ID")]
+				}
+			case Name("ObjStm"):
+				stream, err := file.Decode(typed)
+				if err != nil && !isWarning(err) {
+					continue
+				}
+
+				n, _ := typed.Dictionary[Name("N")].(Integer)
+				offset := 0
+				for index := 0; index < int(n); index++ {
+					obj, length, err := parseNumeric(stream[offset:])
+					inStream, ok := obj.(Integer)
+					if err != nil || !ok || inStream < 0 {
+						break
+					}
+					_, length2, _ := parseNumeric(stream[offset+length:])
+					offset += length + length2
+
+					// objects defined directly in the file take precedence
+					if _, ok := refs[uint(inStream)]; !ok {
+						refs[uint(inStream)] = crossReference{2, uint64(number), uint64(index)}
+						if uint(inStream) >= size {
+							size = uint(inStream) + 1
+						}
+					}
+				}
+			}
+		case Dictionary:
+			if typed[Name("Type")] == Name("Catalog") {
+				catalog = number
+			}
+		}
+	}
+
+	if _, ok := trailer[Name("Root")].(ObjectReference); !ok {
+		if catalog == 0 {
+			return nil, nil, errors.New("no catalog found")
+		}
+		trailer[Name("Root")] = ObjectReference{ObjectNumber: catalog}
+	}
+
+	for name, value := range trailer {
+		if value == nil {
+			delete(trailer, name)
+		}
+	}
+	trailer[Name("Size")] = Integer(size)
+
+	return refs, trailer, nil
+}
+
+// objectHeader checks that the "obj" keyword at i is preceded by an
+// object number and generation number, returning the offset of the
+// object number
+func objectHeader(data []byte, i int) (int, uint, uint64, bool) {
+	// "obj" must be a complete token
+	if i+3 < len(data) && !isWhitespace(data[i+3]) && !isDelimiter(data[i+3]) {
+		return 0, 0, 0, false
+	}
+
+	// reads a number backwards from end, after whitespace
+	number := func(end int) (int, uint64, bool) {
+		start := end
+		for start > 0 && isWhitespace(data[start-1]) {
+			start--
+		}
+		if start == end {
+			return 0, 0, false
+		}
+		end = start
+
+		value, scale := uint64(0), uint64(1)
+		for start > 0 && '0' <= data[start-1] && data[start-1] <= '9' && end-start < 10 {
+			start--
+			value += uint64(data[start]-'0') * scale
+			scale *= 10
+		}
+		return start, value, start < end
+	}
+
+	start, generation, ok := number(i)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	start, objectNumber, ok := number(start)
+	if !ok || objectNumber == 0 {
+		return 0, 0, 0, false
+	}
+
+	// the object number must be a complete token
+	if start > 0 && !isWhitespace(data[start-1]) && !isDelimiter(data[start-1]) {
+		return 0, 0, 0, false
+	}
+
+	return start, uint(objectNumber), generation, true
+}
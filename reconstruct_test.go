@@ -0,0 +1,183 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXrefLoop(t *testing.T) {
+	// 0 and 1 refer to each other
+	filename, cleanup := writeSectionsFile(t, []int{1, 0})
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	eof := bytes.LastIndex(file.mmap, []byte("%%EOF"))
+	_, _, err = file.loadReferenceChain(context.Background(), eof)
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("expected a loop to be detected, got %v", err)
+	}
+
+	// the file was reconstructed
+	err = compare(file.Get(file.Root), Dictionary{Name("Type"): Name("Catalog")})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaveReconstructed(t *testing.T) {
+	filename, cleanup := writeSectionsFile(t, []int{1, 0})
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the broken sections are not referred to by the next save
+	if file.prev != 0 {
+		t.Errorf("expected no previous section, got %d", file.prev)
+	}
+
+	added, err := file.Add(String("added"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// the saved section has all of the references
+	if _, ok := file.Trailer()[Name("Prev")]; ok {
+		t.Errorf("expected no Prev, got %v", file.Trailer()[Name("Prev")])
+	}
+	eof := bytes.LastIndex(file.mmap, []byte("%%EOF"))
+	_, _, err = file.loadReferenceChain(context.Background(), eof)
+	if err != nil {
+		t.Errorf("expected the saved references to load, got %v", err)
+	}
+	err = compare(file.Get(file.Root), Dictionary{Name("Type"): Name("Catalog")})
+	if err != nil {
+		t.Error(err)
+	}
+	err = compare(file.Get(added), String("added"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconstructReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"out of range": "startxref\n999999\n%%EOF\n",
+		"not an xref":  "startxref\n9\n%%EOF\n",
+		"missing":      "%%EOF\n",
+	}
+
+	for name, ending := range tests {
+		filename := filepath.Join(dir, "damaged.pdf")
+		pdf := "%PDF-1.7\n" +
+			"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+			"2 0 obj\n(old)\nendobj\n" +
+			"trailer\n<< /Root 1 0 R /Info 3 0 R >>\n" +
+			// an incremental update
+			"2 0 obj\n(new)\nendobj\n" +
+			"3 0 obj\n<< /Title (damaged) >>\nendobj\n" +
+			ending
+		err := ioutil.WriteFile(filename, []byte(pdf), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := Open(filename)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if file.Root != (ObjectReference{ObjectNumber: 1}) {
+			t.Errorf("%s: expected Root 1 0 R, got %v", name, file.Root)
+		}
+		if file.Info != (ObjectReference{ObjectNumber: 3}) {
+			t.Errorf("%s: expected Info 3 0 R, got %v", name, file.Info)
+		}
+		if file.size != 4 {
+			t.Errorf("%s: expected Size 4, got %d", name, file.size)
+		}
+
+		err = compare(file.Get(ObjectReference{ObjectNumber: 2}), String("new"))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		file.Close()
+	}
+}
+
+// §7.5.7
+func TestObjectStreamExtends(t *testing.T) {
+	newObjectStream := func(t *testing.T, header, objects string, extends uint) Stream {
+		stream, err := NewStream([]byte(header + objects))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Dictionary[Name("Type")] = Name("ObjStm")
+		stream.Dictionary[Name("N")] = Integer(strings.Count(header, " ") / 2)
+		stream.Dictionary[Name("First")] = Integer(len(header))
+		if extends != 0 {
+			stream.Dictionary[Name("Extends")] = ObjectReference{ObjectNumber: extends}
+		}
+		return stream
+	}
+
+	file := &File{objects: map[uint]interface{}{
+		10: IndirectObject{Object: newObjectStream(t, "11 0 ", "(eleven)", 20)},
+		20: IndirectObject{Object: newObjectStream(t, "12 0 ", "(twelve)", 10)},
+		11: crossReference{2, 10, 0},
+		// the cross-reference is wrong, but 10 Extends 20
+		12: crossReference{2, 10, 0},
+		// 10 and 20 Extend each other
+		13: crossReference{2, 10, 0},
+		// an object stream in an object stream
+		14: crossReference{2, 15, 0},
+		15: crossReference{2, 14, 0},
+	}}
+
+	err := compare(file.Get(ObjectReference{ObjectNumber: 11}), String("eleven"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = compare(file.Get(ObjectReference{ObjectNumber: 12}), String("twelve"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, number := range []uint{13, 14} {
+		if _, ok := file.Get(ObjectReference{ObjectNumber: number}).(Null); !ok {
+			t.Errorf("%d: expected Null", number)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package pdf
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
 	"strconv"
 )
 
@@ -16,7 +16,7 @@ import (
 // 0 number_of_next_free_object generation_number_if_used_again
 // 1 byte_offset_of_object generation_number
 // 2 object_number_of_object_stream_containing_this_object index_of_this_object_in_object_stream
-type crossReference [3]uint
+type crossReference [3]uint64
 
 type crossReferences map[Integer]crossReference
 
@@ -31,101 +31,233 @@ type crossReferences map[Integer]crossReference
 // indirect object, then method 2 is used. Otherwise if the
 // trailer has an XRefStm entry, then method 3 is used.
 // Otherwise method 1 is used.
-func (file *File) loadReferences() error {
+//
+// When the cross-references cannot be loaded, they are
+// reconstructed from the objects in the file.
+func (file *File) loadReferences(ctx context.Context) error {
 	// find EOF tag to ignore junk in the file after it
 	eofOffset := bytes.LastIndex(file.mmap, []byte("%%EOF"))
 	if eofOffset == -1 {
 		return errors.New("file does not have PDF ending")
 	}
 
+	refs, trailer, err := file.loadReferenceChain(ctx, eofOffset)
+	if err != nil {
+		if isLimit(err) {
+			return err
+		}
+
+		var err2 error
+		refs, trailer, err2 = file.reconstructReferences(ctx)
+		if err2 != nil {
+			return fmt.Errorf("%v; reconstruction failed: %v", err, err2)
+		}
+
+		// the cross-references in the file are broken, so the
+		// next save writes all of the references without a Prev
+		file.prev = 0
+	}
+
+	if max := file.Limits.MaxObjects; max > 0 && len(refs) > max {
+		return &LimitError{Limit: "MaxObjects", Max: int64(max)}
+	}
+
+	file.objects = refs
+
+	size, ok := trailer[Name("Size")].(Integer)
+	if !ok || size < 0 {
+		return fmt.Errorf("invalid trailer Size: %v", trailer[Name("Size")])
+	}
+	file.size = uint(size)
+	file.trailer = trailer
+
+	// fill in values from the trailer
+	if root, ok := trailer[Name("Root")].(ObjectReference); ok {
+		file.Root = root
+	}
+
+	if encrypt, ok := trailer[Name("Encrypt")].(Dictionary); ok {
+		file.Encrypt = encrypt
+	}
+
+	if info, ok := trailer[Name("Info")].(ObjectReference); ok {
+		file.Info = info
+	}
+
+	if id, ok := trailer[Name("ID")]; ok {
+		file.ID = parseFileIdentifier(id)
+	}
+
+	return nil
+}
+
+// loadReferenceChain loads the cross-reference section at startxref
+// and the sections it refers to, merging their references and trailers
+func (file *File) loadReferenceChain(ctx context.Context, eofOffset int) (map[uint]interface{}, Dictionary, error) {
 	// find last startxref
-	startxrefOffset := bytes.LastIndex(file.mmap, []byte("startxref"))
+	startxrefOffset := bytes.LastIndex(file.mmap[:eofOffset], []byte("startxref"))
 	if startxrefOffset == -1 {
-		return errors.New("could not find startxref")
+		return nil, nil, errors.New("could not find startxref")
 	}
 
 	digits := "0123456789"
-	xrefStart := bytes.IndexAny(file.mmap[startxrefOffset:], digits)
+	xrefStart := bytes.IndexAny(file.mmap[startxrefOffset:eofOffset], digits)
 	if xrefStart == -1 {
-		return errors.New("could not find beginning of startxref reference")
+		return nil, nil, errors.New("could not find beginning of startxref reference")
 	}
 	xrefStart += startxrefOffset
 	xrefEnd := bytes.LastIndexAny(file.mmap[xrefStart:eofOffset], digits)
 	if xrefEnd == -1 {
-		return errors.New("could not find end of startxref reference")
+		return nil, nil, errors.New("could not find end of startxref reference")
 	}
 	xrefEnd += xrefStart + 1
 
-	xrefOffset64, err := strconv.ParseUint(string(file.mmap[xrefStart:xrefEnd]), 10, 64)
+	xrefOffset, err := strconv.ParseInt(string(file.mmap[xrefStart:xrefEnd]), 10, 64)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	xrefOffset := int(xrefOffset64)
 
-	refs, trailer, err := file.parseReferences(xrefOffset)
-	if err != nil {
-		return err
+	// sections are followed iteratively, newest first,
+	// so that the newer references mask the older ones
+	refs := map[uint]interface{}{}
+	var trailer Dictionary
+	seen := map[int64]bool{}
+	for offset := xrefOffset; ; {
+		sectionRefs, sectionTrailer, err := file.parseReferences(ctx, offset, seen)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// hybrid references mask the section's references
+		if hybrid, ok := sectionTrailer[Name("XRefStm")]; ok {
+			hybridOffset, ok := hybrid.(Integer)
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid XRefStm: %v", hybrid)
+			}
+
+			hybridRefs, _, err := file.parseReferences(ctx, int64(hybridOffset), seen)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for number, xref := range hybridRefs {
+				sectionRefs[number] = xref
+			}
+		}
+
+		for number, xref := range sectionRefs {
+			if _, ok := refs[number]; !ok {
+				refs[number] = xref
+			}
+		}
+
+		if trailer == nil {
+			trailer = sectionTrailer
+		} else {
+			for name, value := range sectionTrailer {
+				if _, ok := trailer[name]; !ok {
+					trailer[name] = value
+				}
+			}
+		}
+
+		if max := file.Limits.MaxObjects; max > 0 && len(refs) > max {
+			return nil, nil, &LimitError{Limit: "MaxObjects", Max: int64(max)}
+		}
+
+		prev, hasPrev := sectionTrailer[Name("Prev")]
+		if !hasPrev {
+			break
+		}
+		prevOffset, ok := prev.(Integer)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid Prev: %v", prev)
+		}
+		offset = int64(prevOffset)
 	}
 
+	// the next save is an update to the section at startxref,
+	// which is only known to be valid once the chain is loaded
 	file.prev = Integer(xrefOffset)
-	file.objects = refs
-	file.size = uint(trailer[Name("Size")].(Integer))
 
-	// fill in values from the trailer
-	if root, ok := trailer[Name("Root")]; ok {
-		file.Root = root.(ObjectReference)
-	}
+	return refs, trailer, nil
+}
 
-	if encrypt, ok := trailer[Name("Encrypt")]; ok {
-		file.Encrypt = encrypt.(Dictionary)
+// parseReferences parses the cross-reference section at xrefOffset,
+// seen holds the offsets of the sections already parsed
+func (file *File) parseReferences(ctx context.Context, xrefOffset int64, seen map[int64]bool) (map[uint]interface{}, Dictionary, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if info, ok := trailer[Name("Info")]; ok {
-		file.Info = info.(ObjectReference)
+	if seen[xrefOffset] {
+		return nil, nil, fmt.Errorf("cross-reference section at %d is part of a loop", xrefOffset)
 	}
+	seen[xrefOffset] = true
 
-	if id, ok := trailer[Name("ID")]; ok {
-		file.ID = id.(Array)
+	if max := file.Limits.MaxXrefSections; max > 0 && len(seen) > max {
+		return nil, nil, &LimitError{Limit: "MaxXrefSections", Max: int64(max)}
 	}
 
-	// println(string(file.mmap[xrefStart:xrefEnd]), xrefOffset)
-	// println(string(file.mmap[xrefOffset : xrefOffset+200]))
-
-	return nil
-}
-
-// parse and recursively load and merge references and trailer
-func (file *File) parseReferences(xrefOffset int) (map[uint]interface{}, Dictionary, error) {
-	// fmt.Println("parseReferences", xrefOffset)
+	if xrefOffset < 0 || xrefOffset >= int64(len(file.mmap)) {
+		return nil, nil, fmt.Errorf("cross-reference section at %d is outside of the file", xrefOffset)
+	}
 
-	// parse refs, trailer
 	refs := map[uint]interface{}{}
 	var trailer Dictionary
 
+	// count the objects, checking MaxObjects
+	objects := 0
+	countObjects := func(n int) error {
+		objects += n
+		if max := file.Limits.MaxObjects; max > 0 && objects > max {
+			return &LimitError{Limit: "MaxObjects", Max: int64(max)}
+		}
+		return nil
+	}
+
 	switch file.mmap[xrefOffset] {
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		// indirect object and therefore a cross-reference stream §7.5.8
-		xrstreamAsObject, _, err := parseIndirectObject(file.mmap[xrefOffset:])
+		xrstreamAsObject, _, err := parseNestedIndirectObject(file.mmap[xrefOffset:], file.maxDepth())
 		if err != nil {
 			return nil, nil, err
 		}
-		xrstream := xrstreamAsObject.(IndirectObject).Object.(Stream)
+		indirect, _ := xrstreamAsObject.(IndirectObject)
+		xrstream, ok := indirect.Object.(Stream)
+		if !ok {
+			return nil, nil, fmt.Errorf("cross-reference stream at %d is not a stream", xrefOffset)
+		}
 
-		stream, err := xrstream.Decode()
-		if err != nil {
+		stream, err := file.DecodeContext(ctx, xrstream)
+		if err != nil && !isWarning(err) {
 			return nil, nil, err
 		}
 
 		trailer = xrstream.Dictionary
 
-		w := xrstream.Dictionary[Name("W")].(Array)
-		size := int(xrstream.Dictionary[Name("Size")].(Integer))
+		w, ok := xrstream.Dictionary[Name("W")].(Array)
+		if !ok || len(w) != 3 {
+			return nil, nil, fmt.Errorf("invalid W: %v", xrstream.Dictionary[Name("W")])
+		}
+		size, ok := xrstream.Dictionary[Name("Size")].(Integer)
+		if !ok || size < 0 {
+			return nil, nil, fmt.Errorf("invalid Size: %v", xrstream.Dictionary[Name("Size")])
+		}
 
 		wi := []int{}
 		stride := 0
-		for _, integer := range w {
-			stride += int(integer.(Integer))
-			wi = append(wi, int(integer.(Integer)))
+		for _, obj := range w {
+			width, ok := obj.(Integer)
+			if !ok || width < 0 || width > 8 {
+				return nil, nil, fmt.Errorf("invalid W: %v", w)
+			}
+			stride += int(width)
+			wi = append(wi, int(width))
+		}
+		if stride == 0 {
+			return nil, nil, fmt.Errorf("invalid W: %v", w)
 		}
 
 		type index struct {
@@ -137,15 +269,37 @@ func (file *File) parseReferences(xrefOffset int) (map[uint]interface{}, Diction
 		indexArrayAsObject := xrstream.Dictionary[Name("Index")]
 		if indexArrayAsObject == nil {
 			// default when Index is not specified
-			indexes = append(indexes, index{0, size})
+			indexes = append(indexes, index{0, int(size)})
 		} else {
-			indexArray := indexArrayAsObject.(Array)
+			indexArray, ok := indexArrayAsObject.(Array)
+			if !ok || len(indexArray)%2 != 0 {
+				return nil, nil, fmt.Errorf("invalid Index: %v", indexArrayAsObject)
+			}
 			for i := 0; i < len(indexArray); i += 2 {
-				indexes = append(indexes, index{
-					int(indexArray[i].(Integer)),
-					int(indexArray[i+1].(Integer)),
-				})
+				objectNumber, ok1 := indexArray[i].(Integer)
+				count, ok2 := indexArray[i+1].(Integer)
+				if !ok1 || !ok2 || objectNumber < 0 || count < 0 {
+					return nil, nil, fmt.Errorf("invalid Index: %v", indexArray)
+				}
+				indexes = append(indexes, index{int(objectNumber), int(count)})
+			}
+		}
+
+		// entries are counted so that entries*stride cannot overflow
+		entries := 0
+		for _, index := range indexes {
+			err = countObjects(index.size)
+			if err != nil {
+				return nil, nil, err
 			}
+			if index.size > len(stream)/stride-entries {
+				entries = len(stream)/stride + 1
+				break
+			}
+			entries += index.size
+		}
+		if entries*stride > len(stream) {
+			return nil, nil, fmt.Errorf("cross-reference stream at %d has %d bytes for %d entries", xrefOffset, len(stream), entries)
 		}
 
 		offset := 0
@@ -155,9 +309,15 @@ func (file *File) parseReferences(xrefOffset int) (map[uint]interface{}, Diction
 				xref := crossReference{}
 				for i := 0; i < len(wi); i++ {
 					width := wi[i]
-					xref[i] = uint(bytesToInt(stream[offset : offset+width]))
+					xref[i] = bytesToInt(stream[offset : offset+width])
 					offset += width
 				}
+
+				// the type defaults to 1 when its width is 0
+				if wi[0] == 0 {
+					xref[0] = 1
+				}
+
 				refs[uint(objectNumber)] = xref
 				objectNumber++
 			}
@@ -165,11 +325,11 @@ func (file *File) parseReferences(xrefOffset int) (map[uint]interface{}, Diction
 
 	case 'x':
 		// xref table §7.5.4
-		i := xrefOffset
+		i := int(xrefOffset)
 
 		token, n := nextToken(file.mmap[i:])
 		if string(token) != "xref" {
-			log.Fatalln("offset: ", i, "could not match xref")
+			return nil, nil, fmt.Errorf("expected xref at %d", xrefOffset)
 		}
 		i += n
 
@@ -179,69 +339,43 @@ func (file *File) parseReferences(xrefOffset int) (map[uint]interface{}, Diction
 				i += n
 				break
 			}
+			if len(token) == 0 {
+				return nil, nil, fmt.Errorf("could not find the trailer of the cross-reference table at %d", xrefOffset)
+			}
 
-			xrefs, n := parseXrefBlock(file.mmap[i:])
+			xrefs, n, err := parseXrefBlock(file.mmap[i:])
+			if err != nil {
+				return nil, nil, err
+			}
+			err = countObjects(len(xrefs))
+			if err != nil {
+				return nil, nil, err
+			}
 			for objectNumber, xref := range xrefs {
 				refs[uint(objectNumber)] = xref
 			}
 			i += n
 		}
 
-		trailerObj, n, err := parseObject(file.mmap[i:])
-		if err != nil {
-			fmt.Println("XREF TRAILER:", err)
-		}
-		i += n
-
-		trailer = trailerObj.(Dictionary)
-
-	default:
-		fmt.Println(xrefOffset)
-		println(string(file.mmap[xrefOffset : xrefOffset+20]))
-		panic(file.mmap[xrefOffset])
-	}
-
-	// previous references are masked by the current one
-	prev, hasPrev := trailer[Name("Prev")]
-	if hasPrev {
-		prevRefs, prevTrailer, err := file.parseReferences(int(prev.(Integer)))
+		trailerObj, _, err := parseNestedObject(file.mmap[i:], file.maxDepth())
 		if err != nil {
-			return refs, trailer, err
+			return nil, nil, fmt.Errorf("could not parse the trailer: %v", err)
 		}
 
-		for prevRef := range prevRefs {
-			if _, ok := refs[prevRef]; !ok {
-				refs[prevRef] = prevRefs[prevRef]
-			}
+		var ok bool
+		trailer, ok = trailerObj.(Dictionary)
+		if !ok {
+			return nil, nil, fmt.Errorf("trailer is a %T, not a Dictionary", trailerObj)
 		}
 
-		for name := range prevTrailer {
-			if _, ok := trailer[name]; !ok {
-				trailer[name] = prevTrailer[name]
-			}
-		}
-	}
-
-	// hybrid references mask current ones
-	if hybrid, hasHybrid := trailer[Name("XRefStm")]; hasHybrid {
-		hybridRefs, hybridTrailer, err := file.parseReferences(int(hybrid.(Integer)))
-		if err != nil {
-			return refs, trailer, err
-		}
-
-		for hybridRef := range hybridRefs {
-			refs[hybridRef] = hybridRefs[hybridRef]
-		}
-
-		for name := range hybridTrailer {
-			trailer[name] = hybridTrailer[name]
-		}
+	default:
+		return nil, nil, fmt.Errorf("no cross-reference section at %d", xrefOffset)
 	}
 
 	return refs, trailer, nil
 }
 
-func bytesToInt(bytesOfInt []byte) uint {
+func bytesToInt(bytesOfInt []byte) uint64 {
 	// pad bytesOfInt so that it fits an uint64
 	const sizeOfUint64 = 8
 	diff := sizeOfUint64 - len(bytesOfInt)
@@ -254,22 +388,21 @@ func bytesToInt(bytesOfInt []byte) uint {
 	if err != nil {
 		panic(err)
 	}
-	return uint(value)
+	return value
 }
 
 // Number of bytes required to encode value
-func nBytesForInt(value int) int {
+func nBytesForInt(value uint64) int {
 	i := 1
-	for value >= (1 << uint(8*i)) {
+	for i < 8 && value >= (1<<uint(8*i)) {
 		i++
 	}
 	return i
 }
 
-func intToBytes(value uint, size int) []byte {
+func intToBytes(value uint64, size int) []byte {
 	b := &bytes.Buffer{}
-	v := uint64(value)
-	err := binary.Write(b, binary.BigEndian, v)
+	err := binary.Write(b, binary.BigEndian, value)
 	if err != nil {
 		panic(err)
 	}
@@ -278,8 +411,7 @@ func intToBytes(value uint, size int) []byte {
 	return bytesOfInt[len(bytesOfInt)-size:]
 }
 
-func parseXrefBlock(slice []byte) (crossReferences, int) {
-	log.SetFlags(log.Lshortfile)
+func parseXrefBlock(slice []byte) (crossReferences, int, error) {
 	var i int
 	references := crossReferences{}
 
@@ -287,7 +419,7 @@ func parseXrefBlock(slice []byte) (crossReferences, int) {
 	token, n := nextToken(slice[i:])
 	objectNumber, err := strconv.ParseUint(string(token), 10, 64)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, i, err
 	}
 	i += n
 
@@ -295,16 +427,21 @@ func parseXrefBlock(slice []byte) (crossReferences, int) {
 	token, n = nextToken(slice[i:])
 	nObjects, err := strconv.ParseUint(string(token), 10, 64)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, i, err
 	}
 	i += n
 
+	// each entry is 20 bytes
+	if nObjects > uint64(len(slice)-i)/20+1 {
+		return nil, i, fmt.Errorf("cross-reference subsection of %d entries is longer than the file", nObjects)
+	}
+
 	for j := 0; j < int(nObjects); j++ {
 		// offset
 		token, n = nextToken(slice[i:])
 		offset, err := strconv.ParseUint(string(token), 10, 64)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, i, err
 		}
 		i += n
 
@@ -312,7 +449,7 @@ func parseXrefBlock(slice []byte) (crossReferences, int) {
 		token, n = nextToken(slice[i:])
 		generation, err := strconv.ParseUint(string(token), 10, 64)
 		if err != nil {
-			log.Fatalln(err)
+			return nil, i, err
 		}
 		i += n
 
@@ -322,21 +459,21 @@ func parseXrefBlock(slice []byte) (crossReferences, int) {
 		i += n
 
 		var xref crossReference
-		switch entryType[0] {
-		case 'f':
+		switch string(entryType) {
+		case "f":
 			xref[0] = 0
-		case 'n':
+		case "n":
 			xref[0] = 1
 		default:
-			panic(string(entryType))
+			return nil, i, fmt.Errorf("invalid cross-reference entry type %q", entryType)
 		}
 
-		xref[1] = uint(offset)
-		xref[2] = uint(generation)
+		xref[1] = offset
+		xref[2] = generation
 
 		references[Integer(objectNumber)] = xref
 		objectNumber++
 	}
 
-	return references, i
+	return references, i, nil
 }
@@ -1,14 +1,18 @@
 package pdf
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
 func TestBytesToInt(t *testing.T) {
 	type test struct {
 		b []byte
-		v uint
+		v uint64
 	}
 	tests := []test{
 		test{[]byte{0x0, 0x0, 0x0}, 0},
@@ -16,6 +20,8 @@ func TestBytesToInt(t *testing.T) {
 		test{[]byte{0x0, 0x0, 0x01, 0x0}, 256},
 		test{[]byte{0x0, 0x01, 0x0, 0x0}, 65536},
 		test{[]byte{0x0, 0x01, 0x01, 0x0}, 65792},
+		test{[]byte{0x01, 0x0, 0x0, 0x0, 0x0}, 4294967296},
+		test{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 18446744073709551615},
 	}
 
 	for i, test := range tests {
@@ -29,7 +35,7 @@ func TestBytesToInt(t *testing.T) {
 func TestNBytesForInt(t *testing.T) {
 	type test struct {
 		b []byte
-		v int
+		v uint64
 	}
 	tests := []test{
 		test{[]byte{0x0}, 0},
@@ -37,6 +43,8 @@ func TestNBytesForInt(t *testing.T) {
 		test{[]byte{0x01, 0x0}, 256},
 		test{[]byte{0x01, 0x0, 0x0}, 65536},
 		test{[]byte{0x01, 0x01, 0x0}, 65792},
+		test{[]byte{0x01, 0x0, 0x0, 0x0, 0x0}, 4294967296},
+		test{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 18446744073709551615},
 	}
 
 	for i, test := range tests {
@@ -50,7 +58,7 @@ func TestNBytesForInt(t *testing.T) {
 func TestIntToBytes(t *testing.T) {
 	type test struct {
 		b []byte
-		v uint
+		v uint64
 	}
 	tests := []test{
 		test{[]byte{0x0}, 0},
@@ -58,6 +66,8 @@ func TestIntToBytes(t *testing.T) {
 		test{[]byte{0x01, 0x0}, 256},
 		test{[]byte{0x01, 0x0, 0x0}, 65536},
 		test{[]byte{0x01, 0x01, 0x0}, 65792},
+		test{[]byte{0x01, 0x0, 0x0, 0x0, 0x0}, 4294967296},
+		test{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 18446744073709551615},
 	}
 
 	for i, test := range tests {
@@ -71,7 +81,7 @@ func TestIntToBytes(t *testing.T) {
 func TestIntToBytesAndBack(t *testing.T) {
 	type test struct {
 		b []byte
-		v uint
+		v uint64
 	}
 	tests := []test{
 		test{[]byte{0x0}, 0},
@@ -79,6 +89,8 @@ func TestIntToBytesAndBack(t *testing.T) {
 		test{[]byte{0x01, 0x0}, 256},
 		test{[]byte{0x01, 0x0, 0x0}, 65536},
 		test{[]byte{0x01, 0x01, 0x0}, 65792},
+		test{[]byte{0x01, 0x0, 0x0, 0x0, 0x0}, 4294967296},
+		test{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 18446744073709551615},
 	}
 
 	for i, test := range tests {
@@ -90,3 +102,89 @@ func TestIntToBytesAndBack(t *testing.T) {
 		}
 	}
 }
+
+// offsets beyond 4 GiB need more than 32 bits in the
+// cross-reference stream, Prev and startxref
+func TestLargeFileOffsets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large file test in short mode")
+	}
+	if strconv.IntSize < 64 {
+		t.Skip("large files cannot be mapped on this platform")
+	}
+
+	dir, err := ioutil.TempDir("", "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "large.pdf")
+
+	file, err := Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make the file sparse so that the objects are written past 4 GiB
+	const large = 5 << 30
+	err = os.Truncate(filename, large)
+	if err != nil {
+		t.Skip("unable to create sparse file:", err)
+	}
+
+	file.Root, err = file.Add(Dictionary{Name("Type"): Name("Catalog")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.prev < large {
+		t.Errorf("expected startxref beyond %d, got %d", large, file.prev)
+	}
+
+	catalog, ok := file.Get(file.Root).(Dictionary)
+	if !ok || catalog[Name("Type")] != Name("Catalog") {
+		t.Fatalf("unable to get catalog, got %#v", file.Get(file.Root))
+	}
+
+	// an update will have a Prev beyond 4 GiB
+	info, err := file.Add(Dictionary{Name("Title"): String("large")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Info = info
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, ok := file.Get(file.Root).(Dictionary); !ok {
+		t.Errorf("unable to get catalog after update, got %#v", file.Get(file.Root))
+	}
+
+	err = compare(file.Get(file.Info), Dictionary{Name("Title"): String("large")})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,86 +1,493 @@
 package pdf
 
 import (
-	"encoding/ascii85"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	// "compress/lzw"
-	"bytes"
-	"compress/flate"
+	"io"
 	"io/ioutil"
+	"strings"
+	"sync"
 )
 
-// TODO:
-// - file external file processing
+// A Filter encodes or decodes stream data.
+// - §7.4
+type Filter struct {
+	// Name of the filter as used in a stream's Filter entry.
+	Name Name
 
-// Decode decodes the stream data using the filters in the stream's dictionary.
-func (s Stream) Decode() ([]byte, error) {
-	// when there are no filters, it is already decoded
-	if _, ok := s.Dictionary["Filter"]; !ok {
-		return s.Stream, nil
+	// DecodeParms are the filter's parameters, may be nil.
+	// They are stored in the stream's DecodeParms entry.
+	DecodeParms Dictionary
+
+	// Level is the compression level used when encoding with
+	// FlateDecode (see compress/flate).
+	// Zero uses flate.DefaultCompression.
+	Level int
+}
+
+// NewStream returns a Stream with data encoded by the filters.
+// Filters are listed in the order they are used to decode the data.
+func NewStream(data []byte, filters ...Filter) (Stream, error) {
+	return Stream{Dictionary: Dictionary{}, Stream: data}.Encode(filters...)
+}
+
+// Encode returns a copy of the stream with its data further encoded
+// by the filters. Filters are listed in the order they are used to
+// decode the data and will be used before the stream's existing filters.
+// External file streams cannot be encoded, as their data is not in
+// the stream; use NewExternalStream to write a new external file.
+func (s Stream) Encode(filters ...Filter) (Stream, error) {
+	if s.IsExternal() {
+		return s, errors.New("cannot encode an external file stream")
+	}
+
+	existing, err := s.Filters()
+	if err != nil {
+		return s, err
+	}
+
+	encoded := &bytes.Buffer{}
+	w, err := NewEncoder(encoded, filters...)
+	if err != nil {
+		return s, err
+	}
+	_, err = w.Write(s.Stream)
+	if err != nil {
+		return s, err
+	}
+	err = w.Close()
+	if err != nil {
+		return s, err
+	}
+
+	dict := Dictionary{}
+	for name, value := range s.Dictionary {
+		dict[name] = value
+	}
+	setFilters(dict, append(filters, existing...))
+
+	return Stream{Dictionary: dict, Stream: encoded.Bytes()}, nil
+}
+
+// NewEncoder returns a writer that encodes data with the filters
+// and writes the encoded data to w, allowing large streams to be
+// encoded without holding all of the data in memory.
+// Filters are listed in the order they are used to decode the data.
+//
+// Close must be called to write the end of the data.
+// It does not close w.
+func NewEncoder(w io.Writer, filters ...Filter) (io.WriteCloser, error) {
+	return newEncoder(w, filters, nil)
+}
+
+// NewEncoder returns a writer that encodes data with the filters
+// for a stream in the File. See NewEncoder.
+func (f *File) NewEncoder(w io.Writer, filters ...Filter) (io.WriteCloser, error) {
+	return newEncoder(w, filters, f)
+}
+
+// file may be nil
+func newEncoder(w io.Writer, filters []Filter, file *File) (io.WriteCloser, error) {
+	chain := &encoderChain{}
+
+	// data passes through the last filter first
+	for _, filter := range filters {
+		encoder, ok := lookupEncoder(filter.Name)
+		if !ok {
+			return nil, errors.New("No encoder for " + string(filter.Name))
+		}
+
+		encoding, err := encoder(w, filter, file)
+		if err != nil {
+			return nil, errors.New(string(filter.Name) + ": " + err.Error())
+		}
+
+		named := &filterWriter{name: filter.Name, w: encoding}
+		chain.encoders = append(chain.encoders, named)
+		w = named
+	}
+	chain.w = w
+
+	return chain, nil
+}
+
+// encoderChain closes its encoders from the first to receive data
+// to the last, so that each encoder's final data is encoded
+// by the following encoders
+type encoderChain struct {
+	w        io.Writer
+	encoders []io.WriteCloser
+}
+
+func (c *encoderChain) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func (c *encoderChain) Close() error {
+	for i := len(c.encoders) - 1; i >= 0; i-- {
+		err := c.encoders[i].Close()
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// Filters returns the filters from the stream's Filter
+// and DecodeParms entries, in the order they are used for decoding.
+// For external file streams, FFilter and FDecodeParms are used.
+func (s Stream) Filters() ([]Filter, error) {
+	filters := []Filter{}
+	filterKey, parametersKey := filterKeys(s.Dictionary)
 
 	// extract the list of filters to use
-	filters := []Name{}
-	switch streamFilter := s.Dictionary[Name("Filter")].(type) {
+	switch streamFilter := s.Dictionary[filterKey].(type) {
+	case nil:
+		// when there are no filters, it is already decoded
+		return filters, nil
 	case Name:
-		filters = append(filters, streamFilter)
+		filters = append(filters, Filter{Name: streamFilter})
 	case Array:
 		for _, filter := range streamFilter {
-			filters = append(filters, filter.(Name))
+			name, ok := filter.(Name)
+			if !ok {
+				return nil, fmt.Errorf("filter is a %T, not a Name", filter)
+			}
+			filters = append(filters, Filter{Name: name})
 		}
 	default:
-		panic(fmt.Sprintf("unhandled type: %T", streamFilter))
+		return nil, fmt.Errorf("unhandled Filter type: %T", streamFilter)
 	}
 
 	// extract the filter parameters
-	parameters := []Dictionary{}
-	if dict, ok := s.Dictionary[Name("DecodeParms")]; ok {
-		switch streamParameter := dict.(type) {
+	parameters := []Object{}
+	switch streamParameter := s.Dictionary[parametersKey].(type) {
+	case nil:
+	case Dictionary:
+		parameters = append(parameters, streamParameter)
+	case Array:
+		parameters = streamParameter
+	default:
+		return nil, fmt.Errorf("unhandled DecodeParms type: %T", streamParameter)
+	}
+
+	for i := range filters {
+		if i >= len(parameters) {
+			break
+		}
+
+		switch parameter := parameters[i].(type) {
 		case Dictionary:
-			parameters = append(parameters, streamParameter)
-		case Array:
-			for _, parameter := range streamParameter {
-				parameters = append(parameters, parameter.(Dictionary))
-			}
+			filters[i].DecodeParms = parameter
+		case Null:
+			// the filter's default parameters are used
 		default:
-			panic(fmt.Sprintf("unhandled type: %T", streamParameter))
+			return nil, fmt.Errorf("unhandled DecodeParms type: %T", parameter)
 		}
 	}
 
-	// apply the filters
-	stream := s.Stream
-	for i, filter := range filters {
-		decoder, ok := decoders[filter]
+	return filters, nil
+}
+
+// returns the names of the filter and filter parameter entries,
+// which differ for external file streams
+func filterKeys(dict Dictionary) (Name, Name) {
+	if _, external := dict[Name("F")]; external {
+		return Name("FFilter"), Name("FDecodeParms")
+	}
+	return Name("Filter"), Name("DecodeParms")
+}
+
+// sets the Filter and DecodeParms entries in dict
+func setFilters(dict Dictionary, filters []Filter) {
+	filterKey, parametersKey := filterKeys(dict)
+	delete(dict, filterKey)
+	delete(dict, parametersKey)
+
+	hasParameters := false
+	for _, filter := range filters {
+		if len(filter.DecodeParms) != 0 {
+			hasParameters = true
+		}
+	}
+
+	switch len(filters) {
+	case 0:
+	case 1:
+		dict[filterKey] = filters[0].Name
+		if hasParameters {
+			dict[parametersKey] = filters[0].DecodeParms
+		}
+	default:
+		names := Array{}
+		parameters := Array{}
+		for _, filter := range filters {
+			names = append(names, filter.Name)
+			if len(filter.DecodeParms) == 0 {
+				parameters = append(parameters, Null{})
+			} else {
+				parameters = append(parameters, filter.DecodeParms)
+			}
+		}
+
+		dict[filterKey] = names
+		if hasParameters {
+			dict[parametersKey] = parameters
+		}
+	}
+}
+
+// Decode decodes the stream data using the filters in the stream's dictionary.
+func (s Stream) Decode() ([]byte, error) {
+	return s.decode(context.Background(), nil)
+}
+
+// Decode decodes the stream data using the filters in the
+// stream's dictionary and the File's settings.
+//
+// When the File is Lenient, problems that still allowed data to be
+// decoded are returned as a *Warning along with the decoded data.
+// Exceeding the File's Limits is always an error.
+func (f *File) Decode(s Stream) ([]byte, error) {
+	return s.decode(context.Background(), f)
+}
+
+// DecodeContext is like Decode, but stops decoding when ctx is done.
+func (f *File) DecodeContext(ctx context.Context, s Stream) ([]byte, error) {
+	return s.decode(ctx, f)
+}
+
+// Reader returns a reader of the stream's decoded data. Data is
+// decoded as it is read, so that large streams do not need to be held
+// in memory. Errors found while decoding are returned by Read.
+//
+// The data of external file streams cannot be read without a File,
+// see File.ExternalFile.
+func (s Stream) Reader() (io.Reader, error) {
+	return s.reader(context.Background(), nil)
+}
+
+// Reader returns a reader of the stream's decoded data
+// using the File's settings. See Stream.Reader.
+//
+// The files of external file streams are opened with ExternalFile
+// and stay open until all of the data has been read or a read fails.
+// The reader is also an io.Closer, which closes the file early.
+func (f *File) Reader(s Stream) (io.Reader, error) {
+	return s.reader(context.Background(), f)
+}
+
+// ReaderContext is like Reader, but Read returns ctx's error
+// once ctx is done.
+func (f *File) ReaderContext(ctx context.Context, s Stream) (io.Reader, error) {
+	return s.reader(ctx, f)
+}
+
+// A Warning lists problems that did not prevent stream data from
+// being decoded, though the data may be incomplete.
+type Warning struct {
+	Errors []error
+}
+
+func (w *Warning) Error() string {
+	messages := []string{}
+	for _, err := range w.Errors {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// isWarning reports whether err can be ignored
+func isWarning(err error) bool {
+	_, ok := err.(*Warning)
+	return ok
+}
+
+// decodes using the settings from file, which may be nil
+func (s Stream) decode(ctx context.Context, file *File) ([]byte, error) {
+	lenient := file != nil && file.Lenient
+
+	r, err := s.reader(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		// use whatever data the decoders could recover
+		if !lenient || len(decoded) == 0 || isLimit(err) {
+			return nil, err
+		}
+		return decoded, &Warning{Errors: []error{err}}
+	}
+
+	return decoded, nil
+}
+
+// chains the decoders for the stream's filters,
+// file may be nil. Closing the reader closes
+// the file of an external file stream.
+func (s Stream) reader(ctx context.Context, file *File) (io.ReadCloser, error) {
+	filters, err := s.Filters()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = bytes.NewReader(s.Stream)
+	var external *closeAtEOF
+	if s.IsExternal() {
+		opened, err := s.openExternal(file)
+		if err != nil {
+			return nil, err
+		}
+		external = &closeAtEOF{r: opened}
+		r = external
+	}
+
+	for _, filter := range filters {
+		decoder, ok := lookupDecoder(filter.Name)
 		if !ok {
-			return nil, errors.New("No decoder for " + string(filter))
+			external.Close()
+			return nil, errors.New("No decoder for " + string(filter.Name))
 		}
 
-		parameter := Dictionary{}
-		if i < len(parameters) {
-			parameter = parameters[i]
+		if filter.DecodeParms == nil {
+			filter.DecodeParms = Dictionary{}
 		}
 
-		var err error
-		stream, err = decoder(stream, parameter)
+		decoding, err := decoder(r, filter, file)
 		if err != nil {
-			return nil, errors.New(string(filter) + ": " + err.Error())
+			external.Close()
+			if isLimit(err) {
+				return nil, err
+			}
+			return nil, errors.New(string(filter.Name) + ": " + err.Error())
 		}
+		r = &filterReader{name: filter.Name, r: decoding}
 	}
 
-	return stream, nil
+	if file != nil {
+		decoded, max := file.budget(ctx)
+		r = &limitReader{ctx: ctx, file: file, r: r, decoded: decoded, max: max}
+	}
+
+	return &streamReader{Reader: r, external: external}, nil
+}
+
+// streamReader reads the decoded data of a stream
+type streamReader struct {
+	io.Reader
+	external *closeAtEOF // of an external file stream, may be nil
 }
 
-var decoders = map[Name]func([]byte, Dictionary) ([]byte, error){
-	Name("ASCII85Decode"): func(encoded []byte, dict Dictionary) ([]byte, error) {
-		// the -3 strips the end of data marker
-		return ioutil.ReadAll(ascii85.NewDecoder(bytes.NewBuffer(encoded[:len(encoded)-3])))
-	},
-	Name("FlateDecode"): func(encoded []byte, dict Dictionary) ([]byte, error) {
-		return ioutil.ReadAll(flate.NewReader(bytes.NewBuffer(encoded[2:])))
-	},
-	// There is some problem with LZWDecode and TestFilterExample3
-	// Name("LZWDecode"): func(encoded []byte, dict Dictionary) ([]byte, error) {
-	// 	return ioutil.ReadAll(lzw.NewReader(bytes.NewBuffer(encoded[:len(encoded)-3]), lzw.MSB, 8))
-	// },
+func (sr *streamReader) Close() error {
+	return sr.external.Close()
+}
+
+// filterReader adds the filter's name to errors
+type filterReader struct {
+	name Name
+	r    io.Reader
+}
+
+// a decoding error from a filter
+type filterError struct {
+	name Name
+	err  error
+}
+
+func (err *filterError) Error() string {
+	return string(err.name) + ": " + err.err.Error()
+}
+
+func (fr *filterReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+
+	// keep the name of the filter that failed
+	if _, ok := err.(*filterError); !ok {
+		err = &filterError{name: fr.name, err: err}
+	}
+	return n, err
+}
+
+// filterWriter adds the filter's name to errors
+type filterWriter struct {
+	name Name
+	w    io.WriteCloser
+}
+
+func (fw *filterWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	return n, fw.wrap(err)
+}
+
+func (fw *filterWriter) Close() error {
+	return fw.wrap(fw.w.Close())
+}
+
+func (fw *filterWriter) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// keep the name of the filter that failed
+	if _, ok := err.(*filterError); !ok {
+		err = &filterError{name: fw.name, err: err}
+	}
+	return err
+}
+
+// A Decoder returns a reader of the data read from r decoded by
+// the filter. The file is the File the stream belongs to and is
+// nil for streams decoded without one.
+type Decoder func(r io.Reader, filter Filter, file *File) (io.Reader, error)
+
+// An Encoder returns a writer that encodes data with the filter and
+// writes it to w. Closing the writer must write any buffered data,
+// but not close w. The file is the File the stream belongs to and
+// is nil for streams encoded without one.
+type Encoder func(w io.Writer, filter Filter, file *File) (io.WriteCloser, error)
+
+type registeredFilter struct {
+	decoder Decoder
+	encoder Encoder
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[Name]registeredFilter{}
+)
+
+// RegisterFilter registers the decoder and encoder used for the filter
+// name, replacing any previously registered for that name. Either may
+// be nil when the filter can only be used in one direction.
+//
+// RegisterFilter is typically called from an init function.
+func RegisterFilter(name Name, decoder Decoder, encoder Encoder) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[name] = registeredFilter{decoder: decoder, encoder: encoder}
+}
+
+func lookupDecoder(name Name) (Decoder, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	decoder := registry[name].decoder
+	return decoder, decoder != nil
+}
+
+func lookupEncoder(name Name) (Encoder, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	encoder := registry[name].encoder
+	return encoder, encoder != nil
 }
@@ -2,33 +2,31 @@ package pdf
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 )
 
 //§ 7.4.1
 func TestFilterExample3(t *testing.T) {
-	t.Skip()
-
-	indirectObjectString := "1 0 obj\n<< /Length 534\n/Filter [/ASCII85Decode /LZWDecode] >>\nstream\nJ..)6T`?p&<!J9%_[umg\"B7/Z7KNXbN'S+,*Q/&\"OLT'F\nLIDK#!n`$\"<Atdi`\\Vn%b%)&'cA*VnK\\CJY(sF>c!Jnl@\nRM]WM;jjH6Gnc75idkL5]+cPZKEBPWdR>FF(kj1_R%W_d\n&/jS!;iuad7h?[L-F$+]]0A3Ck*$I0KZ?;<)CJtqi65Xb\nVc3\\n5ua:Q/=0$W<#N3U;H,MQKqfg1?:lUpR;6oN[C2E4\nZNr8Udn.'p+?#X+1>0Kuk$bCDF/(3fL5]Oq)^kJZ!C2H1\n'TO]Rl?Q:&'<5&iP!$Rq;BXRecDN[IJB`,)o8XJOSJ9sD\nS]hQ;Rj@!ND)bD_q&C\\g:inYC%)&u#:u,M6Bm%IY!Kb1+\n\":aAa'S`ViJglLb8<W9k6Yl\\\\0McJQkDeLWdPN?9A'jX*\nal>iG1p&i;eVoK&juJHs9%;Xomop\"5KatWRT\"JQ#qYuL,\nJD?M$0QP)lKn06l1apKDC@\\qJ4B!!(5m+j.7F790m(Vj8\n8l8Q:_CZ(Gm1%X\\N1&u!FKHMB~>\nendstream\nendobj"
-	expectedStream := []byte(`2J
-BT
-/F1 12 Tf 0 Tc
-0 Tw
-72.5 712 TD
-[(Unfiltered streams can be read easily) 65 (, )] TJ
-0 -14 TD
-[(b) 20 (ut generally tak) 10 (e more space than \311)] TJ
-T* (compressed streams.) Tj
-0 -28 TD
-[(Se) 25 (v) 15 (eral encoding methods are a) 20 (v) 25 (ailable in PDF) 80 (.)] TJ 0 -14 TD
-(Some are used for compression and others simply) Tj
-T* [(to represent binary data in an ) 55 (ASCII format.)] TJ
-T* (Some of the compression filters are \
-suitable ) Tj
-T* (for both data and images, while others are \
-suitable only ) Tj
-T* (for continuous-tone images.) Tj
-ET`)
+	// The example in the specification has a duplicated "8" at the
+	// beginning of its last line and its decoded text does not match
+	// Example 1. Both are corrected here.
+	indirectObjectString := "1 0 obj\n<< /Length 533\n/Filter [/ASCII85Decode /LZWDecode] >>\nstream\nJ..)6T`?p&<!J9%_[umg\"B7/Z7KNXbN'S+,*Q/&\"OLT'F\nLIDK#!n`$\"<Atdi`\\Vn%b%)&'cA*VnK\\CJY(sF>c!Jnl@\nRM]WM;jjH6Gnc75idkL5]+cPZKEBPWdR>FF(kj1_R%W_d\n&/jS!;iuad7h?[L-F$+]]0A3Ck*$I0KZ?;<)CJtqi65Xb\nVc3\\n5ua:Q/=0$W<#N3U;H,MQKqfg1?:lUpR;6oN[C2E4\nZNr8Udn.'p+?#X+1>0Kuk$bCDF/(3fL5]Oq)^kJZ!C2H1\n'TO]Rl?Q:&'<5&iP!$Rq;BXRecDN[IJB`,)o8XJOSJ9sD\nS]hQ;Rj@!ND)bD_q&C\\g:inYC%)&u#:u,M6Bm%IY!Kb1+\n\":aAa'S`ViJglLb8<W9k6Yl\\\\0McJQkDeLWdPN?9A'jX*\nal>iG1p&i;eVoK&juJHs9%;Xomop\"5KatWRT\"JQ#qYuL,\nJD?M$0QP)lKn06l1apKDC@\\qJ4B!!(5m+j.7F790m(Vj8\nl8Q:_CZ(Gm1%X\\N1&u!FKHMB~>\nendstream\nendobj"
+	expectedStream := []byte("2 J \r" +
+		"BT\r" +
+		"/F1 12 Tf\r" +
+		"0 Tc 0 Tw 72.5 712 TD [ (Unencoded streams can be read easily)65 (,)] TJ\r" +
+		"0 -14 TD [ (b)20 (ut generally tak)10 (e more space than \\311)] TJ\r" +
+		"T* (encoded streams.)Tj\r" +
+		"0 -28 TD [ (Se)25 (v)15 (eral encoding methods are a)20 (v)25 (ailable in PDF)80 (.)] TJ\r" +
+		"0 -14 TD (Some are used for compression and others simply)Tj\r" +
+		"T* [ (to represent binary data in an )55 (ASCII format.)] TJ\r" +
+		"T* (Some of the compression encoding methods are suitable )Tj\r" +
+		"T* (for both data and images, while others are suitable only )Tj\r" +
+		"T* (for continuous-tone images.)Tj\r" +
+		"ET\r")
 
 	object, _, err := parseIndirectObject([]byte(indirectObjectString))
 	if err != nil {
@@ -44,6 +42,350 @@ ET`)
 	}
 
 	if bytes.Compare(decodedStream, expectedStream) != 0 {
-		t.Errorf("Stream did not decode, got:\n\t%vexpected:\n\t%v", stream, expectedStream)
+		t.Errorf("Stream did not decode, got:\n\t%q\nexpected:\n\t%q", decodedStream, expectedStream)
+	}
+}
+
+func TestNewStream(t *testing.T) {
+	data := bytes.Repeat([]byte("0 0 m 100 100 l S\n"), 100)
+	predictor := Dictionary{Name("Predictor"): Integer(12), Name("Columns"): Integer(4)}
+
+	tests := []struct {
+		filters     []Filter
+		filter      Object
+		decodeParms Object
+	}{
+		{nil, nil, nil},
+		{[]Filter{{Name: "FlateDecode", Level: 9}}, Name("FlateDecode"), nil},
+		{[]Filter{{Name: "FlateDecode", DecodeParms: predictor}}, Name("FlateDecode"), predictor},
+		{
+			[]Filter{{Name: "ASCII85Decode"}, {Name: "LZWDecode", DecodeParms: predictor}},
+			Array{Name("ASCII85Decode"), Name("LZWDecode")},
+			Array{Null{}, predictor},
+		},
+		{
+			[]Filter{{Name: "ASCIIHexDecode"}, {Name: "RunLengthDecode"}},
+			Array{Name("ASCIIHexDecode"), Name("RunLengthDecode")},
+			nil,
+		},
+	}
+
+	for n, test := range tests {
+		stream, err := NewStream(data, test.filters...)
+		if err != nil {
+			t.Errorf("%d: %v", n, err)
+			continue
+		}
+
+		err = compare(stream.Dictionary[Name("Filter")], test.filter)
+		if err != nil {
+			t.Errorf("%d Filter: %v", n, err)
+		}
+
+		err = compare(stream.Dictionary[Name("DecodeParms")], test.decodeParms)
+		if err != nil {
+			t.Errorf("%d DecodeParms: %v", n, err)
+		}
+
+		decoded, err := stream.Decode()
+		if err != nil {
+			t.Errorf("%d: %v", n, err)
+			continue
+		}
+
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%d: decoded data does not match", n)
+		}
+	}
+}
+
+// encoding an encoded stream adds filters in front of the existing ones
+func TestStreamEncode(t *testing.T) {
+	data := []byte("BT /F1 12 Tf (Hello) Tj ET")
+
+	stream, err := NewStream(data, Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Dictionary[Name("Type")] = Name("Example")
+
+	encoded, err := stream.Encode(Filter{Name: "ASCII85Decode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = compare(encoded.Dictionary, Dictionary{
+		Name("Type"):   Name("Example"),
+		Name("Filter"): Array{Name("ASCII85Decode"), Name("FlateDecode")},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := stream.Dictionary[Name("Filter")].(Name); !ok {
+		t.Errorf("the original stream was modified")
+	}
+
+	decoded, err := encoded.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected %q, got %q", data, decoded)
+	}
+}
+
+func TestCompressThreshold(t *testing.T) {
+	filename, cleanup := createTestFile(t)
+	defer cleanup()
+
+	file, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.CompressThreshold = 100
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large "), 100)
+	smallRef, err := file.Add(Stream{Stream: small})
+	if err != nil {
+		t.Fatal(err)
+	}
+	largeRef, err := file.Add(Stream{Stream: large})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = file.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		ref    ObjectReference
+		data   []byte
+		filter Object
+	}{
+		{smallRef, small, nil},
+		{largeRef, large, Name("FlateDecode")},
+	}
+	for n, test := range tests {
+		stream, ok := file.Get(test.ref).(Stream)
+		if !ok {
+			t.Errorf("%d: expected a stream, got %#v", n, file.Get(test.ref))
+			continue
+		}
+
+		err = compare(stream.Dictionary[Name("Filter")], test.filter)
+		if err != nil {
+			t.Errorf("%d: %v", n, err)
+		}
+
+		decoded, err := stream.Decode()
+		if err != nil {
+			t.Errorf("%d: %v", n, err)
+		}
+
+		if !bytes.Equal(decoded, test.data) {
+			t.Errorf("%d: expected %q, got %q", n, test.data, decoded)
+		}
+	}
+}
+
+func TestStreamReader(t *testing.T) {
+	data := bytes.Repeat([]byte("BT /F1 12 Tf (Hello) Tj ET\n"), 1000)
+
+	stream, err := NewStream(data,
+		Filter{Name: "ASCII85Decode"},
+		Filter{Name: "LZWDecode", DecodeParms: Dictionary{Name("Predictor"): Integer(12), Name("Columns"): Integer(7)}},
+		Filter{Name: "RunLengthDecode"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := stream.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read in small pieces to exercise the buffering in each filter
+	decoded := []byte{}
+	buf := make([]byte, 7)
+	for {
+		n, err := r.Read(buf)
+		decoded = append(decoded, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected %d bytes, got %d", len(data), len(decoded))
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	data := bytes.Repeat([]byte("BT /F1 12 Tf (Hello) Tj ET\n"), 1000)
+	filters := []Filter{
+		{Name: "ASCIIHexDecode"},
+		{Name: "FlateDecode", DecodeParms: Dictionary{Name("Predictor"): Integer(2), Name("Columns"): Integer(5)}},
+	}
+
+	// write in small pieces
+	encoded := &bytes.Buffer{}
+	w, err := NewEncoder(encoded, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := 0; start < len(data); start += 13 {
+		end := start + 13
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err = w.Write(data[start:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := NewStream(data, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded.Bytes(), stream.Stream) {
+		t.Errorf("streamed encoding differs from Encode")
+	}
+
+	stream.Stream = encoded.Bytes()
+	decoded, err := stream.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected %d bytes, got %d", len(data), len(decoded))
+	}
+
+	_, err = NewEncoder(encoded, Filter{Name: "DCTDecode"})
+	if err == nil {
+		t.Errorf("expected an error for a filter without an encoder")
+	}
+}
+
+// §7.5.7
+func TestObjectStream(t *testing.T) {
+	objects := "11 0 12 6 13 23 " +
+		"(one) << /Two [1 2] >> /Three"
+	objectStream, err := NewStream([]byte(objects), Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStream.Dictionary[Name("Type")] = Name("ObjStm")
+	objectStream.Dictionary[Name("N")] = Integer(3)
+	objectStream.Dictionary[Name("First")] = Integer(16)
+
+	file := &File{objects: map[uint]interface{}{
+		10: IndirectObject{ObjectReference: ObjectReference{ObjectNumber: 10}, Object: objectStream},
+		11: crossReference{2, 10, 0},
+		12: crossReference{2, 10, 1},
+		// an incorrect index is corrected using the stream's header
+		13: crossReference{2, 10, 0},
+	}}
+
+	expected := map[uint]Object{
+		11: String("one"),
+		12: Dictionary{Name("Two"): Array{Integer(1), Integer(2)}},
+		13: Name("Three"),
+	}
+	for number, object := range expected {
+		err := compare(file.Get(ObjectReference{ObjectNumber: number}), object)
+		if err != nil {
+			t.Errorf("%d: %v", number, err)
+		}
+	}
+}
+
+// object streams in the file are decoded once for all of their objects
+func TestObjectStreamCache(t *testing.T) {
+	const n = 1000
+	header, objects := &bytes.Buffer{}, &bytes.Buffer{}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(header, "%d %d ", 11+i, objects.Len())
+		fmt.Fprintf(objects, "(object %d) ", i)
+	}
+	decoded := append(header.Bytes(), objects.Bytes()...)
+
+	objectStream, err := NewStream(decoded, Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStream.Dictionary[Name("Type")] = Name("ObjStm")
+	objectStream.Dictionary[Name("N")] = Integer(n)
+	objectStream.Dictionary[Name("First")] = Integer(header.Len())
+
+	data := &bytes.Buffer{}
+	_, err = IndirectObject{ObjectReference: ObjectReference{ObjectNumber: 10}, Object: objectStream}.writeTo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decoding the stream twice would exceed MaxMemory
+	file := &File{
+		mmap:    data.Bytes(),
+		objects: map[uint]interface{}{10: crossReference{1, 1, 0}},
+		Limits:  Limits{MaxMemory: int64(len(decoded)) * 3 / 2},
+	}
+	for i := 0; i < n; i++ {
+		file.objects[uint(11+i)] = crossReference{2, 10, uint64(i)}
+	}
+
+	for i := 0; i < n; i++ {
+		expected := String(fmt.Sprintf("object %d", i))
+		err := compare(file.Get(ObjectReference{ObjectNumber: uint(11 + i)}), expected)
+		if err != nil {
+			t.Fatalf("%d: %v", 11+i, err)
+		}
+	}
+}
+
+func TestObjectStreamFirst(t *testing.T) {
+	objectStream, err := NewStream([]byte("11 0 (one)"), Filter{Name: "FlateDecode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStream.Dictionary[Name("Type")] = Name("ObjStm")
+	objectStream.Dictionary[Name("N")] = Integer(1)
+
+	// First is not allocated before the header is read
+	for _, test := range []struct {
+		first  Integer
+		limits Limits
+		err    string
+	}{
+		{-1, Limits{}, "invalid First: -1"},
+		{1 << 40, Limits{}, "First is 1099511627776, but the stream has 10 bytes"},
+		{1 << 40, Limits{MaxStreamSize: 1 << 20}, "exceeded MaxStreamSize of 1048576"},
+	} {
+		objectStream.Dictionary[Name("First")] = test.first
+		file := &File{Limits: test.limits}
+		_, err := file.decodeObjectStream(objectStream)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("First %d: expected an error containing %q, got %v", test.first, test.err, err)
+		}
 	}
 }
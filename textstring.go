@@ -0,0 +1,57 @@
+package pdf
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// the characters of PDFDocEncoding that differ from ISO Latin-1,
+// except for the undefined codes 0x7f, 0x9f and 0xad
+// - Annex D.3
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙',
+	0x1c: '˝', 0x1d: '˛', 0x1e: '˚', 0x1f: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…',
+	0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8a: '−', 0x8b: '‰',
+	0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ',
+	0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł',
+	0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0xa0: '€',
+}
+
+// decodeTextString returns the text of a text string, which is
+// UTF-16BE or UTF-8 when it starts with a byte order mark,
+// otherwise PDFDocEncoding. Invalid and undefined characters
+// are replaced by U+FFFD.
+// - §7.9.2.2
+func decodeTextString(s String) string {
+	switch {
+	case bytes.HasPrefix(s, []byte{0xfe, 0xff}):
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		if len(s)%2 != 0 {
+			units = append(units, utf8.RuneError)
+		}
+		return string(utf16.Decode(units))
+	case bytes.HasPrefix(s, []byte{0xef, 0xbb, 0xbf}):
+		return string(bytes.ToValidUTF8(s[3:], []byte(string(utf8.RuneError))))
+	}
+
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		switch r, ok := pdfDocEncoding[c]; {
+		case ok:
+			runes[i] = r
+		case c == 0x7f || c == 0x9f || c == 0xad:
+			runes[i] = utf8.RuneError
+		default:
+			runes[i] = rune(c)
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,24 @@
+package pdf
+
+import "testing"
+
+// §7.9.2.2
+func TestDecodeTextString(t *testing.T) {
+	tests := map[string]String{
+		"plain":              String("plain"),
+		"Latin-1 é":          String("Latin-1 \xe9"),
+		"PDFDocEncoding • €": String("PDFDocEncoding \x80 \xa0"),
+		"undefined �":        String("undefined \x9f"),
+		"UTF-16 é😀":          String("\xfe\xff\x00U\x00T\x00F\x00-\x001\x006\x00 \x00\xe9\xd8\x3d\xde\x00"),
+		"odd length �":       String("\xfe\xff\x00o\x00d\x00d\x00 \x00l\x00e\x00n\x00g\x00t\x00h\x00 \x00"),
+		"UTF-8 é":            String("\xef\xbb\xbfUTF-8 \xc3\xa9"),
+		"invalid UTF-8 �":    String("\xef\xbb\xbfinvalid UTF-8 \xc3"),
+	}
+
+	for expected, s := range tests {
+		decoded := decodeTextString(s)
+		if decoded != expected {
+			t.Errorf("%q: expected %q, got %q", s, expected, decoded)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package pdf
 
 import (
+	"errors"
 	"io"
+	"math"
+	"strconv"
+	"strings"
 )
 
 // WriteTo serializes the Boolean according to the rules in
@@ -23,7 +27,7 @@ func (b Boolean) writeTo(w io.Writer) (int64, error) {
 func (i Integer) writeTo(w io.Writer) (int64, error) {
 	buf := newBuffer()
 
-	buf.Printf("%d", int(i))
+	buf.Printf("%d", int64(i))
 
 	return buf.WriteTo(w)
 }
@@ -33,11 +37,75 @@ func (i Integer) writeTo(w io.Writer) (int64, error) {
 func (r Real) writeTo(w io.Writer) (int64, error) {
 	buf := newBuffer()
 
-	buf.Printf("%v", float64(r))
+	if math.IsInf(float64(r), 0) || math.IsNaN(float64(r)) {
+		return 0, errors.New("cannot write " + strconv.FormatFloat(float64(r), 'g', -1, 64) + " as a Real")
+	}
+
+	buf.WriteString(formatReal(float64(r), -1))
 
 	return buf.WriteTo(w)
 }
 
+// formatReal formats r in fixed-point form (exponents are not allowed)
+// using at most decimals digits after the decimal point.
+// When decimals is negative, the fewest digits needed to read
+// the value back exactly are used.
+// A decimal point is always included so that the value
+// is read back as a Real and not as an Integer.
+// Zero, including negative zero and values that round to it,
+// is written as 0.0.
+func formatReal(r float64, decimals int) string {
+	s := strconv.FormatFloat(r, 'f', decimals, 64)
+	if strings.Trim(s, "-0.") == "" {
+		return "0.0"
+	}
+
+	if strings.IndexByte(s, '.') == -1 {
+		return s + ".0"
+	}
+
+	// remove trailing zeros, keeping one digit after the point
+	s = strings.TrimRight(s, "0")
+	if s[len(s)-1] == '.' {
+		s += "0"
+	}
+
+	return s
+}
+
+// roundReals returns a copy of obj where all the Reals
+// have been rounded to at most decimals digits after the decimal point.
+func roundReals(obj Object, decimals int) Object {
+	switch typed := obj.(type) {
+	case Real:
+		rounded, err := strconv.ParseFloat(formatReal(float64(typed), decimals), 64)
+		if err != nil {
+			return typed
+		}
+		return Real(rounded)
+	case Array:
+		array := make(Array, len(typed))
+		for i := range typed {
+			array[i] = roundReals(typed[i], decimals)
+		}
+		return array
+	case Dictionary:
+		dict := make(Dictionary, len(typed))
+		for name, value := range typed {
+			dict[name] = roundReals(value, decimals)
+		}
+		return dict
+	case Stream:
+		typed.Dictionary = roundReals(typed.Dictionary, decimals).(Dictionary)
+		return typed
+	case IndirectObject:
+		typed.Object = roundReals(typed.Object, decimals)
+		return typed
+	}
+
+	return obj
+}
+
 // WriteTo serializes the String according to the rules in
 // §7.3.4
 func (s String) writeTo(w io.Writer) (int64, error) {
@@ -46,12 +114,25 @@ func (s String) writeTo(w io.Writer) (int64, error) {
 	buf.WriteByte('(')
 	for _, b := range []byte(s) {
 		switch b {
-		case '(':
-			buf.WriteString("\\(")
-		case ')':
-			buf.WriteString("\\)")
-		default:
+		case '(', ')', '\\':
+			buf.WriteByte('\\')
 			buf.WriteByte(b)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\b':
+			buf.WriteString("\\b")
+		case '\f':
+			buf.WriteString("\\f")
+		default:
+			if b < ' ' || b == 0x7f {
+				buf.Printf("\\%03o", b)
+			} else {
+				buf.WriteByte(b)
+			}
 		}
 	}
 	buf.WriteByte(')')